@@ -0,0 +1,75 @@
+package allocator
+
+import "fmt"
+
+// ValueError is implemented by the errors about a value of an allocator, so that they can
+// be told apart by the kind of the value
+type ValueError interface {
+	error
+	Kind() string
+}
+
+type ValueInUseError struct {
+	k string
+	v string
+	n string
+}
+
+func (e ValueInUseError) Error() string {
+	return fmt.Sprintf("%s %s is currently in use by %s", e.k, e.v, e.n)
+}
+
+func (e ValueInUseError) Kind() string {
+	return e.k
+}
+
+type ValuesExhaustedError struct {
+	k string
+}
+
+func (e ValuesExhaustedError) Error() string {
+	return fmt.Sprintf("configured amount of %s values exhausted", e.k)
+}
+
+type ValueNotFoundError struct {
+	k string
+	v string
+}
+
+func (e ValueNotFoundError) Error() string {
+	return fmt.Sprintf("%s %s not found in list of used values", e.k, e.v)
+}
+
+func (e ValueNotFoundError) Kind() string {
+	return e.k
+}
+
+type ValueOutOfRangeError struct {
+	k string
+	v string
+}
+
+func (e ValueOutOfRangeError) Error() string {
+	return fmt.Sprintf("%s %s is outside of the configured ranges or excluded", e.k, e.v)
+}
+
+func (e ValueOutOfRangeError) Kind() string {
+	return e.k
+}
+
+type InvalidRangeError struct {
+	k string
+	r string
+}
+
+func (e InvalidRangeError) Error() string {
+	return fmt.Sprintf("invalid %s range %s", e.k, e.r)
+}
+
+type UnknownKindError struct {
+	k string
+}
+
+func (e UnknownKindError) Error() string {
+	return fmt.Sprintf("unknown allocator kind %s", e.k)
+}
@@ -0,0 +1,214 @@
+package allocator
+
+import (
+	"go.uber.org/zap"
+)
+
+// Allocator contains the state of an allocator and specifies the kind of its values and
+// the configured ranges and exclusions. It allows binding and releasing a value to a
+// name (string).
+type Allocator struct {
+	l        *zap.Logger
+	probe    Probe
+	Kind     string
+	Ranges   []Range
+	Excluded []Range
+	ValueMap map[uint32]string
+}
+
+// Probe returns a function that reports whether a value is in use outside of the
+// allocator, e.g. a port bound on the host by another process. It is called once for
+// every lookup, so that it can take a snapshot of the state it checks.
+type Probe func() func(v uint32) bool
+
+// New creates and initializes an Allocator object. Values are allocated from the ranges
+// in the order they are provided, skipping the excluded ones and those that the probe
+// reports in use. The probe may be nil.
+func New(kind string, ranges []Range, excluded []Range, probe Probe, logger *zap.Logger) *Allocator {
+	if !IsKind(kind) {
+		logger.Fatal("bad configuration: unknown allocator kind", zap.String("kind", kind))
+	}
+
+	if len(ranges) == 0 {
+		logger.Fatal("bad configuration: no range configured")
+	}
+
+	for i, r := range ranges {
+		if !r.isValid(kind) {
+			logger.Fatal("bad configuration: invalid range", zap.Strings("range", formatRanges(kind, []Range{r})))
+		}
+		for _, other := range ranges[:i] {
+			if r.overlaps(other) {
+				logger.Fatal("bad configuration: ranges overlap", zap.Strings("ranges", formatRanges(kind, []Range{r, other})))
+			}
+		}
+	}
+
+	for _, r := range excluded {
+		if !r.isValid(kind) {
+			logger.Fatal("bad configuration: invalid exclusion", zap.Strings("range", formatRanges(kind, []Range{r})))
+		}
+	}
+
+	a := &Allocator{
+		l:        logger,
+		probe:    probe,
+		Kind:     kind,
+		Ranges:   ranges,
+		Excluded: excluded,
+		ValueMap: make(map[uint32]string),
+	}
+
+	logger.Info("initialized allocator with ranges", zap.String("kind", kind), zap.Strings("ranges", formatRanges(kind, ranges)), zap.Strings("excluded", formatRanges(kind, excluded)))
+
+	return a
+}
+
+// GetNextAvailable returns the next available value within the configured ranges.
+// Values that the probe reports in use are skipped.
+func (a *Allocator) GetNextAvailable() (string, error) {
+	inUse := a.newProbe()
+	for _, r := range a.Ranges {
+		for v := r.From; ; v++ {
+			if _, found := a.ValueMap[v]; !found && !a.isExcluded(v) {
+				if !inUse(v) {
+					return formatValue(a.Kind, v), nil
+				}
+				a.l.Warn("skipping value in use outside of the allocator", zap.String("value", formatValue(a.Kind, v)))
+			}
+			if v == r.To {
+				break
+			}
+		}
+	}
+
+	return "", ValuesExhaustedError{a.Kind}
+}
+
+// Contains reports whether a value is within the configured ranges and is not excluded
+func (a *Allocator) Contains(value string) bool {
+	v, ok := a.parse(value)
+	return ok && a.isConfigured(v)
+}
+
+// IsAvailable reports whether a value is within the configured ranges, not excluded, not
+// bound to a name and not reported in use by the probe
+func (a *Allocator) IsAvailable(value string) bool {
+	v, ok := a.parse(value)
+	if !ok || !a.isConfigured(v) {
+		return false
+	}
+	if _, found := a.ValueMap[v]; found {
+		return false
+	}
+
+	return !a.newProbe()(v)
+}
+
+// Owner returns the name a value is bound to, if any
+func (a *Allocator) Owner(value string) (string, bool) {
+	v, ok := a.parse(value)
+	if !ok {
+		return "", false
+	}
+	name, found := a.ValueMap[v]
+
+	return name, found
+}
+
+// Bind looks up the provided value in the configured ranges and binds it to a name
+// (string). The probe is not consulted, since the value may already be in use by the
+// name it is bound to.
+func (a *Allocator) Bind(value, name string) error {
+	v, ok := a.parse(value)
+	if !ok || !a.isConfigured(v) {
+		a.l.Error("incompatible configuration: tried to bind value outside of configured ranges", zap.String("value", value))
+		return ValueOutOfRangeError{a.Kind, value}
+	}
+
+	if n, found := a.ValueMap[v]; found {
+		a.l.Error("found inconsistent state: value is currently in use", zap.String("used_by", n), zap.String("name", name), zap.String("value", value))
+		return ValueInUseError{k: a.Kind, v: value, n: n}
+	}
+
+	a.l.Debug("binding name to value", zap.String("name", name), zap.String("value", value))
+	a.ValueMap[v] = name
+
+	return nil
+}
+
+// Release looks up the provided value in the allocator state and removes its entry
+func (a *Allocator) Release(value string) error {
+	v, ok := a.parse(value)
+	if _, found := a.ValueMap[v]; !ok || !found {
+		a.l.Error("found inconsistent state: value not found in list of used values", zap.String("value", value))
+		return ValueNotFoundError{a.Kind, value}
+	}
+
+	a.l.Debug("releasing value", zap.String("value", value))
+	delete(a.ValueMap, v)
+	return nil
+}
+
+// Overlaps reports whether the allocator hands out values of the same kind as another
+// one and one of the configured ranges shares a value with one of its ranges
+func (a *Allocator) Overlaps(other *Allocator) bool {
+	if a.Kind != other.Kind {
+		return false
+	}
+
+	for _, r := range a.Ranges {
+		for _, o := range other.Ranges {
+			if r.overlaps(o) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// newProbe returns the function of the probe, or one that reports no value in use if
+// there is no probe
+func (a *Allocator) newProbe() func(uint32) bool {
+	if a.probe == nil {
+		return func(uint32) bool {
+			return false
+		}
+	}
+
+	return a.probe()
+}
+
+// parse parses a value of the kind of the allocator
+func (a *Allocator) parse(value string) (uint32, bool) {
+	v, err := parseValue(a.Kind, value)
+	return v, err == nil
+}
+
+// isConfigured reports whether a value is within one of the configured ranges and is not
+// excluded
+func (a *Allocator) isConfigured(v uint32) bool {
+	if a.isExcluded(v) {
+		return false
+	}
+
+	for _, r := range a.Ranges {
+		if r.contains(v) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isExcluded reports whether a value is within one of the configured exclusions
+func (a *Allocator) isExcluded(v uint32) bool {
+	for _, r := range a.Excluded {
+		if r.contains(v) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,189 @@
+package allocator
+
+import (
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestParseRange(t *testing.T) {
+	tests := []struct {
+		kind    string
+		value   string
+		want    Range
+		wantErr error
+	}{
+		{KindInt, "1-10", Range{From: 1, To: 10}, nil},
+		{KindInt, "7", Range{From: 7, To: 7}, nil},
+		{KindInt, "10-1", Range{}, InvalidRangeError{KindInt, "10-1"}},
+		{KindInt, "x", Range{}, InvalidRangeError{KindInt, "x"}},
+		{KindPort, "3307-3399", Range{From: 3307, To: 3399}, nil},
+		{KindPort, " 3307 - 3399 ", Range{From: 3307, To: 3399}, nil},
+		{KindPort, "1-65535", Range{From: 1, To: 65535}, nil},
+		{KindPort, "0-10", Range{}, InvalidRangeError{KindPort, "0-10"}},
+		{KindPort, "65000-65536", Range{}, InvalidRangeError{KindPort, "65000-65536"}},
+		{KindPort, "10-", Range{}, InvalidRangeError{KindPort, "10-"}},
+		{KindPort, "", Range{}, InvalidRangeError{KindPort, ""}},
+		{KindIP, "127.0.0.2-127.0.0.10", Range{From: 0x7f000002, To: 0x7f00000a}, nil},
+		{KindIP, "127.0.0.2", Range{From: 0x7f000002, To: 0x7f000002}, nil},
+		{KindIP, "::1", Range{}, InvalidRangeError{KindIP, "::1"}},
+		{"uuid", "1-2", Range{}, UnknownKindError{"uuid"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.kind+" "+tt.value, func(t *testing.T) {
+			got, err := ParseRange(tt.kind, tt.value)
+			if err != tt.wantErr || got != tt.want {
+				t.Errorf("ParseRange(%s, %q) = %v, %v, want %v, %v", tt.kind, tt.value, got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAllocator(t *testing.T) {
+	tests := []struct {
+		name     string
+		kind     string
+		ranges   []string
+		excluded []string
+		want     []string
+	}{
+		{"int", KindInt, []string{"1-3"}, nil, []string{"1", "2", "3"}},
+		{"int excluded", KindInt, []string{"1-4"}, []string{"2-3"}, []string{"1", "4"}},
+		{"int ranges in order", KindInt, []string{"10-11", "1"}, nil, []string{"10", "11", "1"}},
+		{"ip", KindIP, []string{"127.0.0.2-127.0.0.3"}, nil, []string{"127.0.0.2", "127.0.0.3"}},
+		{"ip last address", KindIP, []string{"255.255.255.255"}, nil, []string{"255.255.255.255"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ranges, err := ParseRanges(tt.kind, tt.ranges)
+			if err != nil {
+				t.Fatalf("ParseRanges() error = %v", err)
+			}
+			excluded, err := ParseRanges(tt.kind, tt.excluded)
+			if err != nil {
+				t.Fatalf("ParseRanges() error = %v", err)
+			}
+			a := New(tt.kind, ranges, excluded, nil, zap.NewNop())
+
+			for i, want := range tt.want {
+				got, err := a.GetNextAvailable()
+				if err != nil || got != want {
+					t.Fatalf("GetNextAvailable() #%d = %s, %v, want %s", i, got, err, want)
+				}
+				err = a.Bind(got, "name")
+				if err != nil {
+					t.Fatalf("Bind(%s) error = %v", got, err)
+				}
+			}
+
+			_, err = a.GetNextAvailable()
+			if _, ok := err.(ValuesExhaustedError); !ok {
+				t.Errorf("GetNextAvailable() error = %v, want ValuesExhaustedError", err)
+			}
+
+			err = a.Release(tt.want[0])
+			if err != nil {
+				t.Fatalf("Release(%s) error = %v", tt.want[0], err)
+			}
+			if !a.IsAvailable(tt.want[0]) {
+				t.Errorf("IsAvailable(%s) = false after release", tt.want[0])
+			}
+		})
+	}
+}
+
+func TestAllocatorBindRelease(t *testing.T) {
+	a := New(KindInt, []Range{{From: 1, To: 10}}, []Range{{From: 5, To: 5}}, nil, zap.NewNop())
+
+	tests := []struct {
+		name string
+		run  func() error
+		want error
+	}{
+		{"bind", func() error { return a.Bind("1", "a") }, nil},
+		{"bind in use", func() error { return a.Bind("1", "b") }, ValueInUseError{k: KindInt, v: "1", n: "a"}},
+		{"bind excluded", func() error { return a.Bind("5", "b") }, ValueOutOfRangeError{KindInt, "5"}},
+		{"bind out of range", func() error { return a.Bind("11", "b") }, ValueOutOfRangeError{KindInt, "11"}},
+		{"bind invalid", func() error { return a.Bind("x", "b") }, ValueOutOfRangeError{KindInt, "x"}},
+		{"release unbound", func() error { return a.Release("2") }, ValueNotFoundError{KindInt, "2"}},
+		{"release", func() error { return a.Release("1") }, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.run(); err != tt.want {
+				t.Errorf("error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestAllocatorOverlaps(t *testing.T) {
+	ints := New(KindInt, []Range{{From: 1, To: 10}}, nil, nil, zap.NewNop())
+
+	tests := []struct {
+		name  string
+		other *Allocator
+		want  bool
+	}{
+		{"same kind overlapping", New(KindInt, []Range{{From: 10, To: 20}}, nil, nil, zap.NewNop()), true},
+		{"same kind disjoint", New(KindInt, []Range{{From: 11, To: 20}}, nil, nil, zap.NewNop()), false},
+		{"other kind", New(KindIP, []Range{{From: 1, To: 10}}, nil, nil, zap.NewNop()), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ints.Overlaps(tt.other); got != tt.want {
+				t.Errorf("Overlaps() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAllocatorProbe(t *testing.T) {
+	inUse := map[uint32]bool{3307: true}
+	probe := func() func(uint32) bool {
+		return func(v uint32) bool {
+			return inUse[v]
+		}
+	}
+	a := New(KindPort, []Range{{From: 3307, To: 3308}}, nil, probe, zap.NewNop())
+
+	if a.IsAvailable("3307") {
+		t.Error("IsAvailable(3307) = true for a value in use")
+	}
+	if got, err := a.GetNextAvailable(); err != nil || got != "3308" {
+		t.Errorf("GetNextAvailable() = %s, %v, want 3308", got, err)
+	}
+	// binding does not probe, the value may be in use by the name it is bound to
+	if err := a.Bind("3307", "a"); err != nil {
+		t.Errorf("Bind(3307) error = %v", err)
+	}
+}
+
+func TestRangeOverlaps(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b Range
+		want bool
+	}{
+		{"disjoint", Range{1, 10}, Range{11, 20}, false},
+		{"adjacent end", Range{1, 10}, Range{10, 20}, true},
+		{"contained", Range{1, 20}, Range{5, 6}, true},
+		{"identical", Range{5, 5}, Range{5, 5}, true},
+		{"partial", Range{5, 15}, Range{10, 20}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.a.overlaps(tt.b); got != tt.want {
+				t.Errorf("%v.overlaps(%v) = %v, want %v", tt.a, tt.b, got, tt.want)
+			}
+			if got := tt.b.overlaps(tt.a); got != tt.want {
+				t.Errorf("%v.overlaps(%v) = %v, want %v", tt.b, tt.a, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,142 @@
+package allocator
+
+import (
+	"encoding/binary"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Kinds of values an allocator hands out. Int allocates unsigned 32-bit integers, e.g. a
+// server_id, port allocates port numbers and ip allocates IPv4 addresses, e.g. loopback
+// addresses.
+const (
+	KindInt  = "int"
+	KindPort = "port"
+	KindIP   = "ip"
+)
+
+// maxPort is the highest port number
+const maxPort = 65535
+
+// Range is an inclusive range of values, stored as unsigned 32-bit integers whatever
+// their kind
+type Range struct {
+	From uint32
+	To   uint32
+}
+
+// IsKind reports whether kind is a known kind of value
+func IsKind(kind string) bool {
+	switch kind {
+	case KindInt, KindPort, KindIP:
+		return true
+	default:
+		return false
+	}
+}
+
+// ParseRanges parses ranges of a kind in the form `from-to`, or single values
+func ParseRanges(kind string, values []string) ([]Range, error) {
+	ranges := make([]Range, 0, len(values))
+	for _, value := range values {
+		r, err := ParseRange(kind, value)
+		if err != nil {
+			return nil, err
+		}
+		ranges = append(ranges, r)
+	}
+
+	return ranges, nil
+}
+
+// ParseRange parses a range of a kind in the form `from-to`, or a single value
+func ParseRange(kind, value string) (Range, error) {
+	if !IsKind(kind) {
+		return Range{}, UnknownKindError{kind}
+	}
+
+	from, to := value, value
+	if i := strings.IndexByte(value, '-'); i >= 0 {
+		from, to = value[:i], value[i+1:]
+	}
+
+	f, err := parseValue(kind, from)
+	if err != nil {
+		return Range{}, InvalidRangeError{kind, value}
+	}
+	t, err := parseValue(kind, to)
+	if err != nil {
+		return Range{}, InvalidRangeError{kind, value}
+	}
+
+	r := Range{From: f, To: t}
+	if !r.isValid(kind) {
+		return Range{}, InvalidRangeError{kind, value}
+	}
+
+	return r, nil
+}
+
+// parseValue parses a value of a kind
+func parseValue(kind, value string) (uint32, error) {
+	value = strings.TrimSpace(value)
+	if kind == KindIP {
+		ip := net.ParseIP(value).To4()
+		if ip == nil {
+			return 0, ValueOutOfRangeError{kind, value}
+		}
+		return binary.BigEndian.Uint32(ip), nil
+	}
+
+	v, err := strconv.ParseUint(value, 10, 32)
+	if err != nil {
+		return 0, err
+	}
+	return uint32(v), nil
+}
+
+// formatValue formats a value of a kind in the form it is parsed from
+func formatValue(kind string, v uint32) string {
+	if kind == KindIP {
+		ip := make(net.IP, net.IPv4len)
+		binary.BigEndian.PutUint32(ip, v)
+		return ip.String()
+	}
+
+	return strconv.FormatUint(uint64(v), 10)
+}
+
+// formatRanges formats ranges of a kind in the form they are parsed from
+func formatRanges(kind string, ranges []Range) []string {
+	values := make([]string, len(ranges))
+	for i, r := range ranges {
+		if r.From == r.To {
+			values[i] = formatValue(kind, r.From)
+			continue
+		}
+		values[i] = formatValue(kind, r.From) + "-" + formatValue(kind, r.To)
+	}
+
+	return values
+}
+
+// isValid reports whether the range is not empty and contains valid values of a kind
+// only
+func (r Range) isValid(kind string) bool {
+	if kind == KindPort && (r.From == 0 || r.To > maxPort) {
+		return false
+	}
+
+	return r.From <= r.To
+}
+
+// contains reports whether a value is within the range
+func (r Range) contains(v uint32) bool {
+	return v >= r.From && v <= r.To
+}
+
+// overlaps reports whether the range shares a value with another one
+func (r Range) overlaps(other Range) bool {
+	return r.From <= other.To && other.From <= r.To
+}
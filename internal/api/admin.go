@@ -0,0 +1,72 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/dnsinogeorgos/conductor/internal/conductor"
+	"github.com/dnsinogeorgos/conductor/internal/opmanager"
+)
+
+// AdminResource embeds the conductor type to allow extending it's interface with
+// handlers
+type AdminResource struct {
+	*conductor.Conductor
+}
+
+// ReconciliationResponse describes the API reconciliation response object
+type ReconciliationResponse struct {
+	Policy  string           `json:"policy"`
+	Orphans []OrphanResponse `json:"orphans"`
+}
+
+// OrphanResponse describes the API orphan response object
+type OrphanResponse struct {
+	Kind   string `json:"kind"`
+	Name   string `json:"name"`
+	Action string `json:"action"`
+	Error  string `json:"error,omitempty"`
+}
+
+// AdminReconcilePost queues a reconciliation pass with the requested policy.
+func (ar AdminResource) AdminReconcilePost(w http.ResponseWriter, r *http.Request) {
+	policy := r.URL.Query().Get("policy")
+	if policy == "" {
+		policy = conductor.ReconcileReport
+	}
+
+	op, err := ar.Reconcile(policy)
+	if err != nil {
+		switch e := err.(type) {
+		case conductor.UnknownPolicyError:
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		case opmanager.QueueFullError, opmanager.ShuttingDownError:
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		default:
+			_ = e
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+	acceptOperation(w, r, op)
+}
+
+// newReconciliationResponse converts a reconciliation to the API reconciliation response
+// object
+func newReconciliationResponse(rec conductor.Reconciliation) ReconciliationResponse {
+	result := ReconciliationResponse{
+		Policy:  rec.Policy,
+		Orphans: make([]OrphanResponse, 0),
+	}
+	for _, orphan := range rec.Orphans {
+		result.Orphans = append(result.Orphans, OrphanResponse{
+			Kind:   orphan.Kind,
+			Name:   orphan.Name,
+			Action: orphan.Action,
+			Error:  orphan.Error,
+		})
+	}
+
+	return result
+}
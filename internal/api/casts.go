@@ -2,8 +2,10 @@ package api
 
 import (
 	"net/http"
+	"strings"
 
 	"github.com/dnsinogeorgos/conductor/internal/conductor"
+	"github.com/dnsinogeorgos/conductor/internal/opmanager"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/render"
 )
@@ -16,33 +18,181 @@ type CastsResource struct {
 
 // CastResponse describes the API cast response object
 type CastResponse struct {
-	Id        string `json:"id"`
-	Timestamp string `json:"timestamp"`
+	Id                string            `json:"id"`
+	Timestamp         string            `json:"timestamp"`
+	Quiesce           string            `json:"quiesce,omitempty"`
+	QuiesceDurationMs int64             `json:"quiesceDurationMs"`
+	SourceCastId      string            `json:"sourceCastId,omitempty"`
+	SourceReplicaId   string            `json:"sourceReplicaId,omitempty"`
+	SourceCheckpoint  string            `json:"sourceCheckpoint,omitempty"`
+	ExpiresAt         string            `json:"expiresAt,omitempty"`
+	Protected         bool              `json:"protected"`
+	Properties        map[string]string `json:"properties,omitempty"`
+	Usage             *UsageResponse    `json:"usage,omitempty"`
 }
 
-// CastsIdDelete deletes a cast from the filesystem.
+// CastDeletionResponse describes the API response object of a cascading cast deletion
+type CastDeletionResponse struct {
+	Replicas []ReplicaDeletionResponse `json:"replicas"`
+}
+
+// ReplicaDeletionResponse describes the outcome of the deletion of a replica during a
+// cascading cast deletion
+type ReplicaDeletionResponse struct {
+	Id      string `json:"id"`
+	Trashed bool   `json:"trashed,omitempty"`
+	Deleted bool   `json:"deleted"`
+	Status  int    `json:"status,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// CastsIdDelete queues the deletion of a cast from the filesystem. The replicas of the
+// cast are deleted along with it if the cascade query parameter is true, and protection
+// is overridden if the force query parameter is true.
 func (cr CastsResource) CastsIdDelete(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
 
-	err := cr.DeleteCast(id)
+	cascade, ok := parseFlag(r, "cascade")
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	force, ok := parseFlag(r, "force")
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	op, err := cr.DeleteCast(id, cascade, force)
 	if err != nil {
 		switch e := err.(type) {
-		case conductor.CastNotEmpty:
+		case conductor.CastNotEmpty, conductor.ReplicaInUseError:
 			w.WriteHeader(http.StatusConflict)
 			return
+		case conductor.CastProtectedError, conductor.ReplicaProtectedError:
+			w.WriteHeader(http.StatusLocked)
+			return
+		case conductor.CastNotFoundError:
+			w.WriteHeader(http.StatusNotFound)
+			return
+		case opmanager.QueueFullError, opmanager.ShuttingDownError:
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		default:
+			_ = e
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+	acceptOperation(w, r, op)
+}
+
+// CastsIdRefreshPost queues the refresh of a cast from a new snapshot of the filesystem.
+// The replicas listed in the replicas query parameter are recreated with the same names
+// and ports, and the rest are deleted. All the replicas are kept if it is omitted.
+func (cr CastsResource) CastsIdRefreshPost(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	var replicas []string
+	if values, ok := r.URL.Query()["replicas"]; ok {
+		replicas = make([]string, 0)
+		for _, value := range values {
+			for _, replicaId := range strings.Split(value, ",") {
+				if replicaId != "" {
+					replicas = append(replicas, replicaId)
+				}
+			}
+		}
+	}
+
+	op, err := cr.RefreshCast(id, replicas)
+	if err != nil {
+		switch e := err.(type) {
 		case conductor.CastNotFoundError:
 			w.WriteHeader(http.StatusNotFound)
 			return
+		case conductor.ReplicaNotFoundError:
+			w.WriteHeader(http.StatusNotFound)
+			return
+		case conductor.ReplicaInUseError, conductor.CastNotEmpty:
+			w.WriteHeader(http.StatusConflict)
+			return
+		case conductor.ReplicaProtectedError:
+			w.WriteHeader(http.StatusLocked)
+			return
+		case conductor.InsufficientSpaceError:
+			w.WriteHeader(http.StatusInsufficientStorage)
+			return
+		case opmanager.QueueFullError, opmanager.ShuttingDownError:
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
 		default:
 			_ = e
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
 	}
-	w.WriteHeader(http.StatusNoContent)
+	acceptOperation(w, r, op)
 }
 
-// CastsIdGet gets a cast from the filesystem.
+// CastsIdExtendPost sets the expiry of a cast to the ttl query parameter from now, or to
+// the expiresAt query parameter.
+func (cr CastsResource) CastsIdExtendPost(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	expiresAt, ok := parseExpiry(r)
+	if !ok || expiresAt.IsZero() {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	cast, err := cr.ExtendCast(id, expiresAt)
+	if err != nil {
+		switch e := err.(type) {
+		case conductor.CastNotFoundError:
+			w.WriteHeader(http.StatusNotFound)
+			return
+		default:
+			_ = e
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+
+	render.JSON(w, r, newCastResponse(cast))
+}
+
+// CastsIdProtectPost protects a cast from deletion.
+func (cr CastsResource) CastsIdProtectPost(w http.ResponseWriter, r *http.Request) {
+	cr.protect(w, r, true)
+}
+
+// CastsIdProtectDelete removes the protection of a cast from deletion.
+func (cr CastsResource) CastsIdProtectDelete(w http.ResponseWriter, r *http.Request) {
+	cr.protect(w, r, false)
+}
+
+// protect sets whether a cast is protected from deletion and responds with the cast
+func (cr CastsResource) protect(w http.ResponseWriter, r *http.Request, protected bool) {
+	id := chi.URLParam(r, "id")
+
+	cast, err := cr.ProtectCast(id, protected)
+	if err != nil {
+		switch e := err.(type) {
+		case conductor.CastNotFoundError:
+			w.WriteHeader(http.StatusNotFound)
+			return
+		default:
+			_ = e
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+
+	render.JSON(w, r, newCastResponse(cast))
+}
+
+// CastsIdGet gets a cast from the filesystem along with its space accounting.
 func (cr CastsResource) CastsIdGet(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
 
@@ -59,49 +209,117 @@ func (cr CastsResource) CastsIdGet(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	result := CastResponse{
-		Id:        cast.Id,
-		Timestamp: cast.Timestamp,
-	}
+	usage := getUsage(cr.Conductor)
+	result := newCastResponse(cast)
+	result.Usage = newUsageResponse(lookupUsage(usage.Casts, cast.Id))
 	render.JSON(w, r, result)
 }
 
-// CastsIdPost creates a cast on the filesystem.
+// CastsIdPost queues the creation of a cast on the filesystem, or from a replica if the
+// sourceCastId and sourceReplicaId query parameters are provided. The cast expires once
+// it is empty if the ttl or expiresAt query parameter is provided, and the property query
+// parameters override the configured ZFS properties of its dataset.
 func (cr CastsResource) CastsIdPost(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
+	sourceCastId := r.URL.Query().Get("sourceCastId")
+	sourceReplicaId := r.URL.Query().Get("sourceReplicaId")
+	checkpoint := r.URL.Query().Get("checkpoint")
+
+	expiresAt, ok := parseExpiry(r)
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	properties, ok := parseProperties(r)
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
 
-	cast, err := cr.CreateCast(id)
+	var op opmanager.Operation
+	var err error
+	switch {
+	case sourceCastId == "" && sourceReplicaId == "" && checkpoint == "":
+		op, err = cr.CreateCast(id, expiresAt, properties)
+	case sourceCastId != "" && sourceReplicaId != "":
+		op, err = cr.CreateCastFromReplica(id, sourceCastId, sourceReplicaId, checkpoint, expiresAt, properties)
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
 	if err != nil {
 		switch e := err.(type) {
+		case conductor.InvalidPropertyError:
+			w.WriteHeader(http.StatusBadRequest)
+			return
 		case conductor.CastAlreadyExistsError:
 			w.WriteHeader(http.StatusConflict)
 			return
+		case conductor.CastNotFoundError, conductor.ReplicaNotFoundError, conductor.CheckpointNotFoundError:
+			w.WriteHeader(http.StatusNotFound)
+			return
+		case conductor.InsufficientSpaceError:
+			w.WriteHeader(http.StatusInsufficientStorage)
+			return
+		case opmanager.QueueFullError, opmanager.ShuttingDownError:
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
 		default:
 			_ = e
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
 	}
-
-	result := CastResponse{
-		Id:        cast.Id,
-		Timestamp: cast.Timestamp,
-	}
-	w.WriteHeader(http.StatusCreated)
-	render.JSON(w, r, result)
+	acceptOperation(w, r, op)
 }
 
-// CastsGet returns a list of the casts on the filesystem.
+// CastsGet returns a list of the casts on the filesystem along with their space
+// accounting.
 func (cr CastsResource) CastsGet(w http.ResponseWriter, r *http.Request) {
 
 	casts := cr.ListCasts()
+	usage := getUsage(cr.Conductor)
 	result := make([]CastResponse, 0)
 	for _, cast := range casts {
-		item := CastResponse{
-			Id:        cast.Id,
-			Timestamp: cast.Timestamp,
-		}
+		item := newCastResponse(cast)
+		item.Usage = newUsageResponse(lookupUsage(usage.Casts, cast.Id))
 		result = append(result, item)
 	}
 	render.JSON(w, r, result)
 }
+
+// newCastResponse converts a cast to the API cast response object
+func newCastResponse(cast *conductor.Cast) CastResponse {
+	return CastResponse{
+		Id:                cast.Id,
+		Timestamp:         cast.Timestamp,
+		Quiesce:           cast.Quiesce,
+		QuiesceDurationMs: cast.QuiesceDuration.Milliseconds(),
+		SourceCastId:      cast.SourceCastId,
+		SourceReplicaId:   cast.SourceReplicaId,
+		SourceCheckpoint:  cast.SourceCheckpoint,
+		ExpiresAt:         formatExpiry(cast.ExpiresAt),
+		Protected:         cast.Protected,
+		Properties:        cast.Properties,
+	}
+}
+
+// newCastDeletionResponse converts the outcome of a cascading cast deletion to the API
+// response object
+func newCastDeletionResponse(deletion conductor.CastDeletion) CastDeletionResponse {
+	result := CastDeletionResponse{Replicas: make([]ReplicaDeletionResponse, 0)}
+	for _, replica := range deletion.Replicas {
+		item := ReplicaDeletionResponse{
+			Id:      replica.Id,
+			Trashed: replica.Trashed,
+			Deleted: replica.Err == nil,
+		}
+		if replica.Err != nil {
+			item.Status = errorStatus(replica.Err)
+			item.Error = replica.Err.Error()
+		}
+		result.Replicas = append(result.Replicas, item)
+	}
+
+	return result
+}
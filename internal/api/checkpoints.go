@@ -0,0 +1,145 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/dnsinogeorgos/conductor/internal/conductor"
+	"github.com/dnsinogeorgos/conductor/internal/opmanager"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+)
+
+// CheckpointResponse describes the API checkpoint response object
+type CheckpointResponse struct {
+	Name      string `json:"name"`
+	Timestamp string `json:"timestamp,omitempty"`
+}
+
+// ReplicasCastIdIdCheckpointsGet returns a list of the checkpoints of a replica.
+func (rr ReplicasResource) ReplicasCastIdIdCheckpointsGet(w http.ResponseWriter, r *http.Request) {
+	castId := chi.URLParam(r, "castId")
+	id := chi.URLParam(r, "id")
+
+	checkpoints, err := rr.ListCheckpoints(castId, id)
+	if err != nil {
+		switch e := err.(type) {
+		case conductor.CastNotFoundError:
+			w.WriteHeader(http.StatusNotFound)
+			return
+		case conductor.ReplicaNotFoundError:
+			w.WriteHeader(http.StatusNotFound)
+			return
+		default:
+			_ = e
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+
+	result := make([]CheckpointResponse, 0)
+	for _, checkpoint := range checkpoints {
+		item := CheckpointResponse{
+			Name:      checkpoint.Name,
+			Timestamp: checkpoint.Timestamp,
+		}
+		result = append(result, item)
+	}
+	render.JSON(w, r, result)
+}
+
+// ReplicasCastIdIdCheckpointsNamePost queues the creation of a checkpoint of a replica.
+func (rr ReplicasResource) ReplicasCastIdIdCheckpointsNamePost(w http.ResponseWriter, r *http.Request) {
+	castId := chi.URLParam(r, "castId")
+	id := chi.URLParam(r, "id")
+	name := chi.URLParam(r, "name")
+
+	op, err := rr.CreateCheckpoint(castId, id, name)
+	if err != nil {
+		switch e := err.(type) {
+		case conductor.CastNotFoundError:
+			w.WriteHeader(http.StatusNotFound)
+			return
+		case conductor.ReplicaNotFoundError:
+			w.WriteHeader(http.StatusNotFound)
+			return
+		case conductor.CheckpointAlreadyExistsError:
+			w.WriteHeader(http.StatusConflict)
+			return
+		case conductor.InvalidCheckpointNameError:
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		case opmanager.QueueFullError, opmanager.ShuttingDownError:
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		default:
+			_ = e
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+	acceptOperation(w, r, op)
+}
+
+// ReplicasCastIdIdCheckpointsNameRestorePost queues the rollback of a replica to one of
+// its checkpoints. Checkpoints taken after it are discarded.
+func (rr ReplicasResource) ReplicasCastIdIdCheckpointsNameRestorePost(w http.ResponseWriter, r *http.Request) {
+	castId := chi.URLParam(r, "castId")
+	id := chi.URLParam(r, "id")
+	name := chi.URLParam(r, "name")
+
+	op, err := rr.RestoreCheckpoint(castId, id, name)
+	if err != nil {
+		switch e := err.(type) {
+		case conductor.CastNotFoundError:
+			w.WriteHeader(http.StatusNotFound)
+			return
+		case conductor.ReplicaNotFoundError:
+			w.WriteHeader(http.StatusNotFound)
+			return
+		case conductor.CheckpointNotFoundError:
+			w.WriteHeader(http.StatusNotFound)
+			return
+		case opmanager.QueueFullError, opmanager.ShuttingDownError:
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		default:
+			_ = e
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+	acceptOperation(w, r, op)
+}
+
+// ReplicasCastIdIdCheckpointsNameDelete queues the deletion of a checkpoint of a replica.
+func (rr ReplicasResource) ReplicasCastIdIdCheckpointsNameDelete(w http.ResponseWriter, r *http.Request) {
+	castId := chi.URLParam(r, "castId")
+	id := chi.URLParam(r, "id")
+	name := chi.URLParam(r, "name")
+
+	op, err := rr.DeleteCheckpoint(castId, id, name)
+	if err != nil {
+		switch e := err.(type) {
+		case conductor.CastNotFoundError:
+			w.WriteHeader(http.StatusNotFound)
+			return
+		case conductor.ReplicaNotFoundError:
+			w.WriteHeader(http.StatusNotFound)
+			return
+		case conductor.CheckpointNotFoundError:
+			w.WriteHeader(http.StatusNotFound)
+			return
+		case conductor.CheckpointInUseError:
+			w.WriteHeader(http.StatusConflict)
+			return
+		case opmanager.QueueFullError, opmanager.ShuttingDownError:
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		default:
+			_ = e
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+	acceptOperation(w, r, op)
+}
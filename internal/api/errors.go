@@ -0,0 +1,38 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/dnsinogeorgos/conductor/internal/conductor"
+	"github.com/dnsinogeorgos/conductor/internal/opmanager"
+)
+
+// errorStatus returns the HTTP status that corresponds to an error of the conductor
+func errorStatus(err error) int {
+	switch err.(type) {
+	case conductor.UnknownPolicyError, conductor.InvalidCheckpointNameError, conductor.InvalidPropertyError,
+		conductor.InvalidPortError, conductor.InvalidEndpointError:
+		return http.StatusBadRequest
+	case conductor.CastNotFoundError, conductor.ReplicaNotFoundError, conductor.CheckpointNotFoundError,
+		opmanager.OperationNotFoundError:
+		return http.StatusNotFound
+	case conductor.CastAlreadyExistsError, conductor.ReplicaAlreadyExistsError, conductor.CastNotEmpty,
+		conductor.CheckpointAlreadyExistsError, conductor.ReplicaInUseError, conductor.CheckpointInUseError,
+		conductor.DatasetBusyError, conductor.PortError, conductor.CascadeDeleteError,
+		conductor.PortUnavailableError, conductor.ResourceError, conductor.ReplicaHasCheckpointsError:
+		return http.StatusConflict
+	case conductor.CastProtectedError, conductor.ReplicaProtectedError:
+		return http.StatusLocked
+	case conductor.PortsExhaustedError, conductor.ResourcesExhaustedError, opmanager.QueueFullError,
+		opmanager.ShuttingDownError:
+		return http.StatusServiceUnavailable
+	case conductor.InsufficientSpaceError:
+		return http.StatusInsufficientStorage
+	case conductor.UnitError:
+		return http.StatusBadGateway
+	case conductor.HookError:
+		return http.StatusFailedDependency
+	default:
+		return http.StatusInternalServerError
+	}
+}
@@ -0,0 +1,42 @@
+package api
+
+import (
+	"net/http"
+	"time"
+)
+
+// parseExpiry reads the expiry of a request from either the ttl query parameter, a
+// duration such as 90m counted from now, or the expiresAt query parameter, an RFC 3339
+// timestamp. It returns the zero time if neither is provided, and false if they are both
+// provided or invalid.
+func parseExpiry(r *http.Request) (time.Time, bool) {
+	ttl := r.URL.Query().Get("ttl")
+	expiresAt := r.URL.Query().Get("expiresAt")
+
+	switch {
+	case ttl != "" && expiresAt != "":
+		return time.Time{}, false
+	case ttl != "":
+		d, err := time.ParseDuration(ttl)
+		if err != nil || d <= 0 {
+			return time.Time{}, false
+		}
+		return time.Now().UTC().Add(d), true
+	case expiresAt != "":
+		t, err := time.Parse(time.RFC3339, expiresAt)
+		if err != nil || !t.After(time.Now()) {
+			return time.Time{}, false
+		}
+		return t.UTC(), true
+	default:
+		return time.Time{}, true
+	}
+}
+
+// formatExpiry formats an expiry for the API responses, which omit it if it is zero
+func formatExpiry(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
@@ -18,6 +18,10 @@ func NewRouter(cnd *conductor.Conductor) *chi.Mux {
 
 	r.Mount("/casts", CastsResource{cnd}.Routes())
 	r.Mount("/replicas", ReplicasResource{cnd}.Routes())
+	r.Mount("/trash", TrashResource{cnd}.Routes())
+	r.Mount("/pool", PoolResource{cnd}.Routes())
+	r.Mount("/operations", OperationsResource{cnd}.Routes())
+	r.Mount("/admin", AdminResource{cnd}.Routes())
 
 	return r
 }
@@ -31,6 +35,10 @@ func (cr CastsResource) Routes() chi.Router {
 		r.Get("/", cr.CastsIdGet)
 		r.Post("/", cr.CastsIdPost)
 		r.Delete("/", cr.CastsIdDelete)
+		r.Post("/refresh", cr.CastsIdRefreshPost)
+		r.Post("/extend", cr.CastsIdExtendPost)
+		r.Post("/protect", cr.CastsIdProtectPost)
+		r.Delete("/protect", cr.CastsIdProtectDelete)
 	})
 
 	return r
@@ -46,7 +54,57 @@ func (rr ReplicasResource) Routes() chi.Router {
 		r.Get("/", rr.ReplicasCastIdIdGet)
 		r.Post("/", rr.ReplicasCastIdIdPost)
 		r.Delete("/", rr.ReplicasCastIdIdDelete)
+		r.Post("/reset", rr.ReplicasCastIdIdResetPost)
+		r.Post("/extend", rr.ReplicasCastIdIdExtendPost)
+		r.Post("/restore", rr.ReplicasCastIdIdRestorePost)
+		r.Post("/protect", rr.ReplicasCastIdIdProtectPost)
+		r.Delete("/protect", rr.ReplicasCastIdIdProtectDelete)
+		r.Get("/checkpoints", rr.ReplicasCastIdIdCheckpointsGet)
+		r.Route("/checkpoints/{name}", func(r chi.Router) {
+			r.Post("/", rr.ReplicasCastIdIdCheckpointsNamePost)
+			r.Delete("/", rr.ReplicasCastIdIdCheckpointsNameDelete)
+			r.Post("/restore", rr.ReplicasCastIdIdCheckpointsNameRestorePost)
+		})
 	})
 
 	return r
 }
+
+// Routes creates a REST router for the trash resource.
+func (tr TrashResource) Routes() chi.Router {
+	r := chi.NewRouter()
+
+	r.Get("/{castId}", tr.TrashCastIdGet)
+	r.Get("/{castId}/", tr.TrashCastIdGet)
+	r.Delete("/{castId}/{id}", tr.TrashCastIdIdDelete)
+
+	return r
+}
+
+// Routes creates a REST router for the pool resource.
+func (pr PoolResource) Routes() chi.Router {
+	r := chi.NewRouter()
+
+	r.Get("/", pr.PoolGet)
+
+	return r
+}
+
+// Routes creates a REST router for the operations resource.
+func (or OperationsResource) Routes() chi.Router {
+	r := chi.NewRouter()
+
+	r.Get("/", or.OperationsGet)
+	r.Get("/{id}", or.OperationsIdGet)
+
+	return r
+}
+
+// Routes creates a REST router for the admin resource.
+func (ar AdminResource) Routes() chi.Router {
+	r := chi.NewRouter()
+
+	r.Post("/reconcile", ar.AdminReconcilePost)
+
+	return r
+}
@@ -0,0 +1,126 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/dnsinogeorgos/conductor/internal/conductor"
+	"github.com/dnsinogeorgos/conductor/internal/opmanager"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+)
+
+// OperationsResource embeds the conductor type to allow the use of its exported methods
+type OperationsResource struct {
+	*conductor.Conductor
+}
+
+// OperationResponse describes the API operation response object
+type OperationResponse struct {
+	Id        string           `json:"id"`
+	Kind      string           `json:"kind"`
+	CastId    string           `json:"castId"`
+	ReplicaId string           `json:"replicaId,omitempty"`
+	State     string           `json:"state"`
+	Created   string           `json:"created"`
+	Started   string           `json:"started,omitempty"`
+	Finished  string           `json:"finished,omitempty"`
+	Status    int              `json:"status,omitempty"`
+	Error     string           `json:"error,omitempty"`
+	Result    interface{}      `json:"result,omitempty"`
+	Outputs   []OutputResponse `json:"outputs,omitempty"`
+}
+
+// OutputResponse describes the API output response object
+type OutputResponse struct {
+	Name       string `json:"name"`
+	Command    string `json:"command"`
+	Stdout     string `json:"stdout"`
+	Stderr     string `json:"stderr"`
+	ExitCode   int    `json:"exitCode"`
+	DurationMs int64  `json:"durationMs"`
+	Error      string `json:"error,omitempty"`
+}
+
+// OperationsIdGet gets an operation from the queue.
+func (or OperationsResource) OperationsIdGet(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	op, err := or.GetOperation(id)
+	if err != nil {
+		switch e := err.(type) {
+		case opmanager.OperationNotFoundError:
+			w.WriteHeader(http.StatusNotFound)
+			return
+		default:
+			_ = e
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+
+	render.JSON(w, r, newOperationResponse(op))
+}
+
+// OperationsGet returns a list of the known operations.
+func (or OperationsResource) OperationsGet(w http.ResponseWriter, r *http.Request) {
+	ops := or.ListOperations()
+	result := make([]OperationResponse, 0)
+	for _, op := range ops {
+		result = append(result, newOperationResponse(op))
+	}
+	render.JSON(w, r, result)
+}
+
+// acceptOperation responds with the operation that was queued for the request
+func acceptOperation(w http.ResponseWriter, r *http.Request, op opmanager.Operation) {
+	w.Header().Set("Location", "/operations/"+op.Id)
+	w.WriteHeader(http.StatusAccepted)
+	render.JSON(w, r, newOperationResponse(op))
+}
+
+// newOperationResponse converts an operation to the API operation response object
+func newOperationResponse(op opmanager.Operation) OperationResponse {
+	result := OperationResponse{
+		Id:        op.Id,
+		Kind:      op.Kind,
+		CastId:    op.CastId,
+		ReplicaId: op.ReplicaId,
+		State:     string(op.State),
+		Created:   op.Created.Format(time.RFC3339),
+	}
+	if !op.Started.IsZero() {
+		result.Started = op.Started.Format(time.RFC3339)
+	}
+	if !op.Finished.IsZero() {
+		result.Finished = op.Finished.Format(time.RFC3339)
+	}
+	if op.Err != nil {
+		result.Status = errorStatus(op.Err)
+		result.Error = op.Err.Error()
+	}
+	switch res := op.Result.(type) {
+	case conductor.Reconciliation:
+		result.Result = newReconciliationResponse(res)
+	case conductor.CastDeletion:
+		result.Result = newCastDeletionResponse(res)
+	case *conductor.Checkpoint:
+		result.Result = CheckpointResponse{Name: res.Name, Timestamp: res.Timestamp}
+	}
+	for _, output := range op.Outputs {
+		item := OutputResponse{
+			Name:       output.Name,
+			Command:    output.Command,
+			Stdout:     output.Stdout,
+			Stderr:     output.Stderr,
+			ExitCode:   output.ExitCode,
+			DurationMs: output.Duration.Milliseconds(),
+		}
+		if output.Err != nil {
+			item.Error = output.Err.Error()
+		}
+		result.Outputs = append(result.Outputs, item)
+	}
+
+	return result
+}
@@ -0,0 +1,61 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// parseFlag reads a boolean query parameter of the request. A missing parameter is
+// false, and false is returned as the second value if it is not a boolean.
+func parseFlag(r *http.Request, name string) (bool, bool) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return false, true
+	}
+
+	flag, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, false
+	}
+	return flag, true
+}
+
+// parsePort reads the port query parameter of the request. A missing parameter is zero,
+// and false is returned as the second value if it is not a port number.
+func parsePort(r *http.Request) (int32, bool) {
+	value := r.URL.Query().Get("port")
+	if value == "" {
+		return 0, true
+	}
+
+	port, err := strconv.ParseInt(value, 10, 32)
+	if err != nil || port <= 0 {
+		return 0, false
+	}
+	return int32(port), true
+}
+
+// parseProperties reads the ZFS properties of the request from the property query
+// parameters, each one a key=value pair. It returns nil if none are provided, and false
+// if one of them is not a pair or a key is repeated.
+func parseProperties(r *http.Request) (map[string]string, bool) {
+	values := r.URL.Query()["property"]
+	if len(values) == 0 {
+		return nil, true
+	}
+
+	properties := make(map[string]string, len(values))
+	for _, value := range values {
+		pair := strings.SplitN(value, "=", 2)
+		if len(pair) != 2 || pair[0] == "" {
+			return nil, false
+		}
+		if _, ok := properties[pair[0]]; ok {
+			return nil, false
+		}
+		properties[pair[0]] = pair[1]
+	}
+
+	return properties, true
+}
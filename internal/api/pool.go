@@ -0,0 +1,86 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/dnsinogeorgos/conductor/internal/conductor"
+	"github.com/go-chi/render"
+)
+
+// PoolResource embeds the conductor type to allow extending it's interface with
+// handlers
+type PoolResource struct {
+	*conductor.Conductor
+}
+
+// PoolResponse describes the API pool response object
+type PoolResponse struct {
+	Name      string `json:"name"`
+	Size      uint64 `json:"size"`
+	Allocated uint64 `json:"allocated"`
+	Free      uint64 `json:"free"`
+	Available uint64 `json:"available"`
+	Used      uint64 `json:"used"`
+}
+
+// UsageResponse describes the API space accounting object of a cast or replica
+type UsageResponse struct {
+	Used        uint64 `json:"used"`
+	Referenced  uint64 `json:"referenced"`
+	Written     uint64 `json:"written"`
+	LogicalUsed uint64 `json:"logicalUsed"`
+}
+
+// PoolGet returns the size and the usage of the pool.
+func (pr PoolResource) PoolGet(w http.ResponseWriter, r *http.Request) {
+	pool, err := pr.GetPool()
+	if err != nil {
+		switch e := err.(type) {
+		default:
+			_ = e
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+
+	render.JSON(w, r, PoolResponse{
+		Name:      pool.Name,
+		Size:      pool.Size,
+		Allocated: pool.Allocated,
+		Free:      pool.Free,
+		Available: pool.Available,
+		Used:      pool.Used,
+	})
+}
+
+// getUsage reads the space accounting of the casts and replicas for a response. The
+// usage is left out of the response if it cannot be read.
+func getUsage(cnd *conductor.Conductor) conductor.UsageReport {
+	usage, err := cnd.GetUsage()
+	if err != nil {
+		return conductor.UsageReport{}
+	}
+
+	return usage
+}
+
+// lookupUsage returns the usage of a cast or replica by id and whether it was found
+func lookupUsage(usage map[string]conductor.Usage, id string) (conductor.Usage, bool) {
+	u, ok := usage[id]
+	return u, ok
+}
+
+// newUsageResponse converts the usage of a cast or replica to the API usage object, or
+// nil if it was not found
+func newUsageResponse(usage conductor.Usage, ok bool) *UsageResponse {
+	if !ok {
+		return nil
+	}
+
+	return &UsageResponse{
+		Used:        usage.Used,
+		Referenced:  usage.Referenced,
+		Written:     usage.Written,
+		LogicalUsed: usage.LogicalUsed,
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"net/http"
 
 	"github.com/dnsinogeorgos/conductor/internal/conductor"
+	"github.com/dnsinogeorgos/conductor/internal/opmanager"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/render"
 )
@@ -15,18 +16,97 @@ type ReplicasResource struct {
 
 // ReplicaResponse describes the API replica response object
 type ReplicaResponse struct {
-	Id     string `json:"id"`
-	CastId string `json:"castId"`
-	Port   int32  `json:"port"`
-	Error  string `json:"error,omitempty"`
+	Id         string            `json:"id"`
+	CastId     string            `json:"castId"`
+	Port       int32             `json:"port"`
+	Socket     string            `json:"socket,omitempty"`
+	Ports      map[string]int32  `json:"ports,omitempty"`
+	Resources  map[string]string `json:"resources,omitempty"`
+	ExpiresAt  string            `json:"expiresAt,omitempty"`
+	DeletedAt  string            `json:"deletedAt,omitempty"`
+	PurgeAt    string            `json:"purgeAt,omitempty"`
+	Protected  bool              `json:"protected"`
+	Properties map[string]string `json:"properties,omitempty"`
+	Usage      *UsageResponse    `json:"usage,omitempty"`
+	Error      string            `json:"error,omitempty"`
 }
 
-// ReplicasCastIdIdDelete deletes a replica from the provided cast.
+// ReplicasCastIdIdDelete queues the deletion of a replica from the provided cast. The
+// protection of the replica is overridden if the force query parameter is true.
 func (rr ReplicasResource) ReplicasCastIdIdDelete(w http.ResponseWriter, r *http.Request) {
 	castId := chi.URLParam(r, "castId")
 	id := chi.URLParam(r, "id")
 
-	err := rr.DeleteReplica(castId, id)
+	force, ok := parseFlag(r, "force")
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	op, err := rr.DeleteReplica(castId, id, force)
+	if err != nil {
+		switch e := err.(type) {
+		case conductor.CastNotFoundError:
+			w.WriteHeader(http.StatusNotFound)
+			return
+		case conductor.ReplicaNotFoundError:
+			w.WriteHeader(http.StatusNotFound)
+			return
+		case conductor.ReplicaInUseError:
+			w.WriteHeader(http.StatusConflict)
+			return
+		case conductor.ReplicaProtectedError:
+			w.WriteHeader(http.StatusLocked)
+			return
+		case opmanager.QueueFullError, opmanager.ShuttingDownError:
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		default:
+			_ = e
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+	acceptOperation(w, r, op)
+}
+
+// ReplicasCastIdIdResetPost queues the reset of a replica to the state of its origin
+// snapshot.
+func (rr ReplicasResource) ReplicasCastIdIdResetPost(w http.ResponseWriter, r *http.Request) {
+	castId := chi.URLParam(r, "castId")
+	id := chi.URLParam(r, "id")
+
+	op, err := rr.ResetReplica(castId, id)
+	if err != nil {
+		switch e := err.(type) {
+		case conductor.CastNotFoundError:
+			w.WriteHeader(http.StatusNotFound)
+			return
+		case conductor.ReplicaNotFoundError:
+			w.WriteHeader(http.StatusNotFound)
+			return
+		case conductor.ReplicaInUseError, conductor.ReplicaHasCheckpointsError:
+			w.WriteHeader(http.StatusConflict)
+			return
+		case opmanager.QueueFullError, opmanager.ShuttingDownError:
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		default:
+			_ = e
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+	acceptOperation(w, r, op)
+}
+
+// ReplicasCastIdIdRestorePost queues the restore of a deleted replica from the trash of
+// the provided cast.
+func (rr ReplicasResource) ReplicasCastIdIdRestorePost(w http.ResponseWriter, r *http.Request) {
+	castId := chi.URLParam(r, "castId")
+	id := chi.URLParam(r, "id")
+
+	op, err := rr.RestoreTrashedReplica(castId, id)
 	if err != nil {
 		switch e := err.(type) {
 		case conductor.CastNotFoundError:
@@ -35,16 +115,33 @@ func (rr ReplicasResource) ReplicasCastIdIdDelete(w http.ResponseWriter, r *http
 		case conductor.ReplicaNotFoundError:
 			w.WriteHeader(http.StatusNotFound)
 			return
+		case conductor.ReplicaAlreadyExistsError:
+			w.WriteHeader(http.StatusConflict)
+			return
+		case conductor.PortsExhaustedError:
+			result := ReplicaResponse{
+				CastId: castId,
+				Id:     id,
+				Port:   0,
+				Error:  e.Error(),
+			}
+			w.WriteHeader(http.StatusServiceUnavailable)
+			render.JSON(w, r, result)
+			return
+		case opmanager.QueueFullError, opmanager.ShuttingDownError:
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
 		default:
 			_ = e
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
 	}
-	w.WriteHeader(http.StatusNoContent)
+	acceptOperation(w, r, op)
 }
 
-// ReplicasCastIdIdGet gets a replica from the provided cast.
+// ReplicasCastIdIdGet gets a replica from the provided cast along with its space
+// accounting.
 func (rr ReplicasResource) ReplicasCastIdIdGet(w http.ResponseWriter, r *http.Request) {
 	castId := chi.URLParam(r, "castId")
 	id := chi.URLParam(r, "id")
@@ -65,30 +162,132 @@ func (rr ReplicasResource) ReplicasCastIdIdGet(w http.ResponseWriter, r *http.Re
 		}
 	}
 
-	result := ReplicaResponse{
-		CastId: castId,
-		Id:     replica.Id,
-		Port:   replica.Port,
-	}
+	usage := getUsage(rr.Conductor)
+	result := newReplicaResponse(castId, replica)
+	result.Usage = newUsageResponse(lookupUsage(usage.Replicas[castId], replica.Id))
 	w.WriteHeader(http.StatusOK)
 	render.JSON(w, r, result)
 }
 
-// ReplicasCastIdIdPost creates a replica in the provided cast.
+// ReplicasCastIdIdExtendPost sets the expiry of a replica to the ttl query parameter from
+// now, or to the expiresAt query parameter.
+func (rr ReplicasResource) ReplicasCastIdIdExtendPost(w http.ResponseWriter, r *http.Request) {
+	castId := chi.URLParam(r, "castId")
+	id := chi.URLParam(r, "id")
+
+	expiresAt, ok := parseExpiry(r)
+	if !ok || expiresAt.IsZero() {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	replica, err := rr.ExtendReplica(castId, id, expiresAt)
+	if err != nil {
+		switch e := err.(type) {
+		case conductor.CastNotFoundError:
+			w.WriteHeader(http.StatusNotFound)
+			return
+		case conductor.ReplicaNotFoundError:
+			w.WriteHeader(http.StatusNotFound)
+			return
+		default:
+			_ = e
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+
+	render.JSON(w, r, newReplicaResponse(castId, replica))
+}
+
+// ReplicasCastIdIdProtectPost protects a replica from deletion.
+func (rr ReplicasResource) ReplicasCastIdIdProtectPost(w http.ResponseWriter, r *http.Request) {
+	rr.protect(w, r, true)
+}
+
+// ReplicasCastIdIdProtectDelete removes the protection of a replica from deletion.
+func (rr ReplicasResource) ReplicasCastIdIdProtectDelete(w http.ResponseWriter, r *http.Request) {
+	rr.protect(w, r, false)
+}
+
+// protect sets whether a replica is protected from deletion and responds with the
+// replica
+func (rr ReplicasResource) protect(w http.ResponseWriter, r *http.Request, protected bool) {
+	castId := chi.URLParam(r, "castId")
+	id := chi.URLParam(r, "id")
+
+	replica, err := rr.ProtectReplica(castId, id, protected)
+	if err != nil {
+		switch e := err.(type) {
+		case conductor.CastNotFoundError:
+			w.WriteHeader(http.StatusNotFound)
+			return
+		case conductor.ReplicaNotFoundError:
+			w.WriteHeader(http.StatusNotFound)
+			return
+		default:
+			_ = e
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+
+	render.JSON(w, r, newReplicaResponse(castId, replica))
+}
+
+// ReplicasCastIdIdPost queues the creation of a replica in the provided cast. The
+// replica expires if the ttl or expiresAt query parameter is provided, the property
+// query parameters override the configured ZFS properties of its dataset, and the port
+// query parameter requests the port it is bound to. The socket query parameter gives it
+// a socket endpoint instead of a port.
 func (rr ReplicasResource) ReplicasCastIdIdPost(w http.ResponseWriter, r *http.Request) {
 	castId := chi.URLParam(r, "castId")
 	id := chi.URLParam(r, "id")
 
-	replica, err := rr.CreateReplica(castId, id)
+	expiresAt, ok := parseExpiry(r)
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	properties, ok := parseProperties(r)
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	port, ok := parsePort(r)
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	socket, ok := parseFlag(r, "socket")
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	op, err := rr.CreateReplica(castId, id, expiresAt, properties, port, socket)
 	if err != nil {
 		switch e := err.(type) {
+		case conductor.InvalidPropertyError, conductor.InvalidPortError, conductor.InvalidEndpointError:
+			w.WriteHeader(http.StatusBadRequest)
+			return
 		case conductor.CastNotFoundError:
 			w.WriteHeader(http.StatusNotFound)
 			return
 		case conductor.ReplicaAlreadyExistsError:
 			w.WriteHeader(http.StatusConflict)
 			return
-		case conductor.PortsExhaustedError:
+		case conductor.PortUnavailableError:
+			result := ReplicaResponse{
+				CastId: castId,
+				Id:     id,
+				Port:   port,
+				Error:  e.Error(),
+			}
+			w.WriteHeader(http.StatusConflict)
+			render.JSON(w, r, result)
+			return
+		case conductor.PortsExhaustedError, conductor.ResourcesExhaustedError:
 			result := ReplicaResponse{
 				CastId: castId,
 				Id:     id,
@@ -98,23 +297,23 @@ func (rr ReplicasResource) ReplicasCastIdIdPost(w http.ResponseWriter, r *http.R
 			w.WriteHeader(http.StatusServiceUnavailable)
 			render.JSON(w, r, result)
 			return
+		case conductor.InsufficientSpaceError:
+			w.WriteHeader(http.StatusInsufficientStorage)
+			return
+		case opmanager.QueueFullError, opmanager.ShuttingDownError:
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
 		default:
 			_ = e
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
 	}
-
-	result := ReplicaResponse{
-		CastId: castId,
-		Id:     replica.Id,
-		Port:   replica.Port,
-	}
-	w.WriteHeader(http.StatusCreated)
-	render.JSON(w, r, result)
+	acceptOperation(w, r, op)
 }
 
-// ReplicasCastIdGet returns a list of the replicas on a provided cast.
+// ReplicasCastIdGet returns a list of the replicas on a provided cast along with their
+// space accounting.
 func (rr ReplicasResource) ReplicasCastIdGet(w http.ResponseWriter, r *http.Request) {
 	castId := chi.URLParam(r, "castId")
 
@@ -131,14 +330,29 @@ func (rr ReplicasResource) ReplicasCastIdGet(w http.ResponseWriter, r *http.Requ
 		}
 	}
 
+	usage := getUsage(rr.Conductor)
 	result := make([]ReplicaResponse, 0)
 	for _, replica := range replicas {
-		item := ReplicaResponse{
-			CastId: castId,
-			Id:     replica.Id,
-			Port:   replica.Port,
-		}
+		item := newReplicaResponse(castId, replica)
+		item.Usage = newUsageResponse(lookupUsage(usage.Replicas[castId], replica.Id))
 		result = append(result, item)
 	}
 	render.JSON(w, r, result)
 }
+
+// newReplicaResponse converts a replica to the API replica response object
+func newReplicaResponse(castId string, replica *conductor.Replica) ReplicaResponse {
+	return ReplicaResponse{
+		CastId:     castId,
+		Id:         replica.Id,
+		Port:       replica.Port,
+		Socket:     replica.Socket,
+		Ports:      replica.Ports,
+		Resources:  replica.Resources,
+		ExpiresAt:  formatExpiry(replica.ExpiresAt),
+		DeletedAt:  formatExpiry(replica.DeletedAt),
+		PurgeAt:    formatExpiry(replica.PurgeAt),
+		Protected:  replica.Protected,
+		Properties: replica.Properties,
+	}
+}
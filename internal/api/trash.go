@@ -0,0 +1,67 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/dnsinogeorgos/conductor/internal/conductor"
+	"github.com/dnsinogeorgos/conductor/internal/opmanager"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+)
+
+// TrashResource embeds the conductor type to allow extending it's interface with
+// handlers
+type TrashResource struct {
+	*conductor.Conductor
+}
+
+// TrashCastIdGet returns a list of the deleted replicas in the trash of a provided cast.
+func (tr TrashResource) TrashCastIdGet(w http.ResponseWriter, r *http.Request) {
+	castId := chi.URLParam(r, "castId")
+
+	replicas, err := tr.ListTrash(castId)
+	if err != nil {
+		switch e := err.(type) {
+		case conductor.CastNotFoundError:
+			w.WriteHeader(http.StatusNotFound)
+			return
+		default:
+			_ = e
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+
+	result := make([]ReplicaResponse, 0)
+	for _, replica := range replicas {
+		result = append(result, newReplicaResponse(castId, replica))
+	}
+	render.JSON(w, r, result)
+}
+
+// TrashCastIdIdDelete queues the purge of a deleted replica from the trash of the
+// provided cast before its grace period ends.
+func (tr TrashResource) TrashCastIdIdDelete(w http.ResponseWriter, r *http.Request) {
+	castId := chi.URLParam(r, "castId")
+	id := chi.URLParam(r, "id")
+
+	op, err := tr.PurgeTrashedReplica(castId, id)
+	if err != nil {
+		switch e := err.(type) {
+		case conductor.CastNotFoundError:
+			w.WriteHeader(http.StatusNotFound)
+			return
+		case conductor.ReplicaNotFoundError:
+			w.WriteHeader(http.StatusNotFound)
+			return
+		case opmanager.QueueFullError, opmanager.ShuttingDownError:
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		default:
+			_ = e
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+	acceptOperation(w, r, op)
+}
@@ -1,16 +1,49 @@
 package conductor
 
 import (
+	"sort"
 	"time"
 
+	"github.com/dnsinogeorgos/conductor/internal/hooks"
+	"github.com/dnsinogeorgos/conductor/internal/opmanager"
+	"github.com/dnsinogeorgos/conductor/internal/rollback"
+	"github.com/dnsinogeorgos/conductor/internal/zfsmanager"
 	"go.uber.org/zap"
 )
 
 // Cast contains the state of a cast and it's child relationships
 type Cast struct {
-	Id        string
-	Timestamp string
-	replicas  map[string]*Replica
+	Id              string
+	Timestamp       string
+	Quiesce         string
+	QuiesceDuration time.Duration
+	// SourceCastId, SourceReplicaId and SourceCheckpoint are set on casts created from a
+	// replica
+	SourceCastId     string
+	SourceReplicaId  string
+	SourceCheckpoint string
+	// ExpiresAt is the time after which the cast is reaped once it is empty. The zero
+	// time means it does not expire.
+	ExpiresAt time.Time
+	// Protected casts are only deleted when the protection is overridden
+	Protected bool
+	// Properties are the effective values of the ZFS properties of the cast dataset
+	Properties map[string]string
+	replicas   map[string]*Replica
+	trash      map[string]*Replica
+}
+
+// CastDeletion contains the outcome of the cascading deletion of a cast
+type CastDeletion struct {
+	Replicas []ReplicaDeletion
+}
+
+// ReplicaDeletion contains the outcome of the deletion of a replica, or of its purge
+// from the trash, during the cascading deletion of its cast
+type ReplicaDeletion struct {
+	Id      string
+	Trashed bool
+	Err     error
 }
 
 // GetCast retrieves the cast object from the state
@@ -41,50 +74,569 @@ func (cnd *Conductor) ListCasts() []*Cast {
 	return casts
 }
 
-// TODO: Casts must bind ports and create units just like replicas
+// CreateCast validates the request and queues the creation of a cast that expires at the
+// provided time, or never if it is zero. The provided properties override the configured
+// cast properties.
+func (cnd *Conductor) CreateCast(id string, expiresAt time.Time, properties map[string]string) (opmanager.Operation, error) {
+	cnd.mu.RLock()
+	defer cnd.mu.RUnlock()
+
+	if _, ok := cnd.casts[id]; ok {
+		cnd.l.Debug("cannot create cast, already exists", zap.String("cast", id))
+		return opmanager.Operation{}, CastAlreadyExistsError{id}
+	}
+
+	properties, err := mergeProperties(cnd.castProperties, properties)
+	if err != nil {
+		cnd.l.Debug("cannot create cast", zap.String("cast", id), zap.Error(err))
+		return opmanager.Operation{}, err
+	}
+
+	err = cnd.checkFreeSpace()
+	if err != nil {
+		cnd.l.Debug("cannot create cast", zap.String("cast", id), zap.Error(err))
+		return opmanager.Operation{}, err
+	}
+
+	return cnd.om.Submit(OperationCreateCast, id, "", func(opId string) error {
+		return wrapError(cnd.createCast(opId, id, expiresAt, properties))
+	})
+}
+
+// CreateCastFromReplica validates the request and queues the creation of a cast from a
+// replica, or from a checkpoint of it if one is provided. The provided properties
+// override the configured cast properties.
+func (cnd *Conductor) CreateCastFromReplica(id, castId, replicaId, checkpoint string, expiresAt time.Time, properties map[string]string) (opmanager.Operation, error) {
+	cnd.mu.RLock()
+	defer cnd.mu.RUnlock()
+
+	if _, ok := cnd.casts[id]; ok {
+		cnd.l.Debug("cannot create cast, already exists", zap.String("cast", id))
+		return opmanager.Operation{}, CastAlreadyExistsError{id}
+	}
+
+	properties, err := mergeProperties(cnd.castProperties, properties)
+	if err != nil {
+		cnd.l.Debug("cannot create cast", zap.String("cast", id), zap.Error(err))
+		return opmanager.Operation{}, err
+	}
+
+	err = cnd.validateReplica(castId, replicaId)
+	if err != nil {
+		cnd.l.Debug("cannot create cast from replica", zap.String("cast", id), zap.Error(err))
+		return opmanager.Operation{}, err
+	}
+
+	if checkpoint != "" && !cnd.hasCheckpoint(castId, replicaId, checkpoint) {
+		cnd.l.Debug("cannot create cast, checkpoint not found", zap.String("cast", id), zap.String("checkpoint", checkpoint))
+		return opmanager.Operation{}, CheckpointNotFoundError{castId, replicaId, checkpoint}
+	}
+
+	err = cnd.checkFreeSpace()
+	if err != nil {
+		cnd.l.Debug("cannot create cast from replica", zap.String("cast", id), zap.Error(err))
+		return opmanager.Operation{}, err
+	}
+
+	return cnd.om.Submit(OperationCreateCast, id, "", func(opId string) error {
+		return wrapError(cnd.createCastFromReplica(opId, id, castId, replicaId, checkpoint, expiresAt, properties))
+	})
+}
+
+// ExtendCast moves the expiry of a cast to the provided time, or removes it if the time
+// is zero
+func (cnd *Conductor) ExtendCast(id string, expiresAt time.Time) (*Cast, error) {
+	cnd.mu.RLock()
+	_, ok := cnd.casts[id]
+	cnd.mu.RUnlock()
+	if !ok {
+		cnd.l.Debug("cannot extend cast, not found", zap.String("cast", id))
+		return &Cast{}, CastNotFoundError{id}
+	}
+
+	err := cnd.zm.SetCastExpiry(id, expiresAt)
+	if err != nil {
+		return &Cast{}, wrapError(err)
+	}
 
-// CreateCast orchestrates the creation of a cast using the underlying managers
-func (cnd *Conductor) CreateCast(id string) (*Cast, error) {
 	cnd.mu.Lock()
 	defer cnd.mu.Unlock()
 
-	if _, ok := cnd.casts[id]; ok {
+	cast, ok := cnd.casts[id]
+	if !ok {
+		return &Cast{}, CastNotFoundError{id}
+	}
+
+	// the object is replaced instead of modified, since readers use it without the lock
+	cnd.l.Info("extending cast", zap.String("cast", id), zap.Time("expires_at", expiresAt))
+	extended := *cast
+	extended.ExpiresAt = expiresAt
+	cnd.casts[id] = &extended
+
+	return &extended, nil
+}
+
+// DeleteCast validates the request and queues the deletion of a cast. With cascade, the
+// replicas of the cast are deleted and its trash is purged first. Protected casts and
+// replicas are only deleted with force.
+func (cnd *Conductor) DeleteCast(id string, cascade, force bool) (opmanager.Operation, error) {
+	cnd.mu.RLock()
+	defer cnd.mu.RUnlock()
+
+	if _, ok := cnd.casts[id]; !ok {
+		cnd.l.Debug("cannot delete cast, not found", zap.String("cast", id))
+		return opmanager.Operation{}, CastNotFoundError{id}
+	}
+
+	if cnd.casts[id].Protected && !force {
+		cnd.l.Debug("cannot delete cast, protected", zap.String("cast", id))
+		return opmanager.Operation{}, CastProtectedError{id}
+	}
+
+	if cascade {
+		for replicaId, replica := range cnd.casts[id].replicas {
+			if dependent, ok := cnd.getDependentCast(id, replicaId, ""); ok {
+				cnd.l.Debug("cannot delete cast, replica is the source of a cast", zap.String("cast", id), zap.String("replica", replicaId), zap.String("dependent", dependent))
+				return opmanager.Operation{}, ReplicaInUseError{id, replicaId, dependent}
+			}
+			if replica.Protected && !force {
+				cnd.l.Debug("cannot delete cast, replica is protected", zap.String("cast", id), zap.String("replica", replicaId))
+				return opmanager.Operation{}, ReplicaProtectedError{id, replicaId}
+			}
+		}
+
+		return cnd.om.Submit(OperationDeleteCast, id, "", func(opId string) error {
+			result, err := cnd.cascadeDeleteCast(opId, id, force)
+			cnd.om.SetResult(opId, result)
+			return wrapError(err)
+		})
+	}
+
+	if len(cnd.casts[id].replicas) != 0 || len(cnd.casts[id].trash) != 0 {
+		cnd.l.Debug("cannot delete cast, not empty", zap.String("cast", id))
+		return opmanager.Operation{}, CastNotEmpty{id}
+	}
+
+	return cnd.om.Submit(OperationDeleteCast, id, "", func(string) error {
+		// the cast may have been protected while the deletion was queued
+		if !force && cnd.isCastProtected(id) {
+			return CastProtectedError{id}
+		}
+		return wrapError(cnd.deleteCast(id))
+	})
+}
+
+// createCast orchestrates the creation of a cast using the underlying managers and runs
+// the cast hooks. If cast units are enabled, the cast_ready hook runs while a template
+// unit serves the cast on a temporary port, and the unit is stopped before the cast is
+// made available for replicas. The free space of the pool is checked again before the
+// source is quiesced and before the snapshot is cloned. Every completed step is reverted
+// if a later one fails.
+func (cnd *Conductor) createCast(opId, id string, expiresAt time.Time, properties map[string]string) (err error) {
+	cnd.mu.RLock()
+	_, ok := cnd.casts[id]
+	cnd.mu.RUnlock()
+	if ok {
+		cnd.l.Debug("cannot create cast, already exists", zap.String("cast", id))
+		return CastAlreadyExistsError{id}
+	}
+
+	err = cnd.runHook(opId, hooks.PreCast, hooks.Env{CastId: id, MountPoint: cnd.zm.GetFilesystemMountPoint()})
+	if err != nil {
+		return err
+	}
+
+	err = cnd.checkFreeSpace()
+	if err != nil {
+		return err
+	}
+
+	intent, err := cnd.j.Begin(OperationCreateCast, id, "", 0, stepQuiesce)
+	if err != nil {
+		return err
+	}
+
+	// the intent is kept if the source could not be resumed, so that resuming it is
+	// retried on the next start
+	quiesced := false
+	defer func() {
+		if quiesced {
+			cnd.l.Error("source is left quiesced", zap.String("cast", id), zap.String("intent", intent))
+			return
+		}
+		cnd.finishIntent(intent)
+	}()
+
+	rb := rollback.New(cnd.l)
+	defer func() {
+		if err != nil {
+			rb.Run()
+		}
+	}()
+
+	cnd.l.Debug("creating cast dataset", zap.String("cast", id), zap.String("quiesce", cnd.q.Name()))
+	state, err := cnd.zm.CreateCastDataset(id, cnd.q.Name(), cnd.castHooks(opId, intent, id, &quiesced), expiresAt, properties)
+	if err != nil {
+		return err
+	}
+	rb.Add("delete cast dataset", func() error {
+		return cnd.zm.DeleteCastDataset(id)
+	})
+
+	err = cnd.prepareCast(opId, intent, id, cnd.zm.GetCastMountPoint(id), rb)
+	if err != nil {
+		return err
+	}
+	rb.Discard()
+
+	cnd.addCast(id, state)
+
+	return nil
+}
+
+// createCastFromReplica orchestrates the creation of a cast from a replica and runs the
+// cast_ready hook on it. The source is not quiesced, a replica that must be stopped to
+// be consistent should be cast from a checkpoint taken with its unit stopped.
+func (cnd *Conductor) createCastFromReplica(opId, id, castId, replicaId, checkpoint string, expiresAt time.Time, properties map[string]string) (err error) {
+	cnd.mu.RLock()
+	_, ok := cnd.casts[id]
+	cnd.mu.RUnlock()
+	if ok {
 		cnd.l.Debug("cannot create cast, already exists", zap.String("cast", id))
-		return &Cast{}, CastAlreadyExistsError{id}
+		return CastAlreadyExistsError{id}
 	}
 
-	cnd.l.Debug("creating cast dataset", zap.String("cast", id))
-	timestamp, err := cnd.zm.CreateCastDataset(id, cnd.um.StopMainUnit, cnd.um.StartMainUnit)
+	err = cnd.checkFreeSpace()
 	if err != nil {
-		return &Cast{}, err
+		return err
+	}
+
+	intent, err := cnd.j.Begin(OperationCreateCast, id, "", 0, stepCreateDataset)
+	if err != nil {
+		return err
+	}
+	defer cnd.finishIntent(intent)
+
+	rb := rollback.New(cnd.l)
+	defer func() {
+		if err != nil {
+			rb.Run()
+		}
+	}()
+
+	cnd.l.Debug("creating cast dataset from replica", zap.String("cast", id), zap.String("source_cast", castId), zap.String("source_replica", replicaId), zap.String("checkpoint", checkpoint))
+	state, err := cnd.zm.CreateCastDatasetFromReplica(id, castId, replicaId, checkpoint, expiresAt, properties)
+	if err != nil {
+		return err
+	}
+	rb.Add("delete cast dataset", func() error {
+		return cnd.zm.DeleteCastDataset(id)
+	})
+
+	err = cnd.prepareCast(opId, intent, id, cnd.zm.GetCastMountPoint(id), rb)
+	if err != nil {
+		return err
+	}
+	rb.Discard()
+
+	cnd.addCast(id, state)
+
+	return nil
+}
+
+// castHooks returns the functions that quiesce and resume the source around the snapshot
+// of a cast, and that check the free space of the pool and run the post_snapshot hook
+// before the snapshot is cloned. The provided flag is set while the source is quiesced,
+// and the steps are recorded on the intent.
+func (cnd *Conductor) castHooks(opId, intent, id string, quiesced *bool) zfsmanager.CastHooks {
+	return zfsmanager.CastHooks{
+		Quiesce: func() error {
+			*quiesced = true
+			return cnd.q.Quiesce()
+		},
+		Resume: func() error {
+			cnd.stepIntent(intent, stepResume)
+			err := cnd.q.Resume()
+			if err != nil {
+				return err
+			}
+			*quiesced = false
+			cnd.stepIntent(intent, stepCreateDataset)
+			return nil
+		},
+		Snapshotted: func() error {
+			err := cnd.checkFreeSpace()
+			if err != nil {
+				return err
+			}
+			return cnd.runHook(opId, hooks.PostSnapshot, hooks.Env{CastId: id, MountPoint: cnd.zm.GetFilesystemMountPoint()})
+		},
+	}
+}
+
+// prepareCast runs the cast_ready hook on a new cast dataset mounted at the provided
+// path, while a template unit serves it if cast units are enabled. The undo actions are
+// registered on the provided rollback.
+func (cnd *Conductor) prepareCast(opId, intent, id, mountPoint string, rb *rollback.Rollback) (err error) {
+	env := hooks.Env{CastId: id, MountPoint: mountPoint}
+	if cnd.castUnit {
+		cnd.stepIntent(intent, stepStartUnit)
+		env.Port, env.Ports, env.Resources, err = cnd.startCastUnit(id, mountPoint, rb)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = cnd.runHook(opId, hooks.CastReady, env)
+	if err != nil {
+		return err
+	}
+
+	if cnd.castUnit {
+		cnd.stepIntent(intent, stepStopUnit)
+		err = cnd.stopCastUnit(id, env.Port, env.Ports, env.Resources)
+		if err != nil {
+			return err
+		}
 	}
 
+	return nil
+}
+
+// addCast creates the object of a new cast
+func (cnd *Conductor) addCast(id string, state zfsmanager.CastState) {
 	cnd.l.Info("creating cast object", zap.String("cast", id))
+	cast := newCast(id, state)
+	cast.Properties = cnd.getCastProperties(id)
+	cast.replicas = make(map[string]*Replica)
+	cast.trash = make(map[string]*Replica)
+
+	cnd.mu.Lock()
+	cnd.casts[id] = cast
+	cnd.mu.Unlock()
+}
+
+// newCast converts the state of a cast dataset to a cast object without replicas
+func newCast(id string, state zfsmanager.CastState) *Cast {
 	cast := &Cast{
-		Id:        id,
-		Timestamp: timestamp.Format(time.RFC3339),
-		replicas:  make(map[string]*Replica),
+		Id:              id,
+		Timestamp:       state.Timestamp.Format(time.RFC3339),
+		Quiesce:         state.Quiesce,
+		QuiesceDuration: state.QuiesceDuration,
 	}
-	cnd.casts[id] = cast
+	if state.Source != nil {
+		cast.SourceCastId = state.Source.CastId
+		cast.SourceReplicaId = state.Source.ReplicaId
+		cast.SourceCheckpoint = state.Source.Checkpoint
+	}
+	if state.ExpiresAt != nil {
+		cast.ExpiresAt = *state.ExpiresAt
+	}
+	cast.Protected = state.Protected
+
+	return cast
+}
+
+// getDependentCast returns the id of a cast created from a replica, or from one of its
+// checkpoints if a checkpoint is provided, and whether one was found. The caller must
+// hold the lock.
+func (cnd *Conductor) getDependentCast(castId, id, checkpoint string) (string, bool) {
+	for _, cast := range cnd.casts {
+		if cast.SourceCastId != castId || cast.SourceReplicaId != id {
+			continue
+		}
+		if checkpoint == "" || cast.SourceCheckpoint == checkpoint {
+			return cast.Id, true
+		}
+	}
+
+	return "", false
+}
+
+// startCastUnit binds a temporary port, a port of every port slot and a value of every
+// resource for a cast and starts a template unit on its dataset mounted at the provided
+// path. The undo actions are registered on the provided rollback.
+func (cnd *Conductor) startCastUnit(id, mountPoint string, rb *rollback.Rollback) (int32, map[string]int32, map[string]string, error) {
+	name := cnd.getUniqueCastName(id)
+
+	cnd.mu.Lock()
+	port, err := cnd.pm.GetNextAvailable()
+	if err != nil {
+		cnd.mu.Unlock()
+		cnd.l.Error("configured range of ports is exhausted", zap.Error(err))
+		return 0, nil, nil, PortsExhaustedError{s: err.Error()}
+	}
+
+	cnd.l.Debug("binding port for cast", zap.String("cast", id))
+	err = cnd.pm.Bind(port, name)
+	if err != nil {
+		cnd.mu.Unlock()
+		return 0, nil, nil, err
+	}
+	ports, resources, err := cnd.allocateSlots(name, nil, nil)
+	if err != nil {
+		_ = cnd.pm.Release(port)
+		cnd.mu.Unlock()
+		return 0, nil, nil, err
+	}
+	cnd.mu.Unlock()
+	rb.Add("release ports of cast", func() error {
+		cnd.mu.Lock()
+		defer cnd.mu.Unlock()
+		cnd.releaseSlots(name, ports, resources)
+		return cnd.pm.Release(port)
+	})
 
-	return cast, nil
+	cnd.l.Debug("starting cast unit", zap.String("cast", id))
+	err = cnd.um.StartTemplateUnit(name, mountPoint, port, ports, resources)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	rb.Add("stop cast unit", func() error {
+		return cnd.um.StopTemplateUnit(name)
+	})
+
+	return port, ports, resources, nil
 }
 
-// DeleteCast orchestrates the deletion of a cast using the underlying managers
-func (cnd *Conductor) DeleteCast(id string) error {
+// stopCastUnit stops the template unit of a cast and releases its ports and resources
+func (cnd *Conductor) stopCastUnit(id string, port int32, ports map[string]int32, resources map[string]string) error {
+	cnd.l.Debug("stopping cast unit", zap.String("cast", id))
+	err := cnd.um.StopTemplateUnit(cnd.getUniqueCastName(id))
+	if err != nil {
+		return err
+	}
+
 	cnd.mu.Lock()
 	defer cnd.mu.Unlock()
 
-	if _, ok := cnd.casts[id]; !ok {
+	cnd.l.Debug("releasing port for cast", zap.String("cast", id))
+	cnd.releaseSlots(cnd.getUniqueCastName(id), ports, resources)
+	return cnd.pm.Release(port)
+}
+
+// getUniqueCastName returns the unique name of the template unit of a cast. The id is
+// escaped, so the name never contains the separator of the names of replicas.
+func (cnd *Conductor) getUniqueCastName(id string) string {
+	return idEscaper.Replace(id)
+}
+
+// cascadeDeleteCast deletes every replica of a cast, purges its trash and deletes the
+// cast. A replica that fails to be deleted, or is protected without force, does not stop
+// the others but keeps the cast from being deleted. The outcome is reported per replica.
+func (cnd *Conductor) cascadeDeleteCast(opId, id string, force bool) (CastDeletion, error) {
+	result := CastDeletion{Replicas: make([]ReplicaDeletion, 0)}
+
+	cnd.mu.RLock()
+	cast, ok := cnd.casts[id]
+	if !ok {
+		cnd.mu.RUnlock()
+		cnd.l.Debug("cannot delete cast, not found", zap.String("cast", id))
+		return result, CastNotFoundError{id}
+	}
+	protected := cast.Protected
+	cnd.mu.RUnlock()
+	if protected && !force {
+		cnd.l.Debug("cannot delete cast, protected", zap.String("cast", id))
+		return result, CastProtectedError{id}
+	}
+
+	intent, err := cnd.j.Begin(OperationDeleteCast, id, "", 0, stepDeleteReplicas)
+	if err != nil {
+		return result, err
+	}
+	defer cnd.finishIntent(intent)
+
+	return cnd.deleteCastContents(opId, intent, id, force)
+}
+
+// deleteCastContents deletes the replicas and purges the trash of a cast, then steps the
+// provided intent forward and deletes the cast, all of it covered by that single intent.
+// It completes a cascading deletion that was interrupted as well.
+func (cnd *Conductor) deleteCastContents(opId, intent, id string, force bool) (CastDeletion, error) {
+	result := CastDeletion{Replicas: make([]ReplicaDeletion, 0)}
+
+	cnd.mu.RLock()
+	cast, ok := cnd.casts[id]
+	if !ok {
+		cnd.mu.RUnlock()
+		cnd.l.Debug("cannot delete cast, not found", zap.String("cast", id))
+		return result, CastNotFoundError{id}
+	}
+	replicaIds := make([]string, 0, len(cast.replicas))
+	for replicaId := range cast.replicas {
+		replicaIds = append(replicaIds, replicaId)
+	}
+	trashIds := make([]string, 0, len(cast.trash))
+	for replicaId := range cast.trash {
+		trashIds = append(trashIds, replicaId)
+	}
+	cnd.mu.RUnlock()
+	sort.Strings(replicaIds)
+	sort.Strings(trashIds)
+
+	var err error
+	failed := 0
+	for _, replicaId := range replicaIds {
+		if !force && cnd.isReplicaProtected(id, replicaId) {
+			err = ReplicaProtectedError{id, replicaId}
+		} else {
+			err = cnd.removeReplica(opId, id, replicaId, false)
+		}
+		if err != nil {
+			cnd.l.Warn("failed to delete replica of cast", zap.String("cast", id), zap.String("replica", replicaId), zap.Error(err))
+			failed++
+		}
+		result.Replicas = append(result.Replicas, ReplicaDeletion{Id: replicaId, Err: wrapError(err)})
+	}
+	for _, replicaId := range trashIds {
+		err = cnd.removeTrashedReplica(id, replicaId, false)
+		if err != nil {
+			cnd.l.Warn("failed to purge trashed replica of cast", zap.String("cast", id), zap.String("replica", replicaId), zap.Error(err))
+			failed++
+		}
+		result.Replicas = append(result.Replicas, ReplicaDeletion{Id: replicaId, Trashed: true, Err: wrapError(err)})
+	}
+
+	if failed > 0 {
+		return result, CascadeDeleteError{id, failed}
+	}
+
+	cnd.stepIntent(intent, stepDeleteDataset)
+	return result, cnd.removeCast(id, false)
+}
+
+// deleteCast orchestrates the deletion of a cast using the underlying managers
+func (cnd *Conductor) deleteCast(id string) error {
+	return cnd.removeCast(id, true)
+}
+
+// removeCast deletes a cast like deleteCast. The deletion is recorded in the journal if
+// journaled is set, otherwise it is covered by the intent of the operation it is part of.
+func (cnd *Conductor) removeCast(id string, journaled bool) error {
+	cnd.mu.RLock()
+	cast, ok := cnd.casts[id]
+	if !ok {
+		cnd.mu.RUnlock()
 		cnd.l.Debug("cannot delete cast, not found", zap.String("cast", id))
 		return CastNotFoundError{id}
 	}
+	empty := len(cast.replicas) == 0 && len(cast.trash) == 0
+	cnd.mu.RUnlock()
 
-	if len(cnd.casts[id].replicas) != 0 {
+	if !empty {
 		cnd.l.Debug("cannot delete cast, not empty", zap.String("cast", id))
 		return CastNotEmpty{id}
 	}
 
+	if journaled {
+		intent, err := cnd.j.Begin(OperationDeleteCast, id, "", 0, stepDeleteDataset)
+		if err != nil {
+			return err
+		}
+		defer cnd.finishIntent(intent)
+	}
+
 	cnd.l.Debug("deleting cast dataset", zap.String("cast", id))
 	err := cnd.zm.DeleteCastDataset(id)
 	if err != nil {
@@ -92,7 +644,9 @@ func (cnd *Conductor) DeleteCast(id string) error {
 	}
 
 	cnd.l.Info("deleting cast object", zap.String("cast", id))
+	cnd.mu.Lock()
 	delete(cnd.casts, id)
+	cnd.mu.Unlock()
 
 	return nil
 }
@@ -0,0 +1,248 @@
+package conductor
+
+import (
+	"time"
+
+	"github.com/dnsinogeorgos/conductor/internal/opmanager"
+	"github.com/dnsinogeorgos/conductor/internal/rollback"
+	"github.com/dnsinogeorgos/conductor/internal/zfsmanager"
+	"go.uber.org/zap"
+)
+
+// Checkpoint contains the state of a checkpoint of a replica
+type Checkpoint struct {
+	Name      string
+	Timestamp string
+}
+
+// ListCheckpoints returns the checkpoints of a replica ordered by creation
+func (cnd *Conductor) ListCheckpoints(castId, id string) ([]*Checkpoint, error) {
+	cnd.mu.RLock()
+	defer cnd.mu.RUnlock()
+
+	checkpoints := make([]*Checkpoint, 0)
+	err := cnd.validateReplica(castId, id)
+	if err != nil {
+		cnd.l.Debug("cannot list checkpoint objects", zap.String("cast", castId), zap.String("replica", id), zap.Error(err))
+		return checkpoints, err
+	}
+
+	cnd.l.Debug("listing checkpoint objects", zap.String("cast", castId), zap.String("replica", id))
+	states, err := cnd.zm.GetCheckpoints(castId, id)
+	if err != nil {
+		return checkpoints, wrapError(err)
+	}
+	for _, state := range states {
+		checkpoint := &Checkpoint{Name: state.Name}
+		if !state.Timestamp.IsZero() {
+			checkpoint.Timestamp = state.Timestamp.Format(time.RFC3339)
+		}
+		checkpoints = append(checkpoints, checkpoint)
+	}
+
+	return checkpoints, nil
+}
+
+// CreateCheckpoint validates the request and queues the creation of a checkpoint
+func (cnd *Conductor) CreateCheckpoint(castId, id, name string) (opmanager.Operation, error) {
+	cnd.mu.RLock()
+	defer cnd.mu.RUnlock()
+
+	err := cnd.validateReplica(castId, id)
+	if err != nil {
+		cnd.l.Debug("cannot create checkpoint", zap.String("cast", castId), zap.String("replica", id), zap.Error(err))
+		return opmanager.Operation{}, err
+	}
+
+	if zfsmanager.IsReservedCheckpointName(name) {
+		cnd.l.Debug("cannot create checkpoint, reserved name", zap.String("cast", castId), zap.String("replica", id), zap.String("checkpoint", name))
+		return opmanager.Operation{}, InvalidCheckpointNameError{name}
+	}
+
+	if cnd.hasCheckpoint(castId, id, name) {
+		cnd.l.Debug("cannot create checkpoint, already exists", zap.String("cast", castId), zap.String("replica", id), zap.String("checkpoint", name))
+		return opmanager.Operation{}, CheckpointAlreadyExistsError{castId, id, name}
+	}
+
+	return cnd.om.Submit(OperationCreateCheckpoint, castId, id, func(opId string) error {
+		checkpoint, err := cnd.createCheckpoint(castId, id, name)
+		if err == nil {
+			cnd.om.SetResult(opId, checkpoint)
+		}
+		return wrapError(err)
+	})
+}
+
+// RestoreCheckpoint validates the request and queues the restoration of a checkpoint
+func (cnd *Conductor) RestoreCheckpoint(castId, id, name string) (opmanager.Operation, error) {
+	cnd.mu.RLock()
+	defer cnd.mu.RUnlock()
+
+	err := cnd.validateReplica(castId, id)
+	if err != nil {
+		cnd.l.Debug("cannot restore checkpoint", zap.String("cast", castId), zap.String("replica", id), zap.Error(err))
+		return opmanager.Operation{}, err
+	}
+
+	if !cnd.hasCheckpoint(castId, id, name) {
+		cnd.l.Debug("cannot restore checkpoint, not found", zap.String("cast", castId), zap.String("replica", id), zap.String("checkpoint", name))
+		return opmanager.Operation{}, CheckpointNotFoundError{castId, id, name}
+	}
+
+	return cnd.om.Submit(OperationRestoreCheckpoint, castId, id, func(string) error {
+		return wrapError(cnd.restoreCheckpoint(castId, id, name))
+	})
+}
+
+// DeleteCheckpoint validates the request and queues the deletion of a checkpoint
+func (cnd *Conductor) DeleteCheckpoint(castId, id, name string) (opmanager.Operation, error) {
+	cnd.mu.RLock()
+	defer cnd.mu.RUnlock()
+
+	err := cnd.validateReplica(castId, id)
+	if err != nil {
+		cnd.l.Debug("cannot delete checkpoint", zap.String("cast", castId), zap.String("replica", id), zap.Error(err))
+		return opmanager.Operation{}, err
+	}
+
+	if !cnd.hasCheckpoint(castId, id, name) {
+		cnd.l.Debug("cannot delete checkpoint, not found", zap.String("cast", castId), zap.String("replica", id), zap.String("checkpoint", name))
+		return opmanager.Operation{}, CheckpointNotFoundError{castId, id, name}
+	}
+
+	if dependent, ok := cnd.getDependentCast(castId, id, name); ok {
+		cnd.l.Debug("cannot delete checkpoint, it is the source of a cast", zap.String("cast", castId), zap.String("replica", id), zap.String("checkpoint", name), zap.String("dependent", dependent))
+		return opmanager.Operation{}, CheckpointInUseError{castId, id, name, dependent}
+	}
+
+	return cnd.om.Submit(OperationDeleteCheckpoint, castId, id, func(string) error {
+		return wrapError(cnd.deleteCheckpoint(castId, id, name))
+	})
+}
+
+// createCheckpoint takes a checkpoint of a replica. If configured, the replica unit is
+// stopped while the snapshot is taken.
+func (cnd *Conductor) createCheckpoint(castId, id, name string) (*Checkpoint, error) {
+	replica, ok := cnd.getLoadedReplica(castId, id)
+	if !ok {
+		cnd.l.Debug("cannot create checkpoint, replica not found", zap.String("cast", castId), zap.String("replica", id))
+		return nil, ReplicaNotFoundError{castId, id}
+	}
+
+	if !cnd.checkpointStopUnit {
+		cnd.l.Debug("creating checkpoint", zap.String("cast", castId), zap.String("replica", id), zap.String("checkpoint", name))
+		state, err := cnd.zm.CreateCheckpoint(castId, id, name)
+		if err != nil {
+			return nil, err
+		}
+		return &Checkpoint{Name: state.Name, Timestamp: state.Timestamp.Format(time.RFC3339)}, nil
+	}
+
+	intent, err := cnd.j.Begin(OperationCreateCheckpoint, castId, id, replica.Port, stepStopUnit)
+	if err != nil {
+		return nil, err
+	}
+	defer cnd.finishIntent(intent)
+
+	var checkpoint *Checkpoint
+	err = cnd.pauseReplica(intent, castId, id, replica.Port, replica.Ports, replica.Resources, func() error {
+		cnd.l.Debug("creating checkpoint", zap.String("cast", castId), zap.String("replica", id), zap.String("checkpoint", name))
+		state, err := cnd.zm.CreateCheckpoint(castId, id, name)
+		if err != nil {
+			return err
+		}
+		checkpoint = &Checkpoint{Name: state.Name, Timestamp: state.Timestamp.Format(time.RFC3339)}
+		return nil
+	})
+
+	return checkpoint, err
+}
+
+// restoreCheckpoint rolls a replica back to a checkpoint while its unit is stopped
+func (cnd *Conductor) restoreCheckpoint(castId, id, name string) error {
+	replica, ok := cnd.getLoadedReplica(castId, id)
+	if !ok {
+		cnd.l.Debug("cannot restore checkpoint, replica not found", zap.String("cast", castId), zap.String("replica", id))
+		return ReplicaNotFoundError{castId, id}
+	}
+
+	intent, err := cnd.j.Begin(OperationRestoreCheckpoint, castId, id, replica.Port, stepStopUnit)
+	if err != nil {
+		return err
+	}
+	defer cnd.finishIntent(intent)
+
+	return cnd.pauseReplica(intent, castId, id, replica.Port, replica.Ports, replica.Resources, func() error {
+		cnd.l.Debug("restoring checkpoint", zap.String("cast", castId), zap.String("replica", id), zap.String("checkpoint", name))
+		return cnd.zm.RestoreCheckpoint(castId, id, name)
+	})
+}
+
+// deleteCheckpoint deletes a checkpoint of a replica
+func (cnd *Conductor) deleteCheckpoint(castId, id, name string) error {
+	cnd.l.Debug("deleting checkpoint", zap.String("cast", castId), zap.String("replica", id), zap.String("checkpoint", name))
+	return cnd.zm.DeleteCheckpoint(castId, id, name)
+}
+
+// pauseReplica stops the unit of a replica, runs the provided function and starts the
+// unit again whether the function succeeds or not
+func (cnd *Conductor) pauseReplica(intent, castId, id string, port int32, ports map[string]int32, resources map[string]string, fn func() error) (err error) {
+	rb := rollback.New(cnd.l)
+	defer func() {
+		if err != nil {
+			rb.Run()
+		}
+	}()
+
+	urn := cnd.getUniqueReplicaName(castId, id)
+	cnd.l.Debug("stopping replica unit", zap.String("cast", castId), zap.String("replica", id))
+	err = cnd.um.StopTemplateUnit(urn)
+	if err != nil {
+		return err
+	}
+	rb.Add("start replica unit", func() error {
+		return cnd.um.StartTemplateUnit(urn, cnd.zm.GetReplicaMountPoint(castId, id), port, ports, resources)
+	})
+
+	cnd.stepIntent(intent, stepSnapshot)
+	err = fn()
+	if err != nil {
+		return err
+	}
+	rb.Discard()
+
+	cnd.stepIntent(intent, stepStartUnit)
+	cnd.l.Debug("starting replica unit", zap.String("cast", castId), zap.String("replica", id))
+	return cnd.um.StartTemplateUnit(urn, cnd.zm.GetReplicaMountPoint(castId, id), port, ports, resources)
+}
+
+// validateReplica returns an error if a replica or its cast does not exist. The caller
+// must hold the lock.
+func (cnd *Conductor) validateReplica(castId, id string) error {
+	cast, ok := cnd.casts[castId]
+	if !ok {
+		return CastNotFoundError{castId}
+	}
+
+	if _, ok := cast.replicas[id]; !ok {
+		return ReplicaNotFoundError{castId, id}
+	}
+
+	return nil
+}
+
+// hasCheckpoint reports whether a replica has a checkpoint
+func (cnd *Conductor) hasCheckpoint(castId, id, name string) bool {
+	checkpoints, err := cnd.zm.GetCheckpoints(castId, id)
+	if err != nil {
+		return false
+	}
+
+	for _, checkpoint := range checkpoints {
+		if checkpoint.Name == name {
+			return true
+		}
+	}
+
+	return false
+}
@@ -2,6 +2,10 @@ package conductor
 
 import (
 	"fmt"
+
+	"github.com/dnsinogeorgos/conductor/internal/allocator"
+	"github.com/dnsinogeorgos/conductor/internal/unitmanager"
+	"github.com/dnsinogeorgos/conductor/internal/zfsmanager"
 )
 
 type CastAlreadyExistsError struct {
@@ -28,6 +32,32 @@ func (e CastNotEmpty) Error() string {
 	return fmt.Sprintf("cast %s contains replicas", e.c)
 }
 
+type CascadeDeleteError struct {
+	c string
+	n int
+}
+
+func (e CascadeDeleteError) Error() string {
+	return fmt.Sprintf("failed to delete %d replicas of cast %s", e.n, e.c)
+}
+
+type CastProtectedError struct {
+	c string
+}
+
+func (e CastProtectedError) Error() string {
+	return fmt.Sprintf("cast %s is protected", e.c)
+}
+
+type ReplicaProtectedError struct {
+	c string
+	r string
+}
+
+func (e ReplicaProtectedError) Error() string {
+	return fmt.Sprintf("replica %s of cast %s is protected", e.r, e.c)
+}
+
 type ReplicaAlreadyExistsError struct {
 	c string
 	r string
@@ -46,6 +76,64 @@ func (e ReplicaNotFoundError) Error() string {
 	return fmt.Sprintf("replica %s not found in cast %s", e.r, e.c)
 }
 
+type CheckpointAlreadyExistsError struct {
+	c string
+	r string
+	p string
+}
+
+func (e CheckpointAlreadyExistsError) Error() string {
+	return fmt.Sprintf("checkpoint %s already exists in replica %s of cast %s", e.p, e.r, e.c)
+}
+
+type CheckpointNotFoundError struct {
+	c string
+	r string
+	p string
+}
+
+func (e CheckpointNotFoundError) Error() string {
+	return fmt.Sprintf("checkpoint %s not found in replica %s of cast %s", e.p, e.r, e.c)
+}
+
+type InvalidCheckpointNameError struct {
+	p string
+}
+
+func (e InvalidCheckpointNameError) Error() string {
+	return fmt.Sprintf("checkpoint name %s is reserved", e.p)
+}
+
+type ReplicaInUseError struct {
+	c string
+	r string
+	d string
+}
+
+func (e ReplicaInUseError) Error() string {
+	return fmt.Sprintf("replica %s of cast %s is the source of cast %s", e.r, e.c, e.d)
+}
+
+type ReplicaHasCheckpointsError struct {
+	c string
+	r string
+}
+
+func (e ReplicaHasCheckpointsError) Error() string {
+	return fmt.Sprintf("replica %s of cast %s has checkpoints", e.r, e.c)
+}
+
+type CheckpointInUseError struct {
+	c string
+	r string
+	p string
+	d string
+}
+
+func (e CheckpointInUseError) Error() string {
+	return fmt.Sprintf("checkpoint %s in replica %s of cast %s is the source of cast %s", e.p, e.r, e.c, e.d)
+}
+
 type PortsExhaustedError struct {
 	s string
 }
@@ -53,3 +141,157 @@ type PortsExhaustedError struct {
 func (e PortsExhaustedError) Error() string {
 	return e.s
 }
+
+type ResourcesExhaustedError struct {
+	s string
+}
+
+func (e ResourcesExhaustedError) Error() string {
+	return e.s
+}
+
+type InvalidPortError struct {
+	p int32
+}
+
+func (e InvalidPortError) Error() string {
+	return fmt.Sprintf("port %d is outside of the configured ranges or excluded", e.p)
+}
+
+type PortUnavailableError struct {
+	p int32
+}
+
+func (e PortUnavailableError) Error() string {
+	return fmt.Sprintf("port %d is not available", e.p)
+}
+
+type InvalidEndpointError struct {
+	s string
+}
+
+func (e InvalidEndpointError) Error() string {
+	return e.s
+}
+
+type InsufficientSpaceError struct {
+	p string
+	f uint64
+	t uint64
+}
+
+func (e InsufficientSpaceError) Error() string {
+	return fmt.Sprintf("pool %s has %d bytes free, below the threshold of %d bytes", e.p, e.f, e.t)
+}
+
+type DatasetBusyError struct {
+	s string
+}
+
+func (e DatasetBusyError) Error() string {
+	return e.s
+}
+
+type StorageError struct {
+	s string
+}
+
+func (e StorageError) Error() string {
+	return e.s
+}
+
+type PortError struct {
+	s string
+}
+
+func (e PortError) Error() string {
+	return e.s
+}
+
+type ResourceError struct {
+	s string
+}
+
+func (e ResourceError) Error() string {
+	return e.s
+}
+
+type UnitError struct {
+	s string
+}
+
+func (e UnitError) Error() string {
+	return e.s
+}
+
+type HookError struct {
+	s string
+}
+
+func (e HookError) Error() string {
+	return e.s
+}
+
+type InvalidPropertyError struct {
+	p string
+	v string
+}
+
+func (e InvalidPropertyError) Error() string {
+	return fmt.Sprintf("invalid property %s=%s", e.p, e.v)
+}
+
+type UnknownPolicyError struct {
+	p string
+}
+
+func (e UnknownPolicyError) Error() string {
+	return fmt.Sprintf("unknown reconcile policy %s", e.p)
+}
+
+// wrapError converts the errors returned by the underlying managers to the errors
+// exposed by the conductor, so that a state that changed after the validation of a
+// request is reported like it would have been during the validation. Only the failures
+// of the storage itself are storage errors. Errors of the conductor are returned as they
+// are.
+func wrapError(err error) error {
+	switch e := err.(type) {
+	case zfsmanager.DatasetBusyError:
+		return DatasetBusyError{s: e.Error()}
+	case zfsmanager.DatasetError:
+		return StorageError{s: e.Error()}
+	case zfsmanager.CastNotFoundError:
+		return CastNotFoundError{e.Cast()}
+	case zfsmanager.CastNotEmpty:
+		return CastNotEmpty{e.Cast()}
+	case zfsmanager.CastAlreadyExistsError:
+		return CastAlreadyExistsError{e.Cast()}
+	case zfsmanager.ReplicaNotFoundError:
+		return ReplicaNotFoundError{e.Cast(), e.Replica()}
+	case zfsmanager.ReplicaInTrashError:
+		return ReplicaNotFoundError{e.Cast(), e.Replica()}
+	case zfsmanager.ReplicaAlreadyExistsError:
+		return ReplicaAlreadyExistsError{e.Cast(), e.Replica()}
+	case zfsmanager.CheckpointNotFoundError:
+		return CheckpointNotFoundError{e.Cast(), e.Replica(), e.Checkpoint()}
+	case zfsmanager.CheckpointAlreadyExistsError:
+		return CheckpointAlreadyExistsError{e.Cast(), e.Replica(), e.Checkpoint()}
+	case zfsmanager.InvalidCheckpointNameError:
+		return InvalidCheckpointNameError{e.Checkpoint()}
+	case zfsmanager.ReplicaInUseError:
+		return ReplicaInUseError{e.Cast(), e.Replica(), e.Dependent()}
+	case zfsmanager.ReplicaHasCheckpointsError:
+		return ReplicaHasCheckpointsError{e.Cast(), e.Replica()}
+	case zfsmanager.CheckpointInUseError:
+		return CheckpointInUseError{e.Cast(), e.Replica(), e.Checkpoint(), e.Dependent()}
+	case allocator.ValueError:
+		if e.Kind() == allocator.KindPort {
+			return PortError{s: e.Error()}
+		}
+		return ResourceError{s: e.Error()}
+	case unitmanager.UnitJobError, unitmanager.SystemdError:
+		return UnitError{s: e.Error()}
+	default:
+		return err
+	}
+}
@@ -0,0 +1,63 @@
+package conductor
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/dnsinogeorgos/conductor/internal/zfsmanager"
+	"go.uber.org/zap"
+)
+
+func TestWrapError(t *testing.T) {
+	zm := zfsmanager.New(zfsmanager.NewMemoryDriver(), "testpool", "/dev/null", "/testpool", "fs", "/var/lib/fs", "/fs_cast", "/fs_replica", zap.NewNop())
+	hooks := zfsmanager.CastHooks{
+		Quiesce: func() error { return nil },
+		Resume:  func() error { return nil },
+	}
+	_, err := zm.CreateCastDataset("c1", "unit", hooks, time.Time{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = zm.CreateReplicaDataset("c1", "r1", 3307, nil, nil, time.Time{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, castExists := zm.CreateCastDataset("c1", "unit", hooks, time.Time{}, nil)
+	_, castNotFound := zm.GetCastState("c2")
+	_, replicaNotFound := zm.GetReplicaState("c1", "r2")
+	replicaExists := zm.CreateReplicaDataset("c1", "r1", 3308, nil, nil, time.Time{}, nil)
+	castNotEmpty := zm.DeleteCastDataset("c1")
+	checkpointNotFound := zm.DeleteCheckpoint("c1", "r1", "p1")
+	_, err = zm.CreateCheckpoint("c1", "r1", "p1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hasCheckpoints := zm.ResetReplicaDataset("c1", "r1")
+	unknown := errors.New("unknown")
+
+	tests := []struct {
+		name string
+		err  error
+		want error
+	}{
+		{"cast already exists", castExists, CastAlreadyExistsError{"c1"}},
+		{"cast not found", castNotFound, CastNotFoundError{"c2"}},
+		{"replica not found", replicaNotFound, ReplicaNotFoundError{"c1", "r2"}},
+		{"replica already exists", replicaExists, ReplicaAlreadyExistsError{"c1", "r1"}},
+		{"cast not empty", castNotEmpty, CastNotEmpty{"c1"}},
+		{"checkpoint not found", checkpointNotFound, CheckpointNotFoundError{"c1", "r1", "p1"}},
+		{"replica has checkpoints", hasCheckpoints, ReplicaHasCheckpointsError{"c1", "r1"}},
+		{"conductor error", ReplicaProtectedError{"c1", "r1"}, ReplicaProtectedError{"c1", "r1"}},
+		{"unknown error", unknown, unknown},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := wrapError(tt.err); got != tt.want {
+				t.Errorf("wrapError(%v) = %#v, want %#v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
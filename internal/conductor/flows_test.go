@@ -0,0 +1,291 @@
+package conductor
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCastAndReplicaLifecycle(t *testing.T) {
+	cnd, units := newTestConductor(t)
+
+	op, err := cnd.CreateCast("c1", time.Time{}, nil)
+	wait(t, cnd, op, err)
+	if _, err := cnd.GetCast("c1"); err != nil {
+		t.Fatalf("GetCast() error = %v", err)
+	}
+
+	op, err = cnd.CreateReplica("c1", "r1", time.Time{}, nil, 0, false)
+	wait(t, cnd, op, err)
+	replica, err := cnd.GetReplica("c1", "r1")
+	if err != nil {
+		t.Fatalf("GetReplica() error = %v", err)
+	}
+	if replica.Port < 3307 || replica.Port > 3309 {
+		t.Errorf("replica port = %d, want one of 3307-3309", replica.Port)
+	}
+	if port := replica.Ports["admin"]; slotOwner(cnd, "admin", port) != "c1_r1" {
+		t.Errorf("admin port %d is bound to %q, want c1_r1", port, slotOwner(cnd, "admin", port))
+	}
+	if got, want := units.names(), []string{"c1_r1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("running units = %v, want %v", got, want)
+	}
+
+	_, err = cnd.DeleteCast("c1", false, false)
+	if _, ok := err.(CastNotEmpty); !ok {
+		t.Errorf("DeleteCast() with replica error = %v, want CastNotEmpty", err)
+	}
+
+	op, err = cnd.DeleteReplica("c1", "r1", false)
+	wait(t, cnd, op, err)
+	if _, err := cnd.GetReplica("c1", "r1"); err == nil {
+		t.Error("replica still exists after its deletion")
+	}
+	if got := units.names(); len(got) != 0 {
+		t.Errorf("running units = %v, want none", got)
+	}
+	if name, ok := cnd.pm.Owner(replica.Port); ok {
+		t.Errorf("port %d is still bound to %s", replica.Port, name)
+	}
+	if len(cnd.slots["admin"].ValueMap) != 0 {
+		t.Errorf("admin ports still bound after deletion: %v", cnd.slots["admin"].ValueMap)
+	}
+
+	op, err = cnd.DeleteCast("c1", false, false)
+	wait(t, cnd, op, err)
+	if _, err := cnd.GetCast("c1"); err == nil {
+		t.Error("cast still exists after its deletion")
+	}
+	if pending := cnd.j.Pending(); len(pending) != 0 {
+		t.Errorf("journal has %d pending intents, want none", len(pending))
+	}
+}
+
+func TestCreateRejectsBadRequests(t *testing.T) {
+	cnd, _ := newTestConductor(t)
+
+	op, err := cnd.CreateCast("c1", time.Time{}, nil)
+	wait(t, cnd, op, err)
+
+	_, err = cnd.CreateCast("c1", time.Time{}, nil)
+	if _, ok := err.(CastAlreadyExistsError); !ok {
+		t.Errorf("CreateCast() of existing cast error = %v, want CastAlreadyExistsError", err)
+	}
+	_, err = cnd.CreateReplica("c2", "r1", time.Time{}, nil, 0, false)
+	if _, ok := err.(CastNotFoundError); !ok {
+		t.Errorf("CreateReplica() on missing cast error = %v, want CastNotFoundError", err)
+	}
+	_, err = cnd.CreateReplica("c1", "r1", time.Time{}, map[string]string{"mountpoint": "/tmp"}, 0, false)
+	if _, ok := err.(InvalidPropertyError); !ok {
+		t.Errorf("CreateReplica() with unsupported property error = %v, want InvalidPropertyError", err)
+	}
+	_, err = cnd.DeleteReplica("c1", "r1", false)
+	if _, ok := err.(ReplicaNotFoundError); !ok {
+		t.Errorf("DeleteReplica() of missing replica error = %v, want ReplicaNotFoundError", err)
+	}
+}
+
+func TestCreateReplicaRollsBack(t *testing.T) {
+	cnd, units := newTestConductor(t)
+
+	op, err := cnd.CreateCast("c1", time.Time{}, nil)
+	wait(t, cnd, op, err)
+
+	failure := errors.New("unit failed")
+	units.startErr = failure
+	op, err = cnd.CreateReplica("c1", "r1", time.Time{}, nil, 0, false)
+	if err != nil {
+		t.Fatalf("CreateReplica() error = %v", err)
+	}
+	if op = finish(t, cnd, op); op.Err != failure {
+		t.Fatalf("CreateReplica() operation error = %v, want %v", op.Err, failure)
+	}
+
+	if _, err := cnd.GetReplica("c1", "r1"); err == nil {
+		t.Error("replica exists after its creation failed")
+	}
+	if ids, _ := cnd.zm.GetReplicaIds("c1"); len(ids) != 0 {
+		t.Errorf("replica datasets = %v, want none", ids)
+	}
+	if len(cnd.pm.Allocator().ValueMap) != 0 {
+		t.Errorf("ports still bound after rollback: %v", cnd.pm.Allocator().ValueMap)
+	}
+}
+
+func TestCreateCastServesCastUnit(t *testing.T) {
+	cnd, units := newTestConductor(t)
+	cnd.castUnit = true
+
+	// the names of the stopped units that held a port
+	stopped := make([]string, 0)
+	units.onStop = func(name string) {
+		for _, owner := range cnd.pm.Allocator().ValueMap {
+			if owner == name {
+				stopped = append(stopped, name)
+			}
+		}
+	}
+
+	op, err := cnd.CreateCast("c1", time.Time{}, nil)
+	wait(t, cnd, op, err)
+
+	if want := []string{"c1"}; !reflect.DeepEqual(stopped, want) {
+		t.Errorf("stopped units = %v, want %v", stopped, want)
+	}
+	if got := units.names(); len(got) != 0 {
+		t.Errorf("running units = %v, want none", got)
+	}
+	if len(cnd.pm.Allocator().ValueMap) != 0 {
+		t.Errorf("ports still bound after the cast was created: %v", cnd.pm.Allocator().ValueMap)
+	}
+}
+
+func TestCascadeDeleteCast(t *testing.T) {
+	cnd, units := newTestConductor(t)
+	cnd.trashGracePeriod = time.Hour
+
+	op, err := cnd.CreateCast("c1", time.Time{}, nil)
+	wait(t, cnd, op, err)
+	for _, id := range []string{"r1", "r2"} {
+		op, err = cnd.CreateReplica("c1", id, time.Time{}, nil, 0, false)
+		wait(t, cnd, op, err)
+	}
+	op, err = cnd.DeleteReplica("c1", "r2", false)
+	wait(t, cnd, op, err)
+
+	op, err = cnd.DeleteCast("c1", true, false)
+	wait(t, cnd, op, err)
+	op = finish(t, cnd, op)
+
+	want := CastDeletion{Replicas: []ReplicaDeletion{{Id: "r1"}, {Id: "r2", Trashed: true}}}
+	if got := op.Result; !reflect.DeepEqual(got, want) {
+		t.Errorf("DeleteCast() result = %+v, want %+v", got, want)
+	}
+	if _, err := cnd.GetCast("c1"); err == nil {
+		t.Error("cast still exists after its deletion")
+	}
+	if got := units.names(); len(got) != 0 {
+		t.Errorf("running units = %v, want none", got)
+	}
+	if len(cnd.pm.Allocator().ValueMap) != 0 {
+		t.Errorf("ports still bound after cascading deletion: %v", cnd.pm.Allocator().ValueMap)
+	}
+}
+
+func TestCascadeDeleteCastUsesSingleIntent(t *testing.T) {
+	cnd, units := newTestConductor(t)
+
+	op, err := cnd.CreateCast("c1", time.Time{}, nil)
+	wait(t, cnd, op, err)
+	for _, id := range []string{"r1", "r2"} {
+		op, err = cnd.CreateReplica("c1", id, time.Time{}, nil, 0, false)
+		wait(t, cnd, op, err)
+	}
+
+	// the replicas are deleted under the intent of the cast deletion
+	intents := make([]int, 0, 2)
+	units.onStop = func(string) {
+		intents = append(intents, len(cnd.j.Pending()))
+	}
+	op, err = cnd.DeleteCast("c1", true, false)
+	wait(t, cnd, op, err)
+
+	if want := []int{1, 1}; !reflect.DeepEqual(intents, want) {
+		t.Errorf("pending intents while the units stopped = %v, want %v", intents, want)
+	}
+	if pending := cnd.j.Pending(); len(pending) != 0 {
+		t.Errorf("journal has %d pending intents, want none", len(pending))
+	}
+}
+
+func TestCreateChecksFreeSpace(t *testing.T) {
+	cnd, _ := newTestConductor(t)
+
+	op, err := cnd.CreateCast("c1", time.Time{}, nil)
+	wait(t, cnd, op, err)
+
+	pool, err := cnd.zm.GetPoolUsage()
+	if err != nil {
+		t.Fatalf("GetPoolUsage() error = %v", err)
+	}
+	cnd.minFreeBytes = pool.Free + 1
+
+	_, err = cnd.CreateReplica("c1", "r1", time.Time{}, nil, 0, false)
+	if _, ok := err.(InsufficientSpaceError); !ok {
+		t.Errorf("CreateReplica() on a full pool error = %v, want InsufficientSpaceError", err)
+	}
+	_, err = cnd.CreateCast("c2", time.Time{}, nil)
+	if _, ok := err.(InsufficientSpaceError); !ok {
+		t.Errorf("CreateCast() on a full pool error = %v, want InsufficientSpaceError", err)
+	}
+
+	cnd.minFreeBytes = pool.Free / 2
+	op, err = cnd.CreateReplica("c1", "r1", time.Time{}, nil, 0, false)
+	wait(t, cnd, op, err)
+}
+
+func TestRequestedAndStickyPorts(t *testing.T) {
+	cnd, _ := newTestConductor(t)
+	cnd.stickyPorts = StickyPortsReplica
+
+	op, err := cnd.CreateCast("c1", time.Time{}, nil)
+	wait(t, cnd, op, err)
+	op, err = cnd.CreateReplica("c1", "r1", time.Time{}, nil, 3309, false)
+	wait(t, cnd, op, err)
+	first, err := cnd.GetReplica("c1", "r1")
+	if err != nil || first.Port != 3309 {
+		t.Fatalf("GetReplica() = %+v, %v, want the requested port 3309", first, err)
+	}
+
+	_, err = cnd.CreateReplica("c1", "r2", time.Time{}, nil, 3309, false)
+	if _, ok := err.(PortUnavailableError); !ok {
+		t.Errorf("CreateReplica() on a taken port error = %v, want PortUnavailableError", err)
+	}
+	_, err = cnd.CreateReplica("c1", "r2", time.Time{}, nil, 4000, false)
+	if _, ok := err.(InvalidPortError); !ok {
+		t.Errorf("CreateReplica() on a port out of range error = %v, want InvalidPortError", err)
+	}
+
+	// a replica created again with the same name gets the ports it had before instead of
+	// the next available ones
+	op, err = cnd.DeleteReplica("c1", "r1", false)
+	wait(t, cnd, op, err)
+	op, err = cnd.CreateReplica("c1", "r1", time.Time{}, nil, 0, false)
+	wait(t, cnd, op, err)
+	replica, err := cnd.GetReplica("c1", "r1")
+	if err != nil || replica.Port != first.Port || !reflect.DeepEqual(replica.Ports, first.Ports) {
+		t.Errorf("GetReplica() = %+v, %v, want the ports %d and %v of its previous incarnation", replica, err, first.Port, first.Ports)
+	}
+}
+
+func TestCreateReplicaWithSocket(t *testing.T) {
+	cnd, units := newTestConductor(t)
+
+	op, err := cnd.CreateCast("c1", time.Time{}, nil)
+	wait(t, cnd, op, err)
+
+	_, err = cnd.CreateReplica("c1", "r1", time.Time{}, nil, 0, true)
+	if _, ok := err.(InvalidEndpointError); !ok {
+		t.Errorf("CreateReplica() without socket template error = %v, want InvalidEndpointError", err)
+	}
+
+	units.sockets = true
+	_, err = cnd.CreateReplica("c1", "r1", time.Time{}, nil, 3307, true)
+	if _, ok := err.(InvalidEndpointError); !ok {
+		t.Errorf("CreateReplica() with socket and port error = %v, want InvalidEndpointError", err)
+	}
+
+	op, err = cnd.CreateReplica("c1", "r1", time.Time{}, nil, 0, true)
+	wait(t, cnd, op, err)
+	replica, err := cnd.GetReplica("c1", "r1")
+	if err != nil || replica.Port != 0 || replica.Socket != "/run/c1_r1.sock" {
+		t.Fatalf("GetReplica() = %+v, %v, want a socket endpoint without a port", replica, err)
+	}
+	if len(cnd.pm.Allocator().ValueMap) != 0 {
+		t.Errorf("ports bound for a replica with a socket endpoint: %v", cnd.pm.Allocator().ValueMap)
+	}
+
+	op, err = cnd.DeleteReplica("c1", "r1", false)
+	wait(t, cnd, op, err)
+}
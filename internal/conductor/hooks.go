@@ -0,0 +1,29 @@
+package conductor
+
+import (
+	"github.com/dnsinogeorgos/conductor/internal/hooks"
+	"github.com/dnsinogeorgos/conductor/internal/opmanager"
+)
+
+// runHook runs the hook configured for a point of the lifecycle and saves its output on
+// the operation. The error is only returned if the hook failed and its policy is to
+// abort.
+func (cnd *Conductor) runHook(opId, point string, env hooks.Env) error {
+	result, err := cnd.hk.Run(point, env)
+	if result != nil {
+		cnd.om.AddOutput(opId, opmanager.Output{
+			Name:     result.Hook,
+			Command:  result.Command,
+			Stdout:   result.Stdout,
+			Stderr:   result.Stderr,
+			ExitCode: result.ExitCode,
+			Duration: result.Duration,
+			Err:      result.Err,
+		})
+	}
+	if err != nil {
+		return HookError{s: err.Error()}
+	}
+
+	return nil
+}
@@ -0,0 +1,29 @@
+package conductor
+
+import "testing"
+
+func TestUniqueNamesDoNotCollide(t *testing.T) {
+	cnd := &Conductor{}
+
+	tests := []struct {
+		name string
+		a, b string
+	}{
+		{"cast unit and replica", cnd.getUniqueCastName("a_b"), cnd.getUniqueReplicaName("a", "b")},
+		{"replicas split differently", cnd.getUniqueReplicaName("a_b", "c"), cnd.getUniqueReplicaName("a", "b_c")},
+		{"trashed replica", cnd.getTrashedReplicaName("a", "b"), cnd.getUniqueReplicaName("a", "b:trash")},
+		{"escaped id", cnd.getUniqueReplicaName("a", `b\x5fc`), cnd.getUniqueReplicaName("a", "b_c")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.a == tt.b {
+				t.Errorf("unique names collide: %s", tt.a)
+			}
+		})
+	}
+
+	if got, want := cnd.getUniqueReplicaName("c1", "r_1"), `c1_r\x5f1`; got != want {
+		t.Errorf("getUniqueReplicaName() = %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,286 @@
+package conductor
+
+import (
+	"github.com/dnsinogeorgos/conductor/internal/journal"
+	"go.uber.org/zap"
+)
+
+// Steps recorded in the journal. Every step is persisted before it is executed.
+const (
+	stepQuiesce        = "quiesce"
+	stepResume         = "resume"
+	stepCreateDataset  = "create_dataset"
+	stepDeleteDataset  = "delete_dataset"
+	stepResetDataset   = "reset_dataset"
+	stepSnapshot       = "snapshot"
+	stepStartUnit      = "start_unit"
+	stepStopUnit       = "stop_unit"
+	stepSwapDataset    = "swap_dataset"
+	stepCreateReplicas = "create_replicas"
+	stepTrashDataset   = "trash_dataset"
+	stepRestoreDataset = "restore_dataset"
+	stepDeleteReplicas = "delete_replicas"
+)
+
+// stepIntent records the step an operation is about to execute. A failure to record it
+// does not stop the operation, recovery falls back to inspecting the datasets. Nothing is
+// recorded without an intent, for the steps of an operation that is part of another.
+func (cnd *Conductor) stepIntent(id, step string) {
+	if id == "" {
+		return
+	}
+
+	err := cnd.j.Step(id, step)
+	if err != nil {
+		cnd.l.Warn("failed to record step in journal", zap.String("intent", id), zap.String("step", step), zap.Error(err))
+	}
+}
+
+// finishIntent removes an operation from the journal
+func (cnd *Conductor) finishIntent(id string) {
+	err := cnd.j.Finish(id)
+	if err != nil {
+		cnd.l.Error("failed to remove intent from journal", zap.String("intent", id), zap.Error(err))
+	}
+}
+
+// recoverQuiesce resumes the source if an unfinished cast creation may have left it
+// quiesced. It runs before anything else is loaded, since a failure to load must never
+// keep the main unit down.
+func (cnd *Conductor) recoverQuiesce() error {
+	for _, intent := range cnd.j.Pending() {
+		if intent.Kind != OperationCreateCast && intent.Kind != OperationRefreshCast {
+			continue
+		}
+		if intent.Step != stepQuiesce && intent.Step != stepResume {
+			continue
+		}
+
+		cnd.l.Warn("resuming source quiesced by unfinished operation", zap.String("intent", intent.Id), zap.String("cast", intent.CastId), zap.String("quiesce", cnd.q.Name()))
+		return cnd.q.Resume()
+	}
+
+	return nil
+}
+
+// recoverIntents completes or reverts the operations that did not finish before the
+// last shutdown. Casts are always reverted, since their hooks may not have run to
+// completion, and refreshes are completed once their replicas have been deleted.
+// Replicas that reached a consistent state are completed and the rest are reverted, and
+// deletions are always completed. The recovered operations run under the intent they
+// left behind. Intents that cannot be recovered are kept for the next start.
+func (cnd *Conductor) recoverIntents() {
+	for _, intent := range cnd.j.Pending() {
+		cnd.l.Info("recovering unfinished operation",
+			zap.String("intent", intent.Id),
+			zap.String("kind", intent.Kind),
+			zap.String("cast", intent.CastId),
+			zap.String("replica", intent.ReplicaId),
+			zap.String("step", intent.Step),
+		)
+
+		err := cnd.recoverIntent(intent)
+		if err != nil {
+			cnd.l.Error("failed to recover unfinished operation", zap.String("intent", intent.Id), zap.Error(err))
+			continue
+		}
+
+		cnd.finishIntent(intent.Id)
+	}
+}
+
+// recoverIntent completes or reverts a single unfinished operation
+func (cnd *Conductor) recoverIntent(intent journal.Intent) error {
+	castId, id := intent.CastId, intent.ReplicaId
+
+	switch intent.Kind {
+	case OperationCreateCast:
+		if intent.Step == stepStartUnit || intent.Step == stepStopUnit {
+			err := cnd.um.StopTemplateUnit(cnd.getUniqueCastName(castId))
+			if err != nil {
+				cnd.l.Warn("failed to stop unit of unfinished cast", zap.String("cast", castId), zap.Error(err))
+			}
+		}
+		if cnd.hasCast(castId) {
+			cnd.l.Info("deleting cast of unfinished operation", zap.String("cast", castId))
+			err := cnd.zm.DeleteCastDataset(castId)
+			if err != nil {
+				return err
+			}
+			cnd.mu.Lock()
+			delete(cnd.casts, castId)
+			cnd.mu.Unlock()
+			return nil
+		}
+		return cnd.zm.PurgeCastDataset(castId)
+	case OperationRefreshCast:
+		switch intent.Step {
+		case stepDeleteDataset, stepSwapDataset, stepCreateReplicas:
+			cnd.l.Info("completing unfinished refresh", zap.String("cast", castId))
+			hookErr, err := cnd.completeRefresh("", intent.Id, castId, intent.Step, intent.Replicas, intent.Expiries, intent.Protected, intent.Properties, intent.Ports, intent.Resources)
+			if hookErr != nil {
+				cnd.l.Warn("hook of refreshed replica failed", zap.String("cast", castId), zap.Error(hookErr))
+			}
+			return err
+		case stepStartUnit, stepStopUnit:
+			err := cnd.um.StopTemplateUnit(cnd.getUniqueCastName(castId))
+			if err != nil {
+				cnd.l.Warn("failed to stop unit of unfinished cast", zap.String("cast", castId), zap.Error(err))
+			}
+		}
+		return cnd.zm.PurgeRefreshDataset(castId)
+	case OperationDeleteCast:
+		if cnd.hasCast(castId) && intent.Step == stepDeleteReplicas {
+			cnd.l.Info("completing unfinished cascading deletion", zap.String("cast", castId))
+			_, err := cnd.deleteCastContents("", intent.Id, castId, false)
+			if _, ok := err.(CascadeDeleteError); ok {
+				// whether protection was overridden is not recorded, the remaining
+				// replicas and the cast are kept
+				cnd.l.Warn("kept cast of unfinished cascading deletion", zap.String("cast", castId), zap.Error(err))
+				return nil
+			}
+			return err
+		}
+		if cnd.hasCast(castId) {
+			return cnd.removeCast(castId, false)
+		}
+		return cnd.zm.PurgeCastDataset(castId)
+	case OperationCreateReplica:
+		if replica, ok := cnd.getLoadedReplica(castId, id); ok {
+			cnd.l.Info("starting unit of unfinished replica", zap.String("cast", castId), zap.String("replica", id))
+			urn := cnd.getUniqueReplicaName(castId, id)
+			return cnd.um.StartTemplateUnit(urn, cnd.zm.GetReplicaMountPoint(castId, id), replica.Port, replica.Ports, replica.Resources)
+		}
+		cnd.stopOrphanUnit(castId, id)
+		return cnd.zm.PurgeReplicaDataset(castId, id)
+	case OperationDeleteReplica:
+		if _, ok := cnd.getLoadedReplica(castId, id); ok {
+			return cnd.removeReplica("", castId, id, false)
+		}
+		cnd.stopOrphanUnit(castId, id)
+		return cnd.zm.PurgeReplicaDataset(castId, id)
+	case OperationTrashReplica:
+		if _, ok := cnd.getLoadedReplica(castId, id); ok {
+			return cnd.trashReplica("", castId, id)
+		}
+		cnd.stopOrphanUnit(castId, id)
+		if !cnd.hasTrashedReplica(castId, id) {
+			return nil
+		}
+		_, err := cnd.zm.TrashReplicaDataset(castId, id)
+		return err
+	case OperationRestoreReplica:
+		if _, ok := cnd.getLoadedReplica(castId, id); !ok && !cnd.hasTrashedReplica(castId, id) {
+			return nil
+		}
+		return cnd.completeRestore(castId, id, intent.Port)
+	case OperationPurgeReplica:
+		return cnd.removeTrashedReplica(castId, id, false)
+	case OperationCreateCheckpoint, OperationRestoreCheckpoint:
+		if replica, ok := cnd.getLoadedReplica(castId, id); ok {
+			cnd.l.Info("starting unit of paused replica", zap.String("cast", castId), zap.String("replica", id))
+			urn := cnd.getUniqueReplicaName(castId, id)
+			return cnd.um.StartTemplateUnit(urn, cnd.zm.GetReplicaMountPoint(castId, id), replica.Port, replica.Ports, replica.Resources)
+		}
+		return nil
+	case OperationResetReplica:
+		replica, ok := cnd.getLoadedReplica(castId, id)
+		if !ok {
+			return cnd.completeReset(castId, id)
+		}
+		return cnd.restoreReplica(intent.Id, castId, id, intent.Port, replica.Ports, replica.Resources)
+	default:
+		cnd.l.Warn("dropping intent of unknown kind", zap.String("intent", intent.Id), zap.String("kind", intent.Kind))
+		return nil
+	}
+}
+
+// completeReset completes a reset that was interrupted after the replica dataset was
+// destroyed, restores the replica object from the state of its new dataset and starts
+// the replica unit
+func (cnd *Conductor) completeReset(castId, id string) error {
+	err := cnd.zm.ResetReplicaDataset(castId, id)
+	if err != nil {
+		return err
+	}
+
+	cnd.mu.Lock()
+	cast, ok := cnd.casts[castId]
+	if !ok {
+		cnd.mu.Unlock()
+		return CastNotFoundError{castId}
+	}
+
+	cnd.l.Info("restoring replica object", zap.String("cast", castId), zap.String("replica", id))
+	replica, err := cnd.loadReplica(castId, id)
+	if err != nil {
+		cnd.mu.Unlock()
+		return err
+	}
+	cast.replicas[id] = replica
+	cnd.mu.Unlock()
+
+	cnd.l.Info("starting unit of reset replica", zap.String("cast", castId), zap.String("replica", id))
+	urn := cnd.getUniqueReplicaName(castId, id)
+	return cnd.um.StartTemplateUnit(urn, cnd.zm.GetReplicaMountPoint(castId, id), replica.Port, replica.Ports, replica.Resources)
+}
+
+// stopOrphanUnit stops the unit and removes the configuration of a replica that is not
+// loaded. Failures are left to reconciliation.
+func (cnd *Conductor) stopOrphanUnit(castId, id string) {
+	err := cnd.um.StopTemplateUnit(cnd.getUniqueReplicaName(castId, id))
+	if err != nil {
+		cnd.l.Warn("failed to stop unit of unfinished replica", zap.String("cast", castId), zap.String("replica", id), zap.Error(err))
+	}
+}
+
+// hasCast reports whether a cast is loaded
+func (cnd *Conductor) hasCast(id string) bool {
+	cnd.mu.RLock()
+	defer cnd.mu.RUnlock()
+
+	_, ok := cnd.casts[id]
+	return ok
+}
+
+// getLoadedReplica returns a replica if both it and its cast are loaded
+func (cnd *Conductor) getLoadedReplica(castId, id string) (*Replica, bool) {
+	cnd.mu.RLock()
+	defer cnd.mu.RUnlock()
+
+	cast, ok := cnd.casts[castId]
+	if !ok {
+		return nil, false
+	}
+	replica, ok := cast.replicas[id]
+
+	return replica, ok
+}
+
+// getTrashedReplica returns a replica if it is loaded in the trash of its cast
+func (cnd *Conductor) getTrashedReplica(castId, id string) (*Replica, bool) {
+	cnd.mu.RLock()
+	defer cnd.mu.RUnlock()
+
+	cast, ok := cnd.casts[castId]
+	if !ok {
+		return nil, false
+	}
+	trashed, ok := cast.trash[id]
+
+	return trashed, ok
+}
+
+// hasTrashedReplica reports whether a replica is loaded in the trash of its cast
+func (cnd *Conductor) hasTrashedReplica(castId, id string) bool {
+	cnd.mu.RLock()
+	defer cnd.mu.RUnlock()
+
+	cast, ok := cnd.casts[castId]
+	if !ok {
+		return false
+	}
+	_, ok = cast.trash[id]
+
+	return ok
+}
@@ -0,0 +1,79 @@
+package conductor
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/dnsinogeorgos/conductor/internal/zfsmanager"
+)
+
+func TestRecoverInterruptedCreateReplica(t *testing.T) {
+	d := zfsmanager.NewMemoryDriver()
+	units := newFakeUnits()
+	cnd := loadTestConductor(t, d, units)
+
+	op, err := cnd.CreateCast("c1", time.Time{}, nil)
+	wait(t, cnd, op, err)
+
+	// the service stopped after the dataset was cloned and before the unit started
+	_, err = cnd.j.Begin(OperationCreateReplica, "c1", "r1", 3308, stepStartUnit)
+	if err != nil {
+		t.Fatalf("Begin() error = %v", err)
+	}
+	err = cnd.zm.CreateReplicaDataset("c1", "r1", 3308, nil, nil, time.Time{}, nil)
+	if err != nil {
+		t.Fatalf("CreateReplicaDataset() error = %v", err)
+	}
+
+	cnd = loadTestConductor(t, d, units)
+
+	replica, err := cnd.GetReplica("c1", "r1")
+	if err != nil {
+		t.Fatalf("GetReplica() after recovery error = %v", err)
+	}
+	if replica.Port != 3308 || portOwner(cnd, 3308) != "c1_r1" {
+		t.Errorf("replica port = %d, bound to %q, want 3308 bound to c1_r1", replica.Port, portOwner(cnd, 3308))
+	}
+	if got, want := units.names(), []string{"c1_r1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("running units = %v, want %v", got, want)
+	}
+	if pending := cnd.j.Pending(); len(pending) != 0 {
+		t.Errorf("journal has %d pending intents, want none", len(pending))
+	}
+}
+
+func TestRecoverInterruptedDeleteReplica(t *testing.T) {
+	d := zfsmanager.NewMemoryDriver()
+	units := newFakeUnits()
+	cnd := loadTestConductor(t, d, units)
+
+	op, err := cnd.CreateCast("c1", time.Time{}, nil)
+	wait(t, cnd, op, err)
+	op, err = cnd.CreateReplica("c1", "r1", time.Time{}, nil, 0, false)
+	wait(t, cnd, op, err)
+
+	// the service stopped before the deletion stopped the unit
+	_, err = cnd.j.Begin(OperationDeleteReplica, "c1", "r1", 0, stepStopUnit)
+	if err != nil {
+		t.Fatalf("Begin() error = %v", err)
+	}
+
+	cnd = loadTestConductor(t, d, units)
+
+	if _, err := cnd.GetReplica("c1", "r1"); err == nil {
+		t.Error("replica exists after its deletion was recovered")
+	}
+	if ids, _ := cnd.zm.GetReplicaIds("c1"); len(ids) != 0 {
+		t.Errorf("replica datasets = %v, want none", ids)
+	}
+	if got := units.names(); len(got) != 0 {
+		t.Errorf("running units = %v, want none", got)
+	}
+	if len(cnd.pm.Allocator().ValueMap) != 0 {
+		t.Errorf("ports still bound after recovery: %v", cnd.pm.Allocator().ValueMap)
+	}
+	if pending := cnd.j.Pending(); len(pending) != 0 {
+		t.Errorf("journal has %d pending intents, want none", len(pending))
+	}
+}
@@ -5,21 +5,67 @@ import (
 	"sync"
 	"time"
 
+	"github.com/dnsinogeorgos/conductor/internal/allocator"
 	"github.com/dnsinogeorgos/conductor/internal/config"
+	"github.com/dnsinogeorgos/conductor/internal/hooks"
+	"github.com/dnsinogeorgos/conductor/internal/journal"
+	"github.com/dnsinogeorgos/conductor/internal/opmanager"
 	"github.com/dnsinogeorgos/conductor/internal/portmanager"
+	"github.com/dnsinogeorgos/conductor/internal/quiesce"
 	"github.com/dnsinogeorgos/conductor/internal/unitmanager"
 	"github.com/dnsinogeorgos/conductor/internal/zfsmanager"
 	"go.uber.org/zap"
 )
 
-// Conductor contains the managers and the current state structure
+// Conductor contains the managers and the current state structure. Orchestrations run
+// one at a time on the operation queue, mu only guards the state structure, the port
+// managers and the allocators so that readers are not blocked while an operation is
+// running. Multi-step orchestrations record their progress in the journal.
 type Conductor struct {
 	mu    sync.RWMutex
 	l     *zap.Logger
-	um    *unitmanager.UnitManager
+	j     *journal.Journal
+	om    *opmanager.OperationManager
+	um    unitManager
 	pm    *portmanager.PortManager
+	slots map[string]*allocator.Allocator
+	ph    *portmanager.History
 	zm    *zfsmanager.ZFSManager
+	q     quiesce.Strategy
+	hk    *hooks.Runner
 	casts map[string]*Cast
+
+	reconcilePolicy string
+	castUnit        bool
+
+	checkpointStopUnit bool
+
+	reapInterval time.Duration
+
+	trashGracePeriod time.Duration
+	trashPorts       string
+
+	castProperties    map[string]string
+	replicaProperties map[string]string
+
+	minFreeBytes   uint64
+	minFreePercent float64
+
+	stickyPorts string
+}
+
+// unitManager starts and stops the main unit and the units of the replicas. It is
+// implemented by unitmanager.UnitManager and replaced in tests.
+type unitManager interface {
+	StartMainUnit() error
+	StopMainUnit() error
+	StartTemplateUnit(name, datadir string, port int32, ports map[string]int32, resources map[string]string) error
+	StopTemplateUnit(name string) error
+	ListTemplateUnitNames() ([]string, error)
+	ListServiceConfigNames() ([]string, error)
+	DeleteServiceConfig(name string) error
+	HasSockets() bool
+	GetSocketPath(name string) (string, error)
 }
 
 // New creates a Conductor object and populates the current state structure
@@ -29,14 +75,44 @@ func New(cfg *config.Config, logger *zap.Logger) *Conductor {
 		cfg.ConfigTemplatePath,
 		cfg.UnitTemplateString,
 		cfg.ConfigPathTemplateString,
+		cfg.SocketPathTemplateString,
+		logger,
+	)
+	driver, err := zfsmanager.NewDriver(cfg.StorageDriver)
+	if err != nil {
+		logger.Fatal("bad configuration: could not initialize storage driver", zap.Error(err))
+	}
+
+	return newConductor(cfg, um, driver, logger)
+}
+
+// newConductor creates a Conductor object on top of the provided unit manager and
+// storage driver
+func newConductor(cfg *config.Config, um unitManager, driver zfsmanager.Driver, logger *zap.Logger) *Conductor {
+	om := opmanager.New(
+		cfg.QueueSize,
 		logger,
 	)
+	portRanges, err := portmanager.ParseRanges(cfg.PortRanges)
+	if err != nil {
+		logger.Fatal("bad configuration: invalid port range", zap.Error(err))
+	}
+	if cfg.PortLowerBound != 0 || cfg.PortUpperBound != 0 {
+		portRanges = append([]portmanager.Range{{From: uint32(cfg.PortLowerBound), To: uint32(cfg.PortUpperBound)}}, portRanges...)
+	}
+	portExclude, err := portmanager.ParseRanges(cfg.PortExclude)
+	if err != nil {
+		logger.Fatal("bad configuration: invalid port exclusion", zap.Error(err))
+	}
 	pm := portmanager.New(
-		cfg.PortLowerBound,
-		cfg.PortUpperBound,
+		portRanges,
+		portExclude,
+		cfg.PortProbe,
 		logger,
 	)
+	slots := newSlots(cfg.PortSlots, cfg.Resources, cfg.PortProbe, pm, logger)
 	zm := zfsmanager.New(
+		driver,
 		cfg.PoolName,
 		cfg.PoolDev,
 		cfg.PoolPath,
@@ -47,22 +123,128 @@ func New(cfg *config.Config, logger *zap.Logger) *Conductor {
 		logger,
 	)
 
+	j := journal.New(zm, logger)
+	ph := portmanager.NewHistory(zm, logger)
+	q, err := quiesce.New(
+		cfg.QuiesceStrategy,
+		quiesce.Options{
+			StopUnit:       um.StopMainUnit,
+			StartUnit:      um.StartMainUnit,
+			QuiesceCommand: cfg.QuiesceCommand,
+			ResumeCommand:  cfg.ResumeCommand,
+			Timeout:        time.Duration(cfg.QuiesceTimeout) * time.Second,
+		},
+		logger,
+	)
+	if err != nil {
+		logger.Fatal("bad configuration: could not initialize quiesce strategy", zap.Error(err))
+	}
+
+	hookCfg := make(map[string]hooks.Hook)
+	for point, hook := range cfg.Hooks {
+		hookCfg[point] = hooks.Hook{
+			Command:   hook.Command,
+			Timeout:   time.Duration(hook.Timeout) * time.Second,
+			OnFailure: hook.OnFailure,
+		}
+	}
+	hk, err := hooks.New(hookCfg, logger)
+	if err != nil {
+		logger.Fatal("bad configuration: could not initialize hooks", zap.Error(err))
+	}
+
+	if !isReconcilePolicy(cfg.ReconcilePolicy) {
+		logger.Fatal("bad configuration: unknown reconcile policy", zap.String("policy", cfg.ReconcilePolicy))
+	}
+
+	if !isTrashPortsPolicy(cfg.TrashPorts) {
+		logger.Fatal("bad configuration: unknown trash port policy", zap.String("policy", cfg.TrashPorts))
+	}
+
+	castProperties, err := mergeProperties(nil, cfg.CastProperties)
+	if err != nil {
+		logger.Fatal("bad configuration: invalid cast property", zap.Error(err))
+	}
+	replicaProperties, err := mergeProperties(nil, cfg.ReplicaProperties)
+	if err != nil {
+		logger.Fatal("bad configuration: invalid replica property", zap.Error(err))
+	}
+
+	if cfg.MinFreePercent < 0 || cfg.MinFreePercent >= 100 {
+		logger.Fatal("bad configuration: free space percentage out of range", zap.Float64("percent", cfg.MinFreePercent))
+	}
+
+	if !isStickyPortsMode(cfg.StickyPorts) {
+		logger.Fatal("bad configuration: unknown sticky port mode", zap.String("mode", cfg.StickyPorts))
+	}
+
 	conductor := &Conductor{
 		l:     logger,
+		j:     j,
+		om:    om,
 		um:    um,
 		pm:    pm,
+		slots: slots,
+		ph:    ph,
 		zm:    zm,
+		q:     q,
+		hk:    hk,
 		casts: nil,
+
+		reconcilePolicy: cfg.ReconcilePolicy,
+		castUnit:        cfg.CastUnit,
+
+		checkpointStopUnit: cfg.CheckpointStopUnit,
+
+		reapInterval: time.Duration(cfg.ReapInterval) * time.Second,
+
+		trashGracePeriod: time.Duration(cfg.TrashGracePeriod) * time.Second,
+		trashPorts:       cfg.TrashPorts,
+
+		castProperties:    castProperties,
+		replicaProperties: replicaProperties,
+
+		minFreeBytes:   cfg.MinFreeBytes,
+		minFreePercent: cfg.MinFreePercent,
+
+		stickyPorts: cfg.StickyPorts,
 	}
 	logger.Debug("initialized conductor")
 
 	return conductor
 }
 
-// MustLoad executes the load methods recursively and exits if an error occurs
+// MustLoad recovers the operations found in the journal, executes the load methods
+// recursively, reconciles the host according to the configured policy, starts the
+// reaper and exits if an error occurs
 func (cnd *Conductor) MustLoad() {
+	err := cnd.j.Load()
+	if err != nil {
+		// without the journal there is no telling whether the source was left quiesced
+		resumeErr := cnd.q.Resume()
+		if resumeErr != nil {
+			cnd.l.Error("failed to resume source", zap.Error(resumeErr))
+		}
+		cnd.l.Fatal("failed to load journal", zap.Error(err))
+		return
+	}
+
+	err = cnd.recoverQuiesce()
+	if err != nil {
+		cnd.l.Fatal("failed to resume source", zap.Error(err))
+		return
+	}
+
 	cnd.zm.MustLoad()
 
+	if cnd.stickyPorts != StickyPortsNone {
+		err = cnd.ph.Load()
+		if err != nil {
+			cnd.l.Fatal("failed to load port history", zap.Error(err))
+			return
+		}
+	}
+
 	casts, err := cnd.loadCasts()
 	if err != nil {
 		cnd.l.Fatal("failed to populate conductor with casts")
@@ -79,14 +261,40 @@ func (cnd *Conductor) MustLoad() {
 		cast.replicas = replicas
 	}
 
+	// the trash is loaded after every live replica has bound its port, so that a held
+	// port never takes one of theirs
+	for _, cast := range casts {
+		trash, err := cnd.loadTrash(cast.Id)
+		if err != nil {
+			cnd.l.Fatal("failed to populate cast with trashed replicas", zap.String("cast", cast.Id))
+			return
+		}
+
+		cast.trash = trash
+	}
+
 	cnd.casts = casts
+
+	cnd.recoverIntents()
+
+	_, err = cnd.reconcile(cnd.reconcilePolicy)
+	if err != nil {
+		cnd.l.Fatal("failed to reconcile", zap.Error(err))
+		return
+	}
+
+	if cnd.reapInterval > 0 {
+		go cnd.reap()
+	}
+
 	return
 }
 
-// Shutdown waits to acquire lock and exits
+// Shutdown waits for the running operation to finish and exits
 func (cnd *Conductor) Shutdown() {
 	cnd.l.Info("received signal, shutting down")
 
+	cnd.om.Shutdown()
 	cnd.mu.Lock()
 	cnd.l.Info("goodbye")
 	os.Exit(0)
@@ -98,16 +306,13 @@ func (cnd *Conductor) loadCasts() (map[string]*Cast, error) {
 
 	castIds := cnd.zm.GetCastIds()
 	for _, castId := range castIds {
-		timestamp, err := cnd.zm.GetCastTimestamp(castId)
+		state, err := cnd.zm.GetCastState(castId)
 		if err != nil {
 			return nil, err
 		}
 
-		casts[castId] = &Cast{
-			Id:        castId,
-			Timestamp: timestamp.Format(time.RFC3339),
-			replicas:  nil,
-		}
+		casts[castId] = newCast(castId, state)
+		casts[castId].Properties = cnd.getCastProperties(castId)
 	}
 
 	return casts, nil
@@ -122,22 +327,58 @@ func (cnd *Conductor) loadReplicas(castId string) (map[string]*Replica, error) {
 		return nil, err
 	}
 	for _, replicaId := range replicaIds {
-		port, _ := cnd.zm.GetReplicaPort(castId, replicaId)
+		replica, err := cnd.loadReplica(castId, replicaId)
 		if err != nil {
 			return replicas, err
 		}
-		urn := cnd.getUniqueReplicaName(castId, replicaId)
-		cnd.l.Debug("binding port for replica", zap.String("cast", castId), zap.String("replica", replicaId))
-		err = cnd.pm.Bind(port, urn)
-		if err != nil {
-			return replicas, err
-		}
-
-		replicas[replicaId] = &Replica{
-			Id:   replicaId,
-			Port: port,
-		}
+		replicas[replicaId] = replica
 	}
 
 	return replicas, nil
 }
+
+// loadReplica populates the state of a replica from its dataset and binds its ports and
+// resources. The caller must hold the lock once the conductor is running.
+func (cnd *Conductor) loadReplica(castId, replicaId string) (*Replica, error) {
+	port, err := cnd.zm.GetReplicaPort(castId, replicaId)
+	if err != nil {
+		return nil, err
+	}
+	expiresAt, err := cnd.zm.GetReplicaExpiry(castId, replicaId)
+	if err != nil {
+		return nil, err
+	}
+	protected, err := cnd.zm.GetReplicaProtection(castId, replicaId)
+	if err != nil {
+		return nil, err
+	}
+	ports, err := cnd.zm.GetReplicaPorts(castId, replicaId)
+	if err != nil {
+		return nil, err
+	}
+	resources, err := cnd.zm.GetReplicaResources(castId, replicaId)
+	if err != nil {
+		return nil, err
+	}
+	urn := cnd.getUniqueReplicaName(castId, replicaId)
+	cnd.l.Debug("binding port for replica", zap.String("cast", castId), zap.String("replica", replicaId))
+	err = cnd.bindPort(port, urn)
+	if err != nil {
+		return nil, err
+	}
+	err = cnd.bindSlots(urn, ports, resources)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Replica{
+		Id:         replicaId,
+		Port:       port,
+		Socket:     cnd.getReplicaSocket(castId, replicaId, port),
+		Ports:      ports,
+		Resources:  resources,
+		ExpiresAt:  expiresAt,
+		Protected:  protected,
+		Properties: cnd.getReplicaProperties(castId, replicaId),
+	}, nil
+}
@@ -0,0 +1,183 @@
+package conductor
+
+import (
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dnsinogeorgos/conductor/internal/config"
+	"github.com/dnsinogeorgos/conductor/internal/opmanager"
+	"github.com/dnsinogeorgos/conductor/internal/zfsmanager"
+	"go.uber.org/zap"
+)
+
+// fakeUnits implements unitManager in memory and keeps track of the running template
+// units
+type fakeUnits struct {
+	mu      sync.Mutex
+	running map[string]bool
+	// startErr is returned by StartTemplateUnit, if set
+	startErr error
+	// onStop is called before a template unit is stopped, if set
+	onStop func(name string)
+	// sockets enables socket endpoints, which are placed under /run
+	sockets bool
+}
+
+func newFakeUnits() *fakeUnits {
+	return &fakeUnits{running: make(map[string]bool)}
+}
+
+func (u *fakeUnits) StartMainUnit() error {
+	return nil
+}
+
+func (u *fakeUnits) StopMainUnit() error {
+	return nil
+}
+
+func (u *fakeUnits) StartTemplateUnit(name, datadir string, port int32, ports map[string]int32, resources map[string]string) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if u.startErr != nil {
+		return u.startErr
+	}
+	u.running[name] = true
+	return nil
+}
+
+func (u *fakeUnits) StopTemplateUnit(name string) error {
+	if u.onStop != nil {
+		u.onStop(name)
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	delete(u.running, name)
+	return nil
+}
+
+func (u *fakeUnits) ListTemplateUnitNames() ([]string, error) {
+	return u.names(), nil
+}
+
+func (u *fakeUnits) ListServiceConfigNames() ([]string, error) {
+	return u.names(), nil
+}
+
+func (u *fakeUnits) DeleteServiceConfig(name string) error {
+	return nil
+}
+
+func (u *fakeUnits) HasSockets() bool {
+	return u.sockets
+}
+
+func (u *fakeUnits) GetSocketPath(name string) (string, error) {
+	if !u.sockets {
+		return "", nil
+	}
+	return "/run/" + name + ".sock", nil
+}
+
+// names returns the sorted names of the running template units
+func (u *fakeUnits) names() []string {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	names := make([]string, 0, len(u.running))
+	for name := range u.running {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// newTestConductor creates and loads a Conductor on top of a new memory storage driver
+// and fake units, with three ports to hand out
+func newTestConductor(t *testing.T) (*Conductor, *fakeUnits) {
+	t.Helper()
+
+	units := newFakeUnits()
+	cnd := loadTestConductor(t, zfsmanager.NewMemoryDriver(), units)
+
+	return cnd, units
+}
+
+// loadTestConductor creates and loads a Conductor on top of the provided driver and
+// units, the way the service starts again on a host that already has state
+func loadTestConductor(t *testing.T, d zfsmanager.Driver, units *fakeUnits) *Conductor {
+	t.Helper()
+
+	cfg := &config.Config{
+		QueueSize:       8,
+		ReconcilePolicy: "report",
+		TrashPorts:      "hold",
+		PoolName:        "testpool",
+		PoolDev:         "/dev/null",
+		PoolPath:        "/testpool",
+		FilesystemName:  "fs",
+		FilesystemPath:  "/var/lib/fs",
+		CastPath:        "/fs_cast",
+		ReplicaPath:     "/fs_replica",
+		PortRanges:      []string{"3307-3309"},
+		PortProbe:       "none",
+		StickyPorts:     "none",
+		PortSlots:       map[string]config.PortSlot{"admin": {Ranges: []string{"4000-4002"}}},
+		Resources:       map[string]config.Resource{"address": {Kind: "ip", Ranges: []string{"127.0.1.1-127.0.1.3"}}},
+	}
+	cnd := newConductor(cfg, units, d, zap.NewNop())
+	cnd.MustLoad()
+
+	return cnd
+}
+
+// wait waits for an operation to finish and fails the test if it did not succeed
+func wait(t *testing.T, cnd *Conductor, op opmanager.Operation, err error) {
+	t.Helper()
+
+	if err != nil {
+		t.Fatalf("%s was not queued: %v", op.Kind, err)
+	}
+
+	op = finish(t, cnd, op)
+	if op.State == opmanager.Failed {
+		t.Fatalf("%s failed: %v", op.Kind, op.Err)
+	}
+}
+
+// finish waits for an operation to finish and returns its final state
+func finish(t *testing.T, cnd *Conductor, op opmanager.Operation) opmanager.Operation {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		op, err := cnd.GetOperation(op.Id)
+		if err != nil {
+			t.Fatalf("GetOperation() error = %v", err)
+		}
+		if op.State == opmanager.Succeeded || op.State == opmanager.Failed {
+			return op
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("%s did not finish", op.Kind)
+
+	return op
+}
+
+// portOwner returns the name the main port is bound to, if any
+func portOwner(cnd *Conductor, port int32) string {
+	owner, _ := cnd.pm.Owner(port)
+	return owner
+}
+
+// slotOwner returns the name the port of a port slot is bound to, if any
+func slotOwner(cnd *Conductor, slot string, port int32) string {
+	owner, _ := cnd.slots[slot].Owner(formatPort(port))
+	return owner
+}
@@ -0,0 +1,41 @@
+package conductor
+
+import (
+	"github.com/dnsinogeorgos/conductor/internal/opmanager"
+	"go.uber.org/zap"
+)
+
+// Kinds of the operations submitted by the conductor
+const (
+	OperationCreateCast        = "create_cast"
+	OperationDeleteCast        = "delete_cast"
+	OperationCreateReplica     = "create_replica"
+	OperationDeleteReplica     = "delete_replica"
+	OperationTrashReplica      = "trash_replica"
+	OperationRestoreReplica    = "restore_replica"
+	OperationPurgeReplica      = "purge_replica"
+	OperationResetReplica      = "reset_replica"
+	OperationRefreshCast       = "refresh_cast"
+	OperationCreateCheckpoint  = "create_checkpoint"
+	OperationRestoreCheckpoint = "restore_checkpoint"
+	OperationDeleteCheckpoint  = "delete_checkpoint"
+	OperationReconcile         = "reconcile"
+)
+
+// GetOperation retrieves an operation from the queue
+func (cnd *Conductor) GetOperation(id string) (opmanager.Operation, error) {
+	op, err := cnd.om.Get(id)
+	if err != nil {
+		cnd.l.Debug("cannot get operation, not found", zap.String("operation", id))
+		return opmanager.Operation{}, err
+	}
+
+	cnd.l.Debug("getting operation object", zap.String("operation", id))
+	return op, nil
+}
+
+// ListOperations returns a slice of the known operations
+func (cnd *Conductor) ListOperations() []opmanager.Operation {
+	cnd.l.Debug("listing operation objects")
+	return cnd.om.List()
+}
@@ -0,0 +1,51 @@
+package conductor
+
+import (
+	"github.com/dnsinogeorgos/conductor/internal/zfsmanager"
+	"go.uber.org/zap"
+)
+
+// mergeProperties returns the configured default properties with the provided ones
+// applied over them. It fails on the first property that is not supported or has an
+// invalid value.
+func mergeProperties(defaults, properties map[string]string) (map[string]string, error) {
+	merged := make(map[string]string, len(defaults)+len(properties))
+	for key, value := range defaults {
+		merged[key] = value
+	}
+	for key, value := range properties {
+		merged[key] = value
+	}
+
+	for key, value := range merged {
+		if !zfsmanager.IsValidProperty(key, value) {
+			return nil, InvalidPropertyError{key, value}
+		}
+	}
+
+	return merged, nil
+}
+
+// getCastProperties returns the effective properties of a cast dataset. They are only
+// reported, so a failure to read them is logged and nil is returned.
+func (cnd *Conductor) getCastProperties(id string) map[string]string {
+	properties, err := cnd.zm.GetCastProperties(id)
+	if err != nil {
+		cnd.l.Warn("failed to get properties of cast", zap.String("cast", id), zap.Error(err))
+		return nil
+	}
+
+	return properties
+}
+
+// getReplicaProperties returns the effective properties of a replica dataset. They are
+// only reported, so a failure to read them is logged and nil is returned.
+func (cnd *Conductor) getReplicaProperties(castId, id string) map[string]string {
+	properties, err := cnd.zm.GetReplicaProperties(castId, id)
+	if err != nil {
+		cnd.l.Warn("failed to get properties of replica", zap.String("cast", castId), zap.String("replica", id), zap.Error(err))
+		return nil
+	}
+
+	return properties
+}
@@ -0,0 +1,94 @@
+package conductor
+
+import (
+	"go.uber.org/zap"
+)
+
+// ProtectCast sets whether a cast is protected from deletion. A protected cast is not
+// reaped and is only deleted when the protection is overridden.
+func (cnd *Conductor) ProtectCast(id string, protected bool) (*Cast, error) {
+	cnd.mu.RLock()
+	_, ok := cnd.casts[id]
+	cnd.mu.RUnlock()
+	if !ok {
+		cnd.l.Debug("cannot protect cast, not found", zap.String("cast", id))
+		return &Cast{}, CastNotFoundError{id}
+	}
+
+	err := cnd.zm.SetCastProtection(id, protected)
+	if err != nil {
+		return &Cast{}, wrapError(err)
+	}
+
+	cnd.mu.Lock()
+	defer cnd.mu.Unlock()
+
+	cast, ok := cnd.casts[id]
+	if !ok {
+		return &Cast{}, CastNotFoundError{id}
+	}
+
+	// the object is replaced instead of modified, since readers use it without the lock
+	cnd.l.Info("setting cast protection", zap.String("cast", id), zap.Bool("protected", protected))
+	updated := *cast
+	updated.Protected = protected
+	cnd.casts[id] = &updated
+
+	return &updated, nil
+}
+
+// ProtectReplica sets whether a replica is protected from deletion. A protected replica
+// is not reaped, not dropped by a refresh and only deleted when the protection is
+// overridden.
+func (cnd *Conductor) ProtectReplica(castId, id string, protected bool) (*Replica, error) {
+	cnd.mu.RLock()
+	err := cnd.validateReplica(castId, id)
+	cnd.mu.RUnlock()
+	if err != nil {
+		cnd.l.Debug("cannot protect replica", zap.String("cast", castId), zap.String("replica", id), zap.Error(err))
+		return &Replica{}, err
+	}
+
+	return cnd.setReplicaProtection(castId, id, protected)
+}
+
+// setReplicaProtection records whether a replica is protected in its state and replaces
+// its object
+func (cnd *Conductor) setReplicaProtection(castId, id string, protected bool) (*Replica, error) {
+	err := cnd.zm.SetReplicaProtection(castId, id, protected)
+	if err != nil {
+		return &Replica{}, wrapError(err)
+	}
+
+	cnd.mu.Lock()
+	defer cnd.mu.Unlock()
+
+	err = cnd.validateReplica(castId, id)
+	if err != nil {
+		return &Replica{}, err
+	}
+
+	// the object is replaced instead of modified, since readers use it without the lock
+	cnd.l.Info("setting replica protection", zap.String("cast", castId), zap.String("replica", id), zap.Bool("protected", protected))
+	cast := cnd.casts[castId]
+	updated := *cast.replicas[id]
+	updated.Protected = protected
+	cast.replicas[id] = &updated
+
+	return &updated, nil
+}
+
+// isCastProtected reports whether a cast is loaded and protected
+func (cnd *Conductor) isCastProtected(id string) bool {
+	cnd.mu.RLock()
+	defer cnd.mu.RUnlock()
+
+	cast, ok := cnd.casts[id]
+	return ok && cast.Protected
+}
+
+// isReplicaProtected reports whether a replica is loaded and protected
+func (cnd *Conductor) isReplicaProtected(castId, id string) bool {
+	replica, ok := cnd.getLoadedReplica(castId, id)
+	return ok && replica.Protected
+}
@@ -0,0 +1,84 @@
+package conductor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dnsinogeorgos/conductor/internal/zfsmanager"
+)
+
+func TestProtectReplica(t *testing.T) {
+	d := zfsmanager.NewMemoryDriver()
+	units := newFakeUnits()
+	cnd := loadTestConductor(t, d, units)
+
+	expiresAt := time.Now().Add(time.Hour)
+	op, err := cnd.CreateCast("c1", time.Time{}, nil)
+	wait(t, cnd, op, err)
+	op, err = cnd.CreateReplica("c1", "r1", expiresAt, nil, 0, false)
+	wait(t, cnd, op, err)
+
+	replica, err := cnd.ProtectReplica("c1", "r1", true)
+	if err != nil || !replica.Protected {
+		t.Fatalf("ProtectReplica() = %+v, %v, want a protected replica", replica, err)
+	}
+
+	_, err = cnd.DeleteReplica("c1", "r1", false)
+	if _, ok := err.(ReplicaProtectedError); !ok {
+		t.Errorf("DeleteReplica() of protected replica error = %v, want ReplicaProtectedError", err)
+	}
+	_, err = cnd.DeleteCast("c1", true, false)
+	if _, ok := err.(ReplicaProtectedError); !ok {
+		t.Errorf("DeleteCast() with protected replica error = %v, want ReplicaProtectedError", err)
+	}
+	_, err = cnd.RefreshCast("c1", []string{})
+	if _, ok := err.(ReplicaProtectedError); !ok {
+		t.Errorf("RefreshCast() dropping protected replica error = %v, want ReplicaProtectedError", err)
+	}
+
+	reapAt(t, cnd, expiresAt.Add(time.Minute))
+	if _, err := cnd.GetReplica("c1", "r1"); err != nil {
+		t.Errorf("protected replica was reaped: %v", err)
+	}
+
+	// the protection is persisted in the state of the replica
+	cnd = loadTestConductor(t, d, units)
+	replica, err = cnd.GetReplica("c1", "r1")
+	if err != nil || !replica.Protected {
+		t.Fatalf("GetReplica() after reload = %+v, %v, want a protected replica", replica, err)
+	}
+
+	op, err = cnd.DeleteReplica("c1", "r1", true)
+	wait(t, cnd, op, err)
+	if _, err := cnd.GetReplica("c1", "r1"); err == nil {
+		t.Error("protected replica was not deleted with force")
+	}
+}
+
+func TestProtectCast(t *testing.T) {
+	cnd, _ := newTestConductor(t)
+
+	op, err := cnd.CreateCast("c1", time.Now().Add(time.Hour), nil)
+	wait(t, cnd, op, err)
+
+	cast, err := cnd.ProtectCast("c1", true)
+	if err != nil || !cast.Protected {
+		t.Fatalf("ProtectCast() = %+v, %v, want a protected cast", cast, err)
+	}
+
+	_, err = cnd.DeleteCast("c1", false, false)
+	if _, ok := err.(CastProtectedError); !ok {
+		t.Errorf("DeleteCast() of protected cast error = %v, want CastProtectedError", err)
+	}
+	reapAt(t, cnd, time.Now().Add(2*time.Hour))
+	if _, err := cnd.GetCast("c1"); err != nil {
+		t.Errorf("protected cast was reaped: %v", err)
+	}
+
+	_, err = cnd.ProtectCast("c1", false)
+	if err != nil {
+		t.Fatalf("ProtectCast() error = %v", err)
+	}
+	op, err = cnd.DeleteCast("c1", false, false)
+	wait(t, cnd, op, err)
+}
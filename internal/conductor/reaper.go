@@ -0,0 +1,122 @@
+package conductor
+
+import (
+	"time"
+
+	"github.com/dnsinogeorgos/conductor/internal/opmanager"
+	"go.uber.org/zap"
+)
+
+// reap periodically queues the deletion of the expired replicas, the purge of the
+// trashed replicas past their grace period, and the deletion of the expired casts once
+// they are empty
+func (cnd *Conductor) reap() {
+	cnd.l.Info("started reaper", zap.Duration("interval", cnd.reapInterval))
+
+	// operations queued by the reaper, so that a deletion is not queued again while it
+	// is pending
+	pending := make(map[string]string)
+
+	ticker := time.NewTicker(cnd.reapInterval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		cnd.reapExpired(now, pending)
+	}
+}
+
+// reapExpired queues the deletion of the replicas and the empty casts that expired
+// before the provided time and the purge of the trashed replicas due by then, skipping
+// the ones with a pending operation. Failures are retried on the next run.
+func (cnd *Conductor) reapExpired(now time.Time, pending map[string]string) {
+	for key, opId := range pending {
+		op, err := cnd.om.Get(opId)
+		if err != nil || (op.State != opmanager.Queued && op.State != opmanager.Running) {
+			delete(pending, key)
+		}
+	}
+
+	replicas, trashed, casts := cnd.getExpired(now)
+
+	for castId, ids := range replicas {
+		for _, id := range ids {
+			key := castId + "/" + id
+			if _, ok := pending[key]; ok {
+				continue
+			}
+
+			cnd.l.Info("reaping expired replica", zap.String("cast", castId), zap.String("replica", id))
+			op, err := cnd.DeleteReplica(castId, id, false)
+			if err != nil {
+				cnd.l.Warn("failed to queue deletion of expired replica", zap.String("cast", castId), zap.String("replica", id), zap.Error(err))
+				continue
+			}
+			pending[key] = op.Id
+		}
+	}
+
+	for castId, ids := range trashed {
+		for _, id := range ids {
+			key := "trash:" + castId + "/" + id
+			if _, ok := pending[key]; ok {
+				continue
+			}
+
+			cnd.l.Info("purging trashed replica", zap.String("cast", castId), zap.String("replica", id))
+			op, err := cnd.PurgeTrashedReplica(castId, id)
+			if err != nil {
+				cnd.l.Warn("failed to queue purge of trashed replica", zap.String("cast", castId), zap.String("replica", id), zap.Error(err))
+				continue
+			}
+			pending[key] = op.Id
+		}
+	}
+
+	for _, id := range casts {
+		if _, ok := pending[id]; ok {
+			continue
+		}
+
+		cnd.l.Info("reaping expired cast", zap.String("cast", id))
+		op, err := cnd.DeleteCast(id, false, false)
+		if err != nil {
+			cnd.l.Warn("failed to queue deletion of expired cast", zap.String("cast", id), zap.Error(err))
+			continue
+		}
+		pending[id] = op.Id
+	}
+}
+
+// getExpired returns the ids of the replicas that expired before the provided time and
+// of the trashed replicas due for purging by cast, and the ids of the empty casts that
+// expired. Protected replicas and casts are skipped.
+func (cnd *Conductor) getExpired(now time.Time) (map[string][]string, map[string][]string, []string) {
+	cnd.mu.RLock()
+	defer cnd.mu.RUnlock()
+
+	replicas := make(map[string][]string)
+	trashed := make(map[string][]string)
+	casts := make([]string, 0)
+	for castId, cast := range cnd.casts {
+		for id, replica := range cast.replicas {
+			if !replica.Protected && isExpired(replica.ExpiresAt, now) {
+				replicas[castId] = append(replicas[castId], id)
+			}
+		}
+		for id, replica := range cast.trash {
+			if isExpired(replica.PurgeAt, now) {
+				trashed[castId] = append(trashed[castId], id)
+			}
+		}
+
+		if !cast.Protected && len(cast.replicas) == 0 && len(cast.trash) == 0 && isExpired(cast.ExpiresAt, now) {
+			casts = append(casts, castId)
+		}
+	}
+
+	return replicas, trashed, casts
+}
+
+// isExpired reports whether an expiry is set and has passed at the provided time
+func isExpired(expiresAt, now time.Time) bool {
+	return !expiresAt.IsZero() && !now.Before(expiresAt)
+}
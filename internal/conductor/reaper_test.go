@@ -0,0 +1,56 @@
+package conductor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dnsinogeorgos/conductor/internal/opmanager"
+)
+
+// reapAt runs the reaper as of the provided time and waits for the deletions it queued
+func reapAt(t *testing.T, cnd *Conductor, now time.Time) {
+	t.Helper()
+
+	pending := make(map[string]string)
+	cnd.reapExpired(now, pending)
+	for _, opId := range pending {
+		wait(t, cnd, opmanager.Operation{Id: opId}, nil)
+	}
+}
+
+func TestReapExpired(t *testing.T) {
+	cnd, _ := newTestConductor(t)
+
+	expiresAt := time.Now().Add(time.Hour)
+	op, err := cnd.CreateCast("c1", expiresAt, nil)
+	wait(t, cnd, op, err)
+	op, err = cnd.CreateReplica("c1", "r1", expiresAt, nil, 0, false)
+	wait(t, cnd, op, err)
+	op, err = cnd.CreateReplica("c1", "r2", time.Time{}, nil, 0, false)
+	wait(t, cnd, op, err)
+
+	reapAt(t, cnd, time.Now())
+	if _, err := cnd.GetReplica("c1", "r1"); err != nil {
+		t.Errorf("replica was reaped before it expired: %v", err)
+	}
+
+	// the cast is kept while it has replicas
+	later := expiresAt.Add(time.Minute)
+	reapAt(t, cnd, later)
+	if _, err := cnd.GetReplica("c1", "r1"); err == nil {
+		t.Error("expired replica was not reaped")
+	}
+	if _, err := cnd.GetReplica("c1", "r2"); err != nil {
+		t.Errorf("replica without expiry was reaped: %v", err)
+	}
+	if _, err := cnd.GetCast("c1"); err != nil {
+		t.Errorf("expired cast with replicas was reaped: %v", err)
+	}
+
+	op, err = cnd.DeleteReplica("c1", "r2", false)
+	wait(t, cnd, op, err)
+	reapAt(t, cnd, later)
+	if _, err := cnd.GetCast("c1"); err == nil {
+		t.Error("expired empty cast was not reaped")
+	}
+}
@@ -0,0 +1,294 @@
+package conductor
+
+import (
+	"time"
+
+	"github.com/dnsinogeorgos/conductor/internal/opmanager"
+	"github.com/dnsinogeorgos/conductor/internal/zfsmanager"
+	"go.uber.org/zap"
+)
+
+// Reconciliation policies. Report only lists the orphans, clean destroys them and adopt
+// writes new state onto orphaned datasets and cleans up everything else.
+const (
+	ReconcileReport = "report"
+	ReconcileClean  = "clean"
+	ReconcileAdopt  = "adopt"
+)
+
+// Kinds of orphans, next to the datasets and snapshots reported by the zfsmanager
+const (
+	OrphanConfigFile = "config_file"
+	OrphanUnit       = "unit"
+)
+
+// Actions taken on orphans
+const (
+	ActionReported  = "reported"
+	ActionDestroyed = "destroyed"
+	ActionAdopted   = "adopted"
+	ActionFailed    = "failed"
+)
+
+// Orphan describes a resource on the host that does not belong to a known cast or replica
+type Orphan struct {
+	Kind   string
+	Name   string
+	Action string
+	Error  string
+}
+
+// Reconciliation contains the outcome of a reconciliation pass
+type Reconciliation struct {
+	Policy  string
+	Orphans []Orphan
+}
+
+// Reconcile validates the policy and queues a reconciliation pass
+func (cnd *Conductor) Reconcile(policy string) (opmanager.Operation, error) {
+	if !isReconcilePolicy(policy) {
+		cnd.l.Debug("cannot reconcile, unknown policy", zap.String("policy", policy))
+		return opmanager.Operation{}, UnknownPolicyError{policy}
+	}
+
+	return cnd.om.Submit(OperationReconcile, "", "", func(opId string) error {
+		result, err := cnd.reconcile(policy)
+		cnd.om.SetResult(opId, result)
+		return wrapError(err)
+	})
+}
+
+// reconcile finds the datasets, snapshots, units and configuration files that do not
+// belong to a known cast or replica and handles them according to the policy
+func (cnd *Conductor) reconcile(policy string) (Reconciliation, error) {
+	result := Reconciliation{
+		Policy:  policy,
+		Orphans: make([]Orphan, 0),
+	}
+
+	cnd.l.Info("reconciling", zap.String("policy", policy))
+	orphans, err := cnd.zm.FindOrphans()
+	if err != nil {
+		return result, err
+	}
+
+	for _, orphan := range orphans {
+		if orphan.Kind == zfsmanager.OrphanSnapshot {
+			continue
+		}
+		result.Orphans = append(result.Orphans, cnd.reconcileDataset(policy, orphan))
+	}
+
+	if policy != ReconcileReport {
+		// destroyed datasets leave their origin snapshots behind
+		orphans, err = cnd.zm.FindOrphans()
+		if err != nil {
+			return result, err
+		}
+	}
+	for _, orphan := range orphans {
+		if orphan.Kind != zfsmanager.OrphanSnapshot {
+			continue
+		}
+		item := Orphan{Kind: orphan.Kind, Name: orphan.Name, Action: ActionReported}
+		if policy != ReconcileReport {
+			item.Action = ActionDestroyed
+			err := cnd.zm.DestroyOrphan(orphan)
+			if err != nil {
+				item.Action = ActionFailed
+				item.Error = err.Error()
+			}
+		}
+		result.Orphans = append(result.Orphans, item)
+	}
+
+	names := cnd.getUniqueReplicaNames()
+
+	units, err := cnd.um.ListTemplateUnitNames()
+	if err != nil {
+		return result, err
+	}
+	for _, name := range units {
+		if names[name] {
+			continue
+		}
+		item := Orphan{Kind: OrphanUnit, Name: name, Action: ActionReported}
+		if policy != ReconcileReport {
+			cnd.l.Info("stopping orphaned unit", zap.String("name", name))
+			item.Action = ActionDestroyed
+			err := cnd.um.StopTemplateUnit(name)
+			if err != nil {
+				item.Action = ActionFailed
+				item.Error = err.Error()
+			}
+		}
+		result.Orphans = append(result.Orphans, item)
+	}
+
+	configs, err := cnd.um.ListServiceConfigNames()
+	if err != nil {
+		return result, err
+	}
+	for _, name := range configs {
+		if names[name] {
+			continue
+		}
+		item := Orphan{Kind: OrphanConfigFile, Name: name, Action: ActionReported}
+		if policy != ReconcileReport {
+			cnd.l.Info("deleting orphaned cfg file", zap.String("name", name))
+			item.Action = ActionDestroyed
+			err := cnd.um.DeleteServiceConfig(name)
+			if err != nil {
+				item.Action = ActionFailed
+				item.Error = err.Error()
+			}
+		}
+		result.Orphans = append(result.Orphans, item)
+	}
+
+	cnd.l.Info("reconciled", zap.String("policy", policy), zap.Int("orphans", len(result.Orphans)))
+	return result, nil
+}
+
+// reconcileDataset handles an orphaned cast or replica dataset according to the policy
+func (cnd *Conductor) reconcileDataset(policy string, orphan zfsmanager.Orphan) Orphan {
+	item := Orphan{Kind: orphan.Kind, Name: orphan.Name, Action: ActionReported}
+
+	var err error
+	switch policy {
+	case ReconcileReport:
+		cnd.l.Warn("found orphan", zap.String("kind", orphan.Kind), zap.String("orphan", orphan.Name))
+		return item
+	case ReconcileClean:
+		item.Action = ActionDestroyed
+		err = cnd.zm.DestroyOrphan(orphan)
+	case ReconcileAdopt:
+		item.Action = ActionAdopted
+		if orphan.Kind == zfsmanager.OrphanCastDataset {
+			err = cnd.adoptCast(orphan)
+		} else {
+			err = cnd.adoptReplica(orphan)
+		}
+	}
+	if err != nil {
+		item.Action = ActionFailed
+		item.Error = err.Error()
+	}
+
+	return item
+}
+
+// adoptCast loads an orphaned cast dataset along with its replicas
+func (cnd *Conductor) adoptCast(orphan zfsmanager.Orphan) error {
+	timestamp, err := cnd.zm.AdoptCastDataset(orphan)
+	if err != nil {
+		return err
+	}
+
+	cnd.mu.Lock()
+	defer cnd.mu.Unlock()
+
+	replicas, err := cnd.loadReplicas(orphan.Id)
+	if err != nil {
+		return err
+	}
+	trash, err := cnd.loadTrash(orphan.Id)
+	if err != nil {
+		return err
+	}
+
+	cnd.l.Info("adopting cast object", zap.String("cast", orphan.Id))
+	cnd.casts[orphan.Id] = &Cast{
+		Id:        orphan.Id,
+		Timestamp: timestamp.Format(time.RFC3339),
+		replicas:  replicas,
+		trash:     trash,
+	}
+
+	return nil
+}
+
+// adoptReplica binds a new port, a port of every port slot and a value of every resource
+// to an orphaned replica dataset and restarts its unit. The ports and resources it last
+// used are reused if sticky ports are enabled and they are available.
+func (cnd *Conductor) adoptReplica(orphan zfsmanager.Orphan) error {
+	castId, id := orphan.CastId, orphan.Id
+	urn := cnd.getUniqueReplicaName(castId, id)
+
+	cnd.mu.Lock()
+	cast, ok := cnd.casts[castId]
+	if !ok {
+		cnd.mu.Unlock()
+		return CastNotFoundError{castId}
+	}
+	port, previous, err := cnd.selectPort(castId, id, 0)
+	if err != nil {
+		cnd.mu.Unlock()
+		return err
+	}
+	err = cnd.pm.Bind(port, urn)
+	if err != nil {
+		cnd.mu.Unlock()
+		return err
+	}
+	ports, resources, err := cnd.allocateSlots(urn, previous.Ports, previous.Resources)
+	if err != nil {
+		_ = cnd.pm.Release(port)
+		cnd.mu.Unlock()
+		return err
+	}
+	cnd.mu.Unlock()
+
+	err = cnd.zm.AdoptReplicaDataset(orphan, port, ports, resources)
+	if err != nil {
+		cnd.mu.Lock()
+		cnd.releaseSlots(urn, ports, resources)
+		_ = cnd.pm.Release(port)
+		cnd.mu.Unlock()
+		return err
+	}
+
+	cnd.l.Info("adopting replica object", zap.String("cast", castId), zap.String("replica", id))
+	cnd.mu.Lock()
+	cast.replicas[id] = &Replica{
+		Id:        id,
+		Port:      port,
+		Ports:     ports,
+		Resources: resources,
+	}
+	cnd.mu.Unlock()
+	cnd.rememberPorts(castId, id, port, ports, resources)
+
+	// a unit may still be running with a configuration that does not match the new port
+	err = cnd.um.StopTemplateUnit(urn)
+	if err != nil {
+		cnd.l.Warn("failed to stop unit of adopted replica", zap.String("cast", castId), zap.String("replica", id), zap.Error(err))
+	}
+
+	return cnd.um.StartTemplateUnit(urn, cnd.zm.GetReplicaMountPoint(castId, id), port, ports, resources)
+}
+
+// getUniqueReplicaNames returns the set of the unique names of the known replicas
+func (cnd *Conductor) getUniqueReplicaNames() map[string]bool {
+	cnd.mu.RLock()
+	defer cnd.mu.RUnlock()
+
+	names := make(map[string]bool)
+	for _, cast := range cnd.casts {
+		for _, replica := range cast.replicas {
+			names[cnd.getUniqueReplicaName(cast.Id, replica.Id)] = true
+		}
+	}
+
+	return names
+}
+
+// isReconcilePolicy reports whether the provided string is a known policy
+func isReconcilePolicy(policy string) bool {
+	switch policy {
+	case ReconcileReport, ReconcileClean, ReconcileAdopt:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,54 @@
+package conductor
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestReconcileOrphanedUnit(t *testing.T) {
+	cnd, units := newTestConductor(t)
+
+	op, err := cnd.CreateCast("c1", time.Time{}, nil)
+	wait(t, cnd, op, err)
+	op, err = cnd.CreateReplica("c1", "r1", time.Time{}, nil, 0, false)
+	wait(t, cnd, op, err)
+
+	units.running["c1_stray"] = true
+
+	tests := []struct {
+		policy      string
+		wantOrphans []Orphan
+		wantRunning []string
+	}{
+		{
+			ReconcileReport,
+			[]Orphan{
+				{Kind: OrphanUnit, Name: "c1_stray", Action: ActionReported},
+				{Kind: OrphanConfigFile, Name: "c1_stray", Action: ActionReported},
+			},
+			[]string{"c1_r1", "c1_stray"},
+		},
+		{
+			// stopping the unit deletes its configuration file as well
+			ReconcileClean,
+			[]Orphan{
+				{Kind: OrphanUnit, Name: "c1_stray", Action: ActionDestroyed},
+			},
+			[]string{"c1_r1"},
+		},
+	}
+	for _, tt := range tests {
+		op, err := cnd.Reconcile(tt.policy)
+		wait(t, cnd, op, err)
+		op = finish(t, cnd, op)
+
+		result := op.Result.(Reconciliation)
+		if !reflect.DeepEqual(result.Orphans, tt.wantOrphans) {
+			t.Errorf("Reconcile(%s) orphans = %+v, want %+v", tt.policy, result.Orphans, tt.wantOrphans)
+		}
+		if got := units.names(); !reflect.DeepEqual(got, tt.wantRunning) {
+			t.Errorf("running units after Reconcile(%s) = %v, want %v", tt.policy, got, tt.wantRunning)
+		}
+	}
+}
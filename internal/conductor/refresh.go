@@ -0,0 +1,405 @@
+package conductor
+
+import (
+	"sort"
+	"time"
+
+	"github.com/dnsinogeorgos/conductor/internal/hooks"
+	"github.com/dnsinogeorgos/conductor/internal/opmanager"
+	"github.com/dnsinogeorgos/conductor/internal/rollback"
+	"go.uber.org/zap"
+)
+
+// RefreshCast validates the request and queues the refresh of a cast. The provided
+// replicas are recreated on the refreshed cast with the same names and ports, including
+// the ports of their port slots, and the same resources, and the rest are deleted. All
+// the replicas are recreated if replicas is nil. The recreated replicas keep their
+// expiry, protection and properties. The trash of the cast is purged.
+func (cnd *Conductor) RefreshCast(id string, replicas []string) (opmanager.Operation, error) {
+	cnd.mu.RLock()
+	defer cnd.mu.RUnlock()
+
+	cast, ok := cnd.casts[id]
+	if !ok {
+		cnd.l.Debug("cannot refresh cast, not found", zap.String("cast", id))
+		return opmanager.Operation{}, CastNotFoundError{id}
+	}
+
+	kept := make([]*Replica, 0)
+	if replicas == nil {
+		for _, replica := range cast.replicas {
+			kept = append(kept, replica)
+		}
+	}
+	for _, replicaId := range replicas {
+		replica, ok := cast.replicas[replicaId]
+		if !ok {
+			cnd.l.Debug("cannot refresh cast, replica not found", zap.String("cast", id), zap.String("replica", replicaId))
+			return opmanager.Operation{}, ReplicaNotFoundError{id, replicaId}
+		}
+		kept = append(kept, replica)
+	}
+
+	keep := make(map[string]int32)
+	expiries := make(map[string]time.Time)
+	protected := make(map[string]bool)
+	for _, replica := range kept {
+		keep[replica.Id] = replica.Port
+		if !replica.ExpiresAt.IsZero() {
+			expiries[replica.Id] = replica.ExpiresAt
+		}
+		if replica.Protected {
+			protected[replica.Id] = true
+		}
+	}
+
+	for _, replica := range cast.replicas {
+		if _, ok := keep[replica.Id]; !ok && replica.Protected {
+			cnd.l.Debug("cannot refresh cast, dropped replica is protected", zap.String("cast", id), zap.String("replica", replica.Id))
+			return opmanager.Operation{}, ReplicaProtectedError{id, replica.Id}
+		}
+	}
+
+	for _, replica := range cast.replicas {
+		if dependent, ok := cnd.getDependentCast(id, replica.Id, ""); ok {
+			cnd.l.Debug("cannot refresh cast, replica is the source of a cast", zap.String("cast", id), zap.String("replica", replica.Id), zap.String("dependent", dependent))
+			return opmanager.Operation{}, ReplicaInUseError{id, replica.Id, dependent}
+		}
+	}
+
+	err := cnd.checkFreeSpace()
+	if err != nil {
+		cnd.l.Debug("cannot refresh cast", zap.String("cast", id), zap.Error(err))
+		return opmanager.Operation{}, err
+	}
+
+	return cnd.om.Submit(OperationRefreshCast, id, "", func(opId string) error {
+		return wrapError(cnd.refreshCast(opId, id, keep, expiries, protected))
+	})
+}
+
+// refreshCast orchestrates the refresh of a cast. The trash of the cast is purged first,
+// since trashed replicas cannot be recreated on the refreshed cast. A new cast dataset is
+// created from a fresh snapshot of the filesystem next to the existing one and the cast
+// hooks run on it.
+// Up to that point every step is reverted on failure. Then the replicas are deleted, the
+// new dataset replaces the cast and the kept replicas are recreated on it with their
+// ports and properties, so that their units are rendered with the same configuration.
+// From the moment the replicas are deleted the operation can only be completed, and an
+// unfinished refresh is completed on the next start.
+func (cnd *Conductor) refreshCast(opId, id string, keep map[string]int32, expiries map[string]time.Time, protected map[string]bool) (err error) {
+	cnd.mu.RLock()
+	cast, ok := cnd.casts[id]
+	trashIds := make([]string, 0)
+	if ok {
+		for replicaId := range cast.trash {
+			trashIds = append(trashIds, replicaId)
+		}
+	}
+	cnd.mu.RUnlock()
+	if !ok {
+		cnd.l.Debug("cannot refresh cast, not found", zap.String("cast", id))
+		return CastNotFoundError{id}
+	}
+
+	for _, replicaId := range trashIds {
+		cnd.l.Info("purging trashed replica before refresh", zap.String("cast", id), zap.String("replica", replicaId))
+		err = cnd.purgeTrashedReplica(id, replicaId)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = cnd.runHook(opId, hooks.PreCast, hooks.Env{CastId: id, MountPoint: cnd.zm.GetFilesystemMountPoint()})
+	if err != nil {
+		return err
+	}
+
+	err = cnd.zm.PurgeRefreshDataset(id)
+	if err != nil {
+		return err
+	}
+
+	err = cnd.checkFreeSpace()
+	if err != nil {
+		return err
+	}
+
+	properties := make(map[string]map[string]string)
+	ports := make(map[string]map[string]int32)
+	resources := make(map[string]map[string]string)
+	for replicaId := range keep {
+		state, err := cnd.zm.GetReplicaState(id, replicaId)
+		if err != nil {
+			return err
+		}
+		properties[replicaId] = state.Properties
+		ports[replicaId] = state.Ports
+		resources[replicaId] = state.Resources
+	}
+
+	intent, err := cnd.j.Begin(OperationRefreshCast, id, "", 0, stepQuiesce)
+	if err != nil {
+		return err
+	}
+	err = cnd.j.SetReplicas(intent, keep, expiries, protected, properties, ports, resources)
+	if err != nil {
+		cnd.finishIntent(intent)
+		return err
+	}
+
+	// the intent is kept if the source could not be resumed or if the refresh could not
+	// be completed, so that it is retried on the next start
+	quiesced, pending := false, false
+	defer func() {
+		if quiesced {
+			cnd.l.Error("source is left quiesced", zap.String("cast", id), zap.String("intent", intent))
+			return
+		}
+		if pending {
+			cnd.l.Error("refresh is left unfinished", zap.String("cast", id), zap.String("intent", intent))
+			return
+		}
+		cnd.finishIntent(intent)
+	}()
+
+	rb := rollback.New(cnd.l)
+	defer func() {
+		if err != nil {
+			rb.Run()
+		}
+	}()
+
+	cnd.l.Debug("creating refreshed cast dataset", zap.String("cast", id), zap.String("quiesce", cnd.q.Name()))
+	_, err = cnd.zm.CreateRefreshDataset(id, cnd.q.Name(), cnd.castHooks(opId, intent, id, &quiesced))
+	if err != nil {
+		return err
+	}
+	rb.Add("purge refreshed cast dataset", func() error {
+		return cnd.zm.PurgeRefreshDataset(id)
+	})
+
+	err = cnd.prepareCast(opId, intent, id, cnd.zm.GetRefreshMountPoint(id), rb)
+	if err != nil {
+		return err
+	}
+
+	cnd.mu.RLock()
+	dropped := make([]*Replica, 0)
+	for _, replica := range cast.replicas {
+		if _, ok := keep[replica.Id]; !ok {
+			dropped = append(dropped, replica)
+		}
+	}
+	cnd.mu.RUnlock()
+
+	// a replica may have been protected while the refresh was queued
+	for _, replica := range dropped {
+		if replica.Protected {
+			cnd.l.Debug("cannot refresh cast, dropped replica is protected", zap.String("cast", id), zap.String("replica", replica.Id))
+			return ReplicaProtectedError{id, replica.Id}
+		}
+	}
+
+	for _, replica := range dropped {
+		err = cnd.runHook(opId, hooks.PreReplicaDelete, hooks.Env{
+			CastId:     id,
+			ReplicaId:  replica.Id,
+			MountPoint: cnd.zm.GetReplicaMountPoint(id, replica.Id),
+			Port:       replica.Port,
+			Socket:     replica.Socket,
+			Ports:      replica.Ports,
+			Resources:  replica.Resources,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	rb.Discard()
+
+	pending = true
+	cnd.stepIntent(intent, stepDeleteDataset)
+	hookErr, err := cnd.completeRefresh(opId, intent, id, stepDeleteDataset, keep, expiries, protected, properties, ports, resources)
+	if err != nil {
+		return err
+	}
+	pending = false
+
+	return hookErr
+}
+
+// completeRefresh continues a refresh from the provided step. It deletes the replicas
+// of the cast, replaces the cast with its refreshed dataset and recreates the kept
+// replicas with their ports, resources, expiries, protection and properties. The steps
+// that have already been done are skipped, so that an interrupted refresh can be
+// completed by calling it again. Failures of the post_replica_create hooks are returned
+// separately, since they do not leave the refresh unfinished.
+func (cnd *Conductor) completeRefresh(opId, intent, id, step string, keep map[string]int32, expiries map[string]time.Time, protected map[string]bool, properties map[string]map[string]string, ports map[string]map[string]int32, resources map[string]map[string]string) (hookErr, err error) {
+	if step == stepDeleteDataset {
+		cnd.mu.RLock()
+		replicas := make([]*Replica, 0)
+		if cast, ok := cnd.casts[id]; ok {
+			for _, replica := range cast.replicas {
+				replicas = append(replicas, replica)
+			}
+		}
+		cnd.mu.RUnlock()
+
+		for _, replica := range replicas {
+			err = cnd.dropReplica(id, replica, keep)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	cnd.stepIntent(intent, stepSwapDataset)
+	cnd.l.Debug("swapping refreshed cast dataset", zap.String("cast", id))
+	state, err := cnd.zm.SwapRefreshDataset(id)
+	if err != nil {
+		return nil, err
+	}
+
+	cnd.mu.Lock()
+	cast, ok := cnd.casts[id]
+	if !ok {
+		cast = &Cast{Id: id, replicas: make(map[string]*Replica), trash: make(map[string]*Replica)}
+		cnd.casts[id] = cast
+	}
+	refreshed := newCast(id, state)
+	refreshed.Properties = cnd.getCastProperties(id)
+	refreshed.replicas = cast.replicas
+	refreshed.trash = cast.trash
+	*cast = *refreshed
+	cnd.mu.Unlock()
+
+	cnd.stepIntent(intent, stepCreateReplicas)
+	ids := make([]string, 0, len(keep))
+	for replicaId := range keep {
+		ids = append(ids, replicaId)
+	}
+	sort.Strings(ids)
+
+	for _, replicaId := range ids {
+		if replica, ok := cnd.getLoadedReplica(id, replicaId); ok {
+			// recreated before the refresh was interrupted
+			if protected[replicaId] && !replica.Protected {
+				_, err = cnd.setReplicaProtection(id, replicaId, true)
+				if err != nil {
+					return nil, err
+				}
+			}
+			urn := cnd.getUniqueReplicaName(id, replicaId)
+			err = cnd.um.StartTemplateUnit(urn, cnd.zm.GetReplicaMountPoint(id, replicaId), keep[replicaId], replica.Ports, replica.Resources)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		err = cnd.recreateReplica(id, replicaId, keep[replicaId], ports[replicaId], resources[replicaId], expiries[replicaId], protected[replicaId], properties[replicaId])
+		if err != nil {
+			return nil, err
+		}
+
+		err = cnd.runHook(opId, hooks.PostReplicaCreate, hooks.Env{
+			CastId:     id,
+			ReplicaId:  replicaId,
+			MountPoint: cnd.zm.GetReplicaMountPoint(id, replicaId),
+			Port:       keep[replicaId],
+			Socket:     cnd.getReplicaSocket(id, replicaId, keep[replicaId]),
+			Ports:      ports[replicaId],
+			Resources:  resources[replicaId],
+		})
+		if err != nil && hookErr == nil {
+			hookErr = err
+		}
+	}
+
+	cnd.l.Info("refreshed cast", zap.String("cast", id), zap.Int("replicas", len(ids)))
+	return hookErr, nil
+}
+
+// dropReplica stops the unit of a replica that is being refreshed and deletes its
+// dataset. The ports and resources are released unless the replica is recreated.
+func (cnd *Conductor) dropReplica(castId string, replica *Replica, keep map[string]int32) error {
+	urn := cnd.getUniqueReplicaName(castId, replica.Id)
+	cnd.l.Debug("stopping replica unit", zap.String("cast", castId), zap.String("replica", replica.Id))
+	err := cnd.um.StopTemplateUnit(urn)
+	if err != nil {
+		return err
+	}
+
+	cnd.l.Debug("deleting replica dataset", zap.String("cast", castId), zap.String("replica", replica.Id))
+	err = cnd.zm.DeleteReplicaDataset(castId, replica.Id)
+	if err != nil {
+		return err
+	}
+
+	cnd.mu.Lock()
+	defer cnd.mu.Unlock()
+
+	cnd.l.Info("deleting replica object", zap.String("cast", castId), zap.String("replica", replica.Id))
+	delete(cnd.casts[castId].replicas, replica.Id)
+
+	if _, ok := keep[replica.Id]; ok {
+		return nil
+	}
+
+	cnd.l.Debug("releasing port for replica", zap.String("cast", castId), zap.String("replica", replica.Id))
+	cnd.releaseSlots(urn, replica.Ports, replica.Resources)
+	return cnd.releasePort(replica.Port)
+}
+
+// recreateReplica creates a replica of a refreshed cast with the ports, the resources,
+// the expiry, the protection and the properties it had before and starts its unit. The
+// ports and resources are bound first if they are not, e.g. after a restart.
+func (cnd *Conductor) recreateReplica(castId, id string, port int32, ports map[string]int32, resources map[string]string, expiresAt time.Time, protected bool, properties map[string]string) error {
+	urn := cnd.getUniqueReplicaName(castId, id)
+
+	cnd.mu.Lock()
+	if owner, _ := cnd.pm.Owner(port); owner != urn {
+		cnd.l.Debug("binding port for replica", zap.String("cast", castId), zap.String("replica", id))
+		err := cnd.bindPort(port, urn)
+		if err != nil {
+			cnd.mu.Unlock()
+			return err
+		}
+	}
+	err := cnd.bindSlots(urn, ports, resources)
+	cnd.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	cnd.l.Debug("creating replica dataset", zap.String("cast", castId), zap.String("replica", id))
+	err = cnd.zm.CreateReplicaDataset(castId, id, port, ports, resources, expiresAt, properties)
+	if err != nil {
+		return err
+	}
+	if protected {
+		err = cnd.zm.SetReplicaProtection(castId, id, true)
+		if err != nil {
+			return err
+		}
+	}
+
+	replicaProperties := cnd.getReplicaProperties(castId, id)
+
+	cnd.l.Info("creating replica object", zap.String("cast", castId), zap.String("replica", id))
+	cnd.mu.Lock()
+	cnd.casts[castId].replicas[id] = &Replica{
+		Id:         id,
+		Port:       port,
+		Socket:     cnd.getReplicaSocket(castId, id, port),
+		Ports:      ports,
+		Resources:  resources,
+		ExpiresAt:  expiresAt,
+		Protected:  protected,
+		Properties: replicaProperties,
+	}
+	cnd.mu.Unlock()
+
+	cnd.l.Debug("starting replica unit", zap.String("cast", castId), zap.String("replica", id))
+	return cnd.um.StartTemplateUnit(urn, cnd.zm.GetReplicaMountPoint(castId, id), port, ports, resources)
+}
@@ -0,0 +1,138 @@
+package conductor
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/dnsinogeorgos/conductor/internal/zfsmanager"
+)
+
+// renameFailingDriver is a memory driver whose renames fail while failRename is set
+type renameFailingDriver struct {
+	*zfsmanager.MemoryDriver
+	failRename bool
+}
+
+func (d *renameFailingDriver) Rename(name, newName string) (*zfsmanager.Dataset, error) {
+	if d.failRename {
+		return nil, errors.New("injected failure")
+	}
+	return d.MemoryDriver.Rename(name, newName)
+}
+
+// newRefreshedCast creates cast c1 with replicas r1 and r2 and returns them
+func newRefreshedCast(t *testing.T, cnd *Conductor) (*Replica, *Replica) {
+	t.Helper()
+
+	op, err := cnd.CreateCast("c1", time.Time{}, nil)
+	wait(t, cnd, op, err)
+	replicas := make([]*Replica, 0, 2)
+	for _, id := range []string{"r1", "r2"} {
+		op, err = cnd.CreateReplica("c1", id, time.Time{}, map[string]string{"quota": "1G"}, 0, false)
+		wait(t, cnd, op, err)
+		replica, err := cnd.GetReplica("c1", id)
+		if err != nil {
+			t.Fatalf("GetReplica() error = %v", err)
+		}
+		replicas = append(replicas, replica)
+	}
+
+	return replicas[0], replicas[1]
+}
+
+// checkRefreshed checks that only r1 is left on c1 with its ports, resources, properties
+// and a running unit, and that the ports and resources of r2 were released
+func checkRefreshed(t *testing.T, cnd *Conductor, units *fakeUnits, kept, dropped *Replica) {
+	t.Helper()
+
+	replica, err := cnd.GetReplica("c1", "r1")
+	if err != nil {
+		t.Fatalf("GetReplica() of kept replica error = %v", err)
+	}
+	if replica.Port != kept.Port || portOwner(cnd, kept.Port) != "c1_r1" {
+		t.Errorf("kept replica port = %d bound to %q, want %d bound to c1_r1", replica.Port, portOwner(cnd, kept.Port), kept.Port)
+	}
+	if !reflect.DeepEqual(replica.Ports, kept.Ports) || slotOwner(cnd, "admin", kept.Ports["admin"]) != "c1_r1" {
+		t.Errorf("kept replica slot ports = %v, want %v bound to c1_r1", replica.Ports, kept.Ports)
+	}
+	if owner, _ := cnd.slots["address"].Owner(kept.Resources["address"]); !reflect.DeepEqual(replica.Resources, kept.Resources) || owner != "c1_r1" {
+		t.Errorf("kept replica resources = %v, want %v bound to c1_r1", replica.Resources, kept.Resources)
+	}
+	if got := replica.Properties["quota"]; got != "1G" {
+		t.Errorf("kept replica quota = %q, want 1G", got)
+	}
+	if _, err := cnd.GetReplica("c1", "r2"); err == nil {
+		t.Error("dropped replica still exists")
+	}
+	if name, ok := cnd.pm.Owner(dropped.Port); ok {
+		t.Errorf("port %d of dropped replica is still bound to %s", dropped.Port, name)
+	}
+	if name, ok := cnd.slots["admin"].Owner(formatPort(dropped.Ports["admin"])); ok {
+		t.Errorf("slot port %d of dropped replica is still bound to %s", dropped.Ports["admin"], name)
+	}
+	if owner, ok := cnd.slots["address"].Owner(dropped.Resources["address"]); ok {
+		t.Errorf("address %s of dropped replica is still bound to %s", dropped.Resources["address"], owner)
+	}
+	if got, want := units.names(), []string{"c1_r1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("running units = %v, want %v", got, want)
+	}
+	if ids, _ := cnd.zm.GetReplicaIds("c1"); !reflect.DeepEqual(ids, []string{"r1"}) {
+		t.Errorf("replica datasets = %v, want [r1]", ids)
+	}
+	if pending := cnd.j.Pending(); len(pending) != 0 {
+		t.Errorf("journal has %d pending intents, want none", len(pending))
+	}
+}
+
+func TestRefreshCast(t *testing.T) {
+	cnd, units := newTestConductor(t)
+	kept, dropped := newRefreshedCast(t, cnd)
+
+	op, err := cnd.RefreshCast("c1", []string{"r1"})
+	wait(t, cnd, op, err)
+
+	checkRefreshed(t, cnd, units, kept, dropped)
+}
+
+func TestRefreshCastPurgesTrash(t *testing.T) {
+	cnd, units := newTestConductor(t)
+	cnd.trashGracePeriod = time.Hour
+	kept, dropped := newRefreshedCast(t, cnd)
+
+	op, err := cnd.DeleteReplica("c1", "r2", false)
+	wait(t, cnd, op, err)
+	op, err = cnd.RefreshCast("c1", nil)
+	wait(t, cnd, op, err)
+
+	checkRefreshed(t, cnd, units, kept, dropped)
+	if trash, err := cnd.ListTrash("c1"); err != nil || len(trash) != 0 {
+		t.Errorf("ListTrash() = %+v, %v, want an empty trash", trash, err)
+	}
+}
+
+func TestRecoverInterruptedRefresh(t *testing.T) {
+	d := &renameFailingDriver{MemoryDriver: zfsmanager.NewMemoryDriver()}
+	units := newFakeUnits()
+	cnd := loadTestConductor(t, d, units)
+	kept, dropped := newRefreshedCast(t, cnd)
+
+	// the refresh stops after the replicas were deleted, when the dataset is swapped
+	d.failRename = true
+	op, err := cnd.RefreshCast("c1", []string{"r1"})
+	if err != nil {
+		t.Fatalf("RefreshCast() error = %v", err)
+	}
+	if op = finish(t, cnd, op); op.Err == nil {
+		t.Fatal("RefreshCast() succeeded, want the swap to fail")
+	}
+	if pending := cnd.j.Pending(); len(pending) != 1 {
+		t.Fatalf("journal has %d pending intents, want the refresh", len(pending))
+	}
+
+	d.failRename = false
+	cnd = loadTestConductor(t, d, units)
+
+	checkRefreshed(t, cnd, units, kept, dropped)
+}
@@ -1,6 +1,13 @@
 package conductor
 
 import (
+	"strings"
+	"time"
+
+	"github.com/dnsinogeorgos/conductor/internal/hooks"
+	"github.com/dnsinogeorgos/conductor/internal/opmanager"
+	"github.com/dnsinogeorgos/conductor/internal/portmanager"
+	"github.com/dnsinogeorgos/conductor/internal/rollback"
 	"go.uber.org/zap"
 )
 
@@ -8,6 +15,22 @@ import (
 type Replica struct {
 	Id   string
 	Port int32
+	// Socket is the socket path of a replica with a socket endpoint, whose port is zero
+	Socket string
+	// Ports maps the configured port slots to the ports of the replica
+	Ports map[string]int32
+	// Resources maps the configured resources to the values of the replica
+	Resources map[string]string
+	// ExpiresAt is the time after which the replica is reaped. The zero time means it
+	// does not expire.
+	ExpiresAt time.Time
+	// DeletedAt and PurgeAt are set on replicas in the trash
+	DeletedAt time.Time
+	PurgeAt   time.Time
+	// Protected replicas are only deleted when the protection is overridden
+	Protected bool
+	// Properties are the effective values of the ZFS properties of the replica dataset
+	Properties map[string]string
 }
 
 // GetReplica retrieves the replica object from the state
@@ -52,98 +75,455 @@ func (cnd *Conductor) ListReplicas(castId string) ([]*Replica, error) {
 	return replicas, nil
 }
 
-// CreateReplica orchestrates the creation of a replica using the underlying managers
-func (cnd *Conductor) CreateReplica(castId, id string) (*Replica, error) {
+// CreateReplica validates the request and queues the creation of a replica that expires
+// at the provided time, or never if it is zero. The provided properties override the
+// configured replica properties. The replica is bound to the provided port if it is not
+// zero, otherwise a port is selected, unless the replica gets a socket endpoint instead.
+func (cnd *Conductor) CreateReplica(castId, id string, expiresAt time.Time, properties map[string]string, port int32, socket bool) (opmanager.Operation, error) {
+	cnd.mu.RLock()
+	defer cnd.mu.RUnlock()
+
+	if _, ok := cnd.casts[castId]; !ok {
+		cnd.l.Debug("cannot create replica, cast not found", zap.String("cast", castId), zap.String("replica", id))
+		return opmanager.Operation{}, CastNotFoundError{castId}
+	}
+
+	cast := cnd.casts[castId]
+	if _, ok := cast.replicas[id]; ok {
+		cnd.l.Debug("cannot create replica, already exists", zap.String("cast", castId), zap.String("replica", id))
+		return opmanager.Operation{}, ReplicaAlreadyExistsError{castId, id}
+	}
+
+	properties, err := mergeProperties(cnd.replicaProperties, properties)
+	if err != nil {
+		cnd.l.Debug("cannot create replica", zap.String("cast", castId), zap.String("replica", id), zap.Error(err))
+		return opmanager.Operation{}, err
+	}
+
+	if socket {
+		err = cnd.checkSocket(port)
+		if err != nil {
+			cnd.l.Debug("cannot create replica", zap.String("cast", castId), zap.String("replica", id), zap.Error(err))
+			return opmanager.Operation{}, err
+		}
+	} else if port != 0 {
+		err = cnd.checkRequestedPort(port)
+		if err != nil {
+			cnd.l.Debug("cannot create replica", zap.String("cast", castId), zap.String("replica", id), zap.Error(err))
+			return opmanager.Operation{}, err
+		}
+	} else {
+		_, err = cnd.pm.GetNextAvailable()
+		if err != nil {
+			cnd.l.Error("configured range of ports is exhausted", zap.Error(err))
+			return opmanager.Operation{}, PortsExhaustedError{s: err.Error()}
+		}
+	}
+
+	err = cnd.checkSlots()
+	if err != nil {
+		return opmanager.Operation{}, err
+	}
+
+	err = cnd.checkFreeSpace()
+	if err != nil {
+		cnd.l.Debug("cannot create replica", zap.String("cast", castId), zap.String("replica", id), zap.Error(err))
+		return opmanager.Operation{}, err
+	}
+
+	return cnd.om.Submit(OperationCreateReplica, castId, id, func(opId string) error {
+		return wrapError(cnd.createReplica(opId, castId, id, expiresAt, properties, port, socket))
+	})
+}
+
+// DeleteReplica validates the request and queues the deletion of a replica, or its move
+// into the trash if a grace period is configured. Protected replicas are only deleted
+// with force.
+func (cnd *Conductor) DeleteReplica(castId, id string, force bool) (opmanager.Operation, error) {
+	cnd.mu.RLock()
+	defer cnd.mu.RUnlock()
+
+	if _, ok := cnd.casts[castId]; !ok {
+		cnd.l.Debug("cannot delete replica, cast not found", zap.String("cast", castId), zap.String("replica", id))
+		return opmanager.Operation{}, CastNotFoundError{castId}
+	}
+
+	cast := cnd.casts[castId]
+	replica, ok := cast.replicas[id]
+	if !ok {
+		cnd.l.Debug("cannot delete replica, replica not found", zap.String("cast", castId), zap.String("replica", id))
+		return opmanager.Operation{}, ReplicaNotFoundError{castId, id}
+	}
+
+	if replica.Protected && !force {
+		cnd.l.Debug("cannot delete replica, protected", zap.String("cast", castId), zap.String("replica", id))
+		return opmanager.Operation{}, ReplicaProtectedError{castId, id}
+	}
+
+	if dependent, ok := cnd.getDependentCast(castId, id, ""); ok {
+		cnd.l.Debug("cannot delete replica, it is the source of a cast", zap.String("cast", castId), zap.String("replica", id), zap.String("dependent", dependent))
+		return opmanager.Operation{}, ReplicaInUseError{castId, id, dependent}
+	}
+
+	kind, task := OperationDeleteReplica, cnd.deleteReplica
+	if cnd.isTrashEnabled() {
+		kind, task = OperationTrashReplica, cnd.trashReplica
+	}
+
+	return cnd.om.Submit(kind, castId, id, func(opId string) error {
+		// the replica may have been protected while the deletion was queued
+		if !force && cnd.isReplicaProtected(castId, id) {
+			return ReplicaProtectedError{castId, id}
+		}
+		return wrapError(task(opId, castId, id))
+	})
+}
+
+// ExtendReplica moves the expiry of a replica to the provided time, or removes it if the
+// time is zero
+func (cnd *Conductor) ExtendReplica(castId, id string, expiresAt time.Time) (*Replica, error) {
+	cnd.mu.RLock()
+	err := cnd.validateReplica(castId, id)
+	cnd.mu.RUnlock()
+	if err != nil {
+		cnd.l.Debug("cannot extend replica", zap.String("cast", castId), zap.String("replica", id), zap.Error(err))
+		return &Replica{}, err
+	}
+
+	err = cnd.zm.SetReplicaExpiry(castId, id, expiresAt)
+	if err != nil {
+		return &Replica{}, wrapError(err)
+	}
+
 	cnd.mu.Lock()
 	defer cnd.mu.Unlock()
 
-	port, portErr := cnd.pm.GetNextAvailable()
+	err = cnd.validateReplica(castId, id)
+	if err != nil {
+		return &Replica{}, err
+	}
+
+	// the object is replaced instead of modified, since readers use it without the lock
+	cnd.l.Info("extending replica", zap.String("cast", castId), zap.String("replica", id), zap.Time("expires_at", expiresAt))
+	cast := cnd.casts[castId]
+	extended := *cast.replicas[id]
+	extended.ExpiresAt = expiresAt
+	cast.replicas[id] = &extended
+
+	return &extended, nil
+}
+
+// ResetReplica validates the request and queues the reset of a replica
+func (cnd *Conductor) ResetReplica(castId, id string) (opmanager.Operation, error) {
+	cnd.mu.RLock()
+	defer cnd.mu.RUnlock()
 
 	if _, ok := cnd.casts[castId]; !ok {
+		cnd.l.Debug("cannot reset replica, cast not found", zap.String("cast", castId), zap.String("replica", id))
+		return opmanager.Operation{}, CastNotFoundError{castId}
+	}
+
+	cast := cnd.casts[castId]
+	if _, ok := cast.replicas[id]; !ok {
+		cnd.l.Debug("cannot reset replica, replica not found", zap.String("cast", castId), zap.String("replica", id))
+		return opmanager.Operation{}, ReplicaNotFoundError{castId, id}
+	}
+
+	if dependent, ok := cnd.getDependentCast(castId, id, ""); ok {
+		cnd.l.Debug("cannot reset replica, it is the source of a cast", zap.String("cast", castId), zap.String("replica", id), zap.String("dependent", dependent))
+		return opmanager.Operation{}, ReplicaInUseError{castId, id, dependent}
+	}
+
+	// the dataset of a replica whose reset failed halfway is not loaded until the reset is
+	// retried
+	if checkpoints, err := cnd.zm.GetCheckpoints(castId, id); err == nil && len(checkpoints) != 0 {
+		cnd.l.Debug("cannot reset replica, it has checkpoints", zap.String("cast", castId), zap.String("replica", id))
+		return opmanager.Operation{}, ReplicaHasCheckpointsError{castId, id}
+	}
+
+	return cnd.om.Submit(OperationResetReplica, castId, id, func(string) error {
+		return wrapError(cnd.resetReplica(castId, id))
+	})
+}
+
+// createReplica orchestrates the creation of a replica using the underlying managers and
+// runs the post_replica_create hook. The free space of the pool is checked again before
+// the cast is cloned, and so is the requested port before it is bound. A replica with a
+// socket endpoint is not bound to a port. Every completed step is reverted if a later one
+// fails.
+func (cnd *Conductor) createReplica(opId, castId, id string, expiresAt time.Time, properties map[string]string, requested int32, socket bool) (err error) {
+	err = cnd.checkFreeSpace()
+	if err != nil {
+		return err
+	}
+
+	cnd.mu.Lock()
+	if _, ok := cnd.casts[castId]; !ok {
+		cnd.mu.Unlock()
 		cnd.l.Debug("cannot create replica, cast not found", zap.String("cast", castId), zap.String("replica", id))
-		return &Replica{}, CastNotFoundError{castId}
+		return CastNotFoundError{castId}
 	}
 
 	cast := cnd.casts[castId]
 	if _, ok := cast.replicas[id]; ok {
+		cnd.mu.Unlock()
 		cnd.l.Debug("cannot create replica, already exists", zap.String("cast", castId), zap.String("replica", id))
-		return &Replica{}, ReplicaAlreadyExistsError{castId, id}
+		return ReplicaAlreadyExistsError{castId, id}
+	}
+
+	port, previous := socketPort, portmanager.Assignment{}
+	if socket {
+		previous, _ = cnd.getStickyAssignment(castId, id)
+	} else {
+		port, previous, err = cnd.selectPort(castId, id, requested)
+		if err != nil {
+			cnd.mu.Unlock()
+			cnd.l.Debug("cannot create replica", zap.String("cast", castId), zap.String("replica", id), zap.Error(err))
+			return err
+		}
 	}
 
 	urn := cnd.getUniqueReplicaName(castId, id)
 	cnd.l.Debug("binding port for replica", zap.String("cast", castId), zap.String("replica", id))
-	if portErr != nil {
-		cnd.l.Error("configured range of ports is exhausted", zap.Error(portErr))
-		return &Replica{}, PortsExhaustedError{s: portErr.Error()}
+	err = cnd.bindPort(port, urn)
+	if err != nil {
+		cnd.mu.Unlock()
+		return err
 	}
-	err := cnd.pm.Bind(port, urn)
+	ports, resources, err := cnd.allocateSlots(urn, previous.Ports, previous.Resources)
 	if err != nil {
-		return &Replica{}, err
+		_ = cnd.releasePort(port)
+		cnd.mu.Unlock()
+		return err
+	}
+	cnd.mu.Unlock()
+
+	rb := rollback.New(cnd.l)
+	defer func() {
+		if err != nil {
+			rb.Run()
+		}
+	}()
+	rb.Add("release ports of replica", func() error {
+		cnd.mu.Lock()
+		defer cnd.mu.Unlock()
+		cnd.releaseSlots(urn, ports, resources)
+		return cnd.releasePort(port)
+	})
+
+	intent, err := cnd.j.Begin(OperationCreateReplica, castId, id, port, stepCreateDataset)
+	if err != nil {
+		return err
 	}
+	defer cnd.finishIntent(intent)
 
 	cnd.l.Debug("creating replica dataset", zap.String("cast", castId), zap.String("replica", id))
-	err = cnd.zm.CreateReplicaDataset(castId, id, port)
+	err = cnd.zm.CreateReplicaDataset(castId, id, port, ports, resources, expiresAt, properties)
 	if err != nil {
-		return &Replica{}, err
+		return err
 	}
+	rb.Add("delete replica dataset", func() error {
+		return cnd.zm.DeleteReplicaDataset(castId, id)
+	})
 
-	cnd.l.Info("creating replica object", zap.String("cast", castId), zap.String("replica", id))
-	replica := &Replica{
-		Id:   id,
-		Port: port,
+	cnd.stepIntent(intent, stepStartUnit)
+	cnd.l.Debug("starting replica unit", zap.String("cast", castId), zap.String("replica", id))
+	err = cnd.um.StartTemplateUnit(urn, cnd.zm.GetReplicaMountPoint(castId, id), port, ports, resources)
+	if err != nil {
+		return err
 	}
-	cast.replicas[id] = replica
+	rb.Add("stop replica unit", func() error {
+		return cnd.um.StopTemplateUnit(urn)
+	})
 
-	err = cnd.um.StartTemplateUnit(urn, cnd.zm.GetReplicaMountPoint(castId, id), port)
+	err = cnd.runHook(opId, hooks.PostReplicaCreate, hooks.Env{
+		CastId:     castId,
+		ReplicaId:  id,
+		MountPoint: cnd.zm.GetReplicaMountPoint(castId, id),
+		Port:       port,
+		Socket:     cnd.getReplicaSocket(castId, id, port),
+		Ports:      ports,
+		Resources:  resources,
+	})
 	if err != nil {
-		return &Replica{}, err
+		return err
 	}
 
-	return replica, nil
+	cnd.l.Info("creating replica object", zap.String("cast", castId), zap.String("replica", id))
+	replica := &Replica{
+		Id:         id,
+		Port:       port,
+		Socket:     cnd.getReplicaSocket(castId, id, port),
+		Ports:      ports,
+		Resources:  resources,
+		ExpiresAt:  expiresAt,
+		Properties: cnd.getReplicaProperties(castId, id),
+	}
+	cnd.mu.Lock()
+	cast.replicas[id] = replica
+	cnd.mu.Unlock()
+	cnd.rememberPorts(castId, id, port, ports, resources)
+
+	return nil
 }
 
-// DeleteReplica orchestrates the deletion of a replica using the underlying managers
-func (cnd *Conductor) DeleteReplica(castId, id string) error {
-	cnd.mu.Lock()
-	defer cnd.mu.Unlock()
+// deleteReplica runs the pre_replica_delete hook and orchestrates the deletion of a
+// replica using the underlying managers. The replica unit is started again if its
+// dataset cannot be deleted.
+func (cnd *Conductor) deleteReplica(opId, castId, id string) error {
+	return cnd.removeReplica(opId, castId, id, true)
+}
 
+// removeReplica deletes a replica like deleteReplica. The deletion is recorded in the
+// journal if journaled is set, otherwise it is covered by the intent of the operation it
+// is part of.
+func (cnd *Conductor) removeReplica(opId, castId, id string, journaled bool) (err error) {
+	cnd.mu.RLock()
 	if _, ok := cnd.casts[castId]; !ok {
+		cnd.mu.RUnlock()
 		cnd.l.Debug("cannot delete replica, cast not found", zap.String("cast", castId), zap.String("replica", id))
 		return CastNotFoundError{castId}
 	}
 
 	cast := cnd.casts[castId]
-	if _, ok := cast.replicas[id]; !ok {
+	replica, ok := cast.replicas[id]
+	cnd.mu.RUnlock()
+	if !ok {
 		cnd.l.Debug("cannot delete replica, replica not found", zap.String("cast", castId), zap.String("replica", id))
 		return ReplicaNotFoundError{castId, id}
 	}
 
+	err = cnd.runHook(opId, hooks.PreReplicaDelete, hooks.Env{
+		CastId:     castId,
+		ReplicaId:  id,
+		MountPoint: cnd.zm.GetReplicaMountPoint(castId, id),
+		Port:       replica.Port,
+		Socket:     replica.Socket,
+		Ports:      replica.Ports,
+		Resources:  replica.Resources,
+	})
+	if err != nil {
+		return err
+	}
+
+	var intent string
+	if journaled {
+		intent, err = cnd.j.Begin(OperationDeleteReplica, castId, id, replica.Port, stepStopUnit)
+		if err != nil {
+			return err
+		}
+		defer cnd.finishIntent(intent)
+	}
+
+	rb := rollback.New(cnd.l)
+	defer func() {
+		if err != nil {
+			rb.Run()
+		}
+	}()
+
 	urn := cnd.getUniqueReplicaName(castId, id)
-	err := cnd.um.StopTemplateUnit(urn)
+	cnd.l.Debug("stopping replica unit", zap.String("cast", castId), zap.String("replica", id))
+	err = cnd.um.StopTemplateUnit(urn)
 	if err != nil {
 		return err
 	}
+	rb.Add("start replica unit", func() error {
+		return cnd.um.StartTemplateUnit(urn, cnd.zm.GetReplicaMountPoint(castId, id), replica.Port, replica.Ports, replica.Resources)
+	})
 
+	cnd.stepIntent(intent, stepDeleteDataset)
 	cnd.l.Debug("deleting replica dataset", zap.String("cast", castId), zap.String("replica", id))
 	err = cnd.zm.DeleteReplicaDataset(castId, id)
 	if err != nil {
 		return err
 	}
+	rb.Discard()
+
+	cnd.mu.Lock()
+	defer cnd.mu.Unlock()
+
+	cnd.l.Info("deleting replica object", zap.String("cast", castId), zap.String("replica", id))
+	delete(cast.replicas, id)
 
-	replica := cast.replicas[id]
 	cnd.l.Debug("releasing port for replica", zap.String("cast", castId), zap.String("replica", id))
-	err = cnd.pm.Release(replica.Port)
+	cnd.releaseSlots(urn, replica.Ports, replica.Resources)
+	return cnd.releasePort(replica.Port)
+}
+
+// resetReplica orchestrates the reset of a replica to the state of its origin snapshot
+// using the underlying managers. The ports, the resources and the name of the replica are
+// kept, and the replica unit is started again whether the reset succeeds or not.
+func (cnd *Conductor) resetReplica(castId, id string) (err error) {
+	cnd.mu.RLock()
+	if _, ok := cnd.casts[castId]; !ok {
+		cnd.mu.RUnlock()
+		cnd.l.Debug("cannot reset replica, cast not found", zap.String("cast", castId), zap.String("replica", id))
+		return CastNotFoundError{castId}
+	}
+
+	cast := cnd.casts[castId]
+	replica, ok := cast.replicas[id]
+	cnd.mu.RUnlock()
+	if !ok {
+		cnd.l.Debug("cannot reset replica, replica not found", zap.String("cast", castId), zap.String("replica", id))
+		return ReplicaNotFoundError{castId, id}
+	}
+
+	intent, err := cnd.j.Begin(OperationResetReplica, castId, id, replica.Port, stepStopUnit)
 	if err != nil {
 		return err
 	}
+	defer cnd.finishIntent(intent)
 
-	cnd.l.Info("deleting replica object", zap.String("cast", castId), zap.String("replica", id))
-	delete(cast.replicas, id)
+	return cnd.restoreReplica(intent, castId, id, replica.Port, replica.Ports, replica.Resources)
+}
+
+// restoreReplica stops the unit of a replica, replaces its dataset with a new clone of
+// the origin snapshot and starts the unit again on the provided ports and resources
+func (cnd *Conductor) restoreReplica(intent, castId, id string, port int32, ports map[string]int32, resources map[string]string) (err error) {
+	rb := rollback.New(cnd.l)
+	defer func() {
+		if err != nil {
+			rb.Run()
+		}
+	}()
+
+	urn := cnd.getUniqueReplicaName(castId, id)
+	cnd.l.Debug("stopping replica unit", zap.String("cast", castId), zap.String("replica", id))
+	err = cnd.um.StopTemplateUnit(urn)
+	if err != nil {
+		return err
+	}
+	rb.Add("start replica unit", func() error {
+		return cnd.um.StartTemplateUnit(urn, cnd.zm.GetReplicaMountPoint(castId, id), port, ports, resources)
+	})
 
+	cnd.stepIntent(intent, stepResetDataset)
+	cnd.l.Debug("resetting replica dataset", zap.String("cast", castId), zap.String("replica", id))
+	err = cnd.zm.ResetReplicaDataset(castId, id)
+	if err != nil {
+		return err
+	}
+	rb.Discard()
+
+	cnd.stepIntent(intent, stepStartUnit)
+	cnd.l.Debug("starting replica unit", zap.String("cast", castId), zap.String("replica", id))
+	err = cnd.um.StartTemplateUnit(urn, cnd.zm.GetReplicaMountPoint(castId, id), port, ports, resources)
+	if err != nil {
+		return err
+	}
+
+	cnd.l.Info("reset replica", zap.String("cast", castId), zap.String("replica", id))
 	return nil
 }
 
-// getUniqueReplicaName returns the unique replica name
+// idEscaper escapes the separators of the unique names and the escape character itself
+// in ids, the way systemd-escape does
+var idEscaper = strings.NewReplacer(`\`, `\x5c`, "_", `\x5f`, ":", `\x3a`)
+
+// getUniqueReplicaName returns the unique replica name. The ids are escaped, so that the
+// names of distinct replicas and casts never collide.
 func (cnd *Conductor) getUniqueReplicaName(castId, id string) string {
-	return castId + "_" + id
+	return idEscaper.Replace(castId) + "_" + idEscaper.Replace(id)
 }
@@ -0,0 +1,310 @@
+package conductor
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+
+	"github.com/dnsinogeorgos/conductor/internal/allocator"
+	"github.com/dnsinogeorgos/conductor/internal/config"
+	"github.com/dnsinogeorgos/conductor/internal/portmanager"
+	"go.uber.org/zap"
+)
+
+// slotNamePattern matches the names of port slots and resources, which are rendered in
+// templates as {{ .Ports.name }} and {{ .Resources.name }} and exported to hooks as
+// CONDUCTOR_PORT_NAME and CONDUCTOR_RESOURCE_NAME
+var slotNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+// slotValue is the value of a port slot or of a resource, keyed by the name of its
+// allocator. The values of port slots are port numbers.
+type slotValue struct {
+	slot  string
+	value string
+	port  bool
+}
+
+// newSlots creates an allocator for every configured port slot and resource, keyed by
+// its name. The allocators of the port slots probe the host like the port manager does.
+// The ranges of allocators of the same kind must not overlap, so that e.g. two resources
+// never hand out the same address, and the ranges of the port slots must not overlap
+// with the ranges of the main port.
+func newSlots(slots map[string]config.PortSlot, resources map[string]config.Resource, probe string, pm *portmanager.PortManager, logger *zap.Logger) map[string]*allocator.Allocator {
+	allocators := make(map[string]*allocator.Allocator, len(slots)+len(resources))
+	for name, slot := range slots {
+		if !slotNamePattern.MatchString(name) {
+			logger.Fatal("bad configuration: invalid port slot name", zap.String("slot", name))
+		}
+
+		ranges, err := portmanager.ParseRanges(slot.Ranges)
+		if err != nil {
+			logger.Fatal("bad configuration: invalid port range of slot", zap.String("slot", name), zap.Error(err))
+		}
+		exclude, err := portmanager.ParseRanges(slot.Exclude)
+		if err != nil {
+			logger.Fatal("bad configuration: invalid port exclusion of slot", zap.String("slot", name), zap.Error(err))
+		}
+
+		allocators[name] = allocator.New(allocator.KindPort, ranges, exclude, portmanager.NewProbe(probe, logger), logger.With(zap.String("slot", name)))
+	}
+
+	for name, resource := range resources {
+		if !slotNamePattern.MatchString(name) {
+			logger.Fatal("bad configuration: invalid resource name", zap.String("resource", name))
+		}
+		if _, found := allocators[name]; found {
+			logger.Fatal("bad configuration: resource has the name of a port slot", zap.String("resource", name))
+		}
+		if resource.Kind == allocator.KindPort {
+			logger.Fatal("bad configuration: ports are allocated through port slots", zap.String("resource", name))
+		}
+
+		ranges, err := allocator.ParseRanges(resource.Kind, resource.Ranges)
+		if err != nil {
+			logger.Fatal("bad configuration: invalid range of resource", zap.String("resource", name), zap.Error(err))
+		}
+		exclude, err := allocator.ParseRanges(resource.Kind, resource.Exclude)
+		if err != nil {
+			logger.Fatal("bad configuration: invalid exclusion of resource", zap.String("resource", name), zap.Error(err))
+		}
+
+		allocators[name] = allocator.New(resource.Kind, ranges, exclude, nil, logger.With(zap.String("resource", name)))
+	}
+
+	for name, a := range allocators {
+		if a.Overlaps(pm.Allocator()) {
+			logger.Fatal("bad configuration: port ranges of slot overlap with the port ranges", zap.String("slot", name))
+		}
+		for other, otherAllocator := range allocators {
+			if other < name && a.Overlaps(otherAllocator) {
+				logger.Fatal("bad configuration: ranges of slots overlap", zap.String("slot", name), zap.String("other", other))
+			}
+		}
+	}
+
+	return allocators
+}
+
+// checkSlots fails with PortsExhaustedError if a port slot has no port available, or
+// with ResourcesExhaustedError if a resource has no value available. The caller must
+// hold the lock.
+func (cnd *Conductor) checkSlots() error {
+	for _, slot := range cnd.getSlotNames() {
+		_, err := cnd.slots[slot].GetNextAvailable()
+		if err != nil {
+			cnd.l.Error("configured range of slot is exhausted", zap.String("slot", slot), zap.Error(err))
+			return cnd.newExhaustedError(slot, err)
+		}
+	}
+
+	return nil
+}
+
+// allocateSlots binds a port of every port slot and a value of every resource to a name
+// and returns them. The provided value of a slot is kept if it is bound to the name or
+// still available, otherwise the next available one is bound. The values bound so far
+// are released if a slot is exhausted. The caller must hold the lock.
+func (cnd *Conductor) allocateSlots(name string, ports map[string]int32, resources map[string]string) (map[string]int32, map[string]string, error) {
+	previous := make(map[string]string)
+	for _, v := range joinSlots(ports, resources) {
+		if _, ok := cnd.getSlot(v); ok {
+			previous[v.slot] = v.value
+		}
+	}
+
+	values := make([]slotValue, 0, len(cnd.slots))
+	bound := make([]slotValue, 0, len(cnd.slots))
+	for _, slot := range cnd.getSlotNames() {
+		a := cnd.slots[slot]
+		value, ok := previous[slot]
+		if owner, found := a.Owner(value); ok && found && owner == name {
+			values = append(values, slotValue{slot: slot, value: value, port: a.Kind == allocator.KindPort})
+			continue
+		}
+
+		if !ok || !a.IsAvailable(value) {
+			var err error
+			value, err = a.GetNextAvailable()
+			if err != nil {
+				cnd.releaseValues(name, bound)
+				cnd.l.Error("configured range of slot is exhausted", zap.String("slot", slot), zap.Error(err))
+				return nil, nil, cnd.newExhaustedError(slot, err)
+			}
+		}
+
+		cnd.l.Debug("binding value of slot", zap.String("name", name), zap.String("slot", slot), zap.String("value", value))
+		err := a.Bind(value, name)
+		if err != nil {
+			cnd.releaseValues(name, bound)
+			return nil, nil, err
+		}
+		v := slotValue{slot: slot, value: value, port: a.Kind == allocator.KindPort}
+		values, bound = append(values, v), append(bound, v)
+	}
+
+	ports, resources = splitSlots(values)
+	return ports, resources, nil
+}
+
+// bindSlots binds the ports of the port slots and the values of the resources to a name.
+// Values that are already bound to the name are skipped, and so are slots that are no
+// longer configured. The caller must hold the lock.
+func (cnd *Conductor) bindSlots(name string, ports map[string]int32, resources map[string]string) error {
+	for _, v := range joinSlots(ports, resources) {
+		a, ok := cnd.getSlot(v)
+		if !ok {
+			cnd.l.Warn("ignoring value of slot that is not configured", zap.String("name", name), zap.String("slot", v.slot), zap.String("value", v.value))
+			continue
+		}
+		if owner, found := a.Owner(v.value); found && owner == name {
+			continue
+		}
+
+		cnd.l.Debug("binding value of slot", zap.String("name", name), zap.String("slot", v.slot), zap.String("value", v.value))
+		err := a.Bind(v.value, name)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// releaseSlots releases the ports of the port slots and the values of the resources that
+// are bound to a name. The caller must hold the lock.
+func (cnd *Conductor) releaseSlots(name string, ports map[string]int32, resources map[string]string) {
+	cnd.releaseValues(name, joinSlots(ports, resources))
+}
+
+// holdSlots binds the ports of the port slots and the values of the resources that are
+// not bound to any name to a name, skipping slots that are no longer configured. The
+// caller must hold the lock.
+func (cnd *Conductor) holdSlots(name string, ports map[string]int32, resources map[string]string) {
+	for _, v := range joinSlots(ports, resources) {
+		a, ok := cnd.getSlot(v)
+		if !ok {
+			continue
+		}
+		if _, used := a.Owner(v.value); used {
+			continue
+		}
+
+		cnd.l.Debug("holding value of slot", zap.String("name", name), zap.String("slot", v.slot), zap.String("value", v.value))
+		err := a.Bind(v.value, name)
+		if err != nil {
+			cnd.l.Warn("failed to hold value of slot", zap.String("name", name), zap.String("slot", v.slot), zap.Error(err))
+		}
+	}
+}
+
+// getBoundSlots returns the ports of the port slots and the values of the resources that
+// are bound to a name. The caller must hold the lock.
+func (cnd *Conductor) getBoundSlots(name string, ports map[string]int32, resources map[string]string) (map[string]int32, map[string]string) {
+	bound := make([]slotValue, 0, len(ports)+len(resources))
+	for _, v := range joinSlots(ports, resources) {
+		if a, ok := cnd.getSlot(v); ok {
+			if owner, found := a.Owner(v.value); found && owner == name {
+				bound = append(bound, v)
+			}
+		}
+	}
+
+	return splitSlots(bound)
+}
+
+// releaseValues releases the values of slots that are bound to a name. The caller must
+// hold the lock.
+func (cnd *Conductor) releaseValues(name string, values []slotValue) {
+	for _, v := range values {
+		a, ok := cnd.getSlot(v)
+		if !ok {
+			continue
+		}
+		if owner, found := a.Owner(v.value); !found || owner != name {
+			continue
+		}
+
+		cnd.l.Debug("releasing value of slot", zap.String("name", name), zap.String("slot", v.slot), zap.String("value", v.value))
+		_ = a.Release(v.value)
+	}
+}
+
+// getSlot returns the allocator of the slot of a value, unless the slot is no longer
+// configured or is no longer a port slot, or a resource, like the value
+func (cnd *Conductor) getSlot(v slotValue) (*allocator.Allocator, bool) {
+	a, ok := cnd.slots[v.slot]
+	if !ok || (a.Kind == allocator.KindPort) != v.port {
+		return nil, false
+	}
+
+	return a, true
+}
+
+// newExhaustedError returns the error reported when the allocator of a slot is exhausted
+func (cnd *Conductor) newExhaustedError(slot string, err error) error {
+	if cnd.slots[slot].Kind == allocator.KindPort {
+		return PortsExhaustedError{s: slot + ": " + err.Error()}
+	}
+
+	return ResourcesExhaustedError{s: slot + ": " + err.Error()}
+}
+
+// getSlotNames returns the names of the configured port slots and resources in order
+func (cnd *Conductor) getSlotNames() []string {
+	names := make([]string, 0, len(cnd.slots))
+	for name := range cnd.slots {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// joinSlots returns the ports of the port slots and the values of the resources as
+// values of their slots
+func joinSlots(ports map[string]int32, resources map[string]string) []slotValue {
+	values := make([]slotValue, 0, len(ports)+len(resources))
+	for slot, port := range ports {
+		values = append(values, slotValue{slot: slot, value: formatPort(port), port: true})
+	}
+	for slot, value := range resources {
+		values = append(values, slotValue{slot: slot, value: value})
+	}
+
+	return values
+}
+
+// splitSlots returns values of slots as the ports of the port slots and the values of the
+// resources. A map is nil if there are no values for it, like the maps of a replica
+// created without port slots or resources.
+func splitSlots(values []slotValue) (map[string]int32, map[string]string) {
+	var ports map[string]int32
+	var resources map[string]string
+	for _, v := range values {
+		if v.port {
+			if ports == nil {
+				ports = make(map[string]int32)
+			}
+			ports[v.slot] = parsePort(v.value)
+			continue
+		}
+		if resources == nil {
+			resources = make(map[string]string)
+		}
+		resources[v.slot] = v.value
+	}
+
+	return ports, resources
+}
+
+// formatPort formats a port number as a value of an allocator of ports
+func formatPort(port int32) string {
+	return strconv.Itoa(int(port))
+}
+
+// parsePort parses a value of an allocator of ports as a port number. Allocators of
+// ports only hand out valid port numbers.
+func parsePort(value string) int32 {
+	port, _ := strconv.ParseInt(value, 10, 32)
+	return int32(port)
+}
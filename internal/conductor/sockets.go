@@ -0,0 +1,56 @@
+package conductor
+
+import "go.uber.org/zap"
+
+// socketPort is the port of a replica with a socket endpoint. It is never bound, so
+// these replicas do not consume ports of the configured ranges.
+const socketPort int32 = 0
+
+// checkSocket fails with InvalidEndpointError if a replica cannot get a socket endpoint,
+// either because no socket path template is configured or because a port is requested
+// as well
+func (cnd *Conductor) checkSocket(port int32) error {
+	if !cnd.um.HasSockets() {
+		return InvalidEndpointError{"socket endpoints are not configured"}
+	}
+	if port != 0 {
+		return InvalidEndpointError{"a port cannot be requested for a replica with a socket endpoint"}
+	}
+
+	return nil
+}
+
+// bindPort binds the port of a replica to a name, unless the replica has a socket
+// endpoint. The caller must hold the lock.
+func (cnd *Conductor) bindPort(port int32, name string) error {
+	if port == socketPort {
+		return nil
+	}
+
+	return cnd.pm.Bind(port, name)
+}
+
+// releasePort releases the port of a replica, unless the replica has a socket endpoint.
+// The caller must hold the lock.
+func (cnd *Conductor) releasePort(port int32) error {
+	if port == socketPort {
+		return nil
+	}
+
+	return cnd.pm.Release(port)
+}
+
+// getReplicaSocket returns the socket path of a replica with a socket endpoint, or an
+// empty string if the replica has a port. A failure to render it is only logged.
+func (cnd *Conductor) getReplicaSocket(castId, id string, port int32) string {
+	if port != socketPort {
+		return ""
+	}
+
+	socket, err := cnd.um.GetSocketPath(cnd.getUniqueReplicaName(castId, id))
+	if err != nil {
+		cnd.l.Warn("failed to render socket path of replica", zap.String("cast", castId), zap.String("replica", id), zap.Error(err))
+	}
+
+	return socket
+}
@@ -0,0 +1,36 @@
+package conductor
+
+import (
+	"go.uber.org/zap"
+)
+
+// checkFreeSpace fails with InsufficientSpaceError if the free space of the pool is below
+// the configured thresholds, the larger of the absolute one and the percentage of its
+// size. A warning is logged once the free space falls below twice the threshold. It
+// passes without reading the pool if no threshold is configured.
+func (cnd *Conductor) checkFreeSpace() error {
+	if cnd.minFreeBytes == 0 && cnd.minFreePercent == 0 {
+		return nil
+	}
+
+	pool, err := cnd.zm.GetPoolUsage()
+	if err != nil {
+		return wrapError(err)
+	}
+
+	threshold := cnd.minFreeBytes
+	if percent := uint64(float64(pool.Size) * cnd.minFreePercent / 100); percent > threshold {
+		threshold = percent
+	}
+
+	name := cnd.zm.GetPoolName()
+	if pool.Free < threshold {
+		cnd.l.Warn("pool is below the free space threshold", zap.String("pool", name), zap.Uint64("free", pool.Free), zap.Uint64("threshold", threshold))
+		return InsufficientSpaceError{name, pool.Free, threshold}
+	}
+	if pool.Free < 2*threshold {
+		cnd.l.Warn("pool is approaching the free space threshold", zap.String("pool", name), zap.Uint64("free", pool.Free), zap.Uint64("threshold", threshold))
+	}
+
+	return nil
+}
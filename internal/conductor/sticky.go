@@ -0,0 +1,110 @@
+package conductor
+
+import (
+	"github.com/dnsinogeorgos/conductor/internal/portmanager"
+	"go.uber.org/zap"
+)
+
+// Sticky port modes. None binds the next available ports to a new replica, replica
+// reuses the ports last bound to the replica of the same name on the same cast, and name
+// reuses the ports last bound to a replica of the same name on any cast.
+const (
+	StickyPortsNone    = "none"
+	StickyPortsReplica = "replica"
+	StickyPortsName    = "name"
+)
+
+// checkRequestedPort fails with InvalidPortError if a requested port is not within the
+// configured ranges, or with PortUnavailableError if it is taken. The caller must hold
+// the lock.
+func (cnd *Conductor) checkRequestedPort(port int32) error {
+	if !cnd.pm.Contains(port) {
+		return InvalidPortError{port}
+	}
+	if !cnd.pm.IsAvailable(port) {
+		return PortUnavailableError{port}
+	}
+
+	return nil
+}
+
+// selectPort returns the port to bind to a new replica along with the assignment it last
+// had, whose ports of the port slots and resources are reused if they are available. A
+// requested port must be available, otherwise the remembered port is reused if it is
+// available, or the next available one is selected. The caller must hold the lock.
+func (cnd *Conductor) selectPort(castId, id string, requested int32) (int32, portmanager.Assignment, error) {
+	assignment, ok := cnd.getStickyAssignment(castId, id)
+
+	if requested != 0 {
+		err := cnd.checkRequestedPort(requested)
+		if err != nil {
+			return 0, assignment, err
+		}
+		return requested, assignment, nil
+	}
+
+	if ok && cnd.pm.IsAvailable(assignment.Port) {
+		cnd.l.Debug("reusing sticky port of replica", zap.String("cast", castId), zap.String("replica", id), zap.Int32("port", assignment.Port))
+		return assignment.Port, assignment, nil
+	}
+
+	port, err := cnd.pm.GetNextAvailable()
+	if err != nil {
+		cnd.l.Error("configured range of ports is exhausted", zap.Error(err))
+		return 0, assignment, PortsExhaustedError{s: err.Error()}
+	}
+
+	return port, assignment, nil
+}
+
+// getStickyAssignment returns the ports and resources last bound to a replica, if sticky
+// ports are enabled and there are any
+func (cnd *Conductor) getStickyAssignment(castId, id string) (portmanager.Assignment, bool) {
+	key := cnd.getStickyKey(castId, id)
+	if key == "" {
+		return portmanager.Assignment{}, false
+	}
+
+	return cnd.ph.Get(key)
+}
+
+// rememberPorts records the ports and resources bound to a replica, if sticky ports are
+// enabled. A replica with a socket endpoint keeps the port remembered before it. A
+// failure is only logged, since the replica is usable anyway.
+func (cnd *Conductor) rememberPorts(castId, id string, port int32, ports map[string]int32, resources map[string]string) {
+	key := cnd.getStickyKey(castId, id)
+	if key == "" {
+		return
+	}
+	if previous, ok := cnd.ph.Get(key); ok && port == socketPort {
+		port = previous.Port
+	}
+
+	err := cnd.ph.Set(key, portmanager.Assignment{Port: port, Ports: ports, Resources: resources})
+	if err != nil {
+		cnd.l.Warn("failed to remember ports of replica", zap.String("cast", castId), zap.String("replica", id), zap.Error(err))
+	}
+}
+
+// getStickyKey returns the name under which the ports of a replica are remembered, or an
+// empty string if sticky ports are disabled
+func (cnd *Conductor) getStickyKey(castId, id string) string {
+	switch cnd.stickyPorts {
+	case StickyPortsReplica:
+		return cnd.getUniqueReplicaName(castId, id)
+	case StickyPortsName:
+		return id
+	default:
+		return ""
+	}
+}
+
+// isStickyPortsMode reports whether the provided string is a known sticky port mode
+func isStickyPortsMode(mode string) bool {
+	switch mode {
+	case StickyPortsNone, StickyPortsReplica, StickyPortsName:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,586 @@
+package conductor
+
+import (
+	"time"
+
+	"github.com/dnsinogeorgos/conductor/internal/hooks"
+	"github.com/dnsinogeorgos/conductor/internal/opmanager"
+	"github.com/dnsinogeorgos/conductor/internal/rollback"
+	"github.com/dnsinogeorgos/conductor/internal/zfsmanager"
+	"go.uber.org/zap"
+)
+
+// Port policies of the trash. Hold keeps the ports and resources of a deleted replica
+// reserved until it is purged, so that a restored replica comes back on them, release
+// makes them available to new replicas right away.
+const (
+	TrashPortsHold    = "hold"
+	TrashPortsRelease = "release"
+)
+
+// ListTrash returns a slice of the deleted replicas of a cast that can be restored
+func (cnd *Conductor) ListTrash(castId string) ([]*Replica, error) {
+	cnd.mu.RLock()
+	defer cnd.mu.RUnlock()
+
+	replicas := make([]*Replica, 0)
+	if _, ok := cnd.casts[castId]; !ok {
+		cnd.l.Debug("cannot list trashed replicas, cast not found", zap.String("cast", castId))
+		return replicas, CastNotFoundError{castId}
+	}
+
+	cnd.l.Debug("listing trashed replica objects", zap.String("cast", castId))
+	for _, replica := range cnd.casts[castId].trash {
+		replicas = append(replicas, replica)
+	}
+
+	return replicas, nil
+}
+
+// RestoreTrashedReplica validates the request and queues the restore of a deleted
+// replica
+func (cnd *Conductor) RestoreTrashedReplica(castId, id string) (opmanager.Operation, error) {
+	cnd.mu.RLock()
+	defer cnd.mu.RUnlock()
+
+	if _, ok := cnd.casts[castId]; !ok {
+		cnd.l.Debug("cannot restore replica, cast not found", zap.String("cast", castId), zap.String("replica", id))
+		return opmanager.Operation{}, CastNotFoundError{castId}
+	}
+
+	cast := cnd.casts[castId]
+	if _, ok := cast.replicas[id]; ok {
+		cnd.l.Debug("cannot restore replica, already exists", zap.String("cast", castId), zap.String("replica", id))
+		return opmanager.Operation{}, ReplicaAlreadyExistsError{castId, id}
+	}
+
+	trashed, ok := cast.trash[id]
+	if !ok {
+		cnd.l.Debug("cannot restore replica, not in the trash", zap.String("cast", castId), zap.String("replica", id))
+		return opmanager.Operation{}, ReplicaNotFoundError{castId, id}
+	}
+
+	if _, err := cnd.getRestorePort(castId, trashed); err != nil {
+		cnd.l.Error("configured range of ports is exhausted", zap.Error(err))
+		return opmanager.Operation{}, PortsExhaustedError{s: err.Error()}
+	}
+
+	return cnd.om.Submit(OperationRestoreReplica, castId, id, func(opId string) error {
+		return wrapError(cnd.restoreTrashedReplica(opId, castId, id))
+	})
+}
+
+// PurgeTrashedReplica validates the request and queues the destruction of a deleted
+// replica before its grace period ends
+func (cnd *Conductor) PurgeTrashedReplica(castId, id string) (opmanager.Operation, error) {
+	cnd.mu.RLock()
+	defer cnd.mu.RUnlock()
+
+	if _, ok := cnd.casts[castId]; !ok {
+		cnd.l.Debug("cannot purge replica, cast not found", zap.String("cast", castId), zap.String("replica", id))
+		return opmanager.Operation{}, CastNotFoundError{castId}
+	}
+
+	if _, ok := cnd.casts[castId].trash[id]; !ok {
+		cnd.l.Debug("cannot purge replica, not in the trash", zap.String("cast", castId), zap.String("replica", id))
+		return opmanager.Operation{}, ReplicaNotFoundError{castId, id}
+	}
+
+	return cnd.om.Submit(OperationPurgeReplica, castId, id, func(string) error {
+		return wrapError(cnd.purgeTrashedReplica(castId, id))
+	})
+}
+
+// trashReplica runs the pre_replica_delete hook, stops the unit of a replica and moves
+// it into the trash of its cast. A replica deleted earlier under the same id is purged
+// after the hook. The replica unit is started again if its dataset cannot be moved.
+func (cnd *Conductor) trashReplica(opId, castId, id string) (err error) {
+	cnd.mu.RLock()
+	if _, ok := cnd.casts[castId]; !ok {
+		cnd.mu.RUnlock()
+		cnd.l.Debug("cannot delete replica, cast not found", zap.String("cast", castId), zap.String("replica", id))
+		return CastNotFoundError{castId}
+	}
+
+	cast := cnd.casts[castId]
+	replica, ok := cast.replicas[id]
+	_, trashed := cast.trash[id]
+	cnd.mu.RUnlock()
+	if !ok {
+		cnd.l.Debug("cannot delete replica, replica not found", zap.String("cast", castId), zap.String("replica", id))
+		return ReplicaNotFoundError{castId, id}
+	}
+
+	err = cnd.runHook(opId, hooks.PreReplicaDelete, hooks.Env{
+		CastId:     castId,
+		ReplicaId:  id,
+		MountPoint: cnd.zm.GetReplicaMountPoint(castId, id),
+		Port:       replica.Port,
+		Socket:     replica.Socket,
+		Ports:      replica.Ports,
+		Resources:  replica.Resources,
+	})
+	if err != nil {
+		return err
+	}
+
+	if trashed {
+		cnd.l.Info("purging previously deleted replica", zap.String("cast", castId), zap.String("replica", id))
+		err = cnd.purgeTrashedReplica(castId, id)
+		if err != nil {
+			return err
+		}
+	}
+
+	intent, err := cnd.j.Begin(OperationTrashReplica, castId, id, replica.Port, stepStopUnit)
+	if err != nil {
+		return err
+	}
+	defer cnd.finishIntent(intent)
+
+	rb := rollback.New(cnd.l)
+	defer func() {
+		if err != nil {
+			rb.Run()
+		}
+	}()
+
+	urn := cnd.getUniqueReplicaName(castId, id)
+	cnd.l.Debug("stopping replica unit", zap.String("cast", castId), zap.String("replica", id))
+	err = cnd.um.StopTemplateUnit(urn)
+	if err != nil {
+		return err
+	}
+	rb.Add("start replica unit", func() error {
+		return cnd.um.StartTemplateUnit(urn, cnd.zm.GetReplicaMountPoint(castId, id), replica.Port, replica.Ports, replica.Resources)
+	})
+
+	cnd.stepIntent(intent, stepTrashDataset)
+	cnd.l.Debug("moving replica dataset into the trash", zap.String("cast", castId), zap.String("replica", id))
+	state, err := cnd.zm.TrashReplicaDataset(castId, id)
+	if err != nil {
+		return err
+	}
+	rb.Discard()
+
+	cnd.mu.Lock()
+	defer cnd.mu.Unlock()
+
+	cnd.l.Info("moving replica object into the trash", zap.String("cast", castId), zap.String("replica", id))
+	delete(cast.replicas, id)
+	cast.trash[id] = cnd.newTrashedReplica(castId, state)
+
+	cnd.l.Debug("releasing port for replica", zap.String("cast", castId), zap.String("replica", id))
+	cnd.releaseSlots(urn, replica.Ports, replica.Resources)
+	err = cnd.releasePort(replica.Port)
+	if err != nil {
+		return err
+	}
+	if cnd.trashPorts == TrashPortsHold {
+		cnd.l.Debug("holding port for trashed replica", zap.String("cast", castId), zap.String("replica", id))
+		cnd.holdSlots(cnd.getTrashedReplicaName(castId, id), replica.Ports, replica.Resources)
+		return cnd.bindPort(replica.Port, cnd.getTrashedReplicaName(castId, id))
+	}
+
+	return nil
+}
+
+// restoreTrashedReplica moves a replica out of the trash of its cast, starts its unit on
+// the held ports and resources, or on new ones if they were released and taken, and
+// runs the post_replica_create hook. An expiry that has passed is removed, since the
+// reaper would delete the replica again. Every completed step is reverted if a later
+// one fails.
+func (cnd *Conductor) restoreTrashedReplica(opId, castId, id string) (err error) {
+	cnd.mu.Lock()
+	if _, ok := cnd.casts[castId]; !ok {
+		cnd.mu.Unlock()
+		cnd.l.Debug("cannot restore replica, cast not found", zap.String("cast", castId), zap.String("replica", id))
+		return CastNotFoundError{castId}
+	}
+
+	cast := cnd.casts[castId]
+	if _, ok := cast.replicas[id]; ok {
+		cnd.mu.Unlock()
+		cnd.l.Debug("cannot restore replica, already exists", zap.String("cast", castId), zap.String("replica", id))
+		return ReplicaAlreadyExistsError{castId, id}
+	}
+
+	trashed, ok := cast.trash[id]
+	if !ok {
+		cnd.mu.Unlock()
+		cnd.l.Debug("cannot restore replica, not in the trash", zap.String("cast", castId), zap.String("replica", id))
+		return ReplicaNotFoundError{castId, id}
+	}
+
+	port, err := cnd.getRestorePort(castId, trashed)
+	if err != nil {
+		cnd.mu.Unlock()
+		cnd.l.Error("configured range of ports is exhausted", zap.Error(err))
+		return PortsExhaustedError{s: err.Error()}
+	}
+
+	urn := cnd.getUniqueReplicaName(castId, id)
+	trashName := cnd.getTrashedReplicaName(castId, id)
+	held := cnd.isHeldPort(castId, id, port)
+	if held {
+		_ = cnd.pm.Release(port)
+	}
+	cnd.l.Debug("binding port for replica", zap.String("cast", castId), zap.String("replica", id))
+	err = cnd.bindPort(port, urn)
+	if err != nil {
+		cnd.mu.Unlock()
+		return err
+	}
+	heldPorts, heldResources := cnd.getBoundSlots(trashName, trashed.Ports, trashed.Resources)
+	cnd.releaseSlots(trashName, heldPorts, heldResources)
+	ports, resources, err := cnd.allocateSlots(urn, trashed.Ports, trashed.Resources)
+	if err != nil {
+		cnd.holdSlots(trashName, heldPorts, heldResources)
+		_ = cnd.releasePort(port)
+		if held {
+			_ = cnd.pm.Bind(port, trashName)
+		}
+		cnd.mu.Unlock()
+		return err
+	}
+	cnd.mu.Unlock()
+
+	rb := rollback.New(cnd.l)
+	defer func() {
+		if err != nil {
+			rb.Run()
+		}
+	}()
+	rb.Add("release ports of replica", func() error {
+		cnd.mu.Lock()
+		defer cnd.mu.Unlock()
+		cnd.releaseSlots(urn, ports, resources)
+		cnd.holdSlots(trashName, heldPorts, heldResources)
+		err := cnd.releasePort(port)
+		if err != nil || !held {
+			return err
+		}
+		return cnd.pm.Bind(port, trashName)
+	})
+
+	intent, err := cnd.j.Begin(OperationRestoreReplica, castId, id, port, stepRestoreDataset)
+	if err != nil {
+		return err
+	}
+	defer cnd.finishIntent(intent)
+
+	cnd.l.Debug("moving replica dataset out of the trash", zap.String("cast", castId), zap.String("replica", id))
+	err = cnd.zm.RestoreReplicaDataset(castId, id, port, ports, resources)
+	if err != nil {
+		return err
+	}
+	rb.Add("move replica dataset into the trash", func() error {
+		_, err := cnd.zm.TrashReplicaDataset(castId, id)
+		return err
+	})
+	expiresAt, err := cnd.clearPastExpiry(castId, id, trashed.ExpiresAt)
+	if err != nil {
+		return err
+	}
+
+	cnd.stepIntent(intent, stepStartUnit)
+	cnd.l.Debug("starting replica unit", zap.String("cast", castId), zap.String("replica", id))
+	err = cnd.um.StartTemplateUnit(urn, cnd.zm.GetReplicaMountPoint(castId, id), port, ports, resources)
+	if err != nil {
+		return err
+	}
+	rb.Add("stop replica unit", func() error {
+		return cnd.um.StopTemplateUnit(urn)
+	})
+
+	err = cnd.runHook(opId, hooks.PostReplicaCreate, hooks.Env{
+		CastId:     castId,
+		ReplicaId:  id,
+		MountPoint: cnd.zm.GetReplicaMountPoint(castId, id),
+		Port:       port,
+		Socket:     cnd.getReplicaSocket(castId, id, port),
+		Ports:      ports,
+		Resources:  resources,
+	})
+	if err != nil {
+		return err
+	}
+	properties := cnd.getReplicaProperties(castId, id)
+
+	cnd.mu.Lock()
+	defer cnd.mu.Unlock()
+
+	cnd.l.Info("restoring replica object from the trash", zap.String("cast", castId), zap.String("replica", id), zap.Int32("port", port))
+	delete(cast.trash, id)
+	cast.replicas[id] = &Replica{
+		Id:         id,
+		Port:       port,
+		Socket:     cnd.getReplicaSocket(castId, id, port),
+		Ports:      ports,
+		Resources:  resources,
+		ExpiresAt:  expiresAt,
+		Protected:  trashed.Protected,
+		Properties: properties,
+	}
+	cnd.rememberPorts(castId, id, port, ports, resources)
+
+	return nil
+}
+
+// completeRestore completes an interrupted restore of a trashed replica on the port
+// recorded in the journal and starts the replica unit. The ports of the port slots and
+// the resources are the ones recorded in its state.
+func (cnd *Conductor) completeRestore(castId, id string, port int32) error {
+	var ports map[string]int32
+	var resources map[string]string
+	if replica, ok := cnd.getLoadedReplica(castId, id); ok {
+		ports, resources = replica.Ports, replica.Resources
+	} else if trashed, ok := cnd.getTrashedReplica(castId, id); ok {
+		ports, resources = trashed.Ports, trashed.Resources
+	}
+
+	err := cnd.zm.RestoreReplicaDataset(castId, id, port, ports, resources)
+	if err != nil {
+		if _, ok := err.(zfsmanager.ReplicaAlreadyExistsError); !ok {
+			return err
+		}
+	}
+
+	ports, err = cnd.zm.GetReplicaPorts(castId, id)
+	if err != nil {
+		return err
+	}
+	resources, err = cnd.zm.GetReplicaResources(castId, id)
+	if err != nil {
+		return err
+	}
+
+	expiresAt, err := cnd.zm.GetReplicaExpiry(castId, id)
+	if err != nil {
+		return err
+	}
+	expiresAt, err = cnd.clearPastExpiry(castId, id, expiresAt)
+	if err != nil {
+		return err
+	}
+	protected, err := cnd.zm.GetReplicaProtection(castId, id)
+	if err != nil {
+		return err
+	}
+	properties := cnd.getReplicaProperties(castId, id)
+
+	cnd.mu.Lock()
+	cast, ok := cnd.casts[castId]
+	if !ok {
+		cnd.mu.Unlock()
+		return CastNotFoundError{castId}
+	}
+
+	urn := cnd.getUniqueReplicaName(castId, id)
+	if trashed, ok := cast.trash[id]; ok {
+		if cnd.isHeldPort(castId, id, trashed.Port) {
+			_ = cnd.pm.Release(trashed.Port)
+		}
+		cnd.releaseSlots(cnd.getTrashedReplicaName(castId, id), trashed.Ports, trashed.Resources)
+	}
+	if owner, _ := cnd.pm.Owner(port); owner != urn {
+		err = cnd.bindPort(port, urn)
+		if err != nil {
+			cnd.mu.Unlock()
+			return err
+		}
+	}
+	err = cnd.bindSlots(urn, ports, resources)
+	if err != nil {
+		cnd.mu.Unlock()
+		return err
+	}
+
+	cnd.l.Info("restoring replica object from the trash", zap.String("cast", castId), zap.String("replica", id), zap.Int32("port", port))
+	delete(cast.trash, id)
+	cast.replicas[id] = &Replica{
+		Id:         id,
+		Port:       port,
+		Socket:     cnd.getReplicaSocket(castId, id, port),
+		Ports:      ports,
+		Resources:  resources,
+		ExpiresAt:  expiresAt,
+		Protected:  protected,
+		Properties: properties,
+	}
+	cnd.mu.Unlock()
+
+	cnd.l.Info("starting unit of restored replica", zap.String("cast", castId), zap.String("replica", id))
+	return cnd.um.StartTemplateUnit(urn, cnd.zm.GetReplicaMountPoint(castId, id), port, ports, resources)
+}
+
+// clearPastExpiry removes the expiry of a restored replica if it has passed and returns
+// the expiry the replica is left with
+func (cnd *Conductor) clearPastExpiry(castId, id string, expiresAt time.Time) (time.Time, error) {
+	if !isExpired(expiresAt, time.Now()) {
+		return expiresAt, nil
+	}
+
+	cnd.l.Info("removing past expiry of restored replica", zap.String("cast", castId), zap.String("replica", id), zap.Time("expires_at", expiresAt))
+	err := cnd.zm.SetReplicaExpiry(castId, id, time.Time{})
+	if err != nil {
+		return expiresAt, err
+	}
+
+	return time.Time{}, nil
+}
+
+// purgeTrashedReplica destroys a replica in the trash of a cast and releases its ports
+// and resources if they are held. What an interrupted purge left behind is destroyed if
+// the replica is not loaded.
+func (cnd *Conductor) purgeTrashedReplica(castId, id string) error {
+	return cnd.removeTrashedReplica(castId, id, true)
+}
+
+// removeTrashedReplica purges a trashed replica like purgeTrashedReplica. The purge is
+// recorded in the journal if journaled is set, otherwise it is covered by the intent of
+// the operation it is part of.
+func (cnd *Conductor) removeTrashedReplica(castId, id string, journaled bool) error {
+	cnd.mu.RLock()
+	cast, ok := cnd.casts[castId]
+	if !ok {
+		cnd.mu.RUnlock()
+		cnd.l.Debug("cannot purge replica, cast not found", zap.String("cast", castId), zap.String("replica", id))
+		return CastNotFoundError{castId}
+	}
+	trashed, ok := cast.trash[id]
+	cnd.mu.RUnlock()
+
+	var port int32
+	if ok {
+		port = trashed.Port
+	}
+
+	if journaled {
+		intent, err := cnd.j.Begin(OperationPurgeReplica, castId, id, port, stepDeleteDataset)
+		if err != nil {
+			return err
+		}
+		defer cnd.finishIntent(intent)
+	}
+
+	cnd.l.Debug("purging trashed replica dataset", zap.String("cast", castId), zap.String("replica", id))
+	err := cnd.zm.PurgeTrashedReplicaDataset(castId, id)
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		return nil
+	}
+
+	cnd.mu.Lock()
+	defer cnd.mu.Unlock()
+
+	cnd.l.Info("purging trashed replica object", zap.String("cast", castId), zap.String("replica", id))
+	delete(cast.trash, id)
+	cnd.releaseSlots(cnd.getTrashedReplicaName(castId, id), trashed.Ports, trashed.Resources)
+
+	if cnd.isHeldPort(castId, id, port) {
+		cnd.l.Debug("releasing held port for replica", zap.String("cast", castId), zap.String("replica", id))
+		return cnd.pm.Release(port)
+	}
+
+	return nil
+}
+
+// loadTrash discovers the replicas in the trash of a cast and holds their ports, including
+// the ports of their port slots, and their resources if the policy requires it and they
+// are still available
+func (cnd *Conductor) loadTrash(castId string) (map[string]*Replica, error) {
+	trash := make(map[string]*Replica)
+	states, err := cnd.zm.GetTrashedReplicas(castId)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, state := range states {
+		trashed := cnd.newTrashedReplica(castId, state)
+		if _, used := cnd.pm.Owner(trashed.Port); cnd.trashPorts == TrashPortsHold && !used {
+			cnd.l.Debug("holding port for trashed replica", zap.String("cast", castId), zap.String("replica", trashed.Id))
+			err = cnd.bindPort(trashed.Port, cnd.getTrashedReplicaName(castId, trashed.Id))
+			if err != nil {
+				cnd.l.Warn("failed to hold port for trashed replica", zap.String("cast", castId), zap.String("replica", trashed.Id), zap.Error(err))
+			}
+		}
+		if cnd.trashPorts == TrashPortsHold {
+			cnd.holdSlots(cnd.getTrashedReplicaName(castId, trashed.Id), trashed.Ports, trashed.Resources)
+		}
+
+		trash[trashed.Id] = trashed
+	}
+
+	return trash, nil
+}
+
+// getRestorePort returns the port a trashed replica is restored on: the held port, the
+// port it had if it is still available, or the next available one. A replica with a
+// socket endpoint is restored without a port. The caller must hold the lock.
+func (cnd *Conductor) getRestorePort(castId string, trashed *Replica) (int32, error) {
+	if trashed.Port == socketPort {
+		return socketPort, nil
+	}
+	if cnd.isHeldPort(castId, trashed.Id, trashed.Port) {
+		return trashed.Port, nil
+	}
+	if cnd.pm.IsAvailable(trashed.Port) {
+		return trashed.Port, nil
+	}
+
+	return cnd.pm.GetNextAvailable()
+}
+
+// isHeldPort reports whether a port is held for a trashed replica. The caller must hold
+// the lock.
+func (cnd *Conductor) isHeldPort(castId, id string, port int32) bool {
+	owner, _ := cnd.pm.Owner(port)
+	return owner == cnd.getTrashedReplicaName(castId, id)
+}
+
+// newTrashedReplica converts the state of a trashed replica dataset to a replica object,
+// setting the time it is purged at
+func (cnd *Conductor) newTrashedReplica(castId string, state zfsmanager.ReplicaState) *Replica {
+	trashed := &Replica{
+		Id:        state.Id,
+		Port:      state.Port,
+		Socket:    cnd.getReplicaSocket(castId, state.Id, state.Port),
+		Ports:     state.Ports,
+		Resources: state.Resources,
+		Protected: state.Protected,
+	}
+	if state.ExpiresAt != nil {
+		trashed.ExpiresAt = *state.ExpiresAt
+	}
+	if state.DeletedAt != nil {
+		trashed.DeletedAt = *state.DeletedAt
+		trashed.PurgeAt = trashed.DeletedAt.Add(cnd.trashGracePeriod)
+	}
+
+	return trashed
+}
+
+// getTrashedReplicaName returns the name the ports are held under for a trashed replica
+func (cnd *Conductor) getTrashedReplicaName(castId, id string) string {
+	return cnd.getUniqueReplicaName(castId, id) + ":trash"
+}
+
+// isTrashPortsPolicy reports whether the provided string is a known port policy of the
+// trash
+func isTrashPortsPolicy(policy string) bool {
+	switch policy {
+	case TrashPortsHold, TrashPortsRelease:
+		return true
+	default:
+		return false
+	}
+}
+
+// isTrashEnabled reports whether deleted replicas are kept in the trash
+func (cnd *Conductor) isTrashEnabled() bool {
+	return cnd.trashGracePeriod > 0
+}
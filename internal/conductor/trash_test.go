@@ -0,0 +1,163 @@
+package conductor
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/dnsinogeorgos/conductor/internal/zfsmanager"
+)
+
+// newTrashedReplica creates cast c1 with replica r1, deletes the replica into the trash
+// and returns the port it had
+func newTrashedReplica(t *testing.T, cnd *Conductor) int32 {
+	t.Helper()
+
+	op, err := cnd.CreateCast("c1", time.Time{}, nil)
+	wait(t, cnd, op, err)
+	op, err = cnd.CreateReplica("c1", "r1", time.Time{}, nil, 0, false)
+	wait(t, cnd, op, err)
+	replica, err := cnd.GetReplica("c1", "r1")
+	if err != nil {
+		t.Fatalf("GetReplica() error = %v", err)
+	}
+
+	op, err = cnd.DeleteReplica("c1", "r1", false)
+	wait(t, cnd, op, err)
+
+	return replica.Port
+}
+
+// checkTrashed checks that r1 is in the trash of c1 with its port held and its unit
+// stopped
+func checkTrashed(t *testing.T, cnd *Conductor, units *fakeUnits, port int32) {
+	t.Helper()
+
+	if _, err := cnd.GetReplica("c1", "r1"); err == nil {
+		t.Error("trashed replica is still live")
+	}
+	trash, err := cnd.ListTrash("c1")
+	if err != nil || len(trash) != 1 || trash[0].Id != "r1" || trash[0].Port != port {
+		t.Errorf("ListTrash() = %+v, %v, want r1 on port %d", trash, err, port)
+	}
+	if got := portOwner(cnd, port); got != "c1_r1:trash" {
+		t.Errorf("port %d is bound to %q, want it held for the trashed replica", port, got)
+	}
+	if got := units.names(); len(got) != 0 {
+		t.Errorf("running units = %v, want none", got)
+	}
+	if pending := cnd.j.Pending(); len(pending) != 0 {
+		t.Errorf("journal has %d pending intents, want none", len(pending))
+	}
+}
+
+func TestTrashRestoreReplica(t *testing.T) {
+	cnd, units := newTestConductor(t)
+	cnd.trashGracePeriod = time.Hour
+	port := newTrashedReplica(t, cnd)
+
+	checkTrashed(t, cnd, units, port)
+
+	op, err := cnd.RestoreTrashedReplica("c1", "r1")
+	wait(t, cnd, op, err)
+	replica, err := cnd.GetReplica("c1", "r1")
+	if err != nil {
+		t.Fatalf("GetReplica() after restore error = %v", err)
+	}
+	if replica.Port != port || portOwner(cnd, port) != "c1_r1" {
+		t.Errorf("restored replica port = %d bound to %q, want %d bound to c1_r1", replica.Port, portOwner(cnd, port), port)
+	}
+	if got, want := units.names(), []string{"c1_r1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("running units = %v, want %v", got, want)
+	}
+}
+
+func TestRestoreReapedReplica(t *testing.T) {
+	d := zfsmanager.NewMemoryDriver()
+	units := newFakeUnits()
+	cnd := loadTestConductor(t, d, units)
+	cnd.trashGracePeriod = time.Hour
+
+	op, err := cnd.CreateCast("c1", time.Time{}, nil)
+	wait(t, cnd, op, err)
+	op, err = cnd.CreateReplica("c1", "r1", time.Now().Add(10*time.Millisecond), nil, 0, false)
+	wait(t, cnd, op, err)
+	time.Sleep(20 * time.Millisecond)
+
+	reapAt(t, cnd, time.Now())
+	if _, err := cnd.GetReplica("c1", "r1"); err == nil {
+		t.Fatal("expired replica was not reaped")
+	}
+
+	op, err = cnd.RestoreTrashedReplica("c1", "r1")
+	wait(t, cnd, op, err)
+	replica, err := cnd.GetReplica("c1", "r1")
+	if err != nil || !replica.ExpiresAt.IsZero() {
+		t.Fatalf("GetReplica() after restore = %+v, %v, want a replica without expiry", replica, err)
+	}
+
+	reapAt(t, cnd, time.Now())
+	if _, err := cnd.GetReplica("c1", "r1"); err != nil {
+		t.Errorf("restored replica was reaped again: %v", err)
+	}
+
+	// the expiry is removed from the state of the replica as well
+	cnd = loadTestConductor(t, d, units)
+	replica, err = cnd.GetReplica("c1", "r1")
+	if err != nil || !replica.ExpiresAt.IsZero() {
+		t.Errorf("GetReplica() after reload = %+v, %v, want a replica without expiry", replica, err)
+	}
+}
+
+func TestPurgeTrashedReplica(t *testing.T) {
+	cnd, _ := newTestConductor(t)
+	cnd.trashGracePeriod = time.Hour
+	port := newTrashedReplica(t, cnd)
+
+	op, err := cnd.PurgeTrashedReplica("c1", "r1")
+	wait(t, cnd, op, err)
+
+	if trash, _ := cnd.ListTrash("c1"); len(trash) != 0 {
+		t.Errorf("ListTrash() = %+v, want none", trash)
+	}
+	if name, ok := cnd.pm.Owner(port); ok {
+		t.Errorf("port %d of purged replica is still bound to %s", port, name)
+	}
+	_, err = cnd.RestoreTrashedReplica("c1", "r1")
+	if _, ok := err.(ReplicaNotFoundError); !ok {
+		t.Errorf("RestoreTrashedReplica() of purged replica error = %v, want ReplicaNotFoundError", err)
+	}
+}
+
+func TestRecoverInterruptedTrash(t *testing.T) {
+	d := zfsmanager.NewMemoryDriver()
+	units := newFakeUnits()
+	cnd := loadTestConductor(t, d, units)
+
+	op, err := cnd.CreateCast("c1", time.Time{}, nil)
+	wait(t, cnd, op, err)
+	op, err = cnd.CreateReplica("c1", "r1", time.Time{}, nil, 0, false)
+	wait(t, cnd, op, err)
+	replica, err := cnd.GetReplica("c1", "r1")
+	if err != nil {
+		t.Fatalf("GetReplica() error = %v", err)
+	}
+
+	// the service stopped after the dataset was moved into the trash
+	_, err = cnd.j.Begin(OperationTrashReplica, "c1", "r1", replica.Port, stepTrashDataset)
+	if err != nil {
+		t.Fatalf("Begin() error = %v", err)
+	}
+	err = units.StopTemplateUnit("c1_r1")
+	if err != nil {
+		t.Fatalf("StopTemplateUnit() error = %v", err)
+	}
+	_, err = cnd.zm.TrashReplicaDataset("c1", "r1")
+	if err != nil {
+		t.Fatalf("TrashReplicaDataset() error = %v", err)
+	}
+
+	cnd = loadTestConductor(t, d, units)
+
+	checkTrashed(t, cnd, units, replica.Port)
+}
@@ -0,0 +1,90 @@
+package conductor
+
+import (
+	"github.com/dnsinogeorgos/conductor/internal/zfsmanager"
+)
+
+// Usage describes the space accounting of a cast or replica dataset in bytes. Used
+// includes the replicas of a cast, Written is the data that diverged from the origin
+// snapshot and LogicalUsed is Used before compression.
+type Usage struct {
+	Used        uint64
+	Referenced  uint64
+	Written     uint64
+	LogicalUsed uint64
+}
+
+// UsageReport contains the space accounting of the casts and of their replicas
+type UsageReport struct {
+	Casts map[string]Usage
+	// Replicas maps the cast ids to the usage of their replicas by id
+	Replicas map[string]map[string]Usage
+}
+
+// Pool describes the size of the pool, the space allocated in it and the space still
+// available to the filesystem, in bytes
+type Pool struct {
+	Name      string
+	Size      uint64
+	Allocated uint64
+	Free      uint64
+	Available uint64
+	// Used is the space used by the filesystem along with the casts and replicas
+	Used uint64
+}
+
+// GetUsage reads the current space accounting of the casts and replicas
+func (cnd *Conductor) GetUsage() (UsageReport, error) {
+	usage, err := cnd.zm.GetUsage()
+	if err != nil {
+		return UsageReport{}, wrapError(err)
+	}
+
+	report := UsageReport{
+		Casts:    make(map[string]Usage, len(usage.Casts)),
+		Replicas: make(map[string]map[string]Usage, len(usage.Replicas)),
+	}
+	for castId, u := range usage.Casts {
+		report.Casts[castId] = newUsage(u)
+	}
+	for castId, replicas := range usage.Replicas {
+		report.Replicas[castId] = make(map[string]Usage, len(replicas))
+		for replicaId, u := range replicas {
+			report.Replicas[castId][replicaId] = newUsage(u)
+		}
+	}
+
+	return report, nil
+}
+
+// GetPool reads the current size and usage of the pool and of the filesystem
+func (cnd *Conductor) GetPool() (Pool, error) {
+	pool, err := cnd.zm.GetPoolUsage()
+	if err != nil {
+		return Pool{}, wrapError(err)
+	}
+
+	usage, err := cnd.zm.GetUsage()
+	if err != nil {
+		return Pool{}, wrapError(err)
+	}
+
+	return Pool{
+		Name:      cnd.zm.GetPoolName(),
+		Size:      pool.Size,
+		Allocated: pool.Allocated,
+		Free:      pool.Free,
+		Available: usage.Filesystem.Available,
+		Used:      usage.Filesystem.Used,
+	}, nil
+}
+
+// newUsage converts the space accounting of a dataset to the usage of a cast or replica
+func newUsage(u zfsmanager.Usage) Usage {
+	return Usage{
+		Used:        u.Used,
+		Referenced:  u.Referenced,
+		Written:     u.Written,
+		LogicalUsed: u.LogicalUsed,
+	}
+}
@@ -9,25 +9,79 @@ import (
 	"github.com/kelseyhightower/envconfig"
 )
 
+// Hook stores the configuration of a lifecycle hook.
+type Hook struct {
+	Command   string `json:"command"`
+	Timeout   int    `json:"timeout"`
+	OnFailure string `json:"on_failure"`
+}
+
+// PortSlot stores the configuration of a named port slot.
+type PortSlot struct {
+	Ranges  []string `json:"ranges"`
+	Exclude []string `json:"exclude"`
+}
+
+// Resource stores the configuration of a named resource allocator.
+type Resource struct {
+	Kind    string   `json:"kind"`
+	Ranges  []string `json:"ranges"`
+	Exclude []string `json:"exclude"`
+}
+
 // Config stores the configuration loaded during startup.
 type Config struct {
 	Debug   bool   `json:"debug"`
 	Address string `json:"address"`
 	Port    int32  `json:"port"`
 
-	PoolName                 string `json:"pool_name" split_words:"true"`
-	PoolPath                 string `json:"pool_path" split_words:"true"`
-	PoolDev                  string `json:"pool_dev" split_words:"true"`
-	FilesystemName           string `json:"filesystem_name" split_words:"true"`
-	FilesystemPath           string `json:"filesystem_path" split_words:"true"`
-	CastPath                 string `json:"cast_path" split_words:"true"`
-	ReplicaPath              string `json:"replica_path" split_words:"true"`
-	PortLowerBound           int32  `json:"port_from" split_words:"true"`
-	PortUpperBound           int32  `json:"port_to" split_words:"true"`
-	MainUnit                 string `json:"main_unit" split_words:"true"`
-	ConfigTemplatePath       string `json:"config_template_path" split_words:"true"`
-	UnitTemplateString       string `json:"unit_template_string" split_words:"true"`
-	ConfigPathTemplateString string `json:"config_path_template_string" split_words:"true"`
+	QueueSize       int    `json:"queue_size" split_words:"true"`
+	ReconcilePolicy string `json:"reconcile_policy" split_words:"true"`
+
+	QuiesceStrategy string `json:"quiesce_strategy" split_words:"true"`
+	QuiesceCommand  string `json:"quiesce_command" split_words:"true"`
+	ResumeCommand   string `json:"resume_command" split_words:"true"`
+	QuiesceTimeout  int    `json:"quiesce_timeout" split_words:"true"`
+
+	Hooks    map[string]Hook `json:"hooks" ignored:"true"`
+	CastUnit bool            `json:"cast_unit" split_words:"true"`
+
+	CheckpointStopUnit bool `json:"checkpoint_stop_unit" split_words:"true"`
+
+	ReapInterval int `json:"reap_interval" split_words:"true"`
+
+	TrashGracePeriod int    `json:"trash_grace_period" split_words:"true"`
+	TrashPorts       string `json:"trash_ports" split_words:"true"`
+
+	CastProperties    map[string]string `json:"cast_properties" split_words:"true"`
+	ReplicaProperties map[string]string `json:"replica_properties" split_words:"true"`
+
+	MinFreeBytes   uint64  `json:"min_free_bytes" split_words:"true"`
+	MinFreePercent float64 `json:"min_free_percent" split_words:"true"`
+
+	PortSlots   map[string]PortSlot `json:"port_slots" ignored:"true"`
+	StickyPorts string              `json:"sticky_ports" split_words:"true"`
+
+	Resources map[string]Resource `json:"resources" ignored:"true"`
+
+	StorageDriver            string   `json:"storage_driver" split_words:"true"`
+	PoolName                 string   `json:"pool_name" split_words:"true"`
+	PoolPath                 string   `json:"pool_path" split_words:"true"`
+	PoolDev                  string   `json:"pool_dev" split_words:"true"`
+	FilesystemName           string   `json:"filesystem_name" split_words:"true"`
+	FilesystemPath           string   `json:"filesystem_path" split_words:"true"`
+	CastPath                 string   `json:"cast_path" split_words:"true"`
+	ReplicaPath              string   `json:"replica_path" split_words:"true"`
+	PortLowerBound           int32    `json:"port_from" split_words:"true"`
+	PortUpperBound           int32    `json:"port_to" split_words:"true"`
+	PortRanges               []string `json:"port_ranges" split_words:"true"`
+	PortExclude              []string `json:"port_exclude" split_words:"true"`
+	PortProbe                string   `json:"port_probe" split_words:"true"`
+	MainUnit                 string   `json:"main_unit" split_words:"true"`
+	ConfigTemplatePath       string   `json:"config_template_path" split_words:"true"`
+	UnitTemplateString       string   `json:"unit_template_string" split_words:"true"`
+	ConfigPathTemplateString string   `json:"config_path_template_string" split_words:"true"`
+	SocketPathTemplateString string   `json:"socket_path_template_string" split_words:"true"`
 }
 
 // NewConfig creates an empty config instance.
@@ -36,14 +90,23 @@ func NewConfig(name string) (*Config, error) {
 	flag.Parse()
 
 	config := Config{
-		Debug:          false,
-		Address:        "127.0.0.1",
-		Port:           8080,
-		PoolName:       "rootpool",
-		PoolPath:       "/rootpool",
-		FilesystemName: "rootfs",
-		CastPath:       "/rootfs_cast",
-		ReplicaPath:    "/rootfs_replica",
+		Debug:           false,
+		Address:         "127.0.0.1",
+		Port:            8080,
+		QueueSize:       32,
+		ReconcilePolicy: "report",
+		QuiesceStrategy: "unit",
+		QuiesceTimeout:  60,
+		ReapInterval:    60,
+		TrashPorts:      "hold",
+		PortProbe:       "listen",
+		StickyPorts:     "none",
+		StorageDriver:   "zfs",
+		PoolName:        "rootpool",
+		PoolPath:        "/rootpool",
+		FilesystemName:  "rootfs",
+		CastPath:        "/rootfs_cast",
+		ReplicaPath:     "/rootfs_replica",
 	}
 
 	err := envconfig.Process(name, &config)
@@ -67,11 +130,11 @@ func NewConfig(name string) (*Config, error) {
 		return &Config{}, MissingConfigurationVariableError{t: "string", n: "FilesystemPath"}
 	}
 
-	if config.PortLowerBound == 0 {
+	if config.PortLowerBound == 0 && len(config.PortRanges) == 0 {
 		return &Config{}, MissingConfigurationVariableError{t: "int", n: "PortLowerBound"}
 	}
 
-	if config.PortUpperBound == 0 {
+	if config.PortUpperBound == 0 && len(config.PortRanges) == 0 {
 		return &Config{}, MissingConfigurationVariableError{t: "int", n: "PortUpperBound"}
 	}
 
@@ -0,0 +1,41 @@
+package hooks
+
+import "fmt"
+
+type UnknownHookError struct {
+	h string
+}
+
+func (e UnknownHookError) Error() string {
+	return fmt.Sprintf("unknown hook %s", e.h)
+}
+
+type UnknownPolicyError struct {
+	h string
+	p string
+}
+
+func (e UnknownPolicyError) Error() string {
+	return fmt.Sprintf("unknown failure policy %s for hook %s", e.p, e.h)
+}
+
+type CommandError struct {
+	h   string
+	err error
+}
+
+func (e CommandError) Error() string {
+	return fmt.Sprintf("hook %s failed: %s", e.h, e.err)
+}
+
+func (e CommandError) Unwrap() error {
+	return e.err
+}
+
+type TimeoutError struct {
+	h string
+}
+
+func (e TimeoutError) Error() string {
+	return fmt.Sprintf("hook %s timed out", e.h)
+}
@@ -0,0 +1,212 @@
+package hooks
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Points of the lifecycle where hooks can run
+const (
+	PreCast           = "pre_cast"
+	PostSnapshot      = "post_snapshot"
+	CastReady         = "cast_ready"
+	PostReplicaCreate = "post_replica_create"
+	PreReplicaDelete  = "pre_replica_delete"
+)
+
+// Failure policies of the hooks
+const (
+	FailureAbort = "abort"
+	FailureWarn  = "warn"
+)
+
+// defaultTimeout is used for the hooks that do not configure a timeout
+const defaultTimeout = 60 * time.Second
+
+// outputLimit is the amount of bytes kept from the stdout and stderr of each hook
+const outputLimit = 64 * 1024
+
+// Hook describes a command that runs at a point of the lifecycle
+type Hook struct {
+	Command   string
+	Timeout   time.Duration
+	OnFailure string
+}
+
+// Env contains the values exported to the environment of a hook
+type Env struct {
+	CastId     string
+	ReplicaId  string
+	MountPoint string
+	Port       int32
+	// Socket is the socket path of a replica with a socket endpoint
+	Socket string
+	// Ports maps the port slots of a replica to their ports
+	Ports map[string]int32
+	// Resources maps the resources of a replica to their values
+	Resources map[string]string
+}
+
+// Result contains the outcome of a hook that ran
+type Result struct {
+	Hook     string
+	Command  string
+	Stdout   string
+	Stderr   string
+	ExitCode int
+	Duration time.Duration
+	Err      error
+}
+
+// Runner runs the configured hooks
+type Runner struct {
+	l     *zap.Logger
+	hooks map[string]Hook
+}
+
+// New validates the hooks and creates a Runner object
+func New(hooks map[string]Hook, logger *zap.Logger) (*Runner, error) {
+	validated := make(map[string]Hook)
+	for point, hook := range hooks {
+		switch point {
+		case PreCast, PostSnapshot, CastReady, PostReplicaCreate, PreReplicaDelete:
+		default:
+			return nil, UnknownHookError{point}
+		}
+
+		switch hook.OnFailure {
+		case "":
+			hook.OnFailure = FailureAbort
+		case FailureAbort, FailureWarn:
+		default:
+			return nil, UnknownPolicyError{h: point, p: hook.OnFailure}
+		}
+
+		if hook.Timeout <= 0 {
+			hook.Timeout = defaultTimeout
+		}
+
+		if hook.Command == "" {
+			continue
+		}
+		validated[point] = hook
+		logger.Info("configured hook", zap.String("hook", point), zap.String("command", hook.Command), zap.String("on_failure", hook.OnFailure))
+	}
+
+	return &Runner{
+		l:     logger,
+		hooks: validated,
+	}, nil
+}
+
+// Run executes the hook configured for a point through the shell. The result is nil if
+// no hook is configured. The error is only returned if the hook failed and its policy
+// is to abort.
+func (r *Runner) Run(point string, env Env) (*Result, error) {
+	hook, ok := r.hooks[point]
+	if !ok {
+		return nil, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), hook.Timeout)
+	defer cancel()
+
+	var stdout, stderr limitedBuffer
+	cmd := exec.Command("/bin/sh", "-c", hook.Command)
+	cmd.Env = append(os.Environ(),
+		"CONDUCTOR_HOOK="+point,
+		"CONDUCTOR_CAST_ID="+env.CastId,
+		"CONDUCTOR_REPLICA_ID="+env.ReplicaId,
+		"CONDUCTOR_MOUNTPOINT="+env.MountPoint,
+		fmt.Sprintf("CONDUCTOR_PORT=%d", env.Port),
+		"CONDUCTOR_SOCKET="+env.Socket,
+	)
+	for slot, port := range env.Ports {
+		cmd.Env = append(cmd.Env, fmt.Sprintf("CONDUCTOR_PORT_%s=%d", strings.ToUpper(slot), port))
+	}
+	for resource, value := range env.Resources {
+		cmd.Env = append(cmd.Env, "CONDUCTOR_RESOURCE_"+strings.ToUpper(resource)+"="+value)
+	}
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	r.l.Info("running hook", zap.String("hook", point), zap.String("cast", env.CastId), zap.String("replica", env.ReplicaId))
+	started := time.Now()
+	out, err := newOutput(cmd, &stdout, &stderr)
+	if err == nil {
+		err = cmd.Start()
+		out.closeWriters()
+	}
+	if err == nil {
+		done := make(chan error, 1)
+		go func() {
+			done <- cmd.Wait()
+		}()
+
+		select {
+		case err = <-done:
+		case <-ctx.Done():
+			// the whole process group is killed, and the pipes are closed below in
+			// case a child that left the group keeps them open
+			_ = syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+			<-done
+			err = TimeoutError{point}
+		}
+	}
+	if out != nil {
+		out.wait(ctx.Done())
+	}
+
+	result := &Result{
+		Hook:     point,
+		Command:  hook.Command,
+		Stdout:   stdout.String(),
+		Stderr:   stderr.String(),
+		ExitCode: -1,
+		Duration: time.Since(started),
+	}
+	if cmd.ProcessState != nil {
+		result.ExitCode = cmd.ProcessState.ExitCode()
+	}
+	if err == nil {
+		r.l.Info("hook succeeded", zap.String("hook", point), zap.Duration("duration", result.Duration))
+		return result, nil
+	}
+
+	if _, ok := err.(TimeoutError); !ok {
+		err = CommandError{h: point, err: err}
+	}
+	result.Err = err
+
+	if hook.OnFailure == FailureWarn {
+		r.l.Warn("hook failed, continuing", zap.String("hook", point), zap.Error(err))
+		return result, nil
+	}
+
+	r.l.Error("hook failed", zap.String("hook", point), zap.Error(err))
+	return result, err
+}
+
+// limitedBuffer keeps the first outputLimit bytes written to it and discards the rest
+type limitedBuffer struct {
+	bytes.Buffer
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	n := len(p)
+	if room := outputLimit - b.Len(); room < n {
+		if room > 0 {
+			b.Buffer.Write(p[:room])
+		}
+		return n, nil
+	}
+
+	return b.Buffer.Write(p)
+}
@@ -0,0 +1,114 @@
+package hooks
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestRun(t *testing.T) {
+	tests := []struct {
+		name      string
+		hook      Hook
+		wantErr   bool
+		wantCode  int
+		wantOut   string
+		wantStErr string
+	}{
+		{
+			name:    "success",
+			hook:    Hook{Command: "echo $CONDUCTOR_HOOK $CONDUCTOR_CAST_ID $CONDUCTOR_PORT $CONDUCTOR_PORT_METRICS"},
+			wantOut: "pre_cast c1 3307 9104\n",
+		},
+		{
+			name:      "failure aborts",
+			hook:      Hook{Command: "echo oops >&2; exit 3"},
+			wantErr:   true,
+			wantCode:  3,
+			wantStErr: "oops\n",
+		},
+		{
+			name:     "failure warns",
+			hook:     Hook{Command: "exit 1", OnFailure: FailureWarn},
+			wantCode: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := New(map[string]Hook{PreCast: tt.hook}, zap.NewNop())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			result, err := r.Run(PreCast, Env{CastId: "c1", Port: 3307, Ports: map[string]int32{"metrics": 9104}})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Run() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if result.ExitCode != tt.wantCode {
+				t.Errorf("ExitCode = %d, want %d", result.ExitCode, tt.wantCode)
+			}
+			if result.Stdout != tt.wantOut {
+				t.Errorf("Stdout = %q, want %q", result.Stdout, tt.wantOut)
+			}
+			if result.Stderr != tt.wantStErr {
+				t.Errorf("Stderr = %q, want %q", result.Stderr, tt.wantStErr)
+			}
+		})
+	}
+}
+
+func TestRunNotConfigured(t *testing.T) {
+	r, err := New(nil, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := r.Run(CastReady, Env{})
+	if result != nil || err != nil {
+		t.Errorf("Run() = %v, %v, want nil, nil", result, err)
+	}
+}
+
+func TestRunTimeout(t *testing.T) {
+	// the child leaves the process group of the hook and keeps its output open
+	r, err := New(map[string]Hook{PreCast: {Command: "echo started; setsid sleep 30; sleep 30", Timeout: 200 * time.Millisecond}}, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	started := time.Now()
+	result, err := r.Run(PreCast, Env{})
+	if _, ok := err.(TimeoutError); !ok {
+		t.Fatalf("Run() error = %v, want TimeoutError", err)
+	}
+	if elapsed := time.Since(started); elapsed > 200*time.Millisecond+outputGrace+time.Second {
+		t.Errorf("Run() took %s", elapsed)
+	}
+	if !strings.HasPrefix(result.Stdout, "started") {
+		t.Errorf("Stdout = %q, want the output before the timeout", result.Stdout)
+	}
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name    string
+		hooks   map[string]Hook
+		wantErr error
+	}{
+		{"unknown point", map[string]Hook{"post_cast": {Command: "true"}}, UnknownHookError{"post_cast"}},
+		{"unknown policy", map[string]Hook{PreCast: {Command: "true", OnFailure: "retry"}}, UnknownPolicyError{h: PreCast, p: "retry"}},
+		{"valid", map[string]Hook{PreCast: {Command: "true"}}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New(tt.hooks, zap.NewNop())
+			if err != tt.wantErr {
+				t.Errorf("New() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
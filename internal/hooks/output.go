@@ -0,0 +1,81 @@
+package hooks
+
+import (
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+// outputGrace is how long the output of a hook is still read after its deadline, before
+// the pipes are closed
+const outputGrace = time.Second
+
+// output copies the stdout and stderr of a hook through pipes owned by the runner, so
+// that they can be closed if a child that left the process group keeps them open
+type output struct {
+	readers []*os.File
+	writers []*os.File
+	done    chan struct{}
+}
+
+// newOutput connects the stdout and stderr of a command to the provided writers
+func newOutput(cmd *exec.Cmd, stdout, stderr io.Writer) (*output, error) {
+	o := &output{done: make(chan struct{})}
+	for range []io.Writer{stdout, stderr} {
+		pr, pw, err := os.Pipe()
+		if err != nil {
+			o.closeWriters()
+			o.closeReaders()
+			return nil, err
+		}
+		o.readers = append(o.readers, pr)
+		o.writers = append(o.writers, pw)
+	}
+	cmd.Stdout, cmd.Stderr = o.writers[0], o.writers[1]
+
+	var wg sync.WaitGroup
+	for i, w := range []io.Writer{stdout, stderr} {
+		wg.Add(1)
+		go func(r io.Reader, w io.Writer) {
+			defer wg.Done()
+			_, _ = io.Copy(w, r)
+		}(o.readers[i], w)
+	}
+	go func() {
+		wg.Wait()
+		close(o.done)
+	}()
+
+	return o, nil
+}
+
+// wait waits until the output is copied or the deadline passes, and closes the pipes.
+// The output that is still buffered is read for a grace period after the deadline.
+func (o *output) wait(deadline <-chan struct{}) {
+	select {
+	case <-o.done:
+	case <-deadline:
+		select {
+		case <-o.done:
+		case <-time.After(outputGrace):
+		}
+	}
+	o.closeReaders()
+	<-o.done
+}
+
+// closeWriters closes the write ends of the pipes, which the command inherited on start
+func (o *output) closeWriters() {
+	for _, w := range o.writers {
+		_ = w.Close()
+	}
+}
+
+// closeReaders closes the read ends of the pipes, which stops the copies
+func (o *output) closeReaders() {
+	for _, r := range o.readers {
+		_ = r.Close()
+	}
+}
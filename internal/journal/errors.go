@@ -0,0 +1,11 @@
+package journal
+
+import "fmt"
+
+type IntentNotFoundError struct {
+	i string
+}
+
+func (e IntentNotFoundError) Error() string {
+	return fmt.Sprintf("intent %s not found", e.i)
+}
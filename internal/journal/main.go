@@ -0,0 +1,285 @@
+package journal
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Store persists the serialized journal
+type Store interface {
+	// ReadJournal returns the serialized journal, or an error satisfying os.IsNotExist if
+	// it has never been written
+	ReadJournal() ([]byte, error)
+	// WriteJournal replaces the serialized journal
+	WriteJournal(data []byte) error
+}
+
+// Intent records a multi-step operation that is in progress and the last step it reached
+type Intent struct {
+	Id        string    `json:"id"`
+	Kind      string    `json:"kind"`
+	CastId    string    `json:"castId"`
+	ReplicaId string    `json:"replicaId,omitempty"`
+	Port      int32     `json:"port,omitempty"`
+	Step      string    `json:"step"`
+	Started   time.Time `json:"started"`
+	Updated   time.Time `json:"updated"`
+	// Replicas maps the replicas an operation on a whole cast must restore to their ports
+	Replicas map[string]int32 `json:"replicas,omitempty"`
+	// Expiries maps the restored replicas that expire to their expiry
+	Expiries map[string]time.Time `json:"expiries,omitempty"`
+	// Protected lists the restored replicas that are protected from deletion
+	Protected map[string]bool `json:"protected,omitempty"`
+	// Properties maps the restored replicas to the ZFS properties they are cloned with
+	Properties map[string]map[string]string `json:"properties,omitempty"`
+	// Ports maps the restored replicas to the ports of their port slots
+	Ports map[string]map[string]int32 `json:"ports,omitempty"`
+	// Resources maps the restored replicas to the values of their resources
+	Resources map[string]map[string]string `json:"resources,omitempty"`
+}
+
+// Journal is a write-ahead log of the intents of the multi-step operations. Every change
+// is persisted before it is acknowledged, so that the intents that were in progress
+// during a crash can be recovered on the next start.
+type Journal struct {
+	mu      sync.Mutex
+	l       *zap.Logger
+	s       Store
+	intents map[string]*Intent
+}
+
+// New creates an empty Journal object on top of the provided store
+func New(store Store, logger *zap.Logger) *Journal {
+	return &Journal{
+		l:       logger,
+		s:       store,
+		intents: make(map[string]*Intent),
+	}
+}
+
+// Load reads the intents persisted in the store
+func (j *Journal) Load() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	b, err := j.s.ReadJournal()
+	if err != nil {
+		if os.IsNotExist(err) {
+			j.l.Debug("did not find journal. starting empty...")
+			return nil
+		}
+		j.l.Error("failed to read journal", zap.Error(err))
+		return err
+	}
+
+	intents := make([]*Intent, 0)
+	err = json.Unmarshal(b, &intents)
+	if err != nil {
+		j.l.Error("failed to unmarshal journal json", zap.Error(err))
+		return err
+	}
+
+	j.intents = make(map[string]*Intent)
+	for _, intent := range intents {
+		j.intents[intent.Id] = intent
+	}
+	j.l.Info("loaded journal", zap.Int("intents", len(j.intents)))
+
+	return nil
+}
+
+// Begin persists a new intent at its first step and returns its id. The port is recorded
+// for the operations that need it to be recovered.
+func (j *Journal) Begin(kind, castId, replicaId string, port int32, step string) (string, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	id, err := newId()
+	if err != nil {
+		j.l.Error("failed to generate intent id", zap.Error(err))
+		return "", err
+	}
+
+	now := time.Now().UTC()
+	j.intents[id] = &Intent{
+		Id:        id,
+		Kind:      kind,
+		CastId:    castId,
+		ReplicaId: replicaId,
+		Port:      port,
+		Step:      step,
+		Started:   now,
+		Updated:   now,
+	}
+
+	err = j.save()
+	if err != nil {
+		delete(j.intents, id)
+		return "", err
+	}
+
+	j.l.Debug("began intent", zap.String("intent", id), zap.String("kind", kind), zap.String("step", step))
+	return id, nil
+}
+
+// Step persists the step an intent is about to execute
+func (j *Journal) Step(id, step string) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	intent, ok := j.intents[id]
+	if !ok {
+		return IntentNotFoundError{id}
+	}
+
+	previous := intent.Step
+	intent.Step = step
+	intent.Updated = time.Now().UTC()
+
+	err := j.save()
+	if err != nil {
+		intent.Step = previous
+		return err
+	}
+
+	j.l.Debug("stepped intent", zap.String("intent", id), zap.String("step", step))
+	return nil
+}
+
+// SetReplicas persists the replicas, ports, expiries, protection, properties, ports of
+// the port slots and resources an intent must restore
+func (j *Journal) SetReplicas(id string, replicas map[string]int32, expiries map[string]time.Time, protected map[string]bool, properties map[string]map[string]string, ports map[string]map[string]int32, resources map[string]map[string]string) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	intent, ok := j.intents[id]
+	if !ok {
+		return IntentNotFoundError{id}
+	}
+
+	previous, previousExpiries, previousProtected, previousProperties, previousPorts := intent.Replicas, intent.Expiries, intent.Protected, intent.Properties, intent.Ports
+	previousResources := intent.Resources
+	intent.Replicas = make(map[string]int32, len(replicas))
+	for name, port := range replicas {
+		intent.Replicas[name] = port
+	}
+	intent.Expiries = make(map[string]time.Time, len(expiries))
+	for name, expiresAt := range expiries {
+		intent.Expiries[name] = expiresAt
+	}
+	intent.Protected = make(map[string]bool, len(protected))
+	for name, isProtected := range protected {
+		if isProtected {
+			intent.Protected[name] = true
+		}
+	}
+	intent.Properties = make(map[string]map[string]string, len(properties))
+	for name, p := range properties {
+		if len(p) != 0 {
+			intent.Properties[name] = p
+		}
+	}
+	intent.Ports = make(map[string]map[string]int32, len(ports))
+	for name, p := range ports {
+		if len(p) != 0 {
+			intent.Ports[name] = p
+		}
+	}
+	intent.Resources = make(map[string]map[string]string, len(resources))
+	for name, r := range resources {
+		if len(r) != 0 {
+			intent.Resources[name] = r
+		}
+	}
+	intent.Updated = time.Now().UTC()
+
+	err := j.save()
+	if err != nil {
+		intent.Replicas, intent.Expiries, intent.Protected, intent.Properties, intent.Ports = previous, previousExpiries, previousProtected, previousProperties, previousPorts
+		intent.Resources = previousResources
+		return err
+	}
+
+	j.l.Debug("recorded replicas of intent", zap.String("intent", id), zap.Int("replicas", len(replicas)))
+	return nil
+}
+
+// Finish removes a completed or reverted intent from the journal
+func (j *Journal) Finish(id string) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	intent, ok := j.intents[id]
+	if !ok {
+		return IntentNotFoundError{id}
+	}
+
+	delete(j.intents, id)
+	err := j.save()
+	if err != nil {
+		j.intents[id] = intent
+		return err
+	}
+
+	j.l.Debug("finished intent", zap.String("intent", id))
+	return nil
+}
+
+// Pending returns the intents that have not finished, ordered by start time
+func (j *Journal) Pending() []Intent {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	intents := make([]Intent, 0, len(j.intents))
+	for _, intent := range j.intents {
+		intents = append(intents, *intent)
+	}
+	sort.Slice(intents, func(a, b int) bool {
+		return intents[a].Started.Before(intents[b].Started)
+	})
+
+	return intents
+}
+
+// save persists the current intents to the store
+func (j *Journal) save() error {
+	intents := make([]*Intent, 0, len(j.intents))
+	for _, intent := range j.intents {
+		intents = append(intents, intent)
+	}
+	sort.Slice(intents, func(a, b int) bool {
+		return intents[a].Started.Before(intents[b].Started)
+	})
+
+	b, err := json.MarshalIndent(intents, "", "  ")
+	if err != nil {
+		j.l.Error("failed to marshal journal json", zap.Error(err))
+		return err
+	}
+
+	err = j.s.WriteJournal(b)
+	if err != nil {
+		j.l.Error("failed to write journal", zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+// newId generates a random intent id
+func newId() (string, error) {
+	b := make([]byte, 8)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,124 @@
+package journal
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+// memoryStore keeps the serialized journal in memory and fails writes on demand
+type memoryStore struct {
+	data      []byte
+	failWrite bool
+}
+
+func (s *memoryStore) ReadJournal() ([]byte, error) {
+	if s.data == nil {
+		return nil, &os.PathError{Op: "open", Path: "journal", Err: os.ErrNotExist}
+	}
+	return s.data, nil
+}
+
+func (s *memoryStore) WriteJournal(data []byte) error {
+	if s.failWrite {
+		return errors.New("write failed")
+	}
+	s.data = append([]byte(nil), data...)
+	return nil
+}
+
+func TestJournal(t *testing.T) {
+	s := &memoryStore{}
+	j := New(s, zap.NewNop())
+
+	err := j.Load()
+	if err != nil {
+		t.Fatalf("Load() of missing journal error = %v", err)
+	}
+
+	first, err := j.Begin("create_cast", "c1", "", 0, "snapshot")
+	if err != nil {
+		t.Fatalf("Begin() error = %v", err)
+	}
+	second, err := j.Begin("create_replica", "c1", "r1", 3307, "clone")
+	if err != nil {
+		t.Fatalf("Begin() error = %v", err)
+	}
+	err = j.Step(second, "start_unit")
+	if err != nil {
+		t.Fatalf("Step() error = %v", err)
+	}
+
+	// a new journal on the same store recovers the intents in the order they began
+	recovered := New(s, zap.NewNop())
+	err = recovered.Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	pending := recovered.Pending()
+	if len(pending) != 2 {
+		t.Fatalf("Pending() returned %d intents, want 2", len(pending))
+	}
+	if pending[0].Id != first || pending[1].Id != second {
+		t.Errorf("Pending() order = %s, %s, want %s, %s", pending[0].Id, pending[1].Id, first, second)
+	}
+	if got := pending[1]; got.Step != "start_unit" || got.Port != 3307 || got.ReplicaId != "r1" {
+		t.Errorf("recovered intent = %+v", got)
+	}
+
+	err = j.Finish(first)
+	if err != nil {
+		t.Fatalf("Finish() error = %v", err)
+	}
+	if pending := j.Pending(); len(pending) != 1 || pending[0].Id != second {
+		t.Errorf("Pending() after Finish() = %+v, want %s only", pending, second)
+	}
+}
+
+func TestJournalErrors(t *testing.T) {
+	s := &memoryStore{}
+	j := New(s, zap.NewNop())
+
+	id, err := j.Begin("create_cast", "c1", "", 0, "snapshot")
+	if err != nil {
+		t.Fatalf("Begin() error = %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		failWrite bool
+		run       func() error
+		wantStep  string
+		wantCount int
+	}{
+		{"step unknown intent", false, func() error { return j.Step("missing", "clone") }, "snapshot", 1},
+		{"finish unknown intent", false, func() error { return j.Finish("missing") }, "snapshot", 1},
+		{"step not persisted", true, func() error { return j.Step(id, "clone") }, "snapshot", 1},
+		{"finish not persisted", true, func() error { return j.Finish(id) }, "snapshot", 1},
+		{"begin not persisted", true, func() error {
+			_, err := j.Begin("create_cast", "c2", "", 0, "snapshot")
+			return err
+		}, "snapshot", 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s.failWrite = tt.failWrite
+			defer func() { s.failWrite = false }()
+
+			if err := tt.run(); err == nil {
+				t.Error("error = nil, want an error")
+			}
+			pending := j.Pending()
+			if len(pending) != tt.wantCount || pending[0].Step != tt.wantStep {
+				t.Errorf("Pending() = %+v, want %d intent at step %s", pending, tt.wantCount, tt.wantStep)
+			}
+		})
+	}
+
+	if _, ok := j.Step("missing", "clone").(IntentNotFoundError); !ok {
+		t.Error("Step() of unknown intent did not return IntentNotFoundError")
+	}
+}
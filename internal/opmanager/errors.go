@@ -0,0 +1,23 @@
+package opmanager
+
+import "fmt"
+
+type OperationNotFoundError struct {
+	o string
+}
+
+func (e OperationNotFoundError) Error() string {
+	return fmt.Sprintf("operation %s not found", e.o)
+}
+
+type QueueFullError struct{}
+
+func (e QueueFullError) Error() string {
+	return "operation queue is full"
+}
+
+type ShuttingDownError struct{}
+
+func (e ShuttingDownError) Error() string {
+	return "conductor is shutting down"
+}
@@ -0,0 +1,266 @@
+package opmanager
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// retention is the amount of finished operations kept in memory
+const retention = 1000
+
+// State describes the progress of an operation
+type State string
+
+const (
+	Queued    State = "queued"
+	Running   State = "running"
+	Succeeded State = "succeeded"
+	Failed    State = "failed"
+)
+
+// Task is the unit of work executed by an operation. It receives the operation id.
+type Task func(id string) error
+
+// Operation contains the state of an operation submitted to the queue
+type Operation struct {
+	Id        string
+	Kind      string
+	CastId    string
+	ReplicaId string
+	State     State
+	Created   time.Time
+	Started   time.Time
+	Finished  time.Time
+	Err       error
+	Result    interface{}
+	Outputs   []Output
+}
+
+// Output contains the output of a command run by an operation
+type Output struct {
+	Name     string
+	Command  string
+	Stdout   string
+	Stderr   string
+	ExitCode int
+	Duration time.Duration
+	Err      error
+}
+
+// job pairs an operation with the task it executes
+type job struct {
+	op   *Operation
+	task Task
+}
+
+// OperationManager executes the submitted tasks one at a time on a background goroutine
+// and keeps track of their state.
+type OperationManager struct {
+	mu     sync.RWMutex
+	l      *zap.Logger
+	ops    map[string]*Operation
+	queue  chan *job
+	closed bool
+	done   chan struct{}
+}
+
+// New creates an OperationManager object and starts its worker
+func New(size int, logger *zap.Logger) *OperationManager {
+	if size < 1 {
+		logger.Fatal("bad configuration: queue size must be positive")
+	}
+
+	om := &OperationManager{
+		l:     logger,
+		ops:   make(map[string]*Operation),
+		queue: make(chan *job, size),
+		done:  make(chan struct{}),
+	}
+	go om.run()
+
+	logger.Info("initialized opmanager", zap.Int("queue_size", size))
+
+	return om
+}
+
+// Submit queues a task and returns the operation that tracks it
+func (om *OperationManager) Submit(kind, castId, replicaId string, task Task) (Operation, error) {
+	om.mu.Lock()
+	defer om.mu.Unlock()
+
+	if om.closed {
+		return Operation{}, ShuttingDownError{}
+	}
+
+	id, err := newId()
+	if err != nil {
+		om.l.Error("failed to generate operation id", zap.Error(err))
+		return Operation{}, err
+	}
+
+	op := &Operation{
+		Id:        id,
+		Kind:      kind,
+		CastId:    castId,
+		ReplicaId: replicaId,
+		State:     Queued,
+		Created:   time.Now().UTC(),
+	}
+
+	select {
+	case om.queue <- &job{op: op, task: task}:
+	default:
+		om.l.Error("cannot submit operation, queue is full", zap.String("kind", kind))
+		return Operation{}, QueueFullError{}
+	}
+
+	om.l.Debug("queued operation", zap.String("operation", id), zap.String("kind", kind))
+	om.ops[id] = op
+	om.prune()
+
+	return *op, nil
+}
+
+// Get retrieves an operation by id
+func (om *OperationManager) Get(id string) (Operation, error) {
+	om.mu.RLock()
+	defer om.mu.RUnlock()
+
+	op, ok := om.ops[id]
+	if !ok {
+		return Operation{}, OperationNotFoundError{id}
+	}
+
+	return *op, nil
+}
+
+// List returns the known operations ordered by creation time
+func (om *OperationManager) List() []Operation {
+	om.mu.RLock()
+	defer om.mu.RUnlock()
+
+	ops := make([]Operation, 0, len(om.ops))
+	for _, op := range om.ops {
+		ops = append(ops, *op)
+	}
+	sort.Slice(ops, func(i, j int) bool {
+		return ops[i].Created.Before(ops[j].Created)
+	})
+
+	return ops
+}
+
+// SetResult attaches the result of a task to its operation
+func (om *OperationManager) SetResult(id string, result interface{}) {
+	om.mu.Lock()
+	defer om.mu.Unlock()
+
+	if op, ok := om.ops[id]; ok {
+		op.Result = result
+	}
+}
+
+// AddOutput appends the output of a command to an operation
+func (om *OperationManager) AddOutput(id string, output Output) {
+	om.mu.Lock()
+	defer om.mu.Unlock()
+
+	if op, ok := om.ops[id]; ok {
+		op.Outputs = append(op.Outputs, output)
+	}
+}
+
+// Shutdown stops accepting operations and waits for the running operation to finish.
+// Operations still in the queue are not executed.
+func (om *OperationManager) Shutdown() {
+	om.mu.Lock()
+	if !om.closed {
+		om.closed = true
+		close(om.queue)
+	}
+	om.mu.Unlock()
+
+	<-om.done
+}
+
+// run executes the queued tasks until the queue is closed
+func (om *OperationManager) run() {
+	defer close(om.done)
+
+	for j := range om.queue {
+		om.mu.RLock()
+		closed := om.closed
+		om.mu.RUnlock()
+		if closed {
+			om.l.Warn("dropping queued operation on shutdown", zap.String("operation", j.op.Id), zap.String("kind", j.op.Kind))
+			om.finish(j.op, ShuttingDownError{})
+			continue
+		}
+
+		om.mu.Lock()
+		j.op.State = Running
+		j.op.Started = time.Now().UTC()
+		om.mu.Unlock()
+
+		om.l.Info("running operation", zap.String("operation", j.op.Id), zap.String("kind", j.op.Kind))
+		err := j.task(j.op.Id)
+		om.finish(j.op, err)
+	}
+}
+
+// finish records the outcome of an operation
+func (om *OperationManager) finish(op *Operation, err error) {
+	om.mu.Lock()
+	defer om.mu.Unlock()
+
+	op.Finished = time.Now().UTC()
+	op.Err = err
+	if err != nil {
+		op.State = Failed
+		om.l.Error("operation failed", zap.String("operation", op.Id), zap.String("kind", op.Kind), zap.Error(err))
+		return
+	}
+
+	op.State = Succeeded
+	om.l.Info("operation succeeded", zap.String("operation", op.Id), zap.String("kind", op.Kind))
+}
+
+// prune discards the oldest finished operations above the retention limit
+func (om *OperationManager) prune() {
+	if len(om.ops) <= retention {
+		return
+	}
+
+	finished := make([]*Operation, 0)
+	for _, op := range om.ops {
+		if op.State == Succeeded || op.State == Failed {
+			finished = append(finished, op)
+		}
+	}
+	sort.Slice(finished, func(i, j int) bool {
+		return finished[i].Finished.Before(finished[j].Finished)
+	})
+
+	for _, op := range finished {
+		if len(om.ops) <= retention {
+			break
+		}
+		delete(om.ops, op.Id)
+	}
+}
+
+// newId generates a random operation id
+func newId() (string, error) {
+	b := make([]byte, 8)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,162 @@
+package opmanager
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// wait waits for an operation to finish and returns its final state
+func wait(t *testing.T, om *OperationManager, id string) Operation {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		op, err := om.Get(id)
+		if err != nil {
+			t.Fatalf("Get() error = %v", err)
+		}
+		if op.State == Succeeded || op.State == Failed {
+			return op
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("operation %s did not finish", id)
+	return Operation{}
+}
+
+func TestSubmit(t *testing.T) {
+	failure := errors.New("failure")
+
+	tests := []struct {
+		name      string
+		task      Task
+		wantState State
+		wantErr   error
+	}{
+		{"success", func(string) error { return nil }, Succeeded, nil},
+		{"failure", func(string) error { return failure }, Failed, failure},
+	}
+
+	om := New(4, zap.NewNop())
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			op, err := om.Submit("kind", "c1", "r1", tt.task)
+			if err != nil {
+				t.Fatalf("Submit() error = %v", err)
+			}
+			if op.State != Queued || op.Kind != "kind" || op.CastId != "c1" || op.ReplicaId != "r1" {
+				t.Errorf("Submit() = %+v", op)
+			}
+
+			op = wait(t, om, op.Id)
+			if op.State != tt.wantState || op.Err != tt.wantErr {
+				t.Errorf("finished operation state = %s, error = %v, want %s, %v", op.State, op.Err, tt.wantState, tt.wantErr)
+			}
+			if op.Started.IsZero() || op.Finished.Before(op.Started) {
+				t.Errorf("operation times started = %v, finished = %v", op.Started, op.Finished)
+			}
+		})
+	}
+}
+
+func TestSubmitRunsInOrder(t *testing.T) {
+	om := New(8, zap.NewNop())
+
+	order := make(chan int, 3)
+	ids := make([]string, 0, 3)
+	for i := 0; i < 3; i++ {
+		i := i
+		op, err := om.Submit("kind", "", "", func(string) error {
+			order <- i
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Submit() error = %v", err)
+		}
+		ids = append(ids, op.Id)
+	}
+	for _, id := range ids {
+		wait(t, om, id)
+	}
+
+	for want := 0; want < 3; want++ {
+		if got := <-order; got != want {
+			t.Errorf("task %d ran at position %d", got, want)
+		}
+	}
+	if got := len(om.List()); got != 3 {
+		t.Errorf("List() returned %d operations, want 3", got)
+	}
+}
+
+func TestSubmitQueueFullAndShutdown(t *testing.T) {
+	om := New(1, zap.NewNop())
+
+	release := make(chan struct{})
+	running, err := om.Submit("blocking", "", "", func(string) error {
+		<-release
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Submit() error = %v", err)
+	}
+	for {
+		op, _ := om.Get(running.Id)
+		if op.State == Running {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	queued, err := om.Submit("queued", "", "", func(string) error { return nil })
+	if err != nil {
+		t.Fatalf("Submit() error = %v", err)
+	}
+	_, err = om.Submit("full", "", "", func(string) error { return nil })
+	if _, ok := err.(QueueFullError); !ok {
+		t.Errorf("Submit() on a full queue error = %v, want QueueFullError", err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		om.Shutdown()
+		close(done)
+	}()
+	// the running operation finishes, the queued one is dropped
+	for {
+		om.mu.RLock()
+		closed := om.closed
+		om.mu.RUnlock()
+		if closed {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	<-done
+
+	if op, _ := om.Get(running.Id); op.State != Succeeded {
+		t.Errorf("running operation state = %s, want %s", op.State, Succeeded)
+	}
+	if op, _ := om.Get(queued.Id); op.State != Failed {
+		t.Errorf("queued operation state = %s, want %s", op.State, Failed)
+	} else if _, ok := op.Err.(ShuttingDownError); !ok {
+		t.Errorf("queued operation error = %v, want ShuttingDownError", op.Err)
+	}
+	_, err = om.Submit("late", "", "", func(string) error { return nil })
+	if _, ok := err.(ShuttingDownError); !ok {
+		t.Errorf("Submit() after shutdown error = %v, want ShuttingDownError", err)
+	}
+}
+
+func TestGetUnknown(t *testing.T) {
+	om := New(1, zap.NewNop())
+
+	_, err := om.Get("missing")
+	if err != (OperationNotFoundError{"missing"}) {
+		t.Errorf("Get() error = %v, want OperationNotFoundError", err)
+	}
+}
@@ -0,0 +1,142 @@
+package portmanager
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+// HistoryStore persists the serialized port history
+type HistoryStore interface {
+	// ReadPortHistory returns the serialized port history, or an error satisfying
+	// os.IsNotExist if it has never been written
+	ReadPortHistory() ([]byte, error)
+	// WritePortHistory replaces the serialized port history
+	WritePortHistory(data []byte) error
+}
+
+// Assignment is the port, the ports of the port slots and the values of the resources
+// that were last bound to a name
+type Assignment struct {
+	Port      int32             `json:"port"`
+	Ports     map[string]int32  `json:"ports,omitempty"`
+	Resources map[string]string `json:"resources,omitempty"`
+}
+
+// History remembers the last assignment of every name, so that the same ports can be
+// bound to it again. Every change is persisted, so that the history survives restarts.
+type History struct {
+	mu          sync.Mutex
+	l           *zap.Logger
+	s           HistoryStore
+	assignments map[string]Assignment
+}
+
+// NewHistory creates an empty History object on top of the provided store
+func NewHistory(store HistoryStore, logger *zap.Logger) *History {
+	return &History{
+		l:           logger,
+		s:           store,
+		assignments: make(map[string]Assignment),
+	}
+}
+
+// Load reads the assignments persisted in the store
+func (h *History) Load() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	b, err := h.s.ReadPortHistory()
+	if err != nil {
+		if os.IsNotExist(err) {
+			h.l.Debug("did not find port history. starting empty...")
+			return nil
+		}
+		h.l.Error("failed to read port history", zap.Error(err))
+		return err
+	}
+
+	assignments := make(map[string]Assignment)
+	err = json.Unmarshal(b, &assignments)
+	if err != nil {
+		h.l.Error("failed to unmarshal port history json", zap.Error(err))
+		return err
+	}
+
+	h.assignments = assignments
+	h.l.Info("loaded port history", zap.Int("assignments", len(h.assignments)))
+
+	return nil
+}
+
+// Get returns the last assignment of a name, if any
+func (h *History) Get(name string) (Assignment, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	a, ok := h.assignments[name]
+	return a, ok
+}
+
+// Set persists the last assignment of a name
+func (h *History) Set(name string, a Assignment) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	previous, ok := h.assignments[name]
+	if ok && equalAssignments(previous, a) {
+		return nil
+	}
+
+	h.assignments[name] = a
+	err := h.save()
+	if err != nil {
+		if ok {
+			h.assignments[name] = previous
+		} else {
+			delete(h.assignments, name)
+		}
+		return err
+	}
+
+	h.l.Debug("remembered port assignment", zap.String("name", name), zap.Int32("port", a.Port))
+	return nil
+}
+
+// save persists all the assignments to the store
+func (h *History) save() error {
+	b, err := json.MarshalIndent(h.assignments, "", "  ")
+	if err != nil {
+		h.l.Error("failed to marshal port history json", zap.Error(err))
+		return err
+	}
+
+	err = h.s.WritePortHistory(b)
+	if err != nil {
+		h.l.Error("failed to write port history", zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+// equalAssignments reports whether two assignments bind the same ports and values
+func equalAssignments(a, b Assignment) bool {
+	if a.Port != b.Port || len(a.Ports) != len(b.Ports) || len(a.Resources) != len(b.Resources) {
+		return false
+	}
+	for slot, port := range a.Ports {
+		if p, ok := b.Ports[slot]; !ok || p != port {
+			return false
+		}
+	}
+	for resource, value := range a.Resources {
+		if v, ok := b.Resources[resource]; !ok || v != value {
+			return false
+		}
+	}
+
+	return true
+}
@@ -1,100 +1,95 @@
 package portmanager
 
 import (
+	"strconv"
+
+	"github.com/dnsinogeorgos/conductor/internal/allocator"
 	"go.uber.org/zap"
 )
 
-// PortManager contains the state of the port manager and specifies the configured
-// range. It allows binding and releasing a port number to a name (string).
+// Range is an inclusive range of port numbers
+type Range = allocator.Range
+
+// PortManager allocates the main ports of the replicas from an allocator of port numbers
+// that skips the ports bound on the host by another process. It allows binding and
+// releasing a port number to a name (string).
 type PortManager struct {
-	l          *zap.Logger
-	LowerBound int32
-	UpperBound int32
-	PortMap    map[int32]string
+	l *zap.Logger
+	a *allocator.Allocator
 }
 
-// New creates and initializes a PortManager object
-func New(start int32, end int32, logger *zap.Logger) *PortManager {
-	if end < start {
-		logger.Fatal("bad configuration: end port cannot be lower than start port")
+// New creates and initializes a PortManager object. Ports are allocated from the ranges
+// in the order they are provided, skipping the excluded ones and those that the probe
+// finds bound on the host.
+func New(ranges []Range, excluded []Range, probe string, logger *zap.Logger) *PortManager {
+	if !IsProbe(probe) {
+		logger.Fatal("bad configuration: unknown port probe", zap.String("probe", probe))
 	}
 
-	if start == 0 {
-		logger.Fatal("bad configuration: start port cannot be 0")
+	return &PortManager{
+		l: logger,
+		a: allocator.New(allocator.KindPort, ranges, excluded, NewProbe(probe, logger), logger),
 	}
+}
 
-	portMap := make(map[int32]string)
-	pm := &PortManager{
-		l:          logger,
-		LowerBound: start,
-		UpperBound: end,
-		PortMap:    portMap,
-	}
-
-	logger.Info("initialized portmanager with range", zap.Int32("start_port", start), zap.Int32("end_port", end))
-
-	return pm
+// ParseRanges parses port ranges in the form `from-to`, or single ports
+func ParseRanges(values []string) ([]Range, error) {
+	return allocator.ParseRanges(allocator.KindPort, values)
 }
 
-// GetNextAvailable returns the next available port within the configured range
+// GetNextAvailable returns the next available port within the configured ranges. Ports
+// that are bound on the host by another process are skipped.
 func (pm *PortManager) GetNextAvailable() (int32, error) {
-	portList := pm.listPorts()
-	for _, port := range portList {
-		if _, found := pm.PortMap[port]; !found {
-			return port, nil
-		}
+	value, err := pm.a.GetNextAvailable()
+	if err != nil {
+		return 0, err
 	}
 
-	return 0, PortsExhaustedError{}
+	return parsePort(value), nil
 }
 
-// Bind looks up the provided port in a list of available ports and binds it to a name
-// (string)
-func (pm *PortManager) Bind(port int32, name string) error {
-	isValid := false
-
-	portList := pm.listPorts()
-	for _, tryPort := range portList {
-		if port == tryPort {
-			isValid = true
-			break
-		}
-	}
-	if isValid == false {
-		pm.l.Fatal("incompatible configuration: tried to bind port outside of configured range", zap.Int32("port", port))
-	}
+// Contains reports whether a port is within the configured ranges and is not excluded
+func (pm *PortManager) Contains(port int32) bool {
+	return pm.a.Contains(formatPort(port))
+}
 
-	if n, found := pm.PortMap[port]; found {
-		pm.l.Fatal("found inconsistent state: port is currently in use", zap.String("used_by", name), zap.Int32("port", port))
-		return PortInUseError{p: port, n: n}
-	}
+// IsAvailable reports whether a port is within the configured ranges, not excluded, not
+// bound to a name and not bound on the host by another process
+func (pm *PortManager) IsAvailable(port int32) bool {
+	return pm.a.IsAvailable(formatPort(port))
+}
 
-	pm.l.Debug("binding name to port", zap.String("name", name), zap.Int32("port", port))
-	pm.PortMap[port] = name
+// Owner returns the name a port is bound to, if any
+func (pm *PortManager) Owner(port int32) (string, bool) {
+	return pm.a.Owner(formatPort(port))
+}
 
-	return nil
+// Bind looks up the provided port in the configured ranges and binds it to a name
+// (string). The host is not probed, since the port may already be served by the unit of
+// the name it is bound to.
+func (pm *PortManager) Bind(port int32, name string) error {
+	return pm.a.Bind(formatPort(port), name)
 }
 
 // Release looks up the provided port in the port manager state and removes it's entry
 func (pm *PortManager) Release(port int32) error {
-	if _, found := pm.PortMap[port]; !found {
-		pm.l.Fatal("found inconsistent state: port not found in list of used ports", zap.Int32("port", port))
-		return PortNotFoundError{p: port}
-	}
-
-	pm.l.Debug("releasing port", zap.Int32("port", port))
-	delete(pm.PortMap, port)
-	return nil
+	return pm.a.Release(formatPort(port))
 }
 
-// listPorts returns a slice of the port numbers that are configured
-func (pm *PortManager) listPorts() []int32 {
-	length := pm.UpperBound - pm.LowerBound + 1
-	portList := make([]int32, length)
+// Allocator returns the underlying allocator, e.g. to check that other allocators of
+// ports do not overlap with it
+func (pm *PortManager) Allocator() *allocator.Allocator {
+	return pm.a
+}
 
-	for i := range portList {
-		portList[i] = pm.LowerBound + int32(i)
-	}
+// formatPort formats a port number as a value of the allocator
+func formatPort(port int32) string {
+	return strconv.Itoa(int(port))
+}
 
-	return portList
+// parsePort parses a value of the allocator as a port number. The allocator only hands
+// out valid port numbers.
+func parsePort(value string) int32 {
+	port, _ := strconv.ParseInt(value, 10, 32)
+	return int32(port)
 }
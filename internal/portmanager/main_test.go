@@ -0,0 +1,58 @@
+package portmanager
+
+import (
+	"testing"
+
+	"github.com/dnsinogeorgos/conductor/internal/allocator"
+	"go.uber.org/zap"
+)
+
+func TestPortManager(t *testing.T) {
+	pm := New([]Range{{From: 3307, To: 3309}}, []Range{{From: 3308, To: 3308}}, ProbeNone, zap.NewNop())
+
+	tests := []struct {
+		name string
+		run  func() error
+		want string
+	}{
+		{"bind", func() error { return pm.Bind(3307, "a") }, ""},
+		{"bind in use", func() error { return pm.Bind(3307, "b") }, "port 3307 is currently in use by a"},
+		{"bind excluded", func() error { return pm.Bind(3308, "b") }, "port 3308 is outside of the configured ranges or excluded"},
+		{"bind out of range", func() error { return pm.Bind(3310, "b") }, "port 3310 is outside of the configured ranges or excluded"},
+		{"release unbound", func() error { return pm.Release(3309) }, "port 3309 not found in list of used values"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.run()
+			if (err == nil) != (tt.want == "") || err != nil && err.Error() != tt.want {
+				t.Errorf("error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+
+	if name, ok := pm.Owner(3307); !ok || name != "a" {
+		t.Errorf("Owner(3307) = %q, %v, want a", name, ok)
+	}
+
+	port, err := pm.GetNextAvailable()
+	if err != nil || port != 3309 {
+		t.Fatalf("GetNextAvailable() = %d, %v, want 3309", port, err)
+	}
+	err = pm.Bind(port, "b")
+	if err != nil {
+		t.Fatalf("Bind() error = %v", err)
+	}
+	_, err = pm.GetNextAvailable()
+	if _, ok := err.(allocator.ValuesExhaustedError); !ok {
+		t.Errorf("GetNextAvailable() error = %v, want ValuesExhaustedError", err)
+	}
+
+	err = pm.Release(3307)
+	if err != nil {
+		t.Fatalf("Release() error = %v", err)
+	}
+	if !pm.IsAvailable(3307) || pm.IsAvailable(3308) || pm.IsAvailable(3309) {
+		t.Errorf("IsAvailable() = %v %v %v, want true false false", pm.IsAvailable(3307), pm.IsAvailable(3308), pm.IsAvailable(3309))
+	}
+}
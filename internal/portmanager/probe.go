@@ -0,0 +1,119 @@
+package portmanager
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/dnsinogeorgos/conductor/internal/allocator"
+	"go.uber.org/zap"
+)
+
+// Names of the available probes
+const (
+	ProbeListen = "listen"
+	ProbeProc   = "proc"
+	ProbeNone   = "none"
+)
+
+// tcpListen is the state of a listening socket in /proc/net/tcp
+const tcpListen = "0A"
+
+// procNetFiles are the socket tables read by the proc probe
+var procNetFiles = []string{
+	"/proc/net/tcp",
+	"/proc/net/tcp6",
+	"/proc/net/udp",
+	"/proc/net/udp6",
+}
+
+// IsProbe reports whether name is a known probe
+func IsProbe(name string) bool {
+	switch name {
+	case ProbeListen, ProbeProc, ProbeNone:
+		return true
+	default:
+		return false
+	}
+}
+
+// NewProbe returns the probe of an allocator of ports that reports whether a port is
+// bound on the host, or nil for the none probe. The proc probe reads the socket tables
+// once for every lookup, and falls back to the listen probe if they cannot be read.
+func NewProbe(name string, logger *zap.Logger) allocator.Probe {
+	switch name {
+	case ProbeProc:
+		return func() func(uint32) bool {
+			bound, err := readBoundPorts()
+			if err != nil {
+				logger.Warn("failed to read socket tables, falling back to listen probe", zap.Error(err))
+				return listenProbe
+			}
+			return func(port uint32) bool {
+				return bound[port]
+			}
+		}
+	case ProbeListen:
+		return func() func(uint32) bool {
+			return listenProbe
+		}
+	default:
+		return nil
+	}
+}
+
+// listenProbe reports whether a port is bound on the host by trying to listen on it
+// over TCP and UDP on all addresses. A port that cannot be listened on for any reason
+// is considered bound.
+func listenProbe(port uint32) bool {
+	address := ":" + strconv.Itoa(int(port))
+
+	l, err := net.Listen("tcp", address)
+	if err != nil {
+		return true
+	}
+	_ = l.Close()
+
+	c, err := net.ListenPacket("udp", address)
+	if err != nil {
+		return true
+	}
+	_ = c.Close()
+
+	return false
+}
+
+// readBoundPorts returns the local ports of the listening TCP sockets and of all UDP
+// sockets in the socket tables. Missing tables are skipped, e.g. tcp6 when IPv6 is
+// disabled.
+func readBoundPorts() (map[uint32]bool, error) {
+	bound := make(map[uint32]bool)
+	for _, path := range procNetFiles {
+		file, err := ioutil.ReadFile(path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+
+		tcp := strings.Contains(path, "tcp")
+		lines := strings.Split(string(file), "\n")
+		for _, line := range lines[1:] {
+			fields := strings.Fields(line)
+			if len(fields) < 4 || (tcp && fields[3] != tcpListen) {
+				continue
+			}
+			i := strings.LastIndexByte(fields[1], ':')
+			port, err := strconv.ParseUint(fields[1][i+1:], 16, 16)
+			if err != nil {
+				continue
+			}
+			bound[uint32(port)] = true
+		}
+	}
+
+	return bound, nil
+}
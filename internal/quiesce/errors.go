@@ -0,0 +1,40 @@
+package quiesce
+
+import "fmt"
+
+type UnknownStrategyError struct {
+	s string
+}
+
+func (e UnknownStrategyError) Error() string {
+	return fmt.Sprintf("unknown quiesce strategy %s", e.s)
+}
+
+type MissingCommandError struct {
+	s string
+}
+
+func (e MissingCommandError) Error() string {
+	return fmt.Sprintf("quiesce strategy %s requires a quiesce command", e.s)
+}
+
+type CommandError struct {
+	c   string
+	err error
+}
+
+func (e CommandError) Error() string {
+	return fmt.Sprintf("command %q failed: %s", e.c, e.err)
+}
+
+func (e CommandError) Unwrap() error {
+	return e.err
+}
+
+type TimeoutError struct {
+	c string
+}
+
+func (e TimeoutError) Error() string {
+	return fmt.Sprintf("command %q timed out", e.c)
+}
@@ -0,0 +1,124 @@
+package quiesce
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"os/exec"
+	"sync"
+	"syscall"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// execStrategy runs a pair of commands through the shell. The quiesce command is
+// considered done either when it exits successfully, or when it prints its first line
+// while it keeps running. In the latter case it is held open until Resume closes its
+// standard input, which allows it to keep a session such as one holding
+// FLUSH TABLES WITH READ LOCK.
+type execStrategy struct {
+	mu      sync.Mutex
+	l       *zap.Logger
+	quiesce string
+	resume  string
+	timeout time.Duration
+	held    *exec.Cmd
+	stdin   io.WriteCloser
+	exited  chan error
+}
+
+func (s *execStrategy) Name() string {
+	return StrategyExec
+}
+
+func (s *execStrategy) Quiesce() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cmd := exec.Command("/bin/sh", "-c", s.quiesce)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+
+	s.l.Debug("running quiesce command", zap.String("command", s.quiesce))
+	err = cmd.Start()
+	if err != nil {
+		return CommandError{c: s.quiesce, err: err}
+	}
+
+	ready := make(chan struct{})
+	exited := make(chan error, 1)
+	go func() {
+		scanner := bufio.NewScanner(stdout)
+		if scanner.Scan() {
+			close(ready)
+		}
+		// keep draining so that the command never blocks on a full pipe
+		_, _ = io.Copy(io.Discard, stdout)
+		exited <- cmd.Wait()
+	}()
+
+	select {
+	case <-ready:
+		s.l.Debug("quiesce command is holding", zap.String("command", s.quiesce))
+		s.held, s.stdin, s.exited = cmd, stdin, exited
+		return nil
+	case err := <-exited:
+		if err != nil {
+			return CommandError{c: s.quiesce, err: err}
+		}
+		return nil
+	case <-time.After(s.timeout):
+		kill(cmd)
+		<-exited
+		return TimeoutError{s.quiesce}
+	}
+}
+
+func (s *execStrategy) Resume() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var first error
+	if s.resume != "" {
+		s.l.Debug("running resume command", zap.String("command", s.resume))
+		ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+		defer cancel()
+		err := exec.CommandContext(ctx, "/bin/sh", "-c", s.resume).Run()
+		if err != nil {
+			first = CommandError{c: s.resume, err: err}
+		}
+	}
+
+	if s.held != nil {
+		s.l.Debug("releasing quiesce command", zap.String("command", s.quiesce))
+		_ = s.stdin.Close()
+		select {
+		case err := <-s.exited:
+			if err != nil && first == nil {
+				first = CommandError{c: s.quiesce, err: err}
+			}
+		case <-time.After(s.timeout):
+			kill(s.held)
+			<-s.exited
+			if first == nil {
+				first = TimeoutError{s.quiesce}
+			}
+		}
+		s.held, s.stdin, s.exited = nil, nil, nil
+	}
+
+	return first
+}
+
+// kill terminates a command along with the processes it spawned
+func kill(cmd *exec.Cmd) {
+	_ = syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
@@ -0,0 +1,90 @@
+package quiesce
+
+import (
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Names of the available strategies
+const (
+	StrategyUnit = "unit"
+	StrategyExec = "exec"
+	StrategyNone = "none"
+)
+
+// Strategy brings the source to a consistent state before it is snapshotted and resumes
+// it afterwards. Resume must be safe to call without a preceding Quiesce, since it is
+// also used to recover after a crash.
+type Strategy interface {
+	// Name returns the name of the strategy
+	Name() string
+	// Quiesce prepares the source for a snapshot
+	Quiesce() error
+	// Resume undoes Quiesce
+	Resume() error
+}
+
+// Options contains the settings of the strategies
+type Options struct {
+	StopUnit       func() error
+	StartUnit      func() error
+	QuiesceCommand string
+	ResumeCommand  string
+	Timeout        time.Duration
+}
+
+// New returns the strategy registered under the provided name
+func New(name string, opts Options, logger *zap.Logger) (Strategy, error) {
+	switch name {
+	case "", StrategyUnit:
+		return &unitStrategy{stop: opts.StopUnit, start: opts.StartUnit}, nil
+	case StrategyExec:
+		if opts.QuiesceCommand == "" {
+			return nil, MissingCommandError{name}
+		}
+		return &execStrategy{
+			l:       logger,
+			quiesce: opts.QuiesceCommand,
+			resume:  opts.ResumeCommand,
+			timeout: opts.Timeout,
+		}, nil
+	case StrategyNone:
+		return noneStrategy{}, nil
+	default:
+		return nil, UnknownStrategyError{name}
+	}
+}
+
+// unitStrategy stops the main unit during the snapshot
+type unitStrategy struct {
+	stop  func() error
+	start func() error
+}
+
+func (s *unitStrategy) Name() string {
+	return StrategyUnit
+}
+
+func (s *unitStrategy) Quiesce() error {
+	return s.stop()
+}
+
+func (s *unitStrategy) Resume() error {
+	return s.start()
+}
+
+// noneStrategy takes crash consistent snapshots of the running source
+type noneStrategy struct{}
+
+func (noneStrategy) Name() string {
+	return StrategyNone
+}
+
+func (noneStrategy) Quiesce() error {
+	return nil
+}
+
+func (noneStrategy) Resume() error {
+	return nil
+}
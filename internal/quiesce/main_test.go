@@ -0,0 +1,74 @@
+package quiesce
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     Options
+		wantName string
+		wantErr  error
+	}{
+		{"", Options{}, StrategyUnit, nil},
+		{StrategyUnit, Options{}, StrategyUnit, nil},
+		{StrategyExec, Options{QuiesceCommand: "true"}, StrategyExec, nil},
+		{StrategyExec, Options{}, "", MissingCommandError{StrategyExec}},
+		{StrategyNone, Options{}, StrategyNone, nil},
+		{"fsfreeze", Options{}, "", UnknownStrategyError{"fsfreeze"}},
+	}
+
+	for _, tt := range tests {
+		s, err := New(tt.name, tt.opts, zap.NewNop())
+		if err != tt.wantErr {
+			t.Errorf("New(%q) error = %v, want %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if err == nil && s.Name() != tt.wantName {
+			t.Errorf("New(%q).Name() = %s, want %s", tt.name, s.Name(), tt.wantName)
+		}
+	}
+}
+
+func TestExecStrategy(t *testing.T) {
+	tests := []struct {
+		name           string
+		quiesce        string
+		resume         string
+		wantQuiesceErr error
+		wantResumeErr  error
+	}{
+		{"exits", "true", "true", nil, nil},
+		{"holds until resumed", "echo locked; cat", "", nil, nil},
+		{"fails", "exit 3", "", CommandError{}, nil},
+		{"times out", "sleep 5", "", TimeoutError{}, nil},
+		{"resume fails", "true", "exit 3", nil, CommandError{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := New(StrategyExec, Options{
+				QuiesceCommand: tt.quiesce,
+				ResumeCommand:  tt.resume,
+				Timeout:        200 * time.Millisecond,
+			}, zap.NewNop())
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+
+			err = s.Quiesce()
+			if reflect.TypeOf(err) != reflect.TypeOf(tt.wantQuiesceErr) {
+				t.Errorf("Quiesce() error = %v, want %T", err, tt.wantQuiesceErr)
+			}
+			err = s.Resume()
+			if reflect.TypeOf(err) != reflect.TypeOf(tt.wantResumeErr) {
+				t.Errorf("Resume() error = %v, want %T", err, tt.wantResumeErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package rollback
+
+import (
+	"go.uber.org/zap"
+)
+
+// action is an undo action registered by an orchestration step
+type action struct {
+	name string
+	fn   func() error
+}
+
+// Rollback stores the undo actions of an orchestration. Every step that succeeds
+// registers the action that reverts it, and if a later step fails the actions are run
+// in reverse order of registration.
+type Rollback struct {
+	l       *zap.Logger
+	actions []action
+}
+
+// New creates an empty Rollback object
+func New(logger *zap.Logger) *Rollback {
+	return &Rollback{
+		l:       logger,
+		actions: make([]action, 0),
+	}
+}
+
+// Add registers an undo action
+func (rb *Rollback) Add(name string, fn func() error) {
+	rb.actions = append(rb.actions, action{name: name, fn: fn})
+}
+
+// Discard drops the registered undo actions, once the orchestration has passed the
+// point where reverting it is possible
+func (rb *Rollback) Discard() {
+	rb.actions = rb.actions[:0]
+}
+
+// Run executes the registered undo actions in reverse order. Failing actions are logged
+// and do not stop the rest from running. It returns the first error encountered.
+func (rb *Rollback) Run() error {
+	var first error
+
+	for i := len(rb.actions) - 1; i >= 0; i-- {
+		a := rb.actions[i]
+		rb.l.Info("rolling back", zap.String("action", a.name))
+		err := a.fn()
+		if err != nil {
+			rb.l.Error("failed to roll back", zap.String("action", a.name), zap.Error(err))
+			if first == nil {
+				first = err
+			}
+		}
+	}
+	rb.actions = rb.actions[:0]
+
+	return first
+}
@@ -12,6 +12,12 @@ type serviceConfig struct {
 	Name    string
 	Datadir string
 	Port    int32
+	// Ports maps the configured port slots to their ports
+	Ports map[string]int32
+	// Resources maps the configured resources to their values
+	Resources map[string]string
+	// Socket is the rendered socket path, if a socket path template is configured
+	Socket string
 }
 
 // getServiceConfigPath returns the path of for the service configuration according to the
@@ -29,11 +35,19 @@ func (um *UnitManager) getServiceConfigPath(cfg *serviceConfig) (string, error)
 }
 
 // createServiceConfig creates the rendered service configuration file according to configuration
-func (um *UnitManager) createServiceConfig(name, datadir string, port int32) error {
+func (um *UnitManager) createServiceConfig(name, datadir string, port int32, ports map[string]int32, resources map[string]string) error {
+	socket, err := um.GetSocketPath(name)
+	if err != nil {
+		return err
+	}
+
 	cfg := &serviceConfig{
-		Name:    name,
-		Datadir: datadir,
-		Port:    port,
+		Name:      name,
+		Datadir:   datadir,
+		Port:      port,
+		Ports:     ports,
+		Resources: resources,
+		Socket:    socket,
 	}
 
 	cfgPath, err := um.getServiceConfigPath(cfg)
@@ -75,6 +89,10 @@ func (um *UnitManager) deleteServiceConfig(name string) error {
 
 	err = os.Remove(cfgPath)
 	if err != nil {
+		if os.IsNotExist(err) {
+			um.l.Debug("did not find cfg file on disk. skipping...", zap.String("path", cfgPath))
+			return nil
+		}
 		um.l.Error("could not cleanup cfg file from disk", zap.Error(err))
 		return err
 	}
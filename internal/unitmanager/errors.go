@@ -0,0 +1,25 @@
+package unitmanager
+
+import "fmt"
+
+type UnitJobError struct {
+	u string
+	r string
+}
+
+func (e UnitJobError) Error() string {
+	return fmt.Sprintf("systemd job for unit %s finished with result %s", e.u, e.r)
+}
+
+type SystemdError struct {
+	u   string
+	err error
+}
+
+func (e SystemdError) Error() string {
+	return fmt.Sprintf("systemd request for unit %s failed: %s", e.u, e.err)
+}
+
+func (e SystemdError) Unwrap() error {
+	return e.err
+}
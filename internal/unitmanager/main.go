@@ -18,11 +18,13 @@ type UnitManager struct {
 	configFileTemplate *template.Template
 	unitNameTemplate   *template.Template
 	configPathTemplate *template.Template
+	socketPathTemplate *template.Template
 	conn               *dbus.Conn
 }
 
-// New creates a UnitManager object
-func New(mu, ctp, uts, cpts string, logger *zap.Logger) *UnitManager {
+// New creates a UnitManager object. Socket endpoints are disabled if the socket path
+// template string is empty.
+func New(mu, ctp, uts, cpts, spts string, logger *zap.Logger) *UnitManager {
 	conn, err := dbus.NewSystemdConnectionContext(context.TODO())
 	if err != nil {
 		logger.Fatal("could not connect to systemd", zap.Error(err))
@@ -47,12 +49,22 @@ func New(mu, ctp, uts, cpts string, logger *zap.Logger) *UnitManager {
 		return &UnitManager{}
 	}
 
+	var socketPathTemplate *template.Template
+	if spts != "" {
+		socketPathTemplate, err = template.New("cfg").Parse(spts)
+		if err != nil {
+			logger.Fatal("could not load socket path template", zap.Error(err))
+			return &UnitManager{}
+		}
+	}
+
 	unitmanager := &UnitManager{
 		l:                  logger,
 		mainUnit:           mu,
 		configFileTemplate: configFileTemplate,
 		unitNameTemplate:   unitNameTemplate,
 		configPathTemplate: configPathTemplate,
+		socketPathTemplate: socketPathTemplate,
 		conn:               conn,
 	}
 
@@ -61,55 +73,49 @@ func New(mu, ctp, uts, cpts string, logger *zap.Logger) *UnitManager {
 
 // StartMainUnit starts the configured main unit and returns error if unsuccessful
 func (um *UnitManager) StartMainUnit() error {
-	ctx := context.TODO()
-	ch := make(chan string)
-
-	jid, err := um.conn.StartUnitContext(ctx, um.mainUnit, "fail", ch)
+	err := um.startUnit(um.mainUnit)
 	if err != nil {
 		um.l.Error("failed to start main unit", zap.Error(err))
+		return err
 	}
 
-	um.l.Debug("systemd start main unit", zap.Int("job_id", jid), zap.String("value", <-ch))
-
 	return nil
 }
 
 // StopMainUnit stops the configured main unit and returns error if unsuccessful
 func (um *UnitManager) StopMainUnit() error {
-	ctx := context.TODO()
-	ch := make(chan string)
-
-	jid, err := um.conn.StopUnitContext(ctx, um.mainUnit, "fail", ch)
+	err := um.stopUnit(um.mainUnit)
 	if err != nil {
 		um.l.Error("failed to stop main unit", zap.Error(err))
+		return err
 	}
 
-	um.l.Debug("systemd stop main unit", zap.Int("job_id", jid), zap.String("value", <-ch))
-
 	return nil
 }
 
 // StartTemplateUnit creates the related configuration file and starts the systemd template unit
-// as configured
-func (um *UnitManager) StartTemplateUnit(name, datadir string, port int32) error {
-	ctx := context.TODO()
-	ch := make(chan string)
-
-	err := um.createServiceConfig(name, datadir, port)
+// as configured. The ports of the port slots and the resources are rendered along with
+// the port. The configuration file is removed if the unit fails to start.
+func (um *UnitManager) StartTemplateUnit(name, datadir string, port int32, ports map[string]int32, resources map[string]string) error {
+	unitName, err := um.getTemplateUnitName(name)
 	if err != nil {
 		return err
 	}
 
-	unitName, err := um.getTemplateUnitName(name)
+	err = um.createServiceConfig(name, datadir, port, ports, resources)
 	if err != nil {
 		return err
 	}
 
-	jid, err := um.conn.StartUnitContext(ctx, unitName, "fail", ch)
+	err = um.startUnit(unitName)
 	if err != nil {
 		um.l.Error("failed to start unit", zap.String("name", name), zap.Error(err))
+		cfgErr := um.deleteServiceConfig(name)
+		if cfgErr != nil {
+			um.l.Error("failed to clean up cfg file of unit", zap.String("name", name), zap.Error(cfgErr))
+		}
+		return err
 	}
-	um.l.Debug("systemd start unit", zap.String("unit", name), zap.Int("job_id", jid), zap.String("response", <-ch))
 
 	return nil
 }
@@ -117,19 +123,16 @@ func (um *UnitManager) StartTemplateUnit(name, datadir string, port int32) error
 // StopTemplateUnit deletes the related configuration file and stops the systemd template unit
 // unit as configured
 func (um *UnitManager) StopTemplateUnit(name string) error {
-	ctx := context.TODO()
-	ch := make(chan string)
-
 	unitName, err := um.getTemplateUnitName(name)
 	if err != nil {
 		return err
 	}
 
-	jid, err := um.conn.StopUnitContext(ctx, unitName, "fail", ch)
+	err = um.stopUnit(unitName)
 	if err != nil {
 		um.l.Error("failed to stop unit", zap.String("name", name), zap.Error(err))
+		return err
 	}
-	um.l.Debug("systemd stop unit", zap.String("unit", name), zap.Int("job_id", jid), zap.String("response", <-ch))
 
 	err = um.deleteServiceConfig(name)
 	if err != nil {
@@ -139,6 +142,67 @@ func (um *UnitManager) StopTemplateUnit(name string) error {
 	return nil
 }
 
+// startUnit starts a systemd unit and waits for the job to complete
+func (um *UnitManager) startUnit(unitName string) error {
+	ctx := context.TODO()
+	ch := make(chan string)
+
+	jid, err := um.conn.StartUnitContext(ctx, unitName, "fail", ch)
+	if err != nil {
+		return SystemdError{u: unitName, err: err}
+	}
+
+	result := <-ch
+	um.l.Debug("systemd start unit", zap.String("unit", unitName), zap.Int("job_id", jid), zap.String("response", result))
+	if result != "done" {
+		return UnitJobError{u: unitName, r: result}
+	}
+
+	return nil
+}
+
+// stopUnit stops a systemd unit and waits for the job to complete
+func (um *UnitManager) stopUnit(unitName string) error {
+	ctx := context.TODO()
+	ch := make(chan string)
+
+	jid, err := um.conn.StopUnitContext(ctx, unitName, "fail", ch)
+	if err != nil {
+		return SystemdError{u: unitName, err: err}
+	}
+
+	result := <-ch
+	um.l.Debug("systemd stop unit", zap.String("unit", unitName), zap.Int("job_id", jid), zap.String("response", result))
+	if result != "done" {
+		return UnitJobError{u: unitName, r: result}
+	}
+
+	return nil
+}
+
+// HasSockets reports whether a socket path template is configured
+func (um *UnitManager) HasSockets() bool {
+	return um.socketPathTemplate != nil
+}
+
+// GetSocketPath renders the path of the socket of a template unit, or returns an empty
+// string if no socket path template is configured
+func (um *UnitManager) GetSocketPath(name string) (string, error) {
+	if um.socketPathTemplate == nil {
+		return "", nil
+	}
+
+	var socketPathBuffer bytes.Buffer
+
+	err := um.socketPathTemplate.Execute(&socketPathBuffer, &struct{ Name string }{Name: name})
+	if err != nil {
+		um.l.Error("could not render socket path", zap.Error(err))
+		return "", err
+	}
+
+	return socketPathBuffer.String(), nil
+}
+
 // getTemplateUnitName generates the full systemd template unit name according to configuration
 func (um *UnitManager) getTemplateUnitName(name string) (string, error) {
 	var unitNameBuffer bytes.Buffer
@@ -0,0 +1,93 @@
+package unitmanager
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"go.uber.org/zap"
+)
+
+// nameMarker is rendered in place of the name to locate it inside the configured templates
+const nameMarker = "\x00"
+
+// ListTemplateUnitNames returns the names of the template units that are loaded and not
+// inactive, as they were passed to StartTemplateUnit
+func (um *UnitManager) ListTemplateUnitNames() ([]string, error) {
+	pattern, err := um.getTemplateUnitName("*")
+	if err != nil {
+		return nil, err
+	}
+
+	units, err := um.conn.ListUnitsByPatternsContext(context.TODO(), []string{"active", "activating", "deactivating", "reloading", "failed"}, []string{pattern})
+	if err != nil {
+		um.l.Error("failed to list units", zap.String("pattern", pattern), zap.Error(err))
+		return nil, SystemdError{u: pattern, err: err}
+	}
+
+	names := make([]string, 0, len(units))
+	for _, unit := range units {
+		name, ok := um.extractName(um.unitNameTemplate, unit.Name)
+		if ok {
+			names = append(names, name)
+		}
+	}
+
+	return names, nil
+}
+
+// ListServiceConfigNames returns the names of the rendered service configuration files
+// found on disk
+func (um *UnitManager) ListServiceConfigNames() ([]string, error) {
+	pattern, err := um.getServiceConfigPath(&serviceConfig{Name: "*"})
+	if err != nil {
+		return nil, err
+	}
+
+	paths, err := filepath.Glob(pattern)
+	if err != nil {
+		um.l.Error("failed to list cfg files", zap.String("pattern", pattern), zap.Error(err))
+		return nil, err
+	}
+
+	names := make([]string, 0, len(paths))
+	for _, p := range paths {
+		name, ok := um.extractName(um.configPathTemplate, p)
+		if ok {
+			names = append(names, name)
+		}
+	}
+
+	return names, nil
+}
+
+// DeleteServiceConfig removes the rendered service configuration file of a name
+func (um *UnitManager) DeleteServiceConfig(name string) error {
+	return um.deleteServiceConfig(name)
+}
+
+// extractName reverses a template rendered with a name, returning the name
+func (um *UnitManager) extractName(t *template.Template, rendered string) (string, bool) {
+	var buffer bytes.Buffer
+
+	err := t.Execute(&buffer, &serviceConfig{Name: nameMarker})
+	if err != nil {
+		return "", false
+	}
+
+	parts := strings.SplitN(buffer.String(), nameMarker, 2)
+	if len(parts) != 2 {
+		return "", false
+	}
+
+	if !strings.HasPrefix(rendered, parts[0]) || !strings.HasSuffix(rendered, parts[1]) {
+		return "", false
+	}
+	if len(rendered) <= len(parts[0])+len(parts[1]) {
+		return "", false
+	}
+
+	return rendered[len(parts[0]) : len(rendered)-len(parts[1])], true
+}
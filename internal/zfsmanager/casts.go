@@ -2,29 +2,53 @@ package zfsmanager
 
 import (
 	"encoding/json"
-	"io/ioutil"
 	"os"
-	"strings"
 	"time"
 
-	"github.com/mistifyio/go-zfs"
+	"github.com/dnsinogeorgos/conductor/internal/rollback"
 	"go.uber.org/zap"
 )
 
 const castStateFile = ".cast"
 
+// castSnapshotPrefix prefixes the snapshots taken on a replica to create a cast from it,
+// to tell them apart from its checkpoints
+const castSnapshotPrefix = "cast:"
+
 // CastState describes the cast state stored on the dataset
 type CastState struct {
-	Id        string    `json:"id"`
-	Timestamp time.Time `json:"timestamp"`
+	Id              string        `json:"id"`
+	Timestamp       time.Time     `json:"timestamp"`
+	Quiesce         string        `json:"quiesce,omitempty"`
+	QuiesceDuration time.Duration `json:"quiesceDuration,omitempty"`
+	Source          *CastSource   `json:"source,omitempty"`
+	ExpiresAt       *time.Time    `json:"expiresAt,omitempty"`
+	Protected       bool          `json:"protected,omitempty"`
+	// Properties are the ZFS properties the dataset is cloned with besides its mountpoint
+	Properties map[string]string `json:"properties,omitempty"`
+}
+
+// CastSource describes the replica, and optionally its checkpoint, that a cast was
+// created from
+type CastSource struct {
+	CastId     string `json:"castId"`
+	ReplicaId  string `json:"replicaId"`
+	Checkpoint string `json:"checkpoint,omitempty"`
 }
 
 // cast contains the state of a cast and it's child relationships
 type cast struct {
-	id        string
-	ds        *zfs.Dataset
-	replicas  map[string]*replica
-	timestamp time.Time
+	id              string
+	ds              *Dataset
+	replicas        map[string]*replica
+	trash           map[string]*replica
+	timestamp       time.Time
+	quiesce         string
+	quiesceDuration time.Duration
+	source          *CastSource
+	expiresAt       time.Time
+	protected       bool
+	properties      map[string]string
 }
 
 // GetCastMountPoint returns the mount point path of the cast
@@ -61,67 +85,287 @@ func (zm *ZFSManager) GetCastTimestamp(id string) (time.Time, error) {
 	return cast.timestamp, nil
 }
 
-// CreateCastDataset orchestrates the creation of a cast dataset onto the underlying
-// ZFS filesystem
-func (zm *ZFSManager) CreateCastDataset(id string, preHook func() error, postHook func() error) (time.Time, error) {
+// GetCastState returns the state of a cast
+func (zm *ZFSManager) GetCastState(id string) (CastState, error) {
+	zm.mu.Lock()
+	defer zm.mu.Unlock()
+
+	name := zm.getCastFullName(id)
+
+	if _, ok := zm.casts[name]; !ok {
+		zm.l.Error("cannot get cast state, not found", zap.String("cast", id))
+		return CastState{}, CastNotFoundError{id}
+	}
+
+	return zm.casts[name].state(), nil
+}
+
+// SetCastExpiry records the time after which an empty cast is reaped in its state. The
+// zero time removes the expiry.
+func (zm *ZFSManager) SetCastExpiry(id string, expiresAt time.Time) error {
+	zm.mu.Lock()
+	defer zm.mu.Unlock()
+
+	name := zm.getCastFullName(id)
+
+	if _, ok := zm.casts[name]; !ok {
+		zm.l.Error("cannot set cast expiry, not found", zap.String("cast", id))
+		return CastNotFoundError{id}
+	}
+	cast := zm.casts[name]
+
+	previous := cast.expiresAt
+	cast.expiresAt = expiresAt
+	err := zm.saveCastState(cast)
+	if err != nil {
+		cast.expiresAt = previous
+		return err
+	}
+
+	return nil
+}
+
+// SetCastProtection records in the state of a cast whether it is protected from deletion
+func (zm *ZFSManager) SetCastProtection(id string, protected bool) error {
 	zm.mu.Lock()
 	defer zm.mu.Unlock()
 
 	name := zm.getCastFullName(id)
 
+	if _, ok := zm.casts[name]; !ok {
+		zm.l.Error("cannot set cast protection, not found", zap.String("cast", id))
+		return CastNotFoundError{id}
+	}
+	cast := zm.casts[name]
+
+	previous := cast.protected
+	cast.protected = protected
+	err := zm.saveCastState(cast)
+	if err != nil {
+		cast.protected = previous
+		return err
+	}
+
+	return nil
+}
+
+// CastHooks are the functions run around the snapshot of a cast
+type CastHooks struct {
+	// Quiesce prepares the source for the snapshot
+	Quiesce func() error
+	// Resume undoes Quiesce and always runs after the snapshot is attempted
+	Resume func() error
+	// Snapshotted runs after a successful snapshot and Resume, before the clone. It is
+	// optional.
+	Snapshotted func() error
+}
+
+// CreateCastDataset orchestrates the creation of a cast dataset onto the underlying
+// ZFS filesystem. The time spent between the Quiesce and Resume hooks is recorded on the
+// cast along with the name of the quiesce strategy, and the cast expires at the provided
+// time unless it is zero. The dataset is cloned with the provided properties. The hooks
+// run without the lock, which is only taken for the clone. Every completed step is
+// reverted if a later one fails.
+func (zm *ZFSManager) CreateCastDataset(id string, strategy string, hooks CastHooks, expiresAt time.Time, properties map[string]string) (state CastState, err error) {
+	name := zm.getCastFullName(id)
+
+	zm.mu.Lock()
+	_, ok := zm.casts[name]
+	zm.mu.Unlock()
+	if ok {
+		zm.l.Error("cannot create cast, already exists", zap.String("cast", id))
+		return CastState{}, CastAlreadyExistsError{id}
+	}
+
+	rb := rollback.New(zm.l)
+	defer func() {
+		if err != nil {
+			rb.Run()
+		}
+	}()
+
+	snapshot, timestamp, duration, err := zm.snapshotSource(id, id, strategy, hooks, rb)
+	if err != nil {
+		return CastState{}, err
+	}
+
+	zm.mu.Lock()
+	defer zm.mu.Unlock()
+
 	if _, ok := zm.casts[name]; ok {
 		zm.l.Error("cannot create cast, already exists", zap.String("cast", id))
-		return time.Time{}, CastAlreadyExistsError{id}
+		return CastState{}, CastAlreadyExistsError{id}
+	}
+
+	cast := &cast{
+		id:              id,
+		replicas:        make(map[string]*replica),
+		timestamp:       timestamp,
+		quiesce:         strategy,
+		quiesceDuration: duration,
+		expiresAt:       expiresAt,
+		properties:      copyProperties(properties),
+	}
+	err = zm.cloneCast(cast, snapshot.Name, name, zm.GetCastMountPoint(id), rb)
+	if err != nil {
+		return CastState{}, err
 	}
 
-	err := preHook()
+	zm.l.Debug("creating cast", zap.String("cast", id))
+	zm.casts[name] = cast
+
+	return cast.state(), nil
+}
+
+// snapshotSource quiesces the source with the provided hooks, takes the named snapshot
+// of the filesystem and resumes the source. It returns the snapshot along with the time
+// it was taken and the time the source spent quiesced. The undo actions are registered
+// on the provided rollback. The caller must not hold the lock, since the hooks may take
+// as long as their timeouts.
+func (zm *ZFSManager) snapshotSource(id, name, strategy string, hooks CastHooks, rb *rollback.Rollback) (*Dataset, time.Time, time.Duration, error) {
+	quiesced := time.Now()
+	err := hooks.Quiesce()
 	if err != nil {
-		return time.Time{}, err
+		zm.l.Error("failed to quiesce source of cast", zap.String("cast", id), zap.Error(err))
+		resumeErr := hooks.Resume()
+		if resumeErr != nil {
+			zm.l.Error("failed to resume source of cast", zap.String("cast", id), zap.Error(resumeErr))
+		}
+		return nil, time.Time{}, 0, err
 	}
 
 	zm.l.Debug("snapshotting cast", zap.String("cast", id))
 	timestamp := time.Now().UTC()
-	snapshot, err := zm.fs.Snapshot(id, false)
-	if err != nil {
-		zm.l.Fatal("failed to snapshot cast", zap.String("cast", id), zap.Error(err))
-		return time.Time{}, err
+	snapshot, snapErr := zm.d.Snapshot(zm.fs.Name, name)
+	if snapErr != nil {
+		snapErr = newDatasetError("snapshot", zm.fs.Name, snapErr)
+	} else {
+		rb.Add("destroy snapshot of cast", func() error {
+			return zm.d.Destroy(snapshot.Name)
+		})
 	}
 
-	err = postHook()
+	err = hooks.Resume()
+	duration := time.Since(quiesced)
+	if snapErr != nil {
+		zm.l.Error("failed to snapshot cast", zap.String("cast", id), zap.Error(snapErr))
+		if err != nil {
+			zm.l.Error("failed to resume source of cast", zap.String("cast", id), zap.Error(err))
+		}
+		return nil, time.Time{}, 0, snapErr
+	}
 	if err != nil {
-		return time.Time{}, err
+		zm.l.Error("failed to resume source of cast", zap.String("cast", id), zap.Error(err))
+		return nil, time.Time{}, 0, err
 	}
+	zm.l.Info("source was quiesced", zap.String("cast", id), zap.String("strategy", strategy), zap.Duration("duration", duration))
+
+	if hooks.Snapshotted != nil {
+		err = hooks.Snapshotted()
+		if err != nil {
+			return nil, time.Time{}, 0, err
+		}
+	}
+
+	return snapshot, timestamp, duration, nil
+}
+
+// CreateCastDatasetFromReplica orchestrates the creation of a cast dataset from a
+// replica. The cast is cloned from the provided checkpoint of the replica, or from a new
+// snapshot of it if no checkpoint is provided. The cast expires at the provided time
+// unless it is zero and its dataset is cloned with the provided properties. Every
+// completed step is reverted if a later one fails.
+func (zm *ZFSManager) CreateCastDatasetFromReplica(id, castId, replicaId, checkpoint string, expiresAt time.Time, properties map[string]string) (state CastState, err error) {
+	zm.mu.Lock()
+	defer zm.mu.Unlock()
+
+	name := zm.getCastFullName(id)
 
-	zm.l.Debug("cloning snapshot for cast", zap.String("cast", id))
-	mountPoint := zm.castPath + "/" + id
-	p := map[string]string{
-		"mountpoint": mountPoint,
+	if _, ok := zm.casts[name]; ok {
+		zm.l.Error("cannot create cast, already exists", zap.String("cast", id))
+		return CastState{}, CastAlreadyExistsError{id}
 	}
-	dsName := zm.fs.Name + "/" + id
-	dataset, err := snapshot.Clone(dsName, p)
+
+	source, err := zm.lookupReplica(castId, replicaId)
 	if err != nil {
-		zm.l.Fatal("failed to clone snapshot", zap.String("cast", id), zap.Error(err))
-		return time.Time{}, err
+		return CastState{}, err
+	}
+
+	rb := rollback.New(zm.l)
+	defer func() {
+		if err != nil {
+			rb.Run()
+		}
+	}()
+
+	timestamp := time.Now().UTC()
+	snapshot := source.ds.Name + "@" + checkpoint
+	if checkpoint != "" {
+		i := source.findCheckpoint(checkpoint)
+		if i == -1 {
+			zm.l.Error("cannot create cast, checkpoint not found", zap.String("cast", id), zap.String("checkpoint", checkpoint))
+			return CastState{}, CheckpointNotFoundError{castId, replicaId, checkpoint}
+		}
+		if !source.checkpoints[i].Timestamp.IsZero() {
+			timestamp = source.checkpoints[i].Timestamp
+		}
+	} else {
+		zm.l.Debug("snapshotting replica for cast", zap.String("cast", id), zap.String("replica", source.ds.Name))
+		ds, err := zm.d.Snapshot(source.ds.Name, castSnapshotPrefix+id)
+		if err != nil {
+			zm.l.Error("failed to snapshot replica for cast", zap.String("cast", id), zap.Error(err))
+			return CastState{}, newDatasetError("snapshot", source.ds.Name, err)
+		}
+		rb.Add("destroy snapshot of cast", func() error {
+			return zm.d.Destroy(ds.Name)
+		})
+		snapshot = ds.Name
 	}
 
-	zm.l.Debug("preparing cast", zap.String("cast", id))
-	replicas := make(map[string]*replica)
 	cast := &cast{
-		ds:        dataset,
 		id:        id,
-		replicas:  replicas,
+		replicas:  make(map[string]*replica),
 		timestamp: timestamp,
+		source: &CastSource{
+			CastId:     castId,
+			ReplicaId:  replicaId,
+			Checkpoint: checkpoint,
+		},
+		expiresAt:  expiresAt,
+		properties: copyProperties(properties),
 	}
-
-	err = zm.saveCastState(cast)
+	err = zm.cloneCast(cast, snapshot, name, zm.GetCastMountPoint(id), rb)
 	if err != nil {
-		return time.Time{}, err
+		return CastState{}, err
 	}
 
 	zm.l.Debug("creating cast", zap.String("cast", id))
 	zm.casts[name] = cast
 
-	return timestamp, nil
+	return cast.state(), nil
+}
+
+// cloneCast clones a snapshot into the dataset of a cast with the properties of the cast
+// and saves its state. The undo actions are registered on the provided rollback.
+func (zm *ZFSManager) cloneCast(cast *cast, snapshot, name, mountPoint string, rb *rollback.Rollback) error {
+	zm.l.Debug("cloning snapshot for cast", zap.String("cast", cast.id), zap.Strings("properties", propertyFields(cast.properties)))
+	dataset, err := zm.d.Clone(snapshot, name, cloneProperties(mountPoint, cast.properties))
+	if err != nil {
+		zm.l.Error("failed to clone snapshot", zap.String("cast", cast.id), zap.Error(err))
+		return newDatasetError("clone", snapshot, err)
+	}
+	rb.Add("destroy cast dataset", func() error {
+		err := zm.d.Destroy(dataset.Name)
+		if err != nil {
+			return err
+		}
+		return zm.d.RemoveMountPoint(dataset.Mountpoint)
+	})
+
+	zm.l.Debug("preparing cast", zap.String("cast", cast.id))
+	cast.ds = dataset
+
+	return zm.saveCastState(cast)
 }
 
 // DeleteCastDataset orchestrates the deletion of a cast dataset from the underlying
@@ -137,50 +381,56 @@ func (zm *ZFSManager) DeleteCastDataset(id string) error {
 	}
 
 	cast := zm.casts[name]
-	if len(cast.replicas) != 0 {
+	if len(cast.replicas) != 0 || len(cast.trash) != 0 {
 		zm.l.Error("cannot delete cast, not empty", zap.String("cast", id))
 		return CastNotEmpty{id}
 	}
 
-	zm.l.Debug("getting parent snapshot of cast", zap.String("cast", id))
-	origin, err := zfs.GetDataset(cast.ds.Origin)
+	err := zm.destroyCast(cast)
 	if err != nil {
-		zm.l.Fatal("failed to get parent snapshot", zap.String("cast", id), zap.Error(err))
 		return err
 	}
 
-	zm.l.Debug("deleting cast dataset", zap.String("cast", id))
-	err = cast.ds.Destroy(0)
+	err = zm.d.RemoveMountPoint(zm.replicaPath + "/" + id)
 	if err != nil {
-		zm.l.Fatal("failed to delete cast dataset", zap.String("cast", id), zap.Error(err))
-		return err
+		if !os.IsNotExist(err) {
+			zm.l.Warn("failed to delete replica path of cast", zap.String("cast", id), zap.Error(err))
+		} else {
+			zm.l.Debug("did not find replica path. skipping...", zap.String("cast", id))
+		}
 	}
 
-	zm.l.Debug("deleting parent snapshot", zap.String("cast", id))
-	err = origin.Destroy(0)
+	return nil
+}
+
+// destroyCast destroys the dataset of an empty cast along with its origin snapshot and
+// unregisters it
+func (zm *ZFSManager) destroyCast(cast *cast) error {
+	zm.l.Debug("deleting cast dataset", zap.String("cast", cast.id))
+	err := zm.d.Destroy(cast.ds.Name)
 	if err != nil {
-		zm.l.Fatal("failed to delete parent snapshot", zap.String("cast", id), zap.Error(err))
-		return err
+		zm.l.Error("failed to delete cast dataset", zap.String("cast", cast.id), zap.Error(err))
+		return newDatasetError("destroy", cast.ds.Name, err)
 	}
 
-	zm.l.Debug("deleting cast", zap.String("cast", id))
-	delete(zm.casts, name)
-
-	zm.l.Debug("cleaning up after deletion", zap.String("cast", id))
-	mountPoint := cast.ds.Mountpoint
-	err = os.Remove(mountPoint)
-	if err != nil {
-		zm.l.Fatal("failed to delete mountpoint", zap.String("cast", id), zap.Error(err))
-		return err
+	zm.l.Debug("deleting cast", zap.String("cast", cast.id))
+	delete(zm.casts, cast.ds.Name)
+
+	// the cast is gone at this point, failures to clean up after it are left behind to
+	// be found by reconciliation instead of failing the deletion. a checkpoint the cast
+	// was cloned from belongs to its replica and is kept.
+	if cast.source == nil || cast.source.Checkpoint == "" {
+		zm.l.Debug("deleting parent snapshot", zap.String("cast", cast.id))
+		err = zm.d.Destroy(cast.ds.Origin)
+		if err != nil {
+			zm.l.Error("failed to delete parent snapshot", zap.String("cast", cast.id), zap.Error(err))
+		}
 	}
-	err = os.Remove(zm.replicaPath + "/" + id)
+
+	zm.l.Debug("cleaning up after deletion", zap.String("cast", cast.id))
+	err = zm.d.RemoveMountPoint(cast.ds.Mountpoint)
 	if err != nil {
-		_, ok := err.(*os.PathError)
-		if !ok {
-			zm.l.Fatal("failed to delete replica path of cast", zap.String("cast", id), zap.Error(err))
-			return err
-		}
-		zm.l.Debug("did not find replica path. skipping...", zap.String("cast", id))
+		zm.l.Warn("failed to delete mountpoint", zap.String("cast", cast.id), zap.Error(err))
 	}
 
 	return nil
@@ -193,44 +443,46 @@ func (zm *ZFSManager) getCastFullName(id string) string {
 
 // saveCastState saves the cast state into the cast dataset
 func (zm *ZFSManager) saveCastState(cast *cast) error {
-	ss := strings.Split(cast.ds.Name, "/")
-	s := ss[len(ss)-1] + "/" + castStateFile
+	path := cast.ds.Mountpoint + "/" + castStateFile
 
 	zm.l.Debug("marshaling cast state to json", zap.String("cast", cast.id))
-	b, err := json.MarshalIndent(&CastState{
-		Id:        cast.id,
-		Timestamp: cast.timestamp,
-	}, "", "  ")
+	state := cast.state()
+	b, err := json.MarshalIndent(&state, "", "  ")
 	if err != nil {
-		zm.l.Error("failed to marshal cast state json", zap.String("path", zm.castPath+"/"+s))
+		zm.l.Error("failed to marshal cast state json", zap.String("path", path))
 		return err
 	}
 
-	zm.l.Debug("writing cast state file", zap.String("path", cast.ds.Mountpoint+"/"+castStateFile))
-	err = ioutil.WriteFile(cast.ds.Mountpoint+"/"+castStateFile, b, 0644)
+	zm.l.Debug("writing cast state file", zap.String("path", path))
+	err = zm.d.WriteFile(cast.ds, castStateFile, b)
 	if err != nil {
-		zm.l.Error("failed to write cast state file", zap.String("path", cast.ds.Mountpoint+"/"+castStateFile))
+		zm.l.Error("failed to write cast state file", zap.String("path", path))
 		return err
 	}
 
 	return nil
 }
 
-// loadCasts discovers the underlying casts and populates their current state
+// loadCasts discovers the underlying casts and populates their current state. Datasets
+// without a readable state file are skipped and left to reconciliation.
 func (zm *ZFSManager) loadCasts() error {
 	zm.mu.Lock()
 	defer zm.mu.Unlock()
 
 	zm.l.Debug("reading cast datasets")
-	children, err := zm.fs.Children(1)
+	children, err := zm.d.Children(zm.fs.Name)
 	if err != nil {
-		zm.l.Fatal("failed to read cast datasets", zap.Error(err))
-		return err
+		zm.l.Error("failed to read cast datasets", zap.Error(err))
+		return newDatasetError("list children of", zm.fs.Name, err)
 	}
 
 	zm.l.Debug("iterating cast datasets")
 	for _, castDataset := range children {
-		if castDataset.Type == "filesystem" {
+		if isRefreshName(castDataset.Name) {
+			zm.l.Debug("skipping cast of unfinished refresh", zap.String("cast", castDataset.Name))
+			continue
+		}
+		if castDataset.Type == DatasetFilesystem {
 			zm.l.Debug("loading cast from filesystem", zap.String("cast", castDataset.Name))
 
 			cast := &cast{
@@ -239,7 +491,8 @@ func (zm *ZFSManager) loadCasts() error {
 			}
 			err := zm.loadCastState(cast)
 			if err != nil {
-				return err
+				zm.l.Warn("skipping cast without state", zap.String("cast", castDataset.Name), zap.Error(err))
+				continue
 			}
 
 			zm.casts[castDataset.Name] = cast
@@ -252,26 +505,68 @@ func (zm *ZFSManager) loadCasts() error {
 
 // loadCastState loads the state stored inside the cast dataset
 func (zm *ZFSManager) loadCastState(cast *cast) error {
-	zm.l.Debug("reading cast state", zap.String("path", zm.castPath))
-	ss := strings.Split(cast.ds.Name, "/")
-	s := ss[len(ss)-1] + "/" + castStateFile
-	f, e := ioutil.ReadFile(zm.castPath + "/" + s)
+	path := cast.ds.Mountpoint + "/" + castStateFile
+
+	zm.l.Debug("reading cast state", zap.String("path", path))
+	f, e := zm.d.ReadFile(cast.ds, castStateFile)
 	if e != nil {
-		zm.l.Error("failed to read cast state file", zap.String("path", zm.castPath+"/"+s))
+		zm.l.Error("failed to read cast state file", zap.String("path", path))
 		return e
 	}
 
-	zm.l.Debug("unmarshaling cast state json", zap.String("path", zm.castPath+"/"+s))
+	zm.l.Debug("unmarshaling cast state json", zap.String("path", path))
 	fcast := &CastState{}
 	e = json.Unmarshal(f, fcast)
 	if e != nil {
-		zm.l.Error("failed to unmarshal cast state json", zap.String("path", zm.castPath+"/"+s))
+		zm.l.Error("failed to unmarshal cast state json", zap.String("path", path))
 		return e
 	}
 
 	zm.l.Debug("loading cast state", zap.String("cast", fcast.Id))
 	cast.id = fcast.Id
 	cast.timestamp = fcast.Timestamp
+	cast.quiesce = fcast.Quiesce
+	cast.quiesceDuration = fcast.QuiesceDuration
+	cast.source = fcast.Source
+	if fcast.ExpiresAt != nil {
+		cast.expiresAt = *fcast.ExpiresAt
+	}
+	cast.protected = fcast.Protected
+	cast.properties = fcast.Properties
 
 	return nil
 }
+
+// state returns the state of the cast as it is stored on the dataset
+func (c *cast) state() CastState {
+	state := CastState{
+		Id:              c.id,
+		Timestamp:       c.timestamp,
+		Quiesce:         c.quiesce,
+		QuiesceDuration: c.quiesceDuration,
+		Source:          c.source,
+		Protected:       c.protected,
+		Properties:      copyProperties(c.properties),
+	}
+	if !c.expiresAt.IsZero() {
+		expiresAt := c.expiresAt
+		state.ExpiresAt = &expiresAt
+	}
+
+	return state
+}
+
+// getDependentCast returns the id of a cast created from a replica, or from one of its
+// checkpoints if a checkpoint is provided, and whether one was found
+func (zm *ZFSManager) getDependentCast(castId, id, checkpoint string) (string, bool) {
+	for _, cast := range zm.casts {
+		if cast.source == nil || cast.source.CastId != castId || cast.source.ReplicaId != id {
+			continue
+		}
+		if checkpoint == "" || cast.source.Checkpoint == checkpoint {
+			return cast.id, true
+		}
+	}
+
+	return "", false
+}
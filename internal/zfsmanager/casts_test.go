@@ -0,0 +1,99 @@
+package zfsmanager
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCreateDeleteCastDataset(t *testing.T) {
+	zm := newTestManager(t)
+
+	state, err := zm.CreateCastDataset("c1", "unit", noHooks, time.Time{}, nil)
+	if err != nil {
+		t.Fatalf("CreateCastDataset() error = %v", err)
+	}
+	if state.Id != "c1" || state.Quiesce != "unit" {
+		t.Errorf("CreateCastDataset() state = %+v", state)
+	}
+
+	_, err = zm.CreateCastDataset("c1", "unit", noHooks, time.Time{}, nil)
+	if _, ok := err.(CastAlreadyExistsError); !ok {
+		t.Errorf("CreateCastDataset() of existing cast error = %v, want CastAlreadyExistsError", err)
+	}
+
+	// a new manager on the same pool loads the cast from its state file
+	reloaded := newTestManagerWithDriver(t, zm.d)
+	reloaded.MustLoad()
+	got, err := reloaded.GetCastState("c1")
+	if err != nil {
+		t.Fatalf("GetCastState() after reload error = %v", err)
+	}
+	if !got.Timestamp.Equal(state.Timestamp) || got.Quiesce != state.Quiesce {
+		t.Errorf("state after reload = %+v, want %+v", got, state)
+	}
+
+	err = zm.DeleteCastDataset("c1")
+	if err != nil {
+		t.Fatalf("DeleteCastDataset() error = %v", err)
+	}
+	err = zm.DeleteCastDataset("c1")
+	if _, ok := err.(CastNotFoundError); !ok {
+		t.Errorf("DeleteCastDataset() of deleted cast error = %v, want CastNotFoundError", err)
+	}
+	if _, err := zm.d.GetDataset(zm.fs.Name + "@c1"); err == nil {
+		t.Error("snapshot of deleted cast still exists")
+	}
+}
+
+func TestCreateCastDatasetQuiesceFailure(t *testing.T) {
+	zm := newTestManager(t)
+
+	resumed := false
+	hooks := CastHooks{
+		Quiesce: func() error { return errInjected },
+		Resume: func() error {
+			resumed = true
+			return nil
+		},
+	}
+
+	_, err := zm.CreateCastDataset("c1", "unit", hooks, time.Time{}, nil)
+	if err != errInjected {
+		t.Fatalf("CreateCastDataset() error = %v, want %v", err, errInjected)
+	}
+	if !resumed {
+		t.Error("source was not resumed after the quiesce failed")
+	}
+	if ids := zm.GetCastIds(); len(ids) != 0 {
+		t.Errorf("GetCastIds() = %v, want none", ids)
+	}
+	if _, err := zm.d.GetDataset(zm.fs.Name + "@c1"); err == nil {
+		t.Error("snapshot of failed cast was left behind")
+	}
+}
+
+func TestCreateCastDatasetHooksRunWithoutLock(t *testing.T) {
+	zm := newTestManager(t)
+
+	hooks := noHooks
+	hooks.Snapshotted = func() error {
+		// reads of the manager must not block while the post_snapshot hook runs
+		done := make(chan struct{})
+		go func() {
+			zm.GetCastIds()
+			close(done)
+		}()
+		select {
+		case <-done:
+			return nil
+		case <-time.After(time.Second):
+			t.Error("manager is locked while the post_snapshot hook runs")
+			return nil
+		}
+	}
+
+	_, err := zm.CreateCastDataset("c1", "unit", hooks, time.Time{}, nil)
+	if err != nil {
+		t.Fatalf("CreateCastDataset() error = %v", err)
+	}
+}
@@ -0,0 +1,239 @@
+package zfsmanager
+
+import (
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// CheckpointState describes a checkpoint as it is stored in the replica state
+type CheckpointState struct {
+	Name      string    `json:"name"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// GetCheckpoints returns the checkpoints of a replica ordered by creation
+func (zm *ZFSManager) GetCheckpoints(castId, id string) ([]CheckpointState, error) {
+	zm.mu.Lock()
+	defer zm.mu.Unlock()
+
+	replica, err := zm.lookupReplica(castId, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]CheckpointState(nil), replica.checkpoints...), nil
+}
+
+// CreateCheckpoint takes a snapshot of a replica dataset and records it in the replica
+// state
+func (zm *ZFSManager) CreateCheckpoint(castId, id, name string) (CheckpointState, error) {
+	zm.mu.Lock()
+	defer zm.mu.Unlock()
+
+	replica, err := zm.lookupReplica(castId, id)
+	if err != nil {
+		return CheckpointState{}, err
+	}
+
+	if IsReservedCheckpointName(name) {
+		zm.l.Error("cannot create checkpoint, reserved name", zap.String("cast", castId), zap.String("replica", id), zap.String("checkpoint", name))
+		return CheckpointState{}, InvalidCheckpointNameError{name}
+	}
+
+	if replica.findCheckpoint(name) != -1 {
+		zm.l.Error("cannot create checkpoint, already exists", zap.String("cast", castId), zap.String("replica", id), zap.String("checkpoint", name))
+		return CheckpointState{}, CheckpointAlreadyExistsError{castId, id, name}
+	}
+
+	zm.l.Debug("snapshotting checkpoint", zap.String("cast", castId), zap.String("replica", id), zap.String("checkpoint", name))
+	timestamp := time.Now().UTC()
+	snapshot, err := zm.d.Snapshot(replica.ds.Name, name)
+	if err != nil {
+		zm.l.Error("failed to snapshot checkpoint", zap.String("cast", castId), zap.String("replica", id), zap.Error(err))
+		return CheckpointState{}, newDatasetError("snapshot", replica.ds.Name, err)
+	}
+
+	checkpoint := CheckpointState{
+		Name:      name,
+		Timestamp: timestamp,
+	}
+	replica.checkpoints = append(replica.checkpoints, checkpoint)
+
+	err = zm.saveReplicaState(replica)
+	if err != nil {
+		replica.checkpoints = replica.checkpoints[:len(replica.checkpoints)-1]
+		destroyErr := zm.d.Destroy(snapshot.Name)
+		if destroyErr != nil {
+			zm.l.Error("failed to destroy snapshot of checkpoint", zap.String("checkpoint", snapshot.Name), zap.Error(destroyErr))
+		}
+		return CheckpointState{}, err
+	}
+
+	return checkpoint, nil
+}
+
+// RestoreCheckpoint rolls a replica dataset back to a checkpoint. The checkpoints taken
+// after it are destroyed.
+func (zm *ZFSManager) RestoreCheckpoint(castId, id, name string) error {
+	zm.mu.Lock()
+	defer zm.mu.Unlock()
+
+	replica, err := zm.lookupReplica(castId, id)
+	if err != nil {
+		return err
+	}
+
+	if replica.findCheckpoint(name) == -1 {
+		zm.l.Error("cannot restore checkpoint, not found", zap.String("cast", castId), zap.String("replica", id), zap.String("checkpoint", name))
+		return CheckpointNotFoundError{castId, id, name}
+	}
+
+	zm.l.Debug("rolling back to checkpoint", zap.String("cast", castId), zap.String("replica", id), zap.String("checkpoint", name))
+	snapshot := replica.ds.Name + "@" + name
+	err = zm.d.Rollback(snapshot)
+	if err != nil {
+		zm.l.Error("failed to roll back to checkpoint", zap.String("cast", castId), zap.String("replica", id), zap.Error(err))
+		return newDatasetError("roll back to", snapshot, err)
+	}
+
+	// the state file was rolled back along with the data
+	err = zm.syncCheckpoints(replica)
+	if err != nil {
+		return err
+	}
+
+	return zm.saveReplicaState(replica)
+}
+
+// DeleteCheckpoint destroys the snapshot of a checkpoint and removes it from the replica
+// state
+func (zm *ZFSManager) DeleteCheckpoint(castId, id, name string) error {
+	zm.mu.Lock()
+	defer zm.mu.Unlock()
+
+	replica, err := zm.lookupReplica(castId, id)
+	if err != nil {
+		return err
+	}
+
+	i := replica.findCheckpoint(name)
+	if i == -1 {
+		zm.l.Error("cannot delete checkpoint, not found", zap.String("cast", castId), zap.String("replica", id), zap.String("checkpoint", name))
+		return CheckpointNotFoundError{castId, id, name}
+	}
+
+	if dependent, ok := zm.getDependentCast(castId, id, name); ok {
+		zm.l.Error("cannot delete checkpoint, it is the source of a cast", zap.String("cast", castId), zap.String("replica", id), zap.String("checkpoint", name), zap.String("dependent", dependent))
+		return CheckpointInUseError{castId, id, name, dependent}
+	}
+
+	zm.l.Debug("deleting checkpoint", zap.String("cast", castId), zap.String("replica", id), zap.String("checkpoint", name))
+	snapshot := replica.ds.Name + "@" + name
+	err = zm.d.Destroy(snapshot)
+	if err != nil {
+		zm.l.Error("failed to delete checkpoint", zap.String("cast", castId), zap.String("replica", id), zap.Error(err))
+		return newDatasetError("destroy", snapshot, err)
+	}
+
+	replica.checkpoints = append(replica.checkpoints[:i], replica.checkpoints[i+1:]...)
+
+	return zm.saveReplicaState(replica)
+}
+
+// IsReservedCheckpointName reports whether a name is reserved for the snapshots that
+// casts are created from
+func IsReservedCheckpointName(name string) bool {
+	return strings.HasPrefix(name, castSnapshotPrefix)
+}
+
+// lookupReplica returns a loaded replica
+func (zm *ZFSManager) lookupReplica(castId, id string) (*replica, error) {
+	cast, ok := zm.casts[zm.getCastFullName(castId)]
+	if !ok {
+		zm.l.Error("cannot get replica, cast not found", zap.String("cast", castId), zap.String("replica", id))
+		return nil, CastNotFoundError{castId}
+	}
+
+	replica, ok := cast.replicas[zm.getReplicaFullName(castId, id)]
+	if !ok {
+		zm.l.Error("cannot get replica, not found", zap.String("cast", castId), zap.String("replica", id))
+		return nil, ReplicaNotFoundError{castId, id}
+	}
+
+	return replica, nil
+}
+
+// destroyCheckpoints destroys the snapshots of a replica dataset. The caller must make
+// sure that no cast was created from the replica.
+func (zm *ZFSManager) destroyCheckpoints(replica *replica) error {
+	children, err := zm.d.Children(replica.ds.Name)
+	if err != nil {
+		zm.l.Error("failed to read checkpoints", zap.String("replica", replica.ds.Name), zap.Error(err))
+		return newDatasetError("list children of", replica.ds.Name, err)
+	}
+
+	for _, ds := range children {
+		if ds.Type != DatasetSnapshot {
+			continue
+		}
+
+		zm.l.Debug("deleting checkpoint", zap.String("checkpoint", ds.Name))
+		err = zm.d.Destroy(ds.Name)
+		if err != nil {
+			zm.l.Error("failed to delete checkpoint", zap.String("checkpoint", ds.Name), zap.Error(err))
+			return newDatasetError("destroy", ds.Name, err)
+		}
+	}
+	replica.checkpoints = nil
+
+	return nil
+}
+
+// syncCheckpoints aligns the checkpoints recorded in the replica state with the
+// snapshots found on the replica dataset. Snapshots that are not recorded are added
+// without a timestamp.
+func (zm *ZFSManager) syncCheckpoints(replica *replica) error {
+	children, err := zm.d.Children(replica.ds.Name)
+	if err != nil {
+		zm.l.Error("failed to read checkpoints", zap.String("replica", replica.ds.Name), zap.Error(err))
+		return newDatasetError("list children of", replica.ds.Name, err)
+	}
+
+	found := make(map[string]bool)
+	for _, ds := range children {
+		if ds.Type == DatasetSnapshot && !IsReservedCheckpointName(baseSnapshotName(ds.Name)) {
+			found[baseSnapshotName(ds.Name)] = true
+		}
+	}
+
+	checkpoints := make([]CheckpointState, 0, len(found))
+	for _, checkpoint := range replica.checkpoints {
+		if found[checkpoint.Name] {
+			checkpoints = append(checkpoints, checkpoint)
+			delete(found, checkpoint.Name)
+		}
+	}
+	for _, ds := range children {
+		name := baseSnapshotName(ds.Name)
+		if ds.Type == DatasetSnapshot && found[name] {
+			zm.l.Warn("found unrecorded checkpoint", zap.String("checkpoint", ds.Name))
+			checkpoints = append(checkpoints, CheckpointState{Name: name})
+		}
+	}
+	replica.checkpoints = checkpoints
+
+	return nil
+}
+
+// findCheckpoint returns the index of a checkpoint or -1 if it is not found
+func (r *replica) findCheckpoint(name string) int {
+	for i, checkpoint := range r.checkpoints {
+		if checkpoint.Name == name {
+			return i
+		}
+	}
+
+	return -1
+}
@@ -0,0 +1,95 @@
+package zfsmanager
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCheckpoints(t *testing.T) {
+	zm := newTestManager(t)
+	newTestReplica(t, zm)
+
+	_, err := zm.CreateCheckpoint("c1", "r1", "p1")
+	if err != nil {
+		t.Fatalf("CreateCheckpoint() error = %v", err)
+	}
+	_, err = zm.CreateCheckpoint("c1", "r1", "p1")
+	if _, ok := err.(CheckpointAlreadyExistsError); !ok {
+		t.Errorf("CreateCheckpoint() of existing checkpoint error = %v, want CheckpointAlreadyExistsError", err)
+	}
+
+	writeReplicaFile(t, zm, "after")
+	_, err = zm.CreateCheckpoint("c1", "r1", "p2")
+	if err != nil {
+		t.Fatalf("CreateCheckpoint() error = %v", err)
+	}
+
+	err = zm.RestoreCheckpoint("c1", "r1", "p1")
+	if err != nil {
+		t.Fatalf("RestoreCheckpoint() error = %v", err)
+	}
+	if hasReplicaFile(t, zm, "after") {
+		t.Error("changes made after the restored checkpoint survived")
+	}
+	if !hasReplicaFile(t, zm, "data") {
+		t.Error("changes made before the restored checkpoint were lost")
+	}
+
+	checkpoints, err := zm.GetCheckpoints("c1", "r1")
+	if err != nil {
+		t.Fatalf("GetCheckpoints() error = %v", err)
+	}
+	if len(checkpoints) != 1 || checkpoints[0].Name != "p1" {
+		t.Errorf("GetCheckpoints() = %+v, want p1 only", checkpoints)
+	}
+
+	tests := []struct {
+		name       string
+		checkpoint string
+		want       error
+	}{
+		{"missing", "p2", CheckpointNotFoundError{"c1", "r1", "p2"}},
+		{"existing", "p1", nil},
+		{"deleted", "p1", CheckpointNotFoundError{"c1", "r1", "p1"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := zm.DeleteCheckpoint("c1", "r1", tt.checkpoint); err != tt.want {
+				t.Errorf("DeleteCheckpoint(%s) error = %v, want %v", tt.checkpoint, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckpointInUse(t *testing.T) {
+	zm := newTestManager(t)
+	newTestReplica(t, zm)
+
+	_, err := zm.CreateCheckpoint("c1", "r1", "p1")
+	if err != nil {
+		t.Fatalf("CreateCheckpoint() error = %v", err)
+	}
+	_, err = zm.CreateCastDatasetFromReplica("c2", "c1", "r1", "p1", time.Time{}, nil)
+	if err != nil {
+		t.Fatalf("CreateCastDatasetFromReplica() error = %v", err)
+	}
+
+	err = zm.DeleteCheckpoint("c1", "r1", "p1")
+	if _, ok := err.(CheckpointInUseError); !ok {
+		t.Errorf("DeleteCheckpoint() error = %v, want CheckpointInUseError", err)
+	}
+	err = zm.DeleteReplicaDataset("c1", "r1")
+	if _, ok := err.(ReplicaInUseError); !ok {
+		t.Errorf("DeleteReplicaDataset() error = %v, want ReplicaInUseError", err)
+	}
+
+	err = zm.DeleteCastDataset("c2")
+	if err != nil {
+		t.Fatalf("DeleteCastDataset() error = %v", err)
+	}
+	err = zm.DeleteCheckpoint("c1", "r1", "p1")
+	if err != nil {
+		t.Errorf("DeleteCheckpoint() after the dependent cast was deleted error = %v", err)
+	}
+}
@@ -0,0 +1,381 @@
+package zfsmanager
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/mistifyio/go-zfs"
+)
+
+// Dataset types as reported by the storage drivers
+const (
+	DatasetFilesystem = "filesystem"
+	DatasetSnapshot   = "snapshot"
+)
+
+// Dataset describes a dataset of the underlying storage as reported by a driver
+type Dataset struct {
+	Name       string
+	Origin     string
+	Mountpoint string
+	Type       string
+}
+
+// Driver abstracts the storage operations the ZFSManager performs on the pool and its
+// datasets. Dataset names follow the ZFS naming scheme (pool/fs/child@snapshot)
+// regardless of the implementation.
+type Driver interface {
+	// GetCreatePool discovers the pool and creates it on the device if it does not exist
+	GetCreatePool(name, dev, mountPoint string) error
+	// GetCreateFilesystem discovers the filesystem and creates it if it does not exist
+	GetCreateFilesystem(name, mountPoint string) (*Dataset, error)
+	// GetDataset retrieves a dataset by its full name
+	GetDataset(name string) (*Dataset, error)
+	// Children returns the filesystems and snapshots directly below a dataset
+	Children(name string) ([]*Dataset, error)
+	// Snapshot creates a snapshot of a filesystem
+	Snapshot(name, snapshot string) (*Dataset, error)
+	// Clone creates a filesystem from a snapshot with the provided properties
+	Clone(snapshot, name string, properties map[string]string) (*Dataset, error)
+	// Destroy destroys a filesystem or a snapshot
+	Destroy(name string) error
+	// Rollback reverts a filesystem to one of its snapshots, destroying the snapshots
+	// taken after it
+	Rollback(snapshot string) error
+	// Rename renames a filesystem along with its descendants, or a snapshot within its
+	// filesystem
+	Rename(name, newName string) (*Dataset, error)
+	// SetProperty sets a property of a filesystem, e.g. its mountpoint
+	SetProperty(name, key, value string) error
+	// GetProperties returns the effective values of properties of a filesystem, whether
+	// they are set on it, inherited or defaults
+	GetProperties(name string, keys []string) (map[string]string, error)
+	// GetUsage returns the space accounting of a filesystem and of its descendant
+	// filesystems by name
+	GetUsage(name string) (map[string]Usage, error)
+	// GetPoolUsage returns the size of a pool and the space allocated in it
+	GetPoolUsage(name string) (PoolUsage, error)
+	// ReadFile reads a file stored at the root of a mounted dataset
+	ReadFile(ds *Dataset, file string) ([]byte, error)
+	// WriteFile writes a file at the root of a mounted dataset
+	WriteFile(ds *Dataset, file string, data []byte) error
+	// RemoveMountPoint removes a mount point directory that is no longer in use
+	RemoveMountPoint(path string) error
+}
+
+// NewDriver returns the storage driver registered under the provided name
+func NewDriver(name string) (Driver, error) {
+	switch name {
+	case "", "zfs":
+		return &zfsDriver{}, nil
+	case "memory":
+		return NewMemoryDriver(), nil
+	default:
+		return nil, UnknownDriverError{name}
+	}
+}
+
+// zfsDriver implements the Driver interface on top of the ZFS command line tools
+type zfsDriver struct{}
+
+func (d *zfsDriver) GetCreatePool(name, dev, mountPoint string) error {
+	_, err := zfs.GetZpool(name)
+	if err != nil {
+		_, err = zfs.CreateZpool(name, nil, dev, "-m", mountPoint)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (d *zfsDriver) GetCreateFilesystem(name, mountPoint string) (*Dataset, error) {
+	fs, err := zfs.GetDataset(name)
+	if err != nil {
+		properties := map[string]string{
+			"mountpoint": mountPoint,
+		}
+		fs, err = zfs.CreateFilesystem(name, properties)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return fromZFSDataset(fs), nil
+}
+
+func (d *zfsDriver) GetDataset(name string) (*Dataset, error) {
+	ds, err := zfs.GetDataset(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return fromZFSDataset(ds), nil
+}
+
+func (d *zfsDriver) Children(name string) ([]*Dataset, error) {
+	ds, err := zfs.GetDataset(name)
+	if err != nil {
+		return nil, err
+	}
+
+	children, err := ds.Children(1)
+	if err != nil {
+		return nil, err
+	}
+
+	datasets := make([]*Dataset, 0, len(children))
+	for _, child := range children {
+		datasets = append(datasets, fromZFSDataset(child))
+	}
+
+	return datasets, nil
+}
+
+func (d *zfsDriver) Snapshot(name, snapshot string) (*Dataset, error) {
+	ds, err := zfs.GetDataset(name)
+	if err != nil {
+		return nil, err
+	}
+
+	snap, err := ds.Snapshot(snapshot, false)
+	if err != nil {
+		return nil, err
+	}
+
+	return fromZFSDataset(snap), nil
+}
+
+func (d *zfsDriver) Clone(snapshot, name string, properties map[string]string) (*Dataset, error) {
+	snap, err := zfs.GetDataset(snapshot)
+	if err != nil {
+		return nil, err
+	}
+
+	ds, err := snap.Clone(name, properties)
+	if err != nil {
+		return nil, err
+	}
+
+	return fromZFSDataset(ds), nil
+}
+
+func (d *zfsDriver) Destroy(name string) error {
+	ds, err := zfs.GetDataset(name)
+	if err != nil {
+		return err
+	}
+
+	err = ds.Destroy(zfs.DestroyDefault)
+	if err != nil {
+		if isBusy(err) {
+			return DatasetBusyError{name}
+		}
+		return err
+	}
+
+	return nil
+}
+
+func (d *zfsDriver) Rollback(snapshot string) error {
+	snap, err := zfs.GetDataset(snapshot)
+	if err != nil {
+		return err
+	}
+
+	err = snap.Rollback(true)
+	if err != nil {
+		if isBusy(err) {
+			return DatasetBusyError{snapshot}
+		}
+		return err
+	}
+
+	return nil
+}
+
+func (d *zfsDriver) Rename(name, newName string) (*Dataset, error) {
+	// go-zfs does not wrap zfs rename
+	err := runZFS("rename", name, newName)
+	if err != nil {
+		if isBusy(err) {
+			return nil, DatasetBusyError{name}
+		}
+		return nil, err
+	}
+
+	ds, err := zfs.GetDataset(newName)
+	if err != nil {
+		return nil, err
+	}
+
+	return fromZFSDataset(ds), nil
+}
+
+func (d *zfsDriver) SetProperty(name, key, value string) error {
+	ds, err := zfs.GetDataset(name)
+	if err != nil {
+		return err
+	}
+
+	return ds.SetProperty(key, value)
+}
+
+func (d *zfsDriver) GetProperties(name string, keys []string) (map[string]string, error) {
+	// go-zfs only gets one property per command
+	out, err := outputZFS("get", "-H", "-o", "property,value", strings.Join(keys, ","), name)
+	if err != nil {
+		return nil, err
+	}
+
+	properties := make(map[string]string, len(keys))
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		fields := strings.SplitN(line, "\t", 2)
+		if len(fields) == 2 {
+			properties[fields[0]] = fields[1]
+		}
+	}
+
+	return properties, nil
+}
+
+func (d *zfsDriver) GetUsage(name string) (map[string]Usage, error) {
+	out, err := outputZFS("list", "-Hp", "-r", "-t", "filesystem", "-o", "name,used,avail,referenced,written,logicalused", name)
+	if err != nil {
+		return nil, err
+	}
+
+	usage := make(map[string]Usage)
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		fields := strings.Split(line, "\t")
+		if len(fields) != 6 {
+			continue
+		}
+
+		values := make([]uint64, 0, 5)
+		for _, field := range fields[1:] {
+			// properties that do not apply are reported as -
+			value, err := strconv.ParseUint(field, 10, 64)
+			if err != nil {
+				value = 0
+			}
+			values = append(values, value)
+		}
+		usage[fields[0]] = Usage{
+			Used:        values[0],
+			Available:   values[1],
+			Referenced:  values[2],
+			Written:     values[3],
+			LogicalUsed: values[4],
+		}
+	}
+
+	return usage, nil
+}
+
+func (d *zfsDriver) GetPoolUsage(name string) (PoolUsage, error) {
+	pool, err := zfs.GetZpool(name)
+	if err != nil {
+		return PoolUsage{}, err
+	}
+
+	return PoolUsage{
+		Size:      pool.Size,
+		Allocated: pool.Allocated,
+		Free:      pool.Free,
+	}, nil
+}
+
+func (d *zfsDriver) ReadFile(ds *Dataset, file string) ([]byte, error) {
+	return ioutil.ReadFile(ds.Mountpoint + "/" + file)
+}
+
+// WriteFile writes the data to a temporary file and renames it over the target, so that
+// a crash never leaves a truncated file behind
+func (d *zfsDriver) WriteFile(ds *Dataset, file string, data []byte) error {
+	path := ds.Mountpoint + "/" + file
+	f, err := ioutil.TempFile(ds.Mountpoint, file+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	err = os.Chmod(f.Name(), 0644)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), path)
+}
+
+func (d *zfsDriver) RemoveMountPoint(path string) error {
+	return os.Remove(path)
+}
+
+// runZFS runs a zfs subcommand and returns its failure in the form go-zfs reports it
+func runZFS(args ...string) error {
+	_, err := outputZFS(args...)
+	return err
+}
+
+// outputZFS runs a zfs subcommand and returns its output, or its failure in the form
+// go-zfs reports it
+func outputZFS(args ...string) (string, error) {
+	cmd := exec.Command("zfs", args...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	if err != nil {
+		return "", &zfs.Error{
+			Err:    err,
+			Debug:  strings.Join(cmd.Args, " "),
+			Stderr: stderr.String(),
+		}
+	}
+
+	return stdout.String(), nil
+}
+
+// isBusy reports whether the zfs command failed because the dataset is in use
+func isBusy(err error) bool {
+	e, ok := err.(*zfs.Error)
+	if !ok {
+		return false
+	}
+
+	for _, reason := range []string{"dataset is busy", "has children", "has dependent clones"} {
+		if strings.Contains(e.Stderr, reason) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// fromZFSDataset converts a go-zfs dataset to the driver agnostic representation
+func fromZFSDataset(ds *zfs.Dataset) *Dataset {
+	return &Dataset{
+		Name:       ds.Name,
+		Origin:     ds.Origin,
+		Mountpoint: ds.Mountpoint,
+		Type:       ds.Type,
+	}
+}
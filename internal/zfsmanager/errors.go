@@ -10,6 +10,11 @@ func (e CastAlreadyExistsError) Error() string {
 	return fmt.Sprintf("cast %s already exists", e.c)
 }
 
+// Cast returns the id of the cast
+func (e CastAlreadyExistsError) Cast() string {
+	return e.c
+}
+
 type CastNotFoundError struct {
 	c string
 }
@@ -18,6 +23,11 @@ func (e CastNotFoundError) Error() string {
 	return fmt.Sprintf("cast %s not found", e.c)
 }
 
+// Cast returns the id of the cast
+func (e CastNotFoundError) Cast() string {
+	return e.c
+}
+
 type CastNotEmpty struct {
 	c string
 }
@@ -26,6 +36,11 @@ func (e CastNotEmpty) Error() string {
 	return fmt.Sprintf("cast %s contains replicas", e.c)
 }
 
+// Cast returns the id of the cast
+func (e CastNotEmpty) Cast() string {
+	return e.c
+}
+
 type ReplicaAlreadyExistsError struct {
 	c string
 	r string
@@ -35,6 +50,16 @@ func (e ReplicaAlreadyExistsError) Error() string {
 	return fmt.Sprintf("replica %s already exists in cast %s", e.r, e.c)
 }
 
+// Cast returns the id of the cast
+func (e ReplicaAlreadyExistsError) Cast() string {
+	return e.c
+}
+
+// Replica returns the id of the replica
+func (e ReplicaAlreadyExistsError) Replica() string {
+	return e.r
+}
+
 type ReplicaNotFoundError struct {
 	c string
 	r string
@@ -43,3 +68,210 @@ type ReplicaNotFoundError struct {
 func (e ReplicaNotFoundError) Error() string {
 	return fmt.Sprintf("replica %s not found in cast %s", e.r, e.c)
 }
+
+// Cast returns the id of the cast
+func (e ReplicaNotFoundError) Cast() string {
+	return e.c
+}
+
+// Replica returns the id of the replica
+func (e ReplicaNotFoundError) Replica() string {
+	return e.r
+}
+
+type UnknownDriverError struct {
+	d string
+}
+
+func (e UnknownDriverError) Error() string {
+	return fmt.Sprintf("unknown storage driver %s", e.d)
+}
+
+type DatasetBusyError struct {
+	d string
+}
+
+func (e DatasetBusyError) Error() string {
+	return fmt.Sprintf("dataset %s is busy or has dependent datasets", e.d)
+}
+
+type DatasetError struct {
+	op  string
+	d   string
+	err error
+}
+
+func (e DatasetError) Error() string {
+	return fmt.Sprintf("failed to %s dataset %s: %s", e.op, e.d, e.err)
+}
+
+func (e DatasetError) Unwrap() error {
+	return e.err
+}
+
+// newDatasetError wraps the error of a failed storage operation unless the dataset was
+// reported busy by the driver
+func newDatasetError(op, name string, err error) error {
+	if _, ok := err.(DatasetBusyError); ok {
+		return err
+	}
+
+	return DatasetError{op: op, d: name, err: err}
+}
+
+type CheckpointAlreadyExistsError struct {
+	c string
+	r string
+	p string
+}
+
+func (e CheckpointAlreadyExistsError) Error() string {
+	return fmt.Sprintf("checkpoint %s already exists in replica %s of cast %s", e.p, e.r, e.c)
+}
+
+// Cast returns the id of the cast
+func (e CheckpointAlreadyExistsError) Cast() string {
+	return e.c
+}
+
+// Replica returns the id of the replica
+func (e CheckpointAlreadyExistsError) Replica() string {
+	return e.r
+}
+
+// Checkpoint returns the name of the checkpoint
+func (e CheckpointAlreadyExistsError) Checkpoint() string {
+	return e.p
+}
+
+type CheckpointNotFoundError struct {
+	c string
+	r string
+	p string
+}
+
+func (e CheckpointNotFoundError) Error() string {
+	return fmt.Sprintf("checkpoint %s not found in replica %s of cast %s", e.p, e.r, e.c)
+}
+
+// Cast returns the id of the cast
+func (e CheckpointNotFoundError) Cast() string {
+	return e.c
+}
+
+// Replica returns the id of the replica
+func (e CheckpointNotFoundError) Replica() string {
+	return e.r
+}
+
+// Checkpoint returns the name of the checkpoint
+func (e CheckpointNotFoundError) Checkpoint() string {
+	return e.p
+}
+
+type InvalidCheckpointNameError struct {
+	p string
+}
+
+func (e InvalidCheckpointNameError) Error() string {
+	return fmt.Sprintf("checkpoint name %s is reserved", e.p)
+}
+
+// Checkpoint returns the name of the checkpoint
+func (e InvalidCheckpointNameError) Checkpoint() string {
+	return e.p
+}
+
+type ReplicaInUseError struct {
+	c string
+	r string
+	d string
+}
+
+func (e ReplicaInUseError) Error() string {
+	return fmt.Sprintf("replica %s of cast %s is the source of cast %s", e.r, e.c, e.d)
+}
+
+// Cast returns the id of the cast
+func (e ReplicaInUseError) Cast() string {
+	return e.c
+}
+
+// Replica returns the id of the replica
+func (e ReplicaInUseError) Replica() string {
+	return e.r
+}
+
+// Dependent returns the id of the dependent cast
+func (e ReplicaInUseError) Dependent() string {
+	return e.d
+}
+
+type CheckpointInUseError struct {
+	c string
+	r string
+	p string
+	d string
+}
+
+func (e CheckpointInUseError) Error() string {
+	return fmt.Sprintf("checkpoint %s in replica %s of cast %s is the source of cast %s", e.p, e.r, e.c, e.d)
+}
+
+// Cast returns the id of the cast
+func (e CheckpointInUseError) Cast() string {
+	return e.c
+}
+
+// Replica returns the id of the replica
+func (e CheckpointInUseError) Replica() string {
+	return e.r
+}
+
+// Checkpoint returns the name of the checkpoint
+func (e CheckpointInUseError) Checkpoint() string {
+	return e.p
+}
+
+// Dependent returns the id of the dependent cast
+func (e CheckpointInUseError) Dependent() string {
+	return e.d
+}
+
+type ReplicaInTrashError struct {
+	c string
+	r string
+}
+
+func (e ReplicaInTrashError) Error() string {
+	return fmt.Sprintf("replica %s of cast %s is already in the trash", e.r, e.c)
+}
+
+// Cast returns the id of the cast
+func (e ReplicaInTrashError) Cast() string {
+	return e.c
+}
+
+// Replica returns the id of the replica
+func (e ReplicaInTrashError) Replica() string {
+	return e.r
+}
+
+type ReplicaHasCheckpointsError struct {
+	c string
+	r string
+}
+
+func (e ReplicaHasCheckpointsError) Error() string {
+	return fmt.Sprintf("replica %s of cast %s has checkpoints", e.r, e.c)
+}
+
+// Cast returns the id of the cast
+func (e ReplicaHasCheckpointsError) Cast() string {
+	return e.c
+}
+
+// Replica returns the id of the replica
+func (e ReplicaHasCheckpointsError) Replica() string {
+	return e.r
+}
@@ -0,0 +1,13 @@
+package zfsmanager
+
+const portHistoryFile = ".ports"
+
+// ReadPortHistory reads the port history file stored at the root dataset of the pool
+func (zm *ZFSManager) ReadPortHistory() ([]byte, error) {
+	return zm.d.ReadFile(zm.pool, portHistoryFile)
+}
+
+// WritePortHistory writes the port history file at the root dataset of the pool
+func (zm *ZFSManager) WritePortHistory(data []byte) error {
+	return zm.d.WriteFile(zm.pool, portHistoryFile, data)
+}
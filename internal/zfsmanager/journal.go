@@ -0,0 +1,106 @@
+package zfsmanager
+
+import (
+	"go.uber.org/zap"
+)
+
+const journalFile = ".journal"
+
+// ReadJournal reads the journal file stored at the root dataset of the pool
+func (zm *ZFSManager) ReadJournal() ([]byte, error) {
+	return zm.d.ReadFile(zm.pool, journalFile)
+}
+
+// WriteJournal writes the journal file at the root dataset of the pool
+func (zm *ZFSManager) WriteJournal(data []byte) error {
+	return zm.d.WriteFile(zm.pool, journalFile, data)
+}
+
+// PurgeCastDataset destroys what an interrupted creation or deletion left behind of a cast
+// that is not loaded, the clone and then its origin snapshot
+func (zm *ZFSManager) PurgeCastDataset(id string) error {
+	zm.mu.Lock()
+	defer zm.mu.Unlock()
+
+	name := zm.getCastFullName(id)
+	if _, ok := zm.casts[name]; ok {
+		zm.l.Error("cannot purge cast, it is loaded", zap.String("cast", id))
+		return CastAlreadyExistsError{id}
+	}
+
+	// a cast created from a replica is cloned from a snapshot of the replica
+	snapshot := zm.fs.Name + "@" + id
+	if ds, err := zm.d.GetDataset(name); err == nil && ds.Origin != "" {
+		snapshot = ds.Origin
+	} else {
+		for _, cast := range zm.casts {
+			for _, replica := range cast.replicas {
+				candidate := replica.ds.Name + "@" + castSnapshotPrefix + id
+				if _, err := zm.d.GetDataset(candidate); err == nil {
+					snapshot = candidate
+				}
+			}
+		}
+	}
+
+	zm.l.Info("purging leftovers of cast", zap.String("cast", id))
+	if baseSnapshotName(snapshot) != id && !IsReservedCheckpointName(baseSnapshotName(snapshot)) {
+		// the origin is a checkpoint of a replica and is kept
+		return zm.purge(name, "")
+	}
+	return zm.purge(name, snapshot)
+}
+
+// PurgeReplicaDataset destroys what an interrupted creation or deletion left behind of a
+// replica that is not loaded, the clone and then its origin snapshot
+func (zm *ZFSManager) PurgeReplicaDataset(castId, id string) error {
+	zm.mu.Lock()
+	defer zm.mu.Unlock()
+
+	castName := zm.getCastFullName(castId)
+	name := zm.getReplicaFullName(castId, id)
+	if cast, ok := zm.casts[castName]; ok {
+		if _, ok := cast.replicas[name]; ok {
+			zm.l.Error("cannot purge replica, it is loaded", zap.String("cast", castId), zap.String("replica", id))
+			return ReplicaAlreadyExistsError{castId, id}
+		}
+	}
+
+	zm.l.Info("purging leftovers of replica", zap.String("cast", castId), zap.String("replica", id))
+	return zm.purge(name, castName+"@"+id)
+}
+
+// purge destroys a filesystem and a snapshot, skipping the ones that do not exist or
+// are not provided
+func (zm *ZFSManager) purge(name, snapshot string) error {
+	ds, err := zm.d.GetDataset(name)
+	if err == nil {
+		zm.l.Debug("destroying leftover dataset", zap.String("dataset", name))
+		err = zm.d.Destroy(name)
+		if err != nil {
+			zm.l.Error("failed to destroy leftover dataset", zap.String("dataset", name), zap.Error(err))
+			return newDatasetError("destroy", name, err)
+		}
+
+		err = zm.d.RemoveMountPoint(ds.Mountpoint)
+		if err != nil {
+			zm.l.Warn("failed to delete mountpoint of leftover dataset", zap.String("dataset", name), zap.Error(err))
+		}
+	}
+
+	if snapshot == "" {
+		return nil
+	}
+
+	_, err = zm.d.GetDataset(snapshot)
+	if err == nil {
+		zm.l.Debug("destroying leftover snapshot", zap.String("snapshot", snapshot))
+		err = zm.d.Destroy(snapshot)
+		if err != nil {
+			zm.l.Error("failed to destroy leftover snapshot", zap.String("snapshot", snapshot), zap.Error(err))
+			return newDatasetError("destroy", snapshot, err)
+		}
+	}
+
+	return nil
+}
@@ -3,7 +3,6 @@ package zfsmanager
 import (
 	"sync"
 
-	"github.com/mistifyio/go-zfs"
 	"go.uber.org/zap"
 )
 
@@ -13,26 +12,43 @@ import (
 type ZFSManager struct {
 	mu          sync.Mutex
 	l           *zap.Logger
+	d           Driver
 	poolName    string
 	fsName      string
 	castPath    string
 	replicaPath string
-	fs          *zfs.Dataset
+	pool        *Dataset
+	fs          *Dataset
 	casts       map[string]*cast
 }
 
-// New creates a ZFSManager object and loads the current state structure from the
-// underlying ZFS pool
-func New(pn string, pp string, pd string, fn string, fp string, cp string, rp string, logger *zap.Logger) *ZFSManager {
-	pool := getCreatePool(pn, pd, pp, logger)
-	fs := getCreateFilesystem(pool, fn, fp, logger)
+// New creates a ZFSManager object on top of the provided storage driver and discovers
+// or creates the underlying ZFS pool and filesystem
+func New(driver Driver, pn string, pd string, pp string, fn string, fp string, cp string, rp string, logger *zap.Logger) *ZFSManager {
+	err := driver.GetCreatePool(pn, pd, pp)
+	if err != nil {
+		logger.Fatal("failed to get or create pool", zap.Error(err))
+	}
+	pool, err := driver.GetDataset(pn)
+	if err != nil {
+		logger.Fatal("failed to get root dataset of pool", zap.Error(err))
+	}
+	logger.Debug("found pool", zap.String("pool", pn))
+
+	fs, err := driver.GetCreateFilesystem(pn+"/"+fn, fp)
+	if err != nil {
+		logger.Fatal("failed to get or create filesystem", zap.Error(err))
+	}
+	logger.Debug("found filesystem", zap.String("filesystem", fs.Name))
 
 	zm := &ZFSManager{
 		l:           logger,
+		d:           driver,
 		poolName:    pn,
 		fsName:      fn,
 		castPath:    cp,
 		replicaPath: rp,
+		pool:        pool,
 		fs:          fs,
 		casts:       make(map[string]*cast),
 	}
@@ -42,6 +58,11 @@ func New(pn string, pp string, pd string, fn string, fp string, cp string, rp st
 	return zm
 }
 
+// GetFilesystemMountPoint returns the mount point path of the filesystem
+func (zm *ZFSManager) GetFilesystemMountPoint() string {
+	return zm.fs.Mountpoint
+}
+
 // MustLoad executes the load methods recursively and exits if an error occurs
 func (zm *ZFSManager) MustLoad() {
 	err := zm.loadCasts()
@@ -59,42 +80,3 @@ func (zm *ZFSManager) MustLoad() {
 		}
 	}
 }
-
-// getCreatePool discovers the underlying ZFS pool and creates it if it does not exist
-func getCreatePool(name string, dev string, mp string, logger *zap.Logger) *zfs.Zpool {
-	pool, err := zfs.GetZpool(name)
-	if err != nil {
-		logger.Info("pool does not exist, creating", zap.Error(err))
-		pool, err = zfs.CreateZpool(name, nil, dev, "-m", mp)
-		if err != nil {
-			logger.Fatal("failed to create pool", zap.Error(err))
-		}
-	}
-
-	logger.Debug("found pool", zap.String("pool", pool.Name))
-
-	return pool
-}
-
-// getCreateFilesystem discovers the underlying filesystem of the ZFS pool and creates
-// it if it does not exist
-func getCreateFilesystem(pool *zfs.Zpool, name string, mp string, logger *zap.Logger) *zfs.Dataset {
-	fullName := pool.Name + "/" + name
-
-	fs, err := zfs.GetDataset(fullName)
-	if err != nil {
-		logger.Debug("filesystem does not exist, creating", zap.Error(err))
-
-		properties := map[string]string{
-			"mountpoint": mp,
-		}
-		fs, err = zfs.CreateFilesystem(fullName, properties)
-		if err != nil {
-			logger.Fatal("failed to create filesystem", zap.Error(err))
-		}
-	}
-
-	logger.Debug("found filesystem", zap.String("filesystem", fs.Name))
-
-	return fs
-}
@@ -0,0 +1,52 @@
+package zfsmanager
+
+import (
+	"errors"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+// newTestManager creates a ZFSManager on top of a new MemoryDriver
+func newTestManager(t *testing.T) *ZFSManager {
+	t.Helper()
+
+	return newTestManagerWithDriver(t, NewMemoryDriver())
+}
+
+// newTestManagerWithDriver creates a ZFSManager on top of the provided driver
+func newTestManagerWithDriver(t *testing.T, d Driver) *ZFSManager {
+	t.Helper()
+
+	return New(d, "testpool", "/dev/null", "/testpool", "fs", "/var/lib/fs", "/fs_cast", "/fs_replica", zap.NewNop())
+}
+
+// noHooks are cast hooks that do nothing
+var noHooks = CastHooks{
+	Quiesce: func() error { return nil },
+	Resume:  func() error { return nil },
+}
+
+// errInjected is returned by the operations that failingDriver is told to fail
+var errInjected = errors.New("injected failure")
+
+// failingDriver is a MemoryDriver whose clones and renames fail on demand
+type failingDriver struct {
+	*MemoryDriver
+	failClone  bool
+	failRename bool
+}
+
+func (d *failingDriver) Clone(snapshot, name string, properties map[string]string) (*Dataset, error) {
+	if d.failClone {
+		return nil, errInjected
+	}
+	return d.MemoryDriver.Clone(snapshot, name, properties)
+}
+
+func (d *failingDriver) Rename(name, newName string) (*Dataset, error) {
+	if d.failRename {
+		return nil, errInjected
+	}
+	return d.MemoryDriver.Rename(name, newName)
+}
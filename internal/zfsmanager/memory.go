@@ -0,0 +1,585 @@
+package zfsmanager
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// memoryDataset contains the state of a dataset kept by the memory driver
+type memoryDataset struct {
+	Dataset
+	files      map[string][]byte
+	properties map[string]string
+	created    uint64
+}
+
+// memoryPropertyDefaults are the values the memory driver reports for properties that
+// are neither set on a dataset nor inherited, as ZFS reports its defaults
+var memoryPropertyDefaults = map[string]string{
+	"quota":          "none",
+	"refquota":       "none",
+	"reservation":    "none",
+	"refreservation": "none",
+	"compression":    "off",
+	"recordsize":     "128K",
+	"primarycache":   "all",
+	"secondarycache": "all",
+	"logbias":        "latency",
+	"sync":           "standard",
+	"atime":          "on",
+}
+
+// memoryPoolSize is the nominal size of the pools of the memory driver
+const memoryPoolSize = 1 << 40
+
+// memoryLocalProperties are the properties that are not inherited by descendants
+var memoryLocalProperties = map[string]bool{
+	"quota":          true,
+	"refquota":       true,
+	"reservation":    true,
+	"refreservation": true,
+}
+
+// MemoryDriver implements the Driver interface in memory. It follows the semantics of
+// ZFS for snapshots, clones and their dependencies, and keeps the files written to the
+// datasets so that the state files survive as they would on a real pool. It is meant
+// for hosts that do not have the ZFS kernel module.
+type MemoryDriver struct {
+	mu       sync.Mutex
+	pools    map[string]string
+	datasets map[string]*memoryDataset
+	dirs     map[string]bool
+	seq      uint64
+}
+
+// NewMemoryDriver creates an empty MemoryDriver object
+func NewMemoryDriver() *MemoryDriver {
+	return &MemoryDriver{
+		pools:    make(map[string]string),
+		datasets: make(map[string]*memoryDataset),
+		dirs:     make(map[string]bool),
+	}
+}
+
+// GetCreatePool registers the pool and its root dataset if it does not exist
+func (d *MemoryDriver) GetCreatePool(name, dev, mountPoint string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if _, ok := d.pools[name]; ok {
+		return nil
+	}
+
+	d.pools[name] = dev
+	d.datasets[name] = &memoryDataset{
+		Dataset: Dataset{
+			Name:       name,
+			Mountpoint: mountPoint,
+			Type:       DatasetFilesystem,
+		},
+		files: make(map[string][]byte),
+	}
+	d.dirs[mountPoint] = true
+
+	return nil
+}
+
+// GetCreateFilesystem returns the filesystem and creates it if it does not exist
+func (d *MemoryDriver) GetCreateFilesystem(name, mountPoint string) (*Dataset, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if ds, ok := d.datasets[name]; ok {
+		return d.copyDataset(ds), nil
+	}
+
+	if _, ok := d.datasets[path.Dir(name)]; !ok {
+		return nil, fmt.Errorf("cannot create '%s': parent does not exist", name)
+	}
+
+	ds := &memoryDataset{
+		Dataset: Dataset{
+			Name:       name,
+			Mountpoint: mountPoint,
+			Type:       DatasetFilesystem,
+		},
+		files: make(map[string][]byte),
+	}
+	d.datasets[name] = ds
+	d.dirs[mountPoint] = true
+
+	return d.copyDataset(ds), nil
+}
+
+// GetDataset retrieves a dataset by its full name
+func (d *MemoryDriver) GetDataset(name string) (*Dataset, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	ds, ok := d.datasets[name]
+	if !ok {
+		return nil, fmt.Errorf("cannot open '%s': dataset does not exist", name)
+	}
+
+	return d.copyDataset(ds), nil
+}
+
+// Children returns the filesystems and snapshots directly below a dataset
+func (d *MemoryDriver) Children(name string) ([]*Dataset, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if _, ok := d.datasets[name]; !ok {
+		return nil, fmt.Errorf("cannot open '%s': dataset does not exist", name)
+	}
+
+	children := make([]*Dataset, 0)
+	for _, ds := range d.datasets {
+		if memoryParent(ds.Name) == name {
+			children = append(children, d.copyDataset(ds))
+		}
+	}
+	sort.Slice(children, func(i, j int) bool {
+		return children[i].Name < children[j].Name
+	})
+
+	return children, nil
+}
+
+// Snapshot creates a snapshot of a filesystem along with a copy of its files
+func (d *MemoryDriver) Snapshot(name, snapshot string) (*Dataset, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	ds, ok := d.datasets[name]
+	if !ok || ds.Type != DatasetFilesystem {
+		return nil, fmt.Errorf("cannot open '%s': dataset does not exist", name)
+	}
+
+	snapName := name + "@" + snapshot
+	if _, ok := d.datasets[snapName]; ok {
+		return nil, fmt.Errorf("cannot create snapshot '%s': dataset already exists", snapName)
+	}
+
+	d.seq++
+	snap := &memoryDataset{
+		Dataset: Dataset{
+			Name: snapName,
+			Type: DatasetSnapshot,
+		},
+		files:   copyFiles(ds.files),
+		created: d.seq,
+	}
+	d.datasets[snapName] = snap
+
+	return d.copyDataset(snap), nil
+}
+
+// Clone creates a filesystem from a snapshot along with a copy of its files
+func (d *MemoryDriver) Clone(snapshot, name string, properties map[string]string) (*Dataset, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	snap, ok := d.datasets[snapshot]
+	if !ok || snap.Type != DatasetSnapshot {
+		return nil, fmt.Errorf("cannot open '%s': snapshot does not exist", snapshot)
+	}
+
+	if _, ok := d.datasets[name]; ok {
+		return nil, fmt.Errorf("cannot create '%s': dataset already exists", name)
+	}
+
+	parent, ok := d.datasets[path.Dir(name)]
+	if !ok {
+		return nil, fmt.Errorf("cannot create '%s': parent does not exist", name)
+	}
+
+	mountPoint, ok := properties["mountpoint"]
+	if !ok {
+		mountPoint = parent.Mountpoint + "/" + path.Base(name)
+	}
+
+	ds := &memoryDataset{
+		Dataset: Dataset{
+			Name:       name,
+			Origin:     snapshot,
+			Mountpoint: mountPoint,
+			Type:       DatasetFilesystem,
+		},
+		files:      copyFiles(snap.files),
+		properties: make(map[string]string),
+	}
+	for key, value := range properties {
+		if key != "mountpoint" {
+			ds.properties[key] = value
+		}
+	}
+	d.datasets[name] = ds
+	d.dirs[path.Dir(mountPoint)] = true
+	d.dirs[mountPoint] = true
+
+	return d.copyDataset(ds), nil
+}
+
+// Destroy destroys a dataset if it has no children and no dependent clones
+func (d *MemoryDriver) Destroy(name string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if _, ok := d.datasets[name]; !ok {
+		return fmt.Errorf("cannot open '%s': dataset does not exist", name)
+	}
+
+	for _, ds := range d.datasets {
+		if memoryParent(ds.Name) == name || ds.Origin == name {
+			return DatasetBusyError{name}
+		}
+	}
+
+	delete(d.datasets, name)
+
+	return nil
+}
+
+// Rollback restores the files of a filesystem from a snapshot and destroys the snapshots
+// taken after it, unless one of them has dependent clones
+func (d *MemoryDriver) Rollback(snapshot string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	snap, ok := d.datasets[snapshot]
+	if !ok || snap.Type != DatasetSnapshot {
+		return fmt.Errorf("cannot open '%s': snapshot does not exist", snapshot)
+	}
+	fs := d.datasets[memoryParent(snapshot)]
+
+	recent := make([]string, 0)
+	for _, ds := range d.datasets {
+		if ds.Type == DatasetSnapshot && memoryParent(ds.Name) == fs.Name && ds.created > snap.created {
+			recent = append(recent, ds.Name)
+		}
+	}
+	for _, ds := range d.datasets {
+		for _, name := range recent {
+			if ds.Origin == name {
+				return DatasetBusyError{name}
+			}
+		}
+	}
+
+	for _, name := range recent {
+		delete(d.datasets, name)
+	}
+	fs.files = copyFiles(snap.files)
+
+	return nil
+}
+
+// Rename renames a filesystem along with its descendants and snapshots, or a snapshot
+// within its filesystem, and updates the origins that refer to them
+func (d *MemoryDriver) Rename(name, newName string) (*Dataset, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	ds, ok := d.datasets[name]
+	if !ok {
+		return nil, fmt.Errorf("cannot open '%s': dataset does not exist", name)
+	}
+
+	if _, ok := d.datasets[newName]; ok {
+		return nil, fmt.Errorf("cannot rename to '%s': dataset already exists", newName)
+	}
+
+	if ds.Type == DatasetSnapshot && memoryParent(name) != memoryParent(newName) {
+		return nil, fmt.Errorf("cannot rename to '%s': snapshots must be part of same dataset", newName)
+	}
+	if ds.Type == DatasetFilesystem {
+		if _, ok := d.datasets[memoryParent(newName)]; !ok {
+			return nil, fmt.Errorf("cannot rename to '%s': parent does not exist", newName)
+		}
+	}
+
+	renamed := make(map[string]string)
+	for old := range d.datasets {
+		switch {
+		case old == name:
+			renamed[old] = newName
+		case ds.Type == DatasetFilesystem && (strings.HasPrefix(old, name+"/") || strings.HasPrefix(old, name+"@")):
+			renamed[old] = newName + old[len(name):]
+		}
+	}
+	for old, renamedName := range renamed {
+		mds := d.datasets[old]
+		delete(d.datasets, old)
+		mds.Name = renamedName
+		d.datasets[renamedName] = mds
+	}
+	for _, mds := range d.datasets {
+		if renamedName, ok := renamed[mds.Origin]; ok {
+			mds.Origin = renamedName
+		}
+	}
+
+	return d.copyDataset(d.datasets[newName]), nil
+}
+
+// SetProperty sets a property of a filesystem. The mountpoint moves the filesystem, other
+// properties are only recorded and reported back.
+func (d *MemoryDriver) SetProperty(name, key, value string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	ds, ok := d.datasets[name]
+	if !ok || ds.Type != DatasetFilesystem {
+		return fmt.Errorf("cannot open '%s': dataset does not exist", name)
+	}
+
+	if key == "mountpoint" {
+		ds.Mountpoint = value
+		d.dirs[path.Dir(value)] = true
+		d.dirs[value] = true
+		return nil
+	}
+
+	if ds.properties == nil {
+		ds.properties = make(map[string]string)
+	}
+	ds.properties[key] = value
+
+	return nil
+}
+
+// GetProperties returns the values of properties of a filesystem, looking them up on
+// the filesystem, then on its ancestors for inheritable properties and then in the
+// defaults. Unknown properties are reported as "-".
+func (d *MemoryDriver) GetProperties(name string, keys []string) (map[string]string, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	ds, ok := d.datasets[name]
+	if !ok || ds.Type != DatasetFilesystem {
+		return nil, fmt.Errorf("cannot open '%s': dataset does not exist", name)
+	}
+
+	properties := make(map[string]string, len(keys))
+	for _, key := range keys {
+		value, ok := memoryPropertyDefaults[key]
+		if !ok {
+			value = "-"
+		}
+		for current := name; ; current = memoryParent(current) {
+			mds, ok := d.datasets[current]
+			if !ok {
+				break
+			}
+			if v, ok := mds.properties[key]; ok {
+				value = v
+				break
+			}
+			if memoryLocalProperties[key] {
+				break
+			}
+		}
+		properties[key] = value
+	}
+
+	return properties, nil
+}
+
+// GetUsage returns the space accounting of a filesystem and its descendants, computed
+// from the size of their files. Files a clone shares with its origin snapshot are not
+// counted as used by it, and the memory driver neither compresses nor accounts for the
+// space held by snapshots.
+func (d *MemoryDriver) GetUsage(name string) (map[string]Usage, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	ds, ok := d.datasets[name]
+	if !ok || ds.Type != DatasetFilesystem {
+		return nil, fmt.Errorf("cannot open '%s': dataset does not exist", name)
+	}
+
+	pool := name
+	if i := strings.Index(name, "/"); i != -1 {
+		pool = name[:i]
+	}
+	allocated := d.used(pool)
+	available := uint64(0)
+	if allocated < memoryPoolSize {
+		available = memoryPoolSize - allocated
+	}
+
+	usage := make(map[string]Usage)
+	for _, mds := range d.datasets {
+		if mds.Type != DatasetFilesystem || (mds.Name != name && !strings.HasPrefix(mds.Name, name+"/")) {
+			continue
+		}
+
+		used := d.used(mds.Name)
+		usage[mds.Name] = Usage{
+			Used:        used,
+			Available:   available,
+			Referenced:  filesSize(mds.files),
+			Written:     d.written(mds),
+			LogicalUsed: used,
+		}
+	}
+
+	return usage, nil
+}
+
+// GetPoolUsage returns the nominal size of a pool and the size of the files stored in it
+func (d *MemoryDriver) GetPoolUsage(name string) (PoolUsage, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if _, ok := d.pools[name]; !ok {
+		return PoolUsage{}, fmt.Errorf("cannot open '%s': no such pool", name)
+	}
+
+	allocated := d.used(name)
+	free := uint64(0)
+	if allocated < memoryPoolSize {
+		free = memoryPoolSize - allocated
+	}
+
+	return PoolUsage{
+		Size:      memoryPoolSize,
+		Allocated: allocated,
+		Free:      free,
+	}, nil
+}
+
+// used returns the space written to a filesystem and its descendant filesystems
+func (d *MemoryDriver) used(name string) uint64 {
+	used := uint64(0)
+	for _, mds := range d.datasets {
+		if mds.Type == DatasetFilesystem && (mds.Name == name || strings.HasPrefix(mds.Name, name+"/")) {
+			used += d.written(mds)
+		}
+	}
+
+	return used
+}
+
+// written returns the size of the files of a filesystem that differ from its origin
+// snapshot
+func (d *MemoryDriver) written(ds *memoryDataset) uint64 {
+	origin, ok := d.datasets[ds.Origin]
+	if !ok {
+		return filesSize(ds.files)
+	}
+
+	written := uint64(0)
+	for file, data := range ds.files {
+		if shared, ok := origin.files[file]; !ok || string(shared) != string(data) {
+			written += uint64(len(data))
+		}
+	}
+
+	return written
+}
+
+// ReadFile reads a file stored on a dataset
+func (d *MemoryDriver) ReadFile(ds *Dataset, file string) ([]byte, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	mds, ok := d.datasets[ds.Name]
+	if !ok {
+		return nil, &os.PathError{Op: "open", Path: ds.Mountpoint + "/" + file, Err: syscall.ENOENT}
+	}
+
+	data, ok := mds.files[file]
+	if !ok {
+		return nil, &os.PathError{Op: "open", Path: ds.Mountpoint + "/" + file, Err: syscall.ENOENT}
+	}
+
+	return append([]byte(nil), data...), nil
+}
+
+// WriteFile writes a file onto a filesystem
+func (d *MemoryDriver) WriteFile(ds *Dataset, file string, data []byte) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	mds, ok := d.datasets[ds.Name]
+	if !ok || mds.Type != DatasetFilesystem {
+		return &os.PathError{Op: "open", Path: ds.Mountpoint + "/" + file, Err: syscall.ENOENT}
+	}
+
+	mds.files[file] = append([]byte(nil), data...)
+
+	return nil
+}
+
+// RemoveMountPoint removes a mount point that is empty and not in use
+func (d *MemoryDriver) RemoveMountPoint(p string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if !d.dirs[p] {
+		return &os.PathError{Op: "remove", Path: p, Err: syscall.ENOENT}
+	}
+
+	for dir := range d.dirs {
+		if path.Dir(dir) == p {
+			return &os.PathError{Op: "remove", Path: p, Err: syscall.ENOTEMPTY}
+		}
+	}
+	for _, ds := range d.datasets {
+		if ds.Type == DatasetFilesystem && ds.Mountpoint == p {
+			return &os.PathError{Op: "remove", Path: p, Err: syscall.EBUSY}
+		}
+	}
+
+	delete(d.dirs, p)
+
+	return nil
+}
+
+// copyDataset returns a copy of the dataset description so that callers cannot modify
+// the driver state
+func (d *MemoryDriver) copyDataset(ds *memoryDataset) *Dataset {
+	c := ds.Dataset
+	return &c
+}
+
+// memoryParent returns the name of the dataset that contains the provided dataset or
+// snapshot
+func memoryParent(name string) string {
+	if i := strings.Index(name, "@"); i != -1 {
+		return name[:i]
+	}
+
+	if !strings.Contains(name, "/") {
+		return ""
+	}
+
+	return path.Dir(name)
+}
+
+// filesSize returns the total size of the files of a dataset
+func filesSize(files map[string][]byte) uint64 {
+	size := uint64(0)
+	for _, data := range files {
+		size += uint64(len(data))
+	}
+
+	return size
+}
+
+// copyFiles returns a deep copy of the files of a dataset
+func copyFiles(files map[string][]byte) map[string][]byte {
+	c := make(map[string][]byte, len(files))
+	for name, data := range files {
+		c[name] = append([]byte(nil), data...)
+	}
+
+	return c
+}
@@ -0,0 +1,120 @@
+package zfsmanager
+
+import (
+	"sort"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// SupportedProperties are the ZFS properties that can be set on cast and replica
+// datasets and whose effective values are reported. The mountpoint is managed and cannot
+// be set.
+var SupportedProperties = []string{
+	"quota",
+	"refquota",
+	"reservation",
+	"refreservation",
+	"compression",
+	"recordsize",
+	"primarycache",
+	"secondarycache",
+	"logbias",
+	"sync",
+	"atime",
+}
+
+// IsValidProperty reports whether a property is supported and its value is not empty.
+// The value itself is validated by ZFS when it is set.
+func IsValidProperty(key, value string) bool {
+	if value == "" || strings.ContainsAny(value, " \t\n,") {
+		return false
+	}
+
+	for _, supported := range SupportedProperties {
+		if key == supported {
+			return true
+		}
+	}
+
+	return false
+}
+
+// GetCastProperties returns the effective values of the supported properties of a cast
+// dataset
+func (zm *ZFSManager) GetCastProperties(id string) (map[string]string, error) {
+	zm.mu.Lock()
+	defer zm.mu.Unlock()
+
+	name := zm.getCastFullName(id)
+
+	if _, ok := zm.casts[name]; !ok {
+		zm.l.Error("cannot get cast properties, not found", zap.String("cast", id))
+		return nil, CastNotFoundError{id}
+	}
+
+	return zm.getProperties(name)
+}
+
+// GetReplicaProperties returns the effective values of the supported properties of a
+// replica dataset
+func (zm *ZFSManager) GetReplicaProperties(castId, id string) (map[string]string, error) {
+	zm.mu.Lock()
+	defer zm.mu.Unlock()
+
+	replica, err := zm.lookupReplica(castId, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return zm.getProperties(replica.ds.Name)
+}
+
+// getProperties reads the effective values of the supported properties of a dataset
+func (zm *ZFSManager) getProperties(name string) (map[string]string, error) {
+	properties, err := zm.d.GetProperties(name, SupportedProperties)
+	if err != nil {
+		zm.l.Error("failed to get properties", zap.String("dataset", name), zap.Error(err))
+		return nil, newDatasetError("get properties of", name, err)
+	}
+
+	return properties, nil
+}
+
+// cloneProperties returns the properties a dataset is cloned with, the provided ones
+// along with its mount point
+func cloneProperties(mountPoint string, properties map[string]string) map[string]string {
+	p := map[string]string{
+		"mountpoint": mountPoint,
+	}
+	for key, value := range properties {
+		p[key] = value
+	}
+
+	return p
+}
+
+// copyProperties returns a copy of properties, or nil if there are none
+func copyProperties(properties map[string]string) map[string]string {
+	if len(properties) == 0 {
+		return nil
+	}
+
+	p := make(map[string]string, len(properties))
+	for key, value := range properties {
+		p[key] = value
+	}
+
+	return p
+}
+
+// propertyFields returns the properties as sorted key=value pairs for logging
+func propertyFields(properties map[string]string) []string {
+	fields := make([]string, 0, len(properties))
+	for key, value := range properties {
+		fields = append(fields, key+"="+value)
+	}
+	sort.Strings(fields)
+
+	return fields
+}
@@ -0,0 +1,218 @@
+package zfsmanager
+
+import (
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Kinds of orphaned datasets
+const (
+	OrphanCastDataset    = "cast_dataset"
+	OrphanReplicaDataset = "replica_dataset"
+	OrphanSnapshot       = "snapshot"
+)
+
+// Orphan describes a dataset or snapshot under the filesystem that does not belong to a
+// loaded cast or replica
+type Orphan struct {
+	Kind   string
+	Name   string
+	CastId string
+	Id     string
+}
+
+// FindOrphans lists the datasets without a readable state file and the snapshots that
+// are not the origin of any dataset, below the filesystem and the loaded casts
+func (zm *ZFSManager) FindOrphans() ([]Orphan, error) {
+	zm.mu.Lock()
+	defer zm.mu.Unlock()
+
+	orphans := make([]Orphan, 0)
+
+	zm.l.Debug("looking for orphaned casts")
+	children, err := zm.d.Children(zm.fs.Name)
+	if err != nil {
+		zm.l.Error("failed to read cast datasets", zap.Error(err))
+		return nil, newDatasetError("list children of", zm.fs.Name, err)
+	}
+	for _, ds := range children {
+		if ds.Type != DatasetFilesystem {
+			continue
+		}
+		if _, ok := zm.casts[ds.Name]; !ok {
+			orphans = append(orphans, Orphan{
+				Kind: OrphanCastDataset,
+				Name: ds.Name,
+				Id:   baseName(ds.Name),
+			})
+		}
+	}
+	orphans = append(orphans, findOrphanSnapshots(children, "")...)
+
+	for _, cast := range zm.casts {
+		zm.l.Debug("looking for orphaned replicas", zap.String("cast", cast.id))
+		children, err := zm.d.Children(cast.ds.Name)
+		if err != nil {
+			zm.l.Error("failed to read replica datasets", zap.String("cast", cast.id), zap.Error(err))
+			return nil, newDatasetError("list children of", cast.ds.Name, err)
+		}
+		for _, ds := range children {
+			if ds.Type != DatasetFilesystem {
+				continue
+			}
+			_, loaded := cast.replicas[ds.Name]
+			_, trashed := cast.trash[ds.Name]
+			if !loaded && !trashed {
+				orphans = append(orphans, Orphan{
+					Kind:   OrphanReplicaDataset,
+					Name:   ds.Name,
+					CastId: cast.id,
+					Id:     baseName(ds.Name),
+				})
+			}
+		}
+		orphans = append(orphans, findOrphanSnapshots(children, cast.id)...)
+	}
+
+	return orphans, nil
+}
+
+// DestroyOrphan destroys an orphaned dataset or snapshot. The origin snapshot of a
+// dataset is left behind and will be reported as an orphan afterwards.
+func (zm *ZFSManager) DestroyOrphan(orphan Orphan) error {
+	zm.mu.Lock()
+	defer zm.mu.Unlock()
+
+	ds, err := zm.d.GetDataset(orphan.Name)
+	if err != nil {
+		zm.l.Error("failed to get orphan", zap.String("orphan", orphan.Name), zap.Error(err))
+		return newDatasetError("get", orphan.Name, err)
+	}
+
+	zm.l.Info("destroying orphan", zap.String("kind", orphan.Kind), zap.String("orphan", orphan.Name))
+	err = zm.d.Destroy(ds.Name)
+	if err != nil {
+		zm.l.Error("failed to destroy orphan", zap.String("orphan", orphan.Name), zap.Error(err))
+		return newDatasetError("destroy", ds.Name, err)
+	}
+
+	if ds.Type == DatasetFilesystem {
+		err = zm.d.RemoveMountPoint(ds.Mountpoint)
+		if err != nil {
+			zm.l.Warn("failed to delete mountpoint of orphan", zap.String("orphan", orphan.Name), zap.Error(err))
+		}
+	}
+
+	return nil
+}
+
+// AdoptCastDataset writes a new state file onto an orphaned cast dataset and loads it
+// along with the replicas it contains
+func (zm *ZFSManager) AdoptCastDataset(orphan Orphan) (time.Time, error) {
+	zm.mu.Lock()
+	defer zm.mu.Unlock()
+
+	ds, err := zm.d.GetDataset(orphan.Name)
+	if err != nil {
+		zm.l.Error("failed to get orphan", zap.String("orphan", orphan.Name), zap.Error(err))
+		return time.Time{}, newDatasetError("get", orphan.Name, err)
+	}
+
+	zm.l.Info("adopting cast", zap.String("cast", orphan.Id))
+	cast := &cast{
+		ds:        ds,
+		id:        orphan.Id,
+		replicas:  make(map[string]*replica),
+		timestamp: time.Now().UTC(),
+	}
+	err = zm.saveCastState(cast)
+	if err != nil {
+		return time.Time{}, err
+	}
+	zm.casts[ds.Name] = cast
+
+	err = zm.loadCastReplicas(cast)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return cast.timestamp, nil
+}
+
+// AdoptReplicaDataset writes a new state file onto an orphaned replica dataset and
+// loads it with the provided port, ports of the port slots and resources
+func (zm *ZFSManager) AdoptReplicaDataset(orphan Orphan, port int32, ports map[string]int32, resources map[string]string) error {
+	zm.mu.Lock()
+	defer zm.mu.Unlock()
+
+	castName := zm.getCastFullName(orphan.CastId)
+	if _, ok := zm.casts[castName]; !ok {
+		zm.l.Error("cannot adopt replica, cast not found", zap.String("cast", orphan.CastId), zap.String("replica", orphan.Id))
+		return CastNotFoundError{orphan.CastId}
+	}
+	cast := zm.casts[castName]
+
+	ds, err := zm.d.GetDataset(orphan.Name)
+	if err != nil {
+		zm.l.Error("failed to get orphan", zap.String("orphan", orphan.Name), zap.Error(err))
+		return newDatasetError("get", orphan.Name, err)
+	}
+
+	zm.l.Info("adopting replica", zap.String("cast", orphan.CastId), zap.String("replica", orphan.Id))
+	replica := &replica{
+		ds:        ds,
+		id:        orphan.Id,
+		parent:    cast,
+		port:      port,
+		ports:     copyPorts(ports),
+		resources: copyResources(resources),
+	}
+	err = zm.syncCheckpoints(replica)
+	if err != nil {
+		return err
+	}
+	err = zm.saveReplicaState(replica)
+	if err != nil {
+		return err
+	}
+	cast.replicas[ds.Name] = replica
+
+	return nil
+}
+
+// findOrphanSnapshots returns the snapshots of a listing that are not the origin of any
+// filesystem in the same listing
+func findOrphanSnapshots(children []*Dataset, castId string) []Orphan {
+	origins := make(map[string]bool)
+	for _, ds := range children {
+		if ds.Type == DatasetFilesystem && ds.Origin != "" {
+			origins[ds.Origin] = true
+		}
+	}
+
+	orphans := make([]Orphan, 0)
+	for _, ds := range children {
+		if ds.Type == DatasetSnapshot && !origins[ds.Name] {
+			orphans = append(orphans, Orphan{
+				Kind:   OrphanSnapshot,
+				Name:   ds.Name,
+				CastId: castId,
+				Id:     baseSnapshotName(ds.Name),
+			})
+		}
+	}
+
+	return orphans
+}
+
+// baseName returns the last component of a dataset name
+func baseName(name string) string {
+	return name[strings.LastIndex(name, "/")+1:]
+}
+
+// baseSnapshotName returns the part of a snapshot name after the @
+func baseSnapshotName(name string) string {
+	return name[strings.Index(name, "@")+1:]
+}
@@ -0,0 +1,179 @@
+package zfsmanager
+
+import (
+	"strings"
+
+	"github.com/dnsinogeorgos/conductor/internal/rollback"
+	"go.uber.org/zap"
+)
+
+// refreshSuffix is appended to the names of the dataset, the snapshot and the mount point
+// of a cast while it is refreshed
+const refreshSuffix = ":refresh"
+
+// GetRefreshMountPoint returns the mount point path of the refreshed dataset of a cast
+// until it replaces the cast
+func (zm *ZFSManager) GetRefreshMountPoint(id string) string {
+	return zm.GetCastMountPoint(id) + refreshSuffix
+}
+
+// CreateRefreshDataset creates a refreshed dataset for a loaded cast from a new snapshot
+// of the filesystem, next to the existing cast. The refreshed dataset carries the state
+// of the cast but is not loaded until SwapRefreshDataset replaces the cast with it. The
+// hooks run without the lock, which is only taken for the clone. Every completed step is
+// reverted if a later one fails.
+func (zm *ZFSManager) CreateRefreshDataset(id string, strategy string, hooks CastHooks) (state CastState, err error) {
+	name := zm.getCastFullName(id)
+
+	zm.mu.Lock()
+	_, ok := zm.casts[name]
+	zm.mu.Unlock()
+	if !ok {
+		zm.l.Error("cannot refresh cast, not found", zap.String("cast", id))
+		return CastState{}, CastNotFoundError{id}
+	}
+
+	rb := rollback.New(zm.l)
+	defer func() {
+		if err != nil {
+			rb.Run()
+		}
+	}()
+
+	snapshot, timestamp, duration, err := zm.snapshotSource(id, id+refreshSuffix, strategy, hooks, rb)
+	if err != nil {
+		return CastState{}, err
+	}
+
+	zm.mu.Lock()
+	defer zm.mu.Unlock()
+
+	old, ok := zm.casts[name]
+	if !ok {
+		zm.l.Error("cannot refresh cast, not found", zap.String("cast", id))
+		return CastState{}, CastNotFoundError{id}
+	}
+
+	cast := &cast{
+		id:              id,
+		replicas:        make(map[string]*replica),
+		timestamp:       timestamp,
+		quiesce:         strategy,
+		quiesceDuration: duration,
+		expiresAt:       old.expiresAt,
+		protected:       old.protected,
+		properties:      old.properties,
+	}
+	err = zm.cloneCast(cast, snapshot.Name, zm.getRefreshFullName(id), zm.GetRefreshMountPoint(id), rb)
+	if err != nil {
+		return CastState{}, err
+	}
+
+	return cast.state(), nil
+}
+
+// PurgeRefreshDataset destroys the refreshed dataset of a cast and its snapshot, skipping
+// the ones that do not exist
+func (zm *ZFSManager) PurgeRefreshDataset(id string) error {
+	zm.mu.Lock()
+	defer zm.mu.Unlock()
+
+	zm.l.Debug("purging refreshed dataset of cast", zap.String("cast", id))
+	return zm.purge(zm.getRefreshFullName(id), zm.fs.Name+"@"+id+refreshSuffix)
+}
+
+// SwapRefreshDataset destroys a cast and replaces it with its refreshed dataset, which is
+// renamed and mounted in its place and loaded. The cast must not have replicas. Every
+// step is skipped if it has already been done, so that an interrupted swap can be
+// completed by calling it again.
+func (zm *ZFSManager) SwapRefreshDataset(id string) (CastState, error) {
+	zm.mu.Lock()
+	defer zm.mu.Unlock()
+
+	name := zm.getCastFullName(id)
+	tempName := zm.getRefreshFullName(id)
+
+	if temp, err := zm.d.GetDataset(tempName); err == nil {
+		if old, ok := zm.casts[name]; ok {
+			if len(old.replicas) != 0 || len(old.trash) != 0 {
+				zm.l.Error("cannot swap refreshed cast, not empty", zap.String("cast", id))
+				return CastState{}, CastNotEmpty{id}
+			}
+
+			err = zm.destroyCast(old)
+			if err != nil {
+				return CastState{}, err
+			}
+		}
+
+		snapshot := zm.fs.Name + "@" + id
+		if temp.Origin != snapshot {
+			if _, err := zm.d.GetDataset(snapshot); err == nil {
+				zm.l.Debug("deleting leftover snapshot of cast", zap.String("cast", id))
+				err = zm.d.Destroy(snapshot)
+				if err != nil {
+					zm.l.Error("failed to delete leftover snapshot of cast", zap.String("cast", id), zap.Error(err))
+					return CastState{}, newDatasetError("destroy", snapshot, err)
+				}
+			}
+
+			zm.l.Debug("renaming refreshed snapshot", zap.String("cast", id))
+			_, err = zm.d.Rename(temp.Origin, snapshot)
+			if err != nil {
+				zm.l.Error("failed to rename refreshed snapshot", zap.String("cast", id), zap.Error(err))
+				return CastState{}, newDatasetError("rename", temp.Origin, err)
+			}
+		}
+
+		zm.l.Debug("renaming refreshed dataset", zap.String("cast", id))
+		_, err = zm.d.Rename(tempName, name)
+		if err != nil {
+			zm.l.Error("failed to rename refreshed dataset", zap.String("cast", id), zap.Error(err))
+			return CastState{}, newDatasetError("rename", tempName, err)
+		}
+	} else if cast, ok := zm.casts[name]; ok {
+		zm.l.Debug("refreshed cast is already loaded", zap.String("cast", id))
+		return cast.state(), nil
+	}
+
+	zm.l.Debug("mounting refreshed dataset", zap.String("cast", id))
+	err := zm.d.SetProperty(name, "mountpoint", zm.GetCastMountPoint(id))
+	if err != nil {
+		zm.l.Error("failed to mount refreshed dataset", zap.String("cast", id), zap.Error(err))
+		return CastState{}, newDatasetError("set mountpoint of", name, err)
+	}
+	err = zm.d.RemoveMountPoint(zm.GetRefreshMountPoint(id))
+	if err != nil {
+		zm.l.Warn("failed to delete mountpoint of refreshed dataset", zap.String("cast", id), zap.Error(err))
+	}
+
+	ds, err := zm.d.GetDataset(name)
+	if err != nil {
+		zm.l.Error("failed to get refreshed dataset", zap.String("cast", id), zap.Error(err))
+		return CastState{}, newDatasetError("get", name, err)
+	}
+
+	cast := &cast{
+		ds:       ds,
+		replicas: make(map[string]*replica),
+	}
+	err = zm.loadCastState(cast)
+	if err != nil {
+		return CastState{}, err
+	}
+
+	zm.l.Info("swapped refreshed cast", zap.String("cast", id))
+	zm.casts[name] = cast
+
+	return cast.state(), nil
+}
+
+// getRefreshFullName returns the full dataset name of the refreshed dataset of a cast
+func (zm *ZFSManager) getRefreshFullName(id string) string {
+	return zm.getCastFullName(id) + refreshSuffix
+}
+
+// isRefreshName reports whether a dataset name belongs to the refreshed dataset of a cast
+func isRefreshName(name string) bool {
+	return strings.HasSuffix(name, refreshSuffix)
+}
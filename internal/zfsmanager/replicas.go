@@ -2,28 +2,48 @@ package zfsmanager
 
 import (
 	"encoding/json"
-	"io/ioutil"
-	"os"
 	"strings"
+	"time"
 
-	"github.com/mistifyio/go-zfs"
+	"github.com/dnsinogeorgos/conductor/internal/rollback"
 	"go.uber.org/zap"
 )
 
 const replicaStateFile = ".replica"
 
+// resetSuffix is appended to the name and the mount point of the new dataset of a replica
+// while it is reset, until it replaces the replica
+const resetSuffix = ":reset"
+
 // ReplicaState describes the replica state stored on the dataset
 type ReplicaState struct {
-	Id   string `json:"id"`
-	Port int32  `json:"port"`
+	Id          string            `json:"id"`
+	Port        int32             `json:"port"`
+	Checkpoints []CheckpointState `json:"checkpoints,omitempty"`
+	ExpiresAt   *time.Time        `json:"expiresAt,omitempty"`
+	DeletedAt   *time.Time        `json:"deletedAt,omitempty"`
+	Protected   bool              `json:"protected,omitempty"`
+	// Properties are the ZFS properties the dataset is cloned with besides its mountpoint
+	Properties map[string]string `json:"properties,omitempty"`
+	// Ports maps the port slots of the replica to their ports
+	Ports map[string]int32 `json:"ports,omitempty"`
+	// Resources maps the resources of the replica to their values
+	Resources map[string]string `json:"resources,omitempty"`
 }
 
 // replica contains the state of a replica and it's parent relationship
 type replica struct {
-	id     string
-	ds     *zfs.Dataset
-	parent *cast
-	port   int32
+	id          string
+	ds          *Dataset
+	parent      *cast
+	port        int32
+	checkpoints []CheckpointState
+	expiresAt   time.Time
+	deletedAt   time.Time
+	protected   bool
+	properties  map[string]string
+	ports       map[string]int32
+	resources   map[string]string
 }
 
 // GetReplicaMountPoint returns the mount point path of the replica
@@ -76,9 +96,123 @@ func (zm *ZFSManager) GetReplicaPort(castId, id string) (int32, error) {
 	return replica.port, nil
 }
 
+// GetReplicaPorts retrieves the ports of the port slots from a replica state
+func (zm *ZFSManager) GetReplicaPorts(castId, id string) (map[string]int32, error) {
+	zm.mu.Lock()
+	defer zm.mu.Unlock()
+
+	replica, err := zm.lookupReplica(castId, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return copyPorts(replica.ports), nil
+}
+
+// GetReplicaResources retrieves the values of the resources from a replica state
+func (zm *ZFSManager) GetReplicaResources(castId, id string) (map[string]string, error) {
+	zm.mu.Lock()
+	defer zm.mu.Unlock()
+
+	replica, err := zm.lookupReplica(castId, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return copyResources(replica.resources), nil
+}
+
+// GetReplicaState returns the state of a replica
+func (zm *ZFSManager) GetReplicaState(castId, id string) (ReplicaState, error) {
+	zm.mu.Lock()
+	defer zm.mu.Unlock()
+
+	replica, err := zm.lookupReplica(castId, id)
+	if err != nil {
+		return ReplicaState{}, err
+	}
+
+	return replica.state(), nil
+}
+
+// GetReplicaExpiry retrieves the expiry from a replica state. The zero time means the
+// replica does not expire.
+func (zm *ZFSManager) GetReplicaExpiry(castId, id string) (time.Time, error) {
+	zm.mu.Lock()
+	defer zm.mu.Unlock()
+
+	replica, err := zm.lookupReplica(castId, id)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return replica.expiresAt, nil
+}
+
+// SetReplicaExpiry records the time after which a replica is reaped in its state. The
+// zero time removes the expiry.
+func (zm *ZFSManager) SetReplicaExpiry(castId, id string, expiresAt time.Time) error {
+	zm.mu.Lock()
+	defer zm.mu.Unlock()
+
+	replica, err := zm.lookupReplica(castId, id)
+	if err != nil {
+		return err
+	}
+
+	previous := replica.expiresAt
+	replica.expiresAt = expiresAt
+	err = zm.saveReplicaState(replica)
+	if err != nil {
+		replica.expiresAt = previous
+		return err
+	}
+
+	return nil
+}
+
+// GetReplicaProtection retrieves from a replica state whether it is protected from
+// deletion
+func (zm *ZFSManager) GetReplicaProtection(castId, id string) (bool, error) {
+	zm.mu.Lock()
+	defer zm.mu.Unlock()
+
+	replica, err := zm.lookupReplica(castId, id)
+	if err != nil {
+		return false, err
+	}
+
+	return replica.protected, nil
+}
+
+// SetReplicaProtection records in the state of a replica whether it is protected from
+// deletion
+func (zm *ZFSManager) SetReplicaProtection(castId, id string, protected bool) error {
+	zm.mu.Lock()
+	defer zm.mu.Unlock()
+
+	replica, err := zm.lookupReplica(castId, id)
+	if err != nil {
+		return err
+	}
+
+	previous := replica.protected
+	replica.protected = protected
+	err = zm.saveReplicaState(replica)
+	if err != nil {
+		replica.protected = previous
+		return err
+	}
+
+	return nil
+}
+
 // CreateReplicaDataset orchestrates the creation of a replica dataset onto the underlying
-// ZFS filesystem
-func (zm *ZFSManager) CreateReplicaDataset(castId, id string, port int32) error {
+// ZFS filesystem. The port, the ports of the port slots and the resources are recorded
+// in its state. The replica expires at the provided time unless it is zero and its
+// dataset is cloned with the provided properties, which are kept when it is reset. Every
+// completed step is reverted if a later one fails.
+func (zm *ZFSManager) CreateReplicaDataset(castId, id string, port int32, ports map[string]int32, resources map[string]string, expiresAt time.Time, properties map[string]string) (err error) {
 	zm.mu.Lock()
 	defer zm.mu.Unlock()
 
@@ -97,32 +231,50 @@ func (zm *ZFSManager) CreateReplicaDataset(castId, id string, port int32) error
 		return ReplicaAlreadyExistsError{castId, id}
 	}
 
+	rb := rollback.New(zm.l)
+	defer func() {
+		if err != nil {
+			rb.Run()
+		}
+	}()
+
 	zm.l.Debug("snapshotting replica", zap.String("cast", castId), zap.String("replica", id))
-	snapshot, err := cast.ds.Snapshot(id, false)
+	snapshot, err := zm.d.Snapshot(cast.ds.Name, id)
 	if err != nil {
-		zm.l.Fatal("failed to snapshot replica ", zap.String("cast", castId), zap.String("replica", id), zap.Error(err))
-		return err
+		zm.l.Error("failed to snapshot replica ", zap.String("cast", castId), zap.String("replica", id), zap.Error(err))
+		return newDatasetError("snapshot", cast.ds.Name, err)
 	}
+	rb.Add("destroy snapshot of replica", func() error {
+		return zm.d.Destroy(snapshot.Name)
+	})
 
-	mountPoint := zm.GetReplicaMountPoint(castId, id)
-	p := map[string]string{
-		"mountpoint": mountPoint,
-	}
+	p := cloneProperties(zm.GetReplicaMountPoint(castId, id), properties)
 
-	zm.l.Debug("cloning snapshot for replica", zap.String("cast", castId), zap.String("replica", id))
+	zm.l.Debug("cloning snapshot for replica", zap.String("cast", castId), zap.String("replica", id), zap.Strings("properties", propertyFields(properties)))
 	dsName := zm.fs.Name + "/" + castId + "/" + id
-	ds, err := snapshot.Clone(dsName, p)
+	ds, err := zm.d.Clone(snapshot.Name, dsName, p)
 	if err != nil {
-		zm.l.Fatal("failed to clone snapshot", zap.String("cast", castId), zap.String("replica", id), zap.Error(err))
-		return err
+		zm.l.Error("failed to clone snapshot", zap.String("cast", castId), zap.String("replica", id), zap.Error(err))
+		return newDatasetError("clone", snapshot.Name, err)
 	}
+	rb.Add("destroy replica dataset", func() error {
+		err := zm.d.Destroy(ds.Name)
+		if err != nil {
+			return err
+		}
+		return zm.d.RemoveMountPoint(ds.Mountpoint)
+	})
 
 	zm.l.Debug("preparing replica", zap.String("cast", castId), zap.String("replica", id))
 	replica := &replica{
-		ds:     ds,
-		id:     id,
-		parent: cast,
-		port:   port,
+		ds:         ds,
+		id:         id,
+		parent:     cast,
+		port:       port,
+		ports:      copyPorts(ports),
+		resources:  copyResources(resources),
+		expiresAt:  expiresAt,
+		properties: copyProperties(properties),
 	}
 
 	err = zm.saveReplicaState(replica)
@@ -159,39 +311,194 @@ func (zm *ZFSManager) DeleteReplicaDataset(castId, id string) error {
 
 	replica := cast.replicas[name]
 
-	zm.l.Debug("getting parent snapshot", zap.String("cast", castId), zap.String("replica", id))
-	origin, err := zfs.GetDataset(replica.ds.Origin)
+	if dependent, ok := zm.getDependentCast(castId, id, ""); ok {
+		zm.l.Error("cannot delete replica, it is the source of a cast", zap.String("cast", castId), zap.String("replica", id), zap.String("dependent", dependent))
+		return ReplicaInUseError{castId, id, dependent}
+	}
+
+	err := zm.destroyReplica(replica)
 	if err != nil {
-		zm.l.Fatal("failed to get parent snapshot", zap.String("cast", castId), zap.String("replica", id), zap.Error(err))
 		return err
 	}
 
-	zm.l.Debug("deleting replica dataset", zap.String("cast", castId), zap.String("replica", id))
-	err = replica.ds.Destroy(0)
+	zm.l.Debug("deleting replica", zap.String("cast", castId), zap.String("replica", id))
+	delete(cast.replicas, name)
+
+	return nil
+}
+
+// destroyReplica destroys the dataset of a replica along with its checkpoints and its
+// origin snapshot
+func (zm *ZFSManager) destroyReplica(replica *replica) error {
+	castId := replica.parent.id
+	err := zm.destroyCheckpoints(replica)
 	if err != nil {
-		zm.l.Fatal("failed to delete replica dataset", zap.String("cast", castId), zap.String("replica", id), zap.Error(err))
 		return err
 	}
 
-	zm.l.Debug("deleting parent snapshot", zap.String("cast", castId), zap.String("replica", id))
-	err = origin.Destroy(0)
+	zm.l.Debug("deleting replica dataset", zap.String("cast", castId), zap.String("replica", replica.id))
+	err = zm.d.Destroy(replica.ds.Name)
+	if err != nil {
+		zm.l.Error("failed to delete replica dataset", zap.String("cast", castId), zap.String("replica", replica.id), zap.Error(err))
+		return newDatasetError("destroy", replica.ds.Name, err)
+	}
+
+	// the replica is gone at this point, failures to clean up after it are left behind
+	// to be found by reconciliation instead of failing the deletion
+	zm.l.Debug("deleting parent snapshot", zap.String("cast", castId), zap.String("replica", replica.id))
+	err = zm.d.Destroy(replica.ds.Origin)
+	if err != nil {
+		zm.l.Error("failed to delete parent snapshot", zap.String("cast", castId), zap.String("replica", replica.id), zap.Error(err))
+	}
+
+	err = zm.d.RemoveMountPoint(replica.ds.Mountpoint)
+	if err != nil {
+		zm.l.Warn("failed to delete mountpoint", zap.String("cast", castId), zap.String("replica", replica.id), zap.Error(err))
+	}
+
+	return nil
+}
+
+// ResetReplicaDataset replaces a replica dataset with a new clone of its origin snapshot,
+// discarding every change made to it. The clone is created next to the replica with its
+// properties and state and only replaces it once it is ready, so a failed reset leaves
+// the replica as it was. A replica with checkpoints is not reset. A reset that was
+// interrupted after the replica dataset was destroyed is completed by calling it again.
+func (zm *ZFSManager) ResetReplicaDataset(castId, id string) (err error) {
+	zm.mu.Lock()
+	defer zm.mu.Unlock()
+
+	castName := zm.getCastFullName(castId)
+	name := zm.getReplicaFullName(castId, id)
+	tempName := name + resetSuffix
+
+	if _, ok := zm.casts[castName]; !ok {
+		zm.l.Error("cannot reset replica, cast not found", zap.String("cast", castId), zap.String("replica", id))
+		return CastNotFoundError{castId}
+	}
+
+	cast := zm.casts[castName]
+
+	if dependent, ok := zm.getDependentCast(castId, id, ""); ok {
+		zm.l.Error("cannot reset replica, it is the source of a cast", zap.String("cast", castId), zap.String("replica", id), zap.String("dependent", dependent))
+		return ReplicaInUseError{castId, id, dependent}
+	}
+
+	r, ok := cast.replicas[name]
+	if !ok {
+		if _, err := zm.d.GetDataset(tempName); err != nil {
+			zm.l.Error("cannot reset replica, not found", zap.String("cast", castId), zap.String("replica", id))
+			return ReplicaNotFoundError{castId, id}
+		}
+
+		zm.l.Warn("completing interrupted reset of replica", zap.String("cast", castId), zap.String("replica", id))
+		return zm.swapResetDataset(cast, id)
+	}
+
+	if len(r.checkpoints) != 0 {
+		zm.l.Error("cannot reset replica, it has checkpoints", zap.String("cast", castId), zap.String("replica", id))
+		return ReplicaHasCheckpointsError{castId, id}
+	}
+
+	if _, err := zm.d.GetDataset(tempName); err == nil {
+		zm.l.Debug("destroying leftover dataset of reset", zap.String("cast", castId), zap.String("replica", id))
+		err = zm.d.Destroy(tempName)
+		if err != nil {
+			zm.l.Error("failed to destroy leftover dataset of reset", zap.String("cast", castId), zap.String("replica", id), zap.Error(err))
+			return newDatasetError("destroy", tempName, err)
+		}
+	}
+
+	rb := rollback.New(zm.l)
+	defer func() {
+		if err != nil {
+			rb.Run()
+		}
+	}()
+
+	zm.l.Debug("cloning origin snapshot for replica", zap.String("cast", castId), zap.String("replica", id))
+	p := cloneProperties(zm.GetReplicaMountPoint(castId, id)+resetSuffix, r.properties)
+	ds, err := zm.d.Clone(r.ds.Origin, tempName, p)
+	if err != nil {
+		zm.l.Error("failed to clone origin snapshot", zap.String("cast", castId), zap.String("replica", id), zap.Error(err))
+		return newDatasetError("clone", r.ds.Origin, err)
+	}
+	rb.Add("destroy reset dataset of replica", func() error {
+		err := zm.d.Destroy(ds.Name)
+		if err != nil {
+			return err
+		}
+		return zm.d.RemoveMountPoint(ds.Mountpoint)
+	})
+
+	reset := *r
+	reset.ds = ds
+	err = zm.saveReplicaState(&reset)
 	if err != nil {
-		zm.l.Fatal("failed to delete parent snapshot", zap.String("cast", castId), zap.String("replica", id), zap.Error(err))
 		return err
 	}
+	rb.Discard()
 
-	zm.l.Debug("deleting replica", zap.String("cast", castId), zap.String("replica", id))
-	delete(cast.replicas, name)
+	return zm.swapResetDataset(cast, id)
+}
+
+// swapResetDataset destroys a replica and replaces it with its reset dataset, which is
+// mounted and renamed in its place and loaded. The destroyed replica is unloaded, so that
+// an interrupted swap is completed by calling it again.
+func (zm *ZFSManager) swapResetDataset(cast *cast, id string) error {
+	name := zm.getReplicaFullName(cast.id, id)
+	tempName := name + resetSuffix
+	mountPoint := zm.GetReplicaMountPoint(cast.id, id)
+
+	if old, ok := cast.replicas[name]; ok {
+		zm.l.Debug("destroying replica dataset", zap.String("cast", cast.id), zap.String("replica", id))
+		err := zm.d.Destroy(old.ds.Name)
+		if err != nil {
+			zm.l.Error("failed to destroy replica dataset", zap.String("cast", cast.id), zap.String("replica", id), zap.Error(err))
+			return newDatasetError("destroy", old.ds.Name, err)
+		}
+		delete(cast.replicas, name)
+	}
 
-	mountPoint := replica.ds.Mountpoint
-	err = os.Remove(mountPoint)
+	zm.l.Debug("mounting reset dataset", zap.String("cast", cast.id), zap.String("replica", id))
+	err := zm.d.SetProperty(tempName, "mountpoint", mountPoint)
+	if err != nil {
+		zm.l.Error("failed to mount reset dataset", zap.String("cast", cast.id), zap.String("replica", id), zap.Error(err))
+		return newDatasetError("set mountpoint of", tempName, err)
+	}
+	err = zm.d.RemoveMountPoint(mountPoint + resetSuffix)
+	if err != nil {
+		zm.l.Warn("failed to delete mountpoint of reset dataset", zap.String("cast", cast.id), zap.String("replica", id), zap.Error(err))
+	}
+
+	zm.l.Debug("renaming reset dataset", zap.String("cast", cast.id), zap.String("replica", id))
+	ds, err := zm.d.Rename(tempName, name)
+	if err != nil {
+		zm.l.Error("failed to rename reset dataset", zap.String("cast", cast.id), zap.String("replica", id), zap.Error(err))
+		return newDatasetError("rename", tempName, err)
+	}
+
+	r := &replica{
+		ds:     ds,
+		parent: cast,
+	}
+	err = zm.loadReplicaState(r)
 	if err != nil {
 		return err
 	}
 
+	zm.l.Debug("resetting replica", zap.String("cast", cast.id), zap.String("replica", id))
+	cast.replicas[name] = r
+
 	return nil
 }
 
+// isResetName reports whether a dataset name belongs to the new dataset of a replica that
+// is being reset
+func isResetName(name string) bool {
+	return strings.HasSuffix(name, resetSuffix)
+}
+
 // getReplicaFullName returns the full dataset name of the replica
 func (zm *ZFSManager) getReplicaFullName(castId, id string) string {
 	return zm.poolName + "/" + zm.fsName + "/" + castId + "/" + id
@@ -199,23 +506,20 @@ func (zm *ZFSManager) getReplicaFullName(castId, id string) string {
 
 // saveReplicaState saves the replica state into the replica dataset
 func (zm *ZFSManager) saveReplicaState(replica *replica) error {
-	ss := strings.Split(replica.ds.Name, "/")
-	s := ss[len(ss)-1] + "/" + replicaStateFile
+	path := replica.ds.Mountpoint + "/" + replicaStateFile
 
 	zm.l.Debug("marshaling replica state to json", zap.String("cast", replica.parent.id), zap.String("replica", replica.id))
-	b, err := json.MarshalIndent(&ReplicaState{
-		Id:   replica.id,
-		Port: replica.port,
-	}, "", "  ")
+	state := replica.state()
+	b, err := json.MarshalIndent(&state, "", "  ")
 	if err != nil {
-		zm.l.Error("failed to marshal replica state json", zap.String("path", zm.replicaPath+"/"+s))
+		zm.l.Error("failed to marshal replica state json", zap.String("path", path))
 		return err
 	}
 
-	zm.l.Debug("writing cast state file", zap.String("path", replica.ds.Mountpoint+"/"+replicaStateFile))
-	err = ioutil.WriteFile(replica.ds.Mountpoint+"/"+replicaStateFile, b, 0644)
+	zm.l.Debug("writing replica state file", zap.String("path", path))
+	err = zm.d.WriteFile(replica.ds, replicaStateFile, b)
 	if err != nil {
-		zm.l.Error("failed to write replica state file", zap.String("path", replica.ds.Mountpoint+"/"+replicaStateFile))
+		zm.l.Error("failed to write replica state file", zap.String("path", path))
 		return err
 	}
 
@@ -231,21 +535,31 @@ func (zm *ZFSManager) loadReplicas(castId string) error {
 	castName := zm.getCastFullName(castId)
 
 	if _, ok := zm.casts[castName]; !ok {
-		zm.l.Fatal("cannot load cast, not found", zap.String("cast", castId))
-		return CastNotFoundError{castName}
+		zm.l.Error("cannot load cast, not found", zap.String("cast", castId))
+		return CastNotFoundError{castId}
 	}
-	cast := zm.casts[castName]
 
-	zm.l.Debug("reading replica datasets", zap.String("cast", castId))
-	children, err := cast.ds.Children(1)
+	return zm.loadCastReplicas(zm.casts[castName])
+}
+
+// loadCastReplicas populates the state of the replicas found inside a cast dataset.
+// Datasets without a readable state file are skipped and left to reconciliation.
+func (zm *ZFSManager) loadCastReplicas(cast *cast) error {
+	zm.l.Debug("reading replica datasets", zap.String("cast", cast.id))
+	children, err := zm.d.Children(cast.ds.Name)
 	if err != nil {
-		zm.l.Fatal("failed to read replica datasets", zap.Error(err))
-		return err
+		zm.l.Error("failed to read replica datasets", zap.Error(err))
+		return newDatasetError("list children of", cast.ds.Name, err)
 	}
 
-	zm.l.Debug("iterating replica datasets", zap.String("cast", castId))
+	zm.l.Debug("iterating replica datasets", zap.String("cast", cast.id))
+	cast.trash = make(map[string]*replica)
 	for _, replicaDataset := range children {
-		if replicaDataset.Type == "filesystem" {
+		if isResetName(replicaDataset.Name) {
+			zm.l.Debug("skipping replica of unfinished reset", zap.String("replica", replicaDataset.Name))
+			continue
+		}
+		if replicaDataset.Type == DatasetFilesystem {
 			zm.l.Debug("loading replica", zap.String("replica", replicaDataset.Name), zap.String("cast", cast.id))
 
 			replica := &replica{
@@ -253,40 +567,111 @@ func (zm *ZFSManager) loadReplicas(castId string) error {
 				parent: cast,
 			}
 			err := zm.loadReplicaState(replica)
+			if err != nil {
+				zm.l.Warn("skipping replica without state", zap.String("replica", replicaDataset.Name), zap.Error(err))
+				continue
+			}
+			err = zm.syncCheckpoints(replica)
 			if err != nil {
 				return err
 			}
 
+			if isTrashName(replicaDataset.Name) {
+				cast.trash[replicaDataset.Name] = replica
+				continue
+			}
 			cast.replicas[replicaDataset.Name] = replica
 		}
 	}
 
-	zm.l.Info("loaded replicas", zap.Int("replicas", len(cast.replicas)), zap.String("cast", cast.id))
+	zm.l.Info("loaded replicas", zap.Int("replicas", len(cast.replicas)), zap.Int("trashed", len(cast.trash)), zap.String("cast", cast.id))
 	return nil
 }
 
 // loadReplicaState loads the state stored inside the replica dataset
 func (zm *ZFSManager) loadReplicaState(replica *replica) error {
-	zm.l.Debug("reading replica state", zap.String("path", zm.replicaPath))
-	ss := strings.Split(replica.ds.Name, "/")
-	s := ss[len(ss)-1] + "/" + replicaStateFile
-	f, e := ioutil.ReadFile(zm.replicaPath + "/" + replica.parent.id + "/" + s)
+	path := replica.ds.Mountpoint + "/" + replicaStateFile
+
+	zm.l.Debug("reading replica state", zap.String("path", path))
+	f, e := zm.d.ReadFile(replica.ds, replicaStateFile)
 	if e != nil {
-		zm.l.Error("failed to read replica state file", zap.String("path", zm.replicaPath+"/"+s))
+		zm.l.Error("failed to read replica state file", zap.String("path", path))
 		return e
 	}
 
-	zm.l.Debug("unmarshaling replica state json", zap.String("path", zm.replicaPath+"/"+s))
+	zm.l.Debug("unmarshaling replica state json", zap.String("path", path))
 	freplica := &ReplicaState{}
 	e = json.Unmarshal(f, freplica)
 	if e != nil {
-		zm.l.Error("failed to unmarshal replica state json", zap.String("path", zm.replicaPath+"/"+s))
+		zm.l.Error("failed to unmarshal replica state json", zap.String("path", path))
 		return e
 	}
 
 	zm.l.Debug("loading replica state", zap.String("replica", freplica.Id))
 	replica.id = freplica.Id
 	replica.port = freplica.Port
+	replica.checkpoints = freplica.Checkpoints
+	if freplica.ExpiresAt != nil {
+		replica.expiresAt = *freplica.ExpiresAt
+	}
+	if freplica.DeletedAt != nil {
+		replica.deletedAt = *freplica.DeletedAt
+	}
+	replica.protected = freplica.Protected
+	replica.properties = freplica.Properties
+	replica.ports = freplica.Ports
+	replica.resources = freplica.Resources
 
 	return nil
 }
+
+// state returns the state of the replica as it is stored on the dataset
+func (r *replica) state() ReplicaState {
+	state := ReplicaState{
+		Id:          r.id,
+		Port:        r.port,
+		Checkpoints: r.checkpoints,
+		Protected:   r.protected,
+		Properties:  copyProperties(r.properties),
+		Ports:       copyPorts(r.ports),
+		Resources:   copyResources(r.resources),
+	}
+	if !r.expiresAt.IsZero() {
+		expiresAt := r.expiresAt
+		state.ExpiresAt = &expiresAt
+	}
+	if !r.deletedAt.IsZero() {
+		deletedAt := r.deletedAt
+		state.DeletedAt = &deletedAt
+	}
+
+	return state
+}
+
+// copyPorts returns a copy of the ports of the port slots, or nil if there are none
+func copyPorts(ports map[string]int32) map[string]int32 {
+	if len(ports) == 0 {
+		return nil
+	}
+
+	p := make(map[string]int32, len(ports))
+	for slot, port := range ports {
+		p[slot] = port
+	}
+
+	return p
+}
+
+// copyResources returns a copy of the values of the resources, or nil if there are none
+func copyResources(resources map[string]string) map[string]string {
+	if len(resources) == 0 {
+		return nil
+	}
+
+	r := make(map[string]string, len(resources))
+	for resource, value := range resources {
+		r[resource] = value
+	}
+
+	return r
+}
@@ -0,0 +1,252 @@
+package zfsmanager
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCreateDeleteReplicaDataset(t *testing.T) {
+	zm := newTestManager(t)
+
+	_, err := zm.CreateCastDataset("c1", "unit", noHooks, time.Time{}, nil)
+	if err != nil {
+		t.Fatalf("CreateCastDataset() error = %v", err)
+	}
+	err = zm.CreateReplicaDataset("c1", "r1", 3307, nil, nil, time.Time{}, nil)
+	if err != nil {
+		t.Fatalf("CreateReplicaDataset() error = %v", err)
+	}
+
+	err = zm.CreateReplicaDataset("c1", "r1", 3308, nil, nil, time.Time{}, nil)
+	if _, ok := err.(ReplicaAlreadyExistsError); !ok {
+		t.Errorf("CreateReplicaDataset() of existing replica error = %v, want ReplicaAlreadyExistsError", err)
+	}
+	err = zm.CreateReplicaDataset("c2", "r1", 3308, nil, nil, time.Time{}, nil)
+	if _, ok := err.(CastNotFoundError); !ok {
+		t.Errorf("CreateReplicaDataset() on missing cast error = %v, want CastNotFoundError", err)
+	}
+
+	ids, err := zm.GetReplicaIds("c1")
+	if err != nil || !reflect.DeepEqual(ids, []string{"r1"}) {
+		t.Errorf("GetReplicaIds() = %v, %v, want [r1]", ids, err)
+	}
+
+	// a new manager on the same pool loads the replica from its state file
+	reloaded := newTestManagerWithDriver(t, zm.d)
+	reloaded.MustLoad()
+	port, err := reloaded.GetReplicaPort("c1", "r1")
+	if err != nil || port != 3307 {
+		t.Errorf("GetReplicaPort() after reload = %d, %v, want 3307", port, err)
+	}
+
+	err = zm.DeleteCastDataset("c1")
+	if _, ok := err.(CastNotEmpty); !ok {
+		t.Errorf("DeleteCastDataset() with replica error = %v, want CastNotEmpty", err)
+	}
+
+	err = zm.DeleteReplicaDataset("c1", "r1")
+	if err != nil {
+		t.Fatalf("DeleteReplicaDataset() error = %v", err)
+	}
+	err = zm.DeleteReplicaDataset("c1", "r1")
+	if _, ok := err.(ReplicaNotFoundError); !ok {
+		t.Errorf("DeleteReplicaDataset() of deleted replica error = %v, want ReplicaNotFoundError", err)
+	}
+	if _, err := zm.d.GetDataset(zm.fs.Name + "/c1@r1"); err == nil {
+		t.Error("snapshot of deleted replica still exists")
+	}
+	err = zm.DeleteCastDataset("c1")
+	if err != nil {
+		t.Errorf("DeleteCastDataset() error = %v", err)
+	}
+}
+
+func TestCreateReplicaDatasetFailedClone(t *testing.T) {
+	d := &failingDriver{MemoryDriver: NewMemoryDriver()}
+	zm := newTestManagerWithDriver(t, d)
+
+	_, err := zm.CreateCastDataset("c1", "unit", noHooks, time.Time{}, nil)
+	if err != nil {
+		t.Fatalf("CreateCastDataset() error = %v", err)
+	}
+
+	d.failClone = true
+	err = zm.CreateReplicaDataset("c1", "r1", 3307, nil, nil, time.Time{}, nil)
+	if _, ok := err.(DatasetError); !ok || !errors.Is(err, errInjected) {
+		t.Errorf("CreateReplicaDataset() error = %v, want DatasetError wrapping %v", err, errInjected)
+	}
+	if ids, _ := zm.GetReplicaIds("c1"); len(ids) != 0 {
+		t.Errorf("GetReplicaIds() = %v, want none", ids)
+	}
+	if _, err := d.GetDataset(zm.fs.Name + "/c1@r1"); err == nil {
+		t.Error("snapshot of failed replica was left behind")
+	}
+}
+
+// newTestReplica creates a cast and a protected, expiring replica with properties and
+// writes a file onto the replica
+func newTestReplica(t *testing.T, zm *ZFSManager) ReplicaState {
+	t.Helper()
+
+	_, err := zm.CreateCastDataset("c1", "unit", noHooks, time.Time{}, nil)
+	if err != nil {
+		t.Fatalf("CreateCastDataset() error = %v", err)
+	}
+
+	expiresAt := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	err = zm.CreateReplicaDataset("c1", "r1", 3307, map[string]int32{"admin": 4000}, map[string]string{"cpu": "1"}, expiresAt, map[string]string{"quota": "1G"})
+	if err != nil {
+		t.Fatalf("CreateReplicaDataset() error = %v", err)
+	}
+	err = zm.SetReplicaProtection("c1", "r1", true)
+	if err != nil {
+		t.Fatalf("SetReplicaProtection() error = %v", err)
+	}
+
+	writeReplicaFile(t, zm, "data")
+
+	state, err := zm.GetReplicaState("c1", "r1")
+	if err != nil {
+		t.Fatalf("GetReplicaState() error = %v", err)
+	}
+
+	return state
+}
+
+// writeReplicaFile writes a file onto the dataset of replica r1 of cast c1
+func writeReplicaFile(t *testing.T, zm *ZFSManager, file string) {
+	t.Helper()
+
+	r, err := zm.lookupReplica("c1", "r1")
+	if err != nil {
+		t.Fatalf("lookupReplica() error = %v", err)
+	}
+	err = zm.d.WriteFile(r.ds, file, []byte("changed"))
+	if err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+}
+
+// hasReplicaFile reports whether a file exists on the dataset of replica r1 of cast c1
+func hasReplicaFile(t *testing.T, zm *ZFSManager, file string) bool {
+	t.Helper()
+
+	r, err := zm.lookupReplica("c1", "r1")
+	if err != nil {
+		t.Fatalf("lookupReplica() error = %v", err)
+	}
+	_, err = zm.d.ReadFile(r.ds, file)
+
+	return err == nil
+}
+
+func TestResetReplicaDataset(t *testing.T) {
+	zm := newTestManager(t)
+	want := newTestReplica(t, zm)
+
+	err := zm.ResetReplicaDataset("c1", "r1")
+	if err != nil {
+		t.Fatalf("ResetReplicaDataset() error = %v", err)
+	}
+
+	if hasReplicaFile(t, zm, "data") {
+		t.Error("changes to the replica survived the reset")
+	}
+	got, err := zm.GetReplicaState("c1", "r1")
+	if err != nil {
+		t.Fatalf("GetReplicaState() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("state after reset = %+v, want %+v", got, want)
+	}
+
+	ds, err := zm.d.GetDataset(zm.getReplicaFullName("c1", "r1"))
+	if err != nil {
+		t.Fatalf("GetDataset() error = %v", err)
+	}
+	if ds.Mountpoint != zm.GetReplicaMountPoint("c1", "r1") {
+		t.Errorf("mountpoint = %s, want %s", ds.Mountpoint, zm.GetReplicaMountPoint("c1", "r1"))
+	}
+	if _, err := zm.d.GetDataset(zm.getReplicaFullName("c1", "r1") + resetSuffix); err == nil {
+		t.Error("dataset of the reset was left behind")
+	}
+}
+
+func TestResetReplicaDatasetWithCheckpoints(t *testing.T) {
+	zm := newTestManager(t)
+	newTestReplica(t, zm)
+
+	_, err := zm.CreateCheckpoint("c1", "r1", "p1")
+	if err != nil {
+		t.Fatalf("CreateCheckpoint() error = %v", err)
+	}
+
+	err = zm.ResetReplicaDataset("c1", "r1")
+	if _, ok := err.(ReplicaHasCheckpointsError); !ok {
+		t.Fatalf("ResetReplicaDataset() error = %v, want ReplicaHasCheckpointsError", err)
+	}
+
+	checkpoints, err := zm.GetCheckpoints("c1", "r1")
+	if err != nil || len(checkpoints) != 1 {
+		t.Errorf("GetCheckpoints() = %v, %v, want p1", checkpoints, err)
+	}
+	if !hasReplicaFile(t, zm, "data") {
+		t.Error("refused reset discarded changes to the replica")
+	}
+}
+
+func TestResetReplicaDatasetFailedClone(t *testing.T) {
+	d := &failingDriver{MemoryDriver: NewMemoryDriver()}
+	zm := newTestManagerWithDriver(t, d)
+	want := newTestReplica(t, zm)
+
+	d.failClone = true
+	err := zm.ResetReplicaDataset("c1", "r1")
+	if _, ok := err.(DatasetError); !ok {
+		t.Fatalf("ResetReplicaDataset() error = %v, want DatasetError", err)
+	}
+
+	if !hasReplicaFile(t, zm, "data") {
+		t.Error("failed reset discarded changes to the replica")
+	}
+	got, err := zm.GetReplicaState("c1", "r1")
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("state after failed reset = %+v, %v, want %+v", got, err, want)
+	}
+}
+
+func TestResetReplicaDatasetInterrupted(t *testing.T) {
+	d := &failingDriver{MemoryDriver: NewMemoryDriver()}
+	zm := newTestManagerWithDriver(t, d)
+	want := newTestReplica(t, zm)
+
+	d.failRename = true
+	err := zm.ResetReplicaDataset("c1", "r1")
+	if _, ok := err.(DatasetError); !ok {
+		t.Fatalf("ResetReplicaDataset() error = %v, want DatasetError", err)
+	}
+	d.failRename = false
+
+	// a restart finds the new dataset of the reset but does not load it as a replica
+	zm = newTestManagerWithDriver(t, d)
+	zm.MustLoad()
+	if _, err := zm.GetReplicaState("c1", "r1"); err == nil {
+		t.Fatal("replica of the interrupted reset is loaded")
+	}
+
+	err = zm.ResetReplicaDataset("c1", "r1")
+	if err != nil {
+		t.Fatalf("ResetReplicaDataset() to complete error = %v", err)
+	}
+	got, err := zm.GetReplicaState("c1", "r1")
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("state after completed reset = %+v, %v, want %+v", got, err, want)
+	}
+
+	err = zm.ResetReplicaDataset("c1", "r2")
+	if _, ok := err.(ReplicaNotFoundError); !ok {
+		t.Errorf("ResetReplicaDataset() of missing replica error = %v, want ReplicaNotFoundError", err)
+	}
+}
@@ -0,0 +1,309 @@
+package zfsmanager
+
+import (
+	"strings"
+	"time"
+
+	"github.com/dnsinogeorgos/conductor/internal/rollback"
+	"go.uber.org/zap"
+)
+
+// trashSuffix is appended to the names of the dataset, the origin snapshot and the mount
+// point of a deleted replica while it is kept in the trash
+const trashSuffix = ":trash"
+
+// GetTrashedReplicas returns the states of the replicas in the trash of a cast
+func (zm *ZFSManager) GetTrashedReplicas(castId string) ([]ReplicaState, error) {
+	zm.mu.Lock()
+	defer zm.mu.Unlock()
+
+	castName := zm.getCastFullName(castId)
+	if _, ok := zm.casts[castName]; !ok {
+		zm.l.Error("cannot get trashed replicas, cast not found", zap.String("cast", castId))
+		return nil, CastNotFoundError{castId}
+	}
+
+	states := make([]ReplicaState, 0)
+	for _, r := range zm.casts[castName].trash {
+		states = append(states, r.state())
+	}
+
+	return states, nil
+}
+
+// TrashReplicaDataset moves a replica into the trash of its cast. The deletion time is
+// recorded in its state, and the dataset and its origin snapshot are renamed and mounted
+// at a hidden path, so that a new replica can take its id. Every step is skipped if it
+// has already been done, so that an interrupted move can be completed by calling it
+// again, and the completed steps are reverted if a later one fails.
+func (zm *ZFSManager) TrashReplicaDataset(castId, id string) (state ReplicaState, err error) {
+	zm.mu.Lock()
+	defer zm.mu.Unlock()
+
+	castName := zm.getCastFullName(castId)
+	if _, ok := zm.casts[castName]; !ok {
+		zm.l.Error("cannot trash replica, cast not found", zap.String("cast", castId), zap.String("replica", id))
+		return ReplicaState{}, CastNotFoundError{castId}
+	}
+	cast := zm.casts[castName]
+
+	name := zm.getReplicaFullName(castId, id)
+	trashName := name + trashSuffix
+
+	r, ok := cast.replicas[name]
+	if !ok {
+		r, ok = cast.trash[trashName]
+		if !ok {
+			zm.l.Error("cannot trash replica, not found", zap.String("cast", castId), zap.String("replica", id))
+			return ReplicaState{}, ReplicaNotFoundError{castId, id}
+		}
+	} else {
+		if dependent, ok := zm.getDependentCast(castId, id, ""); ok {
+			zm.l.Error("cannot trash replica, it is the source of a cast", zap.String("cast", castId), zap.String("replica", id), zap.String("dependent", dependent))
+			return ReplicaState{}, ReplicaInUseError{castId, id, dependent}
+		}
+		if _, ok := cast.trash[trashName]; ok {
+			zm.l.Error("cannot trash replica, already in the trash", zap.String("cast", castId), zap.String("replica", id))
+			return ReplicaState{}, ReplicaInTrashError{castId, id}
+		}
+	}
+
+	rb := rollback.New(zm.l)
+	defer func() {
+		if err != nil {
+			rb.Run()
+		}
+	}()
+
+	if r.deletedAt.IsZero() {
+		r.deletedAt = time.Now().UTC()
+		err = zm.saveReplicaState(r)
+		if err != nil {
+			r.deletedAt = time.Time{}
+			return ReplicaState{}, err
+		}
+		rb.Add("clear deletion time of replica", func() error {
+			r.deletedAt = time.Time{}
+			return zm.saveReplicaState(r)
+		})
+	}
+
+	snapshot := castName + "@" + id
+	mountPoint := zm.GetReplicaMountPoint(castId, id)
+	rb.Add("move replica out of the trash", func() error {
+		return zm.moveReplica(r, name, snapshot, mountPoint)
+	})
+
+	zm.l.Debug("moving replica into the trash", zap.String("cast", castId), zap.String("replica", id))
+	err = zm.moveReplica(r, trashName, snapshot+trashSuffix, mountPoint+trashSuffix)
+	if err != nil {
+		return ReplicaState{}, err
+	}
+
+	delete(cast.replicas, name)
+	if cast.trash == nil {
+		cast.trash = make(map[string]*replica)
+	}
+	cast.trash[trashName] = r
+
+	return r.state(), nil
+}
+
+// RestoreReplicaDataset moves a replica out of the trash of its cast and records the
+// provided port, ports of the port slots and resources in its state. An interrupted
+// restore can be completed by calling it again, and the completed steps are reverted if
+// a later one fails.
+func (zm *ZFSManager) RestoreReplicaDataset(castId, id string, port int32, ports map[string]int32, resources map[string]string) (err error) {
+	zm.mu.Lock()
+	defer zm.mu.Unlock()
+
+	castName := zm.getCastFullName(castId)
+	if _, ok := zm.casts[castName]; !ok {
+		zm.l.Error("cannot restore replica, cast not found", zap.String("cast", castId), zap.String("replica", id))
+		return CastNotFoundError{castId}
+	}
+	cast := zm.casts[castName]
+
+	name := zm.getReplicaFullName(castId, id)
+	trashName := name + trashSuffix
+
+	r, ok := cast.trash[trashName]
+	if live, exists := cast.replicas[name]; exists {
+		if ok || live.deletedAt.IsZero() {
+			zm.l.Error("cannot restore replica, already exists", zap.String("cast", castId), zap.String("replica", id))
+			return ReplicaAlreadyExistsError{castId, id}
+		}
+		// moved out of the trash before the restore was interrupted
+		r, ok = live, true
+	}
+	if !ok {
+		zm.l.Error("cannot restore replica, not in the trash", zap.String("cast", castId), zap.String("replica", id))
+		return ReplicaNotFoundError{castId, id}
+	}
+
+	rb := rollback.New(zm.l)
+	defer func() {
+		if err != nil {
+			rb.Run()
+		}
+	}()
+
+	// the ports are recorded first, so that a replica loaded after an interrupted restore
+	// does not claim the ports and resources it had before it was deleted
+	if r.port != port || !equalPorts(r.ports, ports) || !equalResources(r.resources, resources) {
+		previousPort, previousPorts, previousResources := r.port, r.ports, r.resources
+		r.port, r.ports, r.resources = port, copyPorts(ports), copyResources(resources)
+		err = zm.saveReplicaState(r)
+		if err != nil {
+			r.port, r.ports, r.resources = previousPort, previousPorts, previousResources
+			return err
+		}
+		rb.Add("restore previous ports of replica", func() error {
+			r.port, r.ports, r.resources = previousPort, previousPorts, previousResources
+			return zm.saveReplicaState(r)
+		})
+	}
+
+	snapshot := castName + "@" + id
+	mountPoint := zm.GetReplicaMountPoint(castId, id)
+	rb.Add("move replica into the trash", func() error {
+		return zm.moveReplica(r, trashName, snapshot+trashSuffix, mountPoint+trashSuffix)
+	})
+
+	zm.l.Debug("moving replica out of the trash", zap.String("cast", castId), zap.String("replica", id))
+	err = zm.moveReplica(r, name, snapshot, mountPoint)
+	if err != nil {
+		return err
+	}
+
+	deletedAt := r.deletedAt
+	r.deletedAt = time.Time{}
+	err = zm.saveReplicaState(r)
+	if err != nil {
+		r.deletedAt = deletedAt
+		return err
+	}
+
+	delete(cast.trash, trashName)
+	cast.replicas[name] = r
+
+	return nil
+}
+
+// PurgeTrashedReplicaDataset destroys a replica in the trash of a cast along with its
+// checkpoints and its origin snapshot. What an interrupted purge left behind is
+// destroyed if the replica is not loaded.
+func (zm *ZFSManager) PurgeTrashedReplicaDataset(castId, id string) error {
+	zm.mu.Lock()
+	defer zm.mu.Unlock()
+
+	castName := zm.getCastFullName(castId)
+	if _, ok := zm.casts[castName]; !ok {
+		zm.l.Error("cannot purge replica, cast not found", zap.String("cast", castId), zap.String("replica", id))
+		return CastNotFoundError{castId}
+	}
+	cast := zm.casts[castName]
+
+	trashName := zm.getReplicaFullName(castId, id) + trashSuffix
+	r, ok := cast.trash[trashName]
+	if !ok {
+		zm.l.Info("purging leftovers of trashed replica", zap.String("cast", castId), zap.String("replica", id))
+		if ds, err := zm.d.GetDataset(trashName); err == nil {
+			err = zm.destroyCheckpoints(&replica{ds: ds})
+			if err != nil {
+				return err
+			}
+		}
+		return zm.purge(trashName, castName+"@"+id+trashSuffix)
+	}
+
+	zm.l.Debug("purging trashed replica", zap.String("cast", castId), zap.String("replica", id))
+	err := zm.destroyReplica(r)
+	if err != nil {
+		return err
+	}
+	delete(cast.trash, trashName)
+
+	return nil
+}
+
+// moveReplica renames the dataset of a replica and its origin snapshot and mounts it at
+// the provided path. The steps that have already been done are skipped.
+func (zm *ZFSManager) moveReplica(replica *replica, name, snapshot, mountPoint string) error {
+	var err error
+	if replica.ds.Origin != snapshot {
+		zm.l.Debug("renaming origin snapshot of replica", zap.String("snapshot", replica.ds.Origin), zap.String("name", snapshot))
+		_, err = zm.d.Rename(replica.ds.Origin, snapshot)
+		if err != nil {
+			zm.l.Error("failed to rename origin snapshot of replica", zap.String("snapshot", replica.ds.Origin), zap.Error(err))
+			return newDatasetError("rename", replica.ds.Origin, err)
+		}
+	}
+
+	if replica.ds.Name != name {
+		zm.l.Debug("renaming replica dataset", zap.String("dataset", replica.ds.Name), zap.String("name", name))
+		_, err = zm.d.Rename(replica.ds.Name, name)
+		if err != nil {
+			zm.l.Error("failed to rename replica dataset", zap.String("dataset", replica.ds.Name), zap.Error(err))
+			// the origin of the dataset may have been renamed
+			replica.ds, _ = zm.d.GetDataset(replica.ds.Name)
+			return newDatasetError("rename", replica.ds.Name, err)
+		}
+	}
+
+	if replica.ds.Mountpoint != mountPoint {
+		zm.l.Debug("mounting replica dataset", zap.String("dataset", name), zap.String("mountpoint", mountPoint))
+		err = zm.d.SetProperty(name, "mountpoint", mountPoint)
+		if err != nil {
+			zm.l.Error("failed to mount replica dataset", zap.String("dataset", name), zap.Error(err))
+			replica.ds, _ = zm.d.GetDataset(name)
+			return newDatasetError("set mountpoint of", name, err)
+		}
+		err = zm.d.RemoveMountPoint(replica.ds.Mountpoint)
+		if err != nil {
+			zm.l.Warn("failed to delete previous mountpoint of replica", zap.String("dataset", name), zap.Error(err))
+		}
+	}
+
+	ds, err := zm.d.GetDataset(name)
+	if err != nil {
+		zm.l.Error("failed to get replica dataset", zap.String("dataset", name), zap.Error(err))
+		return newDatasetError("get", name, err)
+	}
+	replica.ds = ds
+
+	return nil
+}
+
+// isTrashName reports whether a dataset name belongs to a replica in the trash
+func isTrashName(name string) bool {
+	return strings.HasSuffix(name, trashSuffix)
+}
+
+// equalPorts reports whether two sets of ports of port slots are the same
+func equalPorts(a, b map[string]int32) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for slot, port := range a {
+		if other, ok := b[slot]; !ok || other != port {
+			return false
+		}
+	}
+
+	return true
+}
+
+// equalResources reports whether two sets of values of resources are the same
+func equalResources(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for resource, value := range a {
+		if other, ok := b[resource]; !ok || other != value {
+			return false
+		}
+	}
+
+	return true
+}
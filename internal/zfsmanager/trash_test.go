@@ -0,0 +1,76 @@
+package zfsmanager
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTrashRestoreReplicaDataset(t *testing.T) {
+	zm := newTestManager(t)
+	newTestReplica(t, zm)
+
+	state, err := zm.TrashReplicaDataset("c1", "r1")
+	if err != nil {
+		t.Fatalf("TrashReplicaDataset() error = %v", err)
+	}
+	if state.DeletedAt == nil {
+		t.Error("deletion time was not recorded")
+	}
+	if _, err := zm.GetReplicaPort("c1", "r1"); err == nil {
+		t.Error("trashed replica is still loaded")
+	}
+	// trashing again completes an interrupted move and changes nothing otherwise
+	_, err = zm.TrashReplicaDataset("c1", "r1")
+	if err != nil {
+		t.Errorf("TrashReplicaDataset() of trashed replica error = %v", err)
+	}
+
+	trashed, err := zm.GetTrashedReplicas("c1")
+	if err != nil || len(trashed) != 1 || trashed[0].Id != "r1" {
+		t.Fatalf("GetTrashedReplicas() = %+v, %v, want r1", trashed, err)
+	}
+
+	err = zm.RestoreReplicaDataset("c1", "r1", 3308, nil, nil)
+	if err != nil {
+		t.Fatalf("RestoreReplicaDataset() error = %v", err)
+	}
+	port, err := zm.GetReplicaPort("c1", "r1")
+	if err != nil || port != 3308 {
+		t.Errorf("GetReplicaPort() after restore = %d, %v, want 3308", port, err)
+	}
+	if !hasReplicaFile(t, zm, "data") {
+		t.Error("data of the replica was lost in the trash")
+	}
+}
+
+func TestPurgeTrashedReplicaDataset(t *testing.T) {
+	zm := newTestManager(t)
+	newTestReplica(t, zm)
+
+	_, err := zm.TrashReplicaDataset("c1", "r1")
+	if err != nil {
+		t.Fatalf("TrashReplicaDataset() error = %v", err)
+	}
+
+	// a new replica may take the id of a trashed one
+	err = zm.CreateReplicaDataset("c1", "r1", 3308, nil, nil, time.Time{}, nil)
+	if err != nil {
+		t.Fatalf("CreateReplicaDataset() with the id of a trashed replica error = %v", err)
+	}
+	_, err = zm.TrashReplicaDataset("c1", "r1")
+	if _, ok := err.(ReplicaInTrashError); !ok {
+		t.Errorf("TrashReplicaDataset() while its id is in the trash error = %v, want ReplicaInTrashError", err)
+	}
+
+	err = zm.PurgeTrashedReplicaDataset("c1", "r1")
+	if err != nil {
+		t.Fatalf("PurgeTrashedReplicaDataset() error = %v", err)
+	}
+	trashed, err := zm.GetTrashedReplicas("c1")
+	if err != nil || len(trashed) != 0 {
+		t.Errorf("GetTrashedReplicas() = %+v, %v, want none", trashed, err)
+	}
+	if _, err := zm.GetReplicaPort("c1", "r1"); err != nil {
+		t.Errorf("live replica was purged along with the trashed one: %v", err)
+	}
+}
@@ -0,0 +1,86 @@
+package zfsmanager
+
+import (
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// Usage describes the space accounting of a filesystem in bytes. Used includes the
+// descendants of the filesystem, Referenced is the data it can access, Written is the
+// data written to it since its origin snapshot and LogicalUsed is Used before
+// compression.
+type Usage struct {
+	Used        uint64
+	Available   uint64
+	Referenced  uint64
+	Written     uint64
+	LogicalUsed uint64
+}
+
+// PoolUsage describes the size of a pool and the space allocated in it in bytes
+type PoolUsage struct {
+	Size      uint64
+	Allocated uint64
+	Free      uint64
+}
+
+// UsageReport contains the space accounting of the filesystem and of the datasets of the
+// casts and replicas below it
+type UsageReport struct {
+	Filesystem Usage
+	Casts      map[string]Usage
+	// Replicas maps the cast ids to the usage of their replicas by id
+	Replicas map[string]map[string]Usage
+}
+
+// GetUsage reads the space accounting of the filesystem, the casts and the replicas. The
+// datasets are matched by name, so the state is not locked while a long operation runs.
+// Datasets of unfinished refreshes and trashed replicas are left out.
+func (zm *ZFSManager) GetUsage() (UsageReport, error) {
+	usage, err := zm.d.GetUsage(zm.fs.Name)
+	if err != nil {
+		zm.l.Error("failed to get usage of filesystem", zap.Error(err))
+		return UsageReport{}, newDatasetError("get usage of", zm.fs.Name, err)
+	}
+
+	report := UsageReport{
+		Filesystem: usage[zm.fs.Name],
+		Casts:      make(map[string]Usage),
+		Replicas:   make(map[string]map[string]Usage),
+	}
+	for name, u := range usage {
+		if !strings.HasPrefix(name, zm.fs.Name+"/") || isRefreshName(name) || isTrashName(name) || isResetName(name) {
+			continue
+		}
+
+		parts := strings.Split(strings.TrimPrefix(name, zm.fs.Name+"/"), "/")
+		switch len(parts) {
+		case 1:
+			report.Casts[parts[0]] = u
+		case 2:
+			if _, ok := report.Replicas[parts[0]]; !ok {
+				report.Replicas[parts[0]] = make(map[string]Usage)
+			}
+			report.Replicas[parts[0]][parts[1]] = u
+		}
+	}
+
+	return report, nil
+}
+
+// GetPoolUsage reads the size of the pool and the space allocated in it
+func (zm *ZFSManager) GetPoolUsage() (PoolUsage, error) {
+	usage, err := zm.d.GetPoolUsage(zm.poolName)
+	if err != nil {
+		zm.l.Error("failed to get usage of pool", zap.String("pool", zm.poolName), zap.Error(err))
+		return PoolUsage{}, newDatasetError("get usage of", zm.poolName, err)
+	}
+
+	return usage, nil
+}
+
+// GetPoolName returns the name of the pool
+func (zm *ZFSManager) GetPoolName() string {
+	return zm.poolName
+}
@@ -0,0 +1,38 @@
+package zfsmanager
+
+import (
+	"testing"
+)
+
+func TestGetUsage(t *testing.T) {
+	zm := newTestManager(t)
+	newTestReplica(t, zm)
+
+	before, err := zm.GetUsage()
+	if err != nil {
+		t.Fatalf("GetUsage() error = %v", err)
+	}
+	writeReplicaFile(t, zm, "more")
+	after, err := zm.GetUsage()
+	if err != nil {
+		t.Fatalf("GetUsage() error = %v", err)
+	}
+
+	// the file written onto the replica is accounted to it and to its cast
+	written := uint64(len("changed"))
+	replica := after.Replicas["c1"]["r1"]
+	if replica.Written != before.Replicas["c1"]["r1"].Written+written {
+		t.Errorf("replica written = %d, want %d more than %d", replica.Written, written, before.Replicas["c1"]["r1"].Written)
+	}
+	if cast := after.Casts["c1"]; cast.Used != before.Casts["c1"].Used+written || cast.Written != before.Casts["c1"].Written {
+		t.Errorf("cast usage = %+v, want only its used space grown by %d", cast, written)
+	}
+
+	pool, err := zm.GetPoolUsage()
+	if err != nil {
+		t.Fatalf("GetPoolUsage() error = %v", err)
+	}
+	if pool.Allocated != after.Filesystem.Used || pool.Free != pool.Size-pool.Allocated {
+		t.Errorf("pool usage = %+v, want %d bytes allocated", pool, after.Filesystem.Used)
+	}
+}
@@ -1,9 +1,11 @@
 #!/usr/bin/env python3
 """
-usage: conductorctl [-h] [-f] {list,create,delete,refresh,help} [cast] [replica]
+usage: conductorctl [-h] [-f]
+                    {list,create,delete,refresh,reset,restore,protect,unprotect,help}
+                    [cast] [replica]
 
 positional arguments:
-  {list,create,delete,refresh,help}
+  {list,create,delete,refresh,reset,restore,protect,unprotect,help}
                         Action to take.
   cast                  Name of cast.
   replica               Name of replica.
@@ -15,6 +17,7 @@ optional arguments:
 """
 
 import sys
+import time
 from argparse import ArgumentParser
 from http.client import responses as rsp
 from prettytable import PrettyTable, MARKDOWN
@@ -23,7 +26,18 @@ import requests
 
 # CONSTANTS
 URL = "http://localhost:8080"
-ACTIONS = ["list", "create", "delete", "refresh", "help"]
+ACTIONS = [
+    "list",
+    "create",
+    "delete",
+    "refresh",
+    "reset",
+    "restore",
+    "protect",
+    "unprotect",
+    "help",
+]
+POLL_INTERVAL = 1
 
 
 # ARGUMENT PARSING
@@ -45,7 +59,7 @@ ARGS = PARSER.parse_args()
 # LOGIC
 def print_table(cast_id=None):
     """Prints conductor service table."""
-    table = PrettyTable(["Timestamp", "Cast", "Replica", "Port"])
+    table = PrettyTable(["Timestamp", "Cast", "Replica", "Port", "Written"])
     for row in populate_table(cast_id):
         table.add_row(row)
     table.set_style(MARKDOWN)
@@ -63,13 +77,14 @@ def put(cast_id, replica_id, force):
 
 
 def update(cast_id, replica_id, force):
-    """Recreates an already existing cast or replica."""
+    """Refreshes a cast keeping its replicas, or recreates a replica."""
     if replica_id is None:
         if force is True:
-            force_delete_cast(cast_id)
+            if get_replicas(cast_id):
+                prompt("This WILL delete all replicas on this cast. Are you sure?")
+            refresh_cast(cast_id, [])
         else:
-            delete_cast(cast_id)
-        create_cast(cast_id)
+            refresh_cast(cast_id)
     else:
         if force is True:
             force_delete_cast(cast_id)
@@ -91,14 +106,36 @@ def delete(cast_id, replica_id, force):
         delete_replica(cast_id, replica_id)
 
 
+def reset(cast_id, replica_id):
+    """Resets a replica to the state of its cast."""
+    if replica_id is None:
+        PARSER.error("action reset requires replica argument")
+    reset_replica(cast_id, replica_id)
+
+
+def restore(cast_id, replica_id):
+    """Restores a deleted replica from the trash of its cast."""
+    if replica_id is None:
+        PARSER.error("action restore requires replica argument")
+    restore_replica(cast_id, replica_id)
+
+
+def protect(cast_id, replica_id, protected):
+    """Sets whether a cast or replica is protected from deletion."""
+    if replica_id is None:
+        set_protection("{}/casts/{}".format(URL, cast_id), protected)
+        name = "cast {}".format(cast_id)
+    else:
+        set_protection("{}/replicas/{}/{}".format(URL, cast_id, replica_id), protected)
+        name = "replica {}/{}".format(cast_id, replica_id)
+    print("{} {}.".format("Protected" if protected else "Unprotected", name))
+
+
 def force_delete_cast(cast_id):
-    """Forcefully deletes a cast."""
-    replicas = get_replicas(cast_id)
-    if replicas:
+    """Forcefully deletes a cast along with its replicas and its trash."""
+    if get_replicas(cast_id):
         prompt("This WILL delete all running replicas on this cast. Are you sure?")
-        for replica in replicas:
-            delete_replica(cast_id, replica["id"])
-    delete_cast(cast_id)
+    delete_cast(cast_id, cascade=True)
 
 
 def populate_table(cast_id):
@@ -108,18 +145,40 @@ def populate_table(cast_id):
         for cast in get_casts():
             replicas = get_replicas(cast["id"])
             for replica in replicas:
-                rows.append(
-                    [cast["timestamp"], cast["id"], replica["id"], replica["port"]]
-                )
+                rows.append(replica_row(cast, replica))
             if not replicas:
-                rows.append([cast["timestamp"], cast["id"], "-", ""])
+                rows.append([cast["timestamp"], cast["id"], "-", "", ""])
     else:
         cast = get_cast(cast_id)
         for replica in get_replicas(cast["id"]):
-            rows.append([cast["timestamp"], cast["id"], replica["id"], replica["port"]])
+            rows.append(replica_row(cast, replica))
     return rows
 
 
+def replica_row(cast, replica):
+    """Returns the table row of a replica."""
+    written = replica.get("usage", {}).get("written")
+    return [
+        cast["timestamp"],
+        cast["id"],
+        replica["id"],
+        replica["port"],
+        format_bytes(written),
+    ]
+
+
+def format_bytes(size):
+    """Formats a size in bytes for humans."""
+    if size is None:
+        return ""
+    unit = "B"
+    for unit in ["B", "K", "M", "G", "T"]:
+        if size < 1024:
+            break
+        size /= 1024
+    return "{:.1f}{}".format(size, unit)
+
+
 def print_response(replica):
     """Prints the conductor response."""
     print(
@@ -129,6 +188,21 @@ def print_response(replica):
     )
 
 
+def wait_operation(req):
+    """Polls an accepted operation until it finishes."""
+    operation = req.json()
+    while operation["state"] in ["queued", "running"]:
+        time.sleep(POLL_INTERVAL)
+        operation = get_operation(operation["id"])
+    if operation["state"] == "failed":
+        print("Operation {} failed: {}".format(operation["id"], operation["error"]))
+        result = operation.get("result") or {}
+        for replica in result.get("replicas", []):
+            if "error" in replica:
+                print("Replica {} failed: {}".format(replica["id"], replica["error"]))
+        sys.exit(1)
+
+
 def prompt(msg):
     """Prompts for action confirmation."""
     response = input(msg + " (y/n): ")
@@ -156,6 +230,15 @@ def get_cast(cast_id):
     sys.exit(1)
 
 
+def get_operation(operation_id):
+    """Retrieves a certain operation from the conductor service."""
+    req = requests.get("{}/operations/{}".format(URL, operation_id))
+    if req.status_code == 200:
+        return req.json()
+    print_response(req)
+    sys.exit(1)
+
+
 def get_replicas(cast_id):
     """Retrieves the list of replicas from the conductor service."""
     req = requests.get("{}/replicas/{}".format(URL, cast_id))
@@ -168,7 +251,8 @@ def get_replicas(cast_id):
 def create_cast(cast_id):
     """Creates a cast at the conductor service."""
     req = requests.post("{}/casts/{}".format(URL, cast_id))
-    if req.status_code == 201:
+    if req.status_code == 202:
+        wait_operation(req)
         print("Created cast {}.".format(cast_id))
     else:
         print_response(req)
@@ -178,17 +262,36 @@ def create_cast(cast_id):
 def create_replica(cast_id, replica_id):
     """Creates a replica at the conductor service."""
     req = requests.post("{}/replicas/{}/{}".format(URL, cast_id, replica_id))
-    if req.status_code == 201:
+    if req.status_code == 202:
+        wait_operation(req)
         print("Created replica {}/{}.".format(cast_id, replica_id))
     else:
         print_response(req)
         sys.exit(1)
 
 
-def delete_cast(cast_id):
-    """Deletes a cast at the conductor service."""
-    req = requests.delete("{}/casts/{}".format(URL, cast_id))
-    if req.status_code == 204:
+def refresh_cast(cast_id, replica_ids=None):
+    """Refreshes a cast at the conductor service, keeping the listed replicas or all."""
+    params = {}
+    if replica_ids is not None:
+        params["replicas"] = ",".join(replica_ids)
+    req = requests.post("{}/casts/{}/refresh".format(URL, cast_id), params=params)
+    if req.status_code == 202:
+        wait_operation(req)
+        print("Refreshed cast {}.".format(cast_id))
+    else:
+        print_response(req)
+        sys.exit(1)
+
+
+def delete_cast(cast_id, cascade=False):
+    """Deletes a cast at the conductor service, along with its replicas if cascading."""
+    params = {}
+    if cascade is True:
+        params["cascade"] = "true"
+    req = requests.delete("{}/casts/{}".format(URL, cast_id), params=params)
+    if req.status_code == 202:
+        wait_operation(req)
         print("Deleted cast {}.".format(cast_id))
     else:
         print_response(req)
@@ -198,13 +301,48 @@ def delete_cast(cast_id):
 def delete_replica(cast_id, replica_id):
     """Deletes a replica at the conductor service."""
     req = requests.delete("{}/replicas/{}/{}".format(URL, cast_id, replica_id))
-    if req.status_code == 204:
+    if req.status_code == 202:
+        wait_operation(req)
         print("Deleted replica {}/{}.".format(cast_id, replica_id))
     else:
         print_response(req)
         sys.exit(1)
 
 
+def reset_replica(cast_id, replica_id):
+    """Resets a replica at the conductor service."""
+    req = requests.post("{}/replicas/{}/{}/reset".format(URL, cast_id, replica_id))
+    if req.status_code == 202:
+        wait_operation(req)
+        print("Reset replica {}/{}.".format(cast_id, replica_id))
+    else:
+        print_response(req)
+        sys.exit(1)
+
+
+def restore_replica(cast_id, replica_id):
+    """Restores a deleted replica at the conductor service."""
+    req = requests.post("{}/replicas/{}/{}/restore".format(URL, cast_id, replica_id))
+    if req.status_code == 202:
+        wait_operation(req)
+        print("Restored replica {}/{}.".format(cast_id, replica_id))
+    else:
+        print_response(req)
+        sys.exit(1)
+
+
+def set_protection(path, protected):
+    """Protects a cast or replica at the conductor service, or unprotects it."""
+    if protected is True:
+        req = requests.post("{}/protect".format(path))
+    else:
+        req = requests.delete("{}/protect".format(path))
+    if req.status_code == 200:
+        return req.json()
+    print_response(req)
+    sys.exit(1)
+
+
 # WIRING
 if ARGS.action == "help":
     PARSER.print_help()
@@ -236,3 +374,14 @@ if ARGS.action == "delete":
 
 if ARGS.action == "refresh":
     update(ARGS.cast, ARGS.replica, ARGS.force)
+
+if ARGS.action == "reset":
+    reset(ARGS.cast, ARGS.replica)
+
+if ARGS.action == "restore":
+    restore(ARGS.cast, ARGS.replica)
+
+if ARGS.action in ["protect", "unprotect"]:
+    if ARGS.cast is None:
+        PARSER.error("action {} requires cast argument".format(ARGS.action))
+    protect(ARGS.cast, ARGS.replica, ARGS.action == "protect")